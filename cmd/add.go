@@ -2,104 +2,284 @@ package cmd
 
 import (
 	"fmt"
-	"os"
 
 	"github.com/anmol7470/passvault/internal"
 	"github.com/spf13/cobra"
 )
 
-var addCmd = &cobra.Command{
-	Use:   "add",
-	Short: "Add a new password entry",
-	Long:  `Add a new password entry to the vault with service name, username, password, and optional notes.`,
-	Run: func(cmd *cobra.Command, args []string) {
-		masterPassword, err := internal.PromptMasterPassword()
-		if err != nil {
-			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
-			os.Exit(1)
-		}
+func newAddCmd(a *app) *cobra.Command {
+	addCmd := &cobra.Command{
+		Use:   "add",
+		Short: "Add a new password entry",
+		Long: `Add a new password entry to the vault with service name, username, password, and optional notes.
+Extra values such as API secrets or PINs can be kept in custom fields with --field, or
+--hidden-field for values that should be encrypted like the password.
+
+With --type, the entry is a secure note, payment card, identity, SSH key or API token instead
+of a login, and you are prompted for the values that type holds. Values of the type such as a
+card's expiry can also be given with --field, for example --field expiry=04/29.
 
-		service, _ := cmd.Flags().GetString("service")
-		username, _ := cmd.Flags().GetString("username")
-		password, _ := cmd.Flags().GetString("password")
-		notes, _ := cmd.Flags().GetString("notes")
-		alias, _ := cmd.Flags().GetString("alias")
+With --url, the entry can be found with 'passvault get --url' from the address of a page.
+URLs match pages of the same registrable domain unless --url-match is host or prefix.
 
-		if service == "" {
-			service, err = internal.PromptString("Service: ")
+With --rotate, the password is due to be changed that long after it last changed, as listed
+by 'passvault due'.
+
+With --output json or yaml, nothing is prompted for: the service, the username and the
+password or --secret-file must be given as flags where the type needs them. The entry that
+was added is written out, without its password.`,
+		RunE: a.run(func(cmd *cobra.Command, args []string) error {
+			vaultKey, err := a.vault.Unlock()
 			if err != nil {
-				fmt.Fprintf(os.Stderr, "Error reading service: %v\n", err)
-				os.Exit(1)
+				return authError(err)
 			}
-		}
+			defer vaultKey.Destroy()
 
-		if username == "" {
-			username, err = internal.PromptString("Username: ")
+			service, _ := cmd.Flags().GetString("service")
+			username, _ := cmd.Flags().GetString("username")
+			password, _ := cmd.Flags().GetString("password")
+			notes, _ := cmd.Flags().GetString("notes")
+			alias, _ := cmd.Flags().GetString("alias")
+			tagFlags, _ := cmd.Flags().GetStringSlice("tag")
+			folderFlag, _ := cmd.Flags().GetString("folder")
+			entryType, _ := cmd.Flags().GetString("type")
+			urlFlags, _ := cmd.Flags().GetStringArray("url")
+			urlMatchFlag, _ := cmd.Flags().GetString("url-match")
+			rotateFlag, _ := cmd.Flags().GetString("rotate")
+
+			schema, err := internal.SchemaOf(internal.EntryType(entryType))
 			if err != nil {
-				fmt.Fprintf(os.Stderr, "Error reading username: %v\n", err)
-				os.Exit(1)
+				return usageError(err)
+			}
+			if username != "" && schema.UsernameLabel == "" {
+				return usageError(fmt.Errorf("a %s has no username", schema.Description))
+			}
+
+			tags, err := internal.NormalizeTags(tagFlags)
+			if err != nil {
+				return usageError(err)
+			}
+
+			folder, err := internal.NormalizeFolder(folderFlag)
+			if err != nil {
+				return usageError(err)
+			}
+
+			urls, err := internal.NormalizeURLs(urlFlags)
+			if err != nil {
+				return usageError(err)
 			}
-		}
 
-		if password == "" {
-			password, err = internal.PromptPasswordWithValidation("Password: ")
+			urlMatch, err := internal.ParseURLMatch(urlMatchFlag)
 			if err != nil {
-				fmt.Fprintf(os.Stderr, "Error reading password: %v\n", err)
-				os.Exit(1)
-			}
-		} else {
-			strength := internal.CheckPasswordStrength(password)
-			if !strength.IsStrong {
-				fmt.Printf("⚠️  Password is weak (score: %d/4)\n", strength.Score)
-				if strength.Feedback != "" {
-					fmt.Printf("Feedback: %s\n", strength.Feedback)
+				return usageError(err)
+			}
+
+			var rotationDays int
+			if rotateFlag != "" {
+				if rotationDays, err = parseRotationPeriod(rotateFlag); err != nil {
+					return usageError(err)
 				}
-				fmt.Printf("Estimated crack time: %s\n", strength.CrackTime)
 			}
-		}
 
-		if notes == "" {
-			notes, err = internal.PromptString("Notes (optional): ")
+			// Scripts can't answer prompts, so what would be asked for must
+			// be given with flags
+			secretFile, _ := cmd.Flags().GetString("secret-file")
+			if a.structured() {
+				switch {
+				case service == "":
+					return a.missingFlag("service")
+				case username == "" && schema.UsernameRequired:
+					return a.missingFlag("username")
+				case password == "" && secretFile == "" && schema.SecretRequired:
+					return a.missingFlag("password")
+				}
+			}
+
+			entryUUID, err := internal.NewEntryUUID()
 			if err != nil {
-				fmt.Fprintf(os.Stderr, "Error reading notes: %v\n", err)
-				os.Exit(1)
+				return err
+			}
+
+			if service == "" {
+				service, err = internal.PromptString(schema.ServiceLabel + ": ")
+				if err != nil {
+					return fail(err, "reading "+internal.LabelInSentence(schema.ServiceLabel))
+				}
 			}
-		}
 
-		if alias == "" {
-			alias, err = internal.PromptString("Alias (optional, for quick access): ")
+			if username == "" && schema.UsernameLabel != "" && !a.structured() {
+				prompt := schema.UsernameLabel + ": "
+				if !schema.UsernameRequired {
+					prompt = schema.UsernameLabel + " (optional): "
+				}
+				username, err = internal.PromptString(prompt)
+				if err != nil {
+					return fail(err, "reading "+internal.LabelInSentence(schema.UsernameLabel))
+				}
+			}
+
+			var secret *internal.SecretBuffer
+			switch {
+			case secretFile != "":
+				secret, err = readSecretFile(secretFile)
+			case password != "":
+				secret = internal.NewSecretBufferFrom([]byte(password))
+				if schema.Type == internal.TypeLogin {
+					strength := internal.CheckPasswordStrength(password)
+					if !strength.IsStrong {
+						fmt.Fprintf(a.prose(), "⚠️  Password is weak (score: %d/4)\n", strength.Score)
+						if strength.Feedback != "" {
+							fmt.Fprintf(a.prose(), "Feedback: %s\n", strength.Feedback)
+						}
+						fmt.Fprintf(a.prose(), "Estimated crack time: %s\n", strength.CrackTime)
+					}
+				}
+			case schema.Type == internal.TypeLogin:
+				secret, err = internal.PromptPasswordWithValidation("Password: ")
+			case a.structured():
+				secret = internal.NewSecretBuffer(0)
+			default:
+				secret, err = promptTypeSecret(schema, nil)
+			}
 			if err != nil {
-				fmt.Fprintf(os.Stderr, "Error reading alias: %v\n", err)
-				os.Exit(1)
+				return fail(err, "reading "+internal.LabelInSentence(schema.SecretLabel))
 			}
-		}
+			defer secret.Destroy()
 
-		if service == "" || username == "" || password == "" {
-			fmt.Fprintf(os.Stderr, "Error: service, username, and password are required\n")
-			os.Exit(1)
-		}
+			if schema.Type != internal.TypeLogin {
+				if err := schema.CheckSecret(secret.Bytes()); err != nil {
+					return usageError(err)
+				}
+			}
 
-		encryptedPassword, err := internal.EncryptPassword(password, masterPassword)
-		if err != nil {
-			fmt.Fprintf(os.Stderr, "Error encrypting password: %v\n", err)
-			os.Exit(1)
-		}
+			fields, err := applyFieldFlags(cmd, nil, vaultKey, entryUUID)
+			if err == nil {
+				fields, err = schema.ConformFields(fields, vaultKey, entryUUID)
+			}
+			if err != nil {
+				return usageError(err)
+			}
 
-		if err := internal.AddPassword(service, username, encryptedPassword, notes, alias); err != nil {
-			fmt.Fprintf(os.Stderr, "Error saving password: %v\n", err)
-			os.Exit(1)
-		}
+			if schema.Type == internal.TypeSSHKey {
+				if fields, err = withSSHPublicKey(fields, secret.Bytes()); err != nil {
+					return usageError(err)
+				}
+			}
 
-		fmt.Printf("Password for %s (%s) added successfully!\n", service, username)
-	},
-}
+			if !a.structured() {
+				fields, err = promptTypeFields(schema, fields, vaultKey, entryUUID, false)
+				if err != nil {
+					return err
+				}
+			}
+
+			fields, err = schema.ConformFields(fields, vaultKey, entryUUID)
+			if err != nil {
+				return usageError(err)
+			}
+
+			if notes == "" && !a.structured() {
+				notes, err = internal.PromptString("Notes (optional): ")
+				if err != nil {
+					return fail(err, "reading notes")
+				}
+			}
+
+			if alias == "" && !a.structured() {
+				alias, err = internal.PromptString("Alias (optional, for quick access): ")
+				if err != nil {
+					return fail(err, "reading alias")
+				}
+			}
+
+			if schema.Type == internal.TypeLogin && (service == "" || username == "" || secret.Len() == 0) {
+				return usageError(fmt.Errorf("service, username, and password are required"))
+			}
+
+			encryptedPassword, err := internal.EncryptSecret(secret.Bytes(), vaultKey, entryUUID)
+			if err != nil {
+				return fail(err, "encrypting "+internal.LabelInSentence(schema.SecretLabel))
+			}
+
+			encryptedTOTP, err := addedTOTP(a, cmd, vaultKey, entryUUID)
+			if err != nil {
+				return err
+			}
 
-func init() {
-	rootCmd.AddCommand(addCmd)
+			entry := internal.PasswordEntry{
+				UUID:              entryUUID,
+				Type:              schema.Type,
+				Service:           service,
+				Username:          username,
+				EncryptedPassword: encryptedPassword,
+				EncryptedTOTP:     encryptedTOTP,
+				Notes:             notes,
+				Alias:             alias,
+				Folder:            folder,
+				Tags:              tags,
+				Fields:            fields,
+				URLs:              urls,
+				URLMatch:          urlMatch,
+				RotationDays:      rotationDays,
+			}
+			if err := a.vault.AddPassword(entry, vaultKey); err != nil {
+				return fail(err, "saving password")
+			}
+
+			if a.structured() {
+				return renderSavedEntry(a, entryUUID, vaultKey)
+			}
+
+			fmt.Fprintf(a.prose(), "%s added successfully!\n", capitalize(entryInSentence(&entry)))
+			return nil
+		}),
+	}
 
 	addCmd.Flags().StringP("service", "s", "", "Service name")
 	addCmd.Flags().StringP("username", "u", "", "Username")
-	addCmd.Flags().StringP("password", "p", "", "Password")
+	addCmd.Flags().StringP("password", "p", "", "Password, or the main secret of another type of entry")
+	addCmd.Flags().StringP("type", "t", string(internal.TypeLogin), "Type of entry ("+entryTypeNames()+")")
+	addCmd.Flags().String("secret-file", "", "Read the main secret, such as a note or private key, from this file")
 	addCmd.Flags().StringP("notes", "n", "", "Notes (optional)")
 	addCmd.Flags().StringP("alias", "a", "", "Alias for quick access (optional)")
+	addCmd.Flags().StringSlice("tag", nil, "Tag for the entry (repeatable or comma-separated)")
+	addCmd.Flags().String("folder", "", "Folder for the entry, such as /work/infra")
+	addCmd.Flags().StringArray("url", nil, "URL of the service, such as its sign-in page (repeatable)")
+	addCmd.Flags().String("url-match", string(internal.MatchDomain), "How the entry's URLs match a page: domain, host or prefix")
+	addCmd.Flags().String("rotate", "", "Days after which the password is due to be changed, such as 90d (optional)")
+	addFieldFlags(addCmd)
+	addCmd.Flags().String("totp", "", "TOTP secret, as base32 or an otpauth:// URI (optional)")
+
+	return addCmd
+}
+
+// addedTOTP returns the encrypted TOTP seed of an entry being added, from the
+// --totp flag or else, in text mode, the prompt
+func addedTOTP(a *app, cmd *cobra.Command, vaultKey *internal.VaultKey, entryUUID string) (string, error) {
+	if totp, _ := cmd.Flags().GetString("totp"); totp != "" {
+		encrypted, err := internal.EncryptTOTP(totp, vaultKey, entryUUID)
+		if err != nil {
+			return "", usageError(err)
+		}
+		return encrypted, nil
+	}
+	if a.structured() {
+		return "", nil
+	}
+
+	input, err := internal.PromptSecret("TOTP secret or otpauth URI (optional): ")
+	if err != nil {
+		return "", fail(err, "reading TOTP secret")
+	}
+	defer input.Destroy()
+
+	if input.Len() == 0 {
+		return "", nil
+	}
+	encrypted, err := internal.EncryptTOTPBytes(input.Bytes(), vaultKey, entryUUID)
+	if err != nil {
+		return "", usageError(err)
+	}
+	return encrypted, nil
 }
@@ -0,0 +1,185 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/anmol7470/passvault/internal"
+	"github.com/spf13/cobra"
+)
+
+func newAgentCmd(a *app) *cobra.Command {
+	agentCmd := &cobra.Command{
+		Use:   "agent",
+		Short: "Run the unlock agent in the foreground",
+		Long: `Run the unlock agent, which keeps unlocked vault keys in memory so that other commands
+do not prompt for the master password. Keys are forgotten after the idle timeout.
+'passvault unlock' starts the agent in the background when it is not running.`,
+		Annotations: map[string]string{skipVaultAnnotation: "true"},
+		RunE: a.run(func(cmd *cobra.Command, args []string) error {
+			timeout, _ := cmd.Flags().GetDuration("timeout")
+
+			signals := make(chan os.Signal, 1)
+			signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+			go func() {
+				<-signals
+				internal.StopAgent()
+			}()
+
+			socketPath, err := internal.AgentSocketPath()
+			if err != nil {
+				return err
+			}
+
+			fmt.Fprintf(a.prose(), "Agent listening on %s (idle timeout %s)\n", socketPath, timeout)
+
+			return internal.RunAgent(timeout)
+		}),
+	}
+
+	agentCmd.Flags().Duration("timeout", internal.DefaultAgentTimeout, "Forget vault keys after this long without use")
+
+	agentCmd.AddCommand(newAgentStatusCmd(a))
+	agentCmd.AddCommand(newAgentStopCmd(a))
+
+	return agentCmd
+}
+
+func newAgentStatusCmd(a *app) *cobra.Command {
+	agentStatusCmd := &cobra.Command{
+		Use:         "status",
+		Short:       "Show whether the agent is running and which vaults it holds",
+		Annotations: map[string]string{skipVaultAnnotation: "true"},
+		RunE: a.run(func(cmd *cobra.Command, args []string) error {
+			status, err := internal.GetAgentStatus()
+			if err != nil {
+				fmt.Fprintln(a.prose(), "Agent: not running")
+				return nil
+			}
+
+			fmt.Fprintf(a.prose(), "Agent: running (pid %d)\n", status.PID)
+			if len(status.Vaults) == 0 {
+				fmt.Fprintln(a.prose(), "No vaults unlocked.")
+				return nil
+			}
+
+			for _, vault := range status.Vaults {
+				fmt.Fprintf(a.prose(), "Unlocked: %s (locks in %s if idle)\n", vault.Path, vault.LocksIn.Round(time.Second))
+			}
+			return nil
+		}),
+	}
+
+	return agentStatusCmd
+}
+
+func newAgentStopCmd(a *app) *cobra.Command {
+	agentStopCmd := &cobra.Command{
+		Use:         "stop",
+		Short:       "Forget all keys and stop the agent",
+		Annotations: map[string]string{skipVaultAnnotation: "true"},
+		RunE: a.run(func(cmd *cobra.Command, args []string) error {
+			if err := internal.StopAgent(); err != nil {
+				return err
+			}
+			fmt.Fprintln(a.prose(), "✓ Agent stopped.")
+			return nil
+		}),
+	}
+
+	return agentStopCmd
+}
+
+func newUnlockCmd(a *app) *cobra.Command {
+	unlockCmd := &cobra.Command{
+		Use:   "unlock",
+		Short: "Unlock the vault in the agent",
+		Long: `Unlock the vault and hand its key to the agent, starting the agent if needed.
+Until the vault is locked again or sits idle for the timeout, commands no longer prompt for the master password.`,
+		RunE: a.run(func(cmd *cobra.Command, args []string) error {
+			timeout, _ := cmd.Flags().GetDuration("timeout")
+
+			masterPassword, err := a.vault.PromptMasterPassword()
+			if err != nil {
+				return authError(err)
+			}
+
+			vaultKey, err := a.vault.OpenVaultKey(masterPassword)
+			masterPassword.Destroy()
+			if err != nil {
+				return fail(authError(err), "unlocking vault")
+			}
+			defer vaultKey.Destroy()
+
+			if !internal.IsAgentRunning() {
+				if err := startAgent(); err != nil {
+					return fail(err, "starting agent")
+				}
+			}
+
+			if err := a.vault.AgentUnlock(vaultKey, timeout); err != nil {
+				return err
+			}
+
+			if timeout <= 0 {
+				fmt.Fprintln(a.prose(), "✓ Vault unlocked. It locks again after the agent's idle timeout.")
+			} else {
+				fmt.Fprintf(a.prose(), "✓ Vault unlocked. It locks again after %s without use.\n", timeout)
+			}
+			return nil
+		}),
+	}
+
+	unlockCmd.Flags().Duration("timeout", 0, "Lock after this long without use (defaults to the agent's timeout)")
+
+	return unlockCmd
+}
+
+func newLockCmd(a *app) *cobra.Command {
+	lockCmd := &cobra.Command{
+		Use:   "lock",
+		Short: "Make the agent forget the vault key",
+		RunE: a.run(func(cmd *cobra.Command, args []string) error {
+			if !internal.IsAgentRunning() {
+				fmt.Fprintln(a.prose(), "Agent is not running; the vault is locked.")
+				return nil
+			}
+
+			if err := a.vault.LockAgent(); err != nil {
+				return err
+			}
+			fmt.Fprintln(a.prose(), "✓ Vault locked.")
+			return nil
+		}),
+	}
+
+	return lockCmd
+}
+
+// startAgent runs 'passvault agent' in the background and waits for it to listen
+func startAgent() error {
+	executable, err := os.Executable()
+	if err != nil {
+		return err
+	}
+
+	agentProcess := exec.Command(executable, "agent", "--timeout", internal.DefaultAgentTimeout.String())
+	detachProcess(agentProcess)
+	if err := agentProcess.Start(); err != nil {
+		return err
+	}
+	agentProcess.Process.Release()
+
+	for range 50 {
+		if internal.IsAgentRunning() {
+			return nil
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+
+	return fmt.Errorf("agent did not start")
+}
@@ -0,0 +1,268 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/anmol7470/passvault/internal"
+	"github.com/spf13/cobra"
+)
+
+func newAttachCmd(a *app) *cobra.Command {
+	attachCmd := &cobra.Command{
+		Use:   "attach",
+		Short: "Manage files attached to entries",
+		Long: fmt.Sprintf(`Attach small files, such as recovery PDFs, certificates or license files, to entries.
+Attachments are encrypted under the vault key like the entries themselves. A file can be
+at most %s, and the attachments of an entry at most %s together.`,
+			internal.FormatSize(internal.MaxAttachmentSize), internal.FormatSize(internal.MaxEntryAttachmentsSize)),
+	}
+
+	attachCmd.AddCommand(newAttachAddCmd(a))
+	attachCmd.AddCommand(newAttachListCmd(a))
+	attachCmd.AddCommand(newAttachExtractCmd(a))
+	attachCmd.AddCommand(newAttachRemoveCmd(a))
+
+	return attachCmd
+}
+
+func newAttachAddCmd(a *app) *cobra.Command {
+	attachAddCmd := &cobra.Command{
+		Use:   "add <alias|query> <file>",
+		Short: "Attach a file to an entry",
+		Args:  cobra.ExactArgs(2),
+		RunE: a.run(func(cmd *cobra.Command, args []string) error {
+			name, _ := cmd.Flags().GetString("name")
+			if name == "" {
+				name = filepath.Base(args[1])
+			}
+
+			// Check the size first so a large file is not read into memory
+			stat, err := os.Stat(args[1])
+			if err != nil {
+				return fail(err, "reading file")
+			}
+			if !stat.Mode().IsRegular() {
+				return fmt.Errorf("%s is not a regular file", args[1])
+			}
+			if stat.Size() > internal.MaxAttachmentSize {
+				return fmt.Errorf("%s is %s; attachments can be at most %s", args[1], internal.FormatSize(int(stat.Size())), internal.FormatSize(internal.MaxAttachmentSize))
+			}
+
+			vaultKey, err := a.vault.Unlock()
+			if err != nil {
+				return authError(err)
+			}
+			defer vaultKey.Destroy()
+
+			entry, err := a.vault.FindPassword(args[0], vaultKey)
+			if err != nil {
+				return err
+			}
+
+			data, err := os.ReadFile(args[1])
+			if err != nil {
+				return fail(err, "reading file")
+			}
+			defer internal.Wipe(data)
+
+			attachment, err := a.vault.AddAttachment(entry.UUID, name, data, vaultKey)
+			if err != nil {
+				return fail(err, "attaching file")
+			}
+
+			fmt.Fprintf(a.prose(), "✓ Attached %s (%s) to %s.\n", attachment.Name, internal.FormatSize(attachment.Size), entryTitle(entry))
+			return nil
+		}),
+	}
+
+	attachAddCmd.Flags().String("name", "", "Name to keep the file under (default: its file name)")
+
+	return attachAddCmd
+}
+
+func newAttachListCmd(a *app) *cobra.Command {
+	attachListCmd := &cobra.Command{
+		Use:   "list [alias|query]",
+		Short: "List the files attached to an entry",
+		Args:  cobra.MaximumNArgs(1),
+		RunE: a.run(func(cmd *cobra.Command, args []string) error {
+			vaultKey, err := a.vault.Unlock()
+			if err != nil {
+				return authError(err)
+			}
+			defer vaultKey.Destroy()
+
+			query := ""
+			if len(args) > 0 {
+				query = args[0]
+			}
+
+			entry, err := a.vault.FindPassword(query, vaultKey)
+			if err != nil {
+				return err
+			}
+
+			attachments, err := a.vault.ListAttachments(entry.UUID, vaultKey)
+			if err != nil {
+				return fail(err, "reading attachments")
+			}
+
+			if len(attachments) == 0 {
+				fmt.Fprintf(a.prose(), "%s has no attachments. Add one with 'passvault attach add'.\n", entryTitle(entry))
+				return nil
+			}
+
+			fmt.Fprintf(a.prose(), "Attachments of %s:\n\n", entryTitle(entry))
+			for _, attachment := range attachments {
+				fmt.Fprintf(a.prose(), "%-22s %10s  %s\n", attachment.CreatedAt, internal.FormatSize(attachment.Size), attachment.Name)
+			}
+			return nil
+		}),
+	}
+
+	return attachListCmd
+}
+
+func newAttachExtractCmd(a *app) *cobra.Command {
+	attachExtractCmd := &cobra.Command{
+		Use:   "extract <alias|query> <name> [destination]",
+		Short: "Save a file attached to an entry",
+		Long: `Decrypt a file attached to an entry and save it to destination, by default a file of the
+same name in the current directory. A destination of - writes the file to standard output.`,
+		Args: cobra.RangeArgs(2, 3),
+		RunE: a.run(func(cmd *cobra.Command, args []string) error {
+			force, _ := cmd.Flags().GetBool("force")
+
+			destination := args[1]
+			if len(args) == 3 {
+				destination = args[2]
+			}
+			if destination != "-" && !force {
+				if _, err := os.Stat(destination); err == nil {
+					return fmt.Errorf("%s already exists. Use --force to overwrite it", destination)
+				}
+			}
+
+			vaultKey, err := a.vault.Unlock()
+			if err != nil {
+				return authError(err)
+			}
+			defer vaultKey.Destroy()
+
+			entry, err := a.vault.FindPassword(args[0], vaultKey)
+			if err != nil {
+				return err
+			}
+
+			data, err := a.vault.ReadAttachment(entry.UUID, args[1], vaultKey)
+			if err != nil {
+				return err
+			}
+			defer internal.Wipe(data)
+
+			if destination == "-" {
+				if _, err := a.stdout.Write(data); err != nil {
+					return fail(err, "writing attachment")
+				}
+				return nil
+			}
+
+			if err := writeAttachment(destination, data, force); err != nil {
+				return fail(err, "writing attachment")
+			}
+
+			absPath, _ := filepath.Abs(destination)
+			fmt.Fprintf(a.prose(), "✓ Saved %s (%s) to: %s\n", args[1], internal.FormatSize(len(data)), absPath)
+			return nil
+		}),
+	}
+
+	attachExtractCmd.Flags().Bool("force", false, "Overwrite the destination if it exists")
+
+	return attachExtractCmd
+}
+
+// formatAttachmentCount describes a number of attachments, such as "2 attachments"
+func formatAttachmentCount(count int) string {
+	if count == 1 {
+		return "1 attachment"
+	}
+	return fmt.Sprintf("%d attachments", count)
+}
+
+// writeAttachment writes an extracted file readable by its owner only,
+// refusing to replace an existing file unless force is set
+func writeAttachment(path string, data []byte, force bool) error {
+	flags := os.O_CREATE | os.O_WRONLY | os.O_EXCL
+	if force {
+		flags = os.O_CREATE | os.O_WRONLY | os.O_TRUNC
+	}
+
+	file, err := os.OpenFile(path, flags, 0600)
+	if err != nil {
+		if errors.Is(err, os.ErrExist) {
+			return fmt.Errorf("%s already exists; use --force to overwrite it", path)
+		}
+		return err
+	}
+
+	if _, err := file.Write(data); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+func newAttachRemoveCmd(a *app) *cobra.Command {
+	attachRemoveCmd := &cobra.Command{
+		Use:   "remove <alias|query> <name>",
+		Short: "Permanently delete a file attached to an entry",
+		Args:  cobra.ExactArgs(2),
+		RunE: a.run(func(cmd *cobra.Command, args []string) error {
+			vaultKey, err := a.vault.Unlock()
+			if err != nil {
+				return authError(err)
+			}
+			defer vaultKey.Destroy()
+
+			entry, err := a.vault.FindPassword(args[0], vaultKey)
+			if err != nil {
+				return err
+			}
+
+			attachments, err := a.vault.ListAttachments(entry.UUID, vaultKey)
+			if err != nil {
+				return fail(err, "reading attachments")
+			}
+			if !slices.ContainsFunc(attachments, func(attachment internal.AttachmentInfo) bool { return attachment.Name == args[1] }) {
+				return fmt.Errorf("%s has no attachment named %q", entryTitle(entry), args[1])
+			}
+
+			fmt.Fprintf(a.prose(), "\nRemove %s from %s? It cannot be restored. (yes/no): ", args[1], entryTitle(entry))
+
+			confirmation, err := internal.PromptString("")
+			if err != nil {
+				return fail(err, "reading confirmation")
+			}
+
+			if strings.ToLower(strings.TrimSpace(confirmation)) != "yes" {
+				fmt.Fprintln(a.prose(), "Removal cancelled.")
+				return nil
+			}
+
+			if err := a.vault.RemoveAttachment(entry.UUID, args[1], vaultKey); err != nil {
+				return err
+			}
+
+			fmt.Fprintf(a.prose(), "✓ Removed %s from %s.\n", args[1], entryTitle(entry))
+			return nil
+		}),
+	}
+
+	return attachRemoveCmd
+}
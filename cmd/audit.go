@@ -2,130 +2,227 @@ package cmd
 
 import (
 	"fmt"
-	"os"
 	"sort"
+	"time"
 
 	"github.com/anmol7470/passvault/internal"
 	"github.com/spf13/cobra"
 )
 
-var auditCmd = &cobra.Command{
-	Use:   "audit",
-	Short: "Audit all passwords for security weaknesses",
-	Long:  `Analyze all stored passwords and generate a security report showing password strength and weak passwords.`,
-	Run: func(cmd *cobra.Command, args []string) {
-		masterPassword, err := internal.PromptMasterPassword()
-		if err != nil {
-			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
-			os.Exit(1)
-		}
-
-		entries, err := internal.ListAllPasswords()
-		if err != nil {
-			fmt.Fprintf(os.Stderr, "Error fetching passwords: %v\n", err)
-			os.Exit(1)
-		}
-
-		if len(entries) == 0 {
-			fmt.Println("No passwords to audit.")
-			return
-		}
-
-		type auditResult struct {
-			entry    internal.PasswordEntry
-			password string
-			strength internal.PasswordStrength
-		}
-
-		var results []auditResult
-		var weakPasswords []auditResult
-		var moderatePasswords []auditResult
-		var strongPasswords []auditResult
-
-		fmt.Printf("Auditing %d password(s)...\n\n", len(entries))
-
-		for _, entry := range entries {
-			decryptedPassword, err := internal.DecryptPassword(entry.EncryptedPassword, masterPassword)
+func newAuditCmd(a *app) *cobra.Command {
+	auditCmd := &cobra.Command{
+		Use:   "audit",
+		Short: "Audit all passwords for security weaknesses",
+		Long: `Analyze all stored passwords and generate a security report showing password strength, weak
+passwords and passwords overdue for rotation.
+
+With --output json or yaml, the counts and every audited password, weakest first, are
+written out instead of the report.`,
+		RunE: a.run(func(cmd *cobra.Command, args []string) error {
+			vaultKey, err := a.vault.Unlock()
 			if err != nil {
-				fmt.Fprintf(os.Stderr, "Warning: Could not decrypt password for %s (%s): %v\n", entry.Service, entry.Username, err)
-				continue
+				return authError(err)
 			}
+			defer vaultKey.Destroy()
 
-			strength := internal.CheckPasswordStrength(decryptedPassword)
+			filter, err := entryFilterFromFlags(cmd)
+			if err != nil {
+				return usageError(err)
+			}
+			// Card numbers, keys and notes aren't passwords, so only logins
+			// are audited unless another type is asked for
+			if filter.Type == "" {
+				filter.Type = internal.TypeLogin
+			}
+
+			entries, err := a.vault.FilterPasswords(filter, vaultKey)
+			if err != nil {
+				return fail(err, "fetching passwords")
+			}
+
+			if len(entries) == 0 && !a.structured() {
+				fmt.Fprintln(a.prose(), "No passwords to audit.")
+				return nil
+			}
+
+			policies, err := a.vault.RotationPolicies(vaultKey)
+			if err != nil {
+				return err
+			}
+
+			now := time.Now()
+			var overdue []entryRotation
+			for _, r := range entryRotations(entries, policies) {
+				if r.rotation.DaysLeft(now) < 0 {
+					overdue = append(overdue, r)
+				}
+			}
+
+			type auditResult struct {
+				entry    internal.PasswordEntry
+				strength internal.PasswordStrength
+			}
+
+			var results []auditResult
+			var weakPasswords []auditResult
+			var moderatePasswords []auditResult
+			var strongPasswords []auditResult
+
+			if !a.structured() {
+				fmt.Fprintf(a.prose(), "Auditing %d password(s)...\n\n", len(entries))
+			}
 
-			result := auditResult{
-				entry:    entry,
-				password: decryptedPassword,
-				strength: strength,
+			for _, entry := range entries {
+				decryptedPassword, err := internal.DecryptPassword(entry.EncryptedPassword, vaultKey, entry.UUID)
+				if err != nil {
+					fmt.Fprintf(a.stderr, "Warning: Could not decrypt password for %s (%s): %v\n", entry.Service, entry.Username, err)
+					continue
+				}
+
+				strength := internal.CheckPasswordStrength(string(decryptedPassword.Bytes()))
+				decryptedPassword.Destroy()
+
+				result := auditResult{
+					entry:    entry,
+					strength: strength,
+				}
+
+				results = append(results, result)
+
+				if strength.Score < 2 {
+					weakPasswords = append(weakPasswords, result)
+				} else if strength.Score < 3 {
+					moderatePasswords = append(moderatePasswords, result)
+				} else {
+					strongPasswords = append(strongPasswords, result)
+				}
 			}
 
-			results = append(results, result)
+			sort.Slice(weakPasswords, func(i, j int) bool {
+				return weakPasswords[i].strength.Score < weakPasswords[j].strength.Score
+			})
+
+			if a.structured() {
+				output := auditOutput{
+					Total:     len(results),
+					Strong:    len(strongPasswords),
+					Moderate:  len(moderatePasswords),
+					Weak:      len(weakPasswords),
+					Overdue:   len(overdue),
+					Passwords: []auditPasswordOutput{},
+				}
+				for _, group := range []struct {
+					rating  string
+					results []auditResult
+				}{{"weak", weakPasswords}, {"moderate", moderatePasswords}, {"strong", strongPasswords}} {
+					for _, result := range group.results {
+						rotation := newRotationOutput(&result.entry, policies)
+						output.Passwords = append(output.Passwords, auditPasswordOutput{
+							UUID:      result.entry.UUID,
+							Service:   result.entry.Service,
+							Username:  result.entry.Username,
+							Rating:    group.rating,
+							Score:     result.strength.Score,
+							CrackTime: result.strength.CrackTime,
+							Feedback:  result.strength.Feedback,
+							Rotation:  rotation,
+							Overdue:   rotation != nil && rotation.DaysLeft < 0,
+						})
+					}
+				}
+				return a.render(output)
+			}
 
-			if strength.Score < 2 {
-				weakPasswords = append(weakPasswords, result)
-			} else if strength.Score < 3 {
-				moderatePasswords = append(moderatePasswords, result)
-			} else {
-				strongPasswords = append(strongPasswords, result)
+			fmt.Fprintln(a.prose(), "═══════════════════════════════════════════════")
+			fmt.Fprintln(a.prose(), "           PASSWORD SECURITY AUDIT")
+			fmt.Fprintln(a.prose(), "═══════════════════════════════════════════════")
+			fmt.Fprintf(a.prose(), "\nTotal passwords: %d\n", len(results))
+			fmt.Fprintf(a.prose(), "Strong passwords (score 3-4): %d (%.1f%%)\n", len(strongPasswords), float64(len(strongPasswords))/float64(len(results))*100)
+			fmt.Fprintf(a.prose(), "Moderate passwords (score 2): %d (%.1f%%)\n", len(moderatePasswords), float64(len(moderatePasswords))/float64(len(results))*100)
+			fmt.Fprintf(a.prose(), "Weak passwords (score 0-1): %d (%.1f%%)\n", len(weakPasswords), float64(len(weakPasswords))/float64(len(results))*100)
+			fmt.Fprintf(a.prose(), "Overdue for rotation: %d\n", len(overdue))
+
+			if len(weakPasswords) > 0 {
+				fmt.Fprintln(a.prose(), "\n═══════════════════════════════════════════════")
+				fmt.Fprintln(a.prose(), "⚠️ WEAK PASSWORDS - IMMEDIATE ACTION REQUIRED")
+				fmt.Fprintln(a.prose(), "═══════════════════════════════════════════════")
+
+				for i, result := range weakPasswords {
+					fmt.Fprintf(a.prose(), "\n%d. %s (%s)\n", i+1, result.entry.Service, result.entry.Username)
+					fmt.Fprintf(a.prose(), "   Score: %d/4\n", result.strength.Score)
+					fmt.Fprintf(a.prose(), "   Crack time: %s\n", result.strength.CrackTime)
+					if result.strength.Feedback != "" {
+						fmt.Fprintf(a.prose(), "   Feedback: %s\n", result.strength.Feedback)
+					}
+				}
 			}
-		}
-
-		sort.Slice(weakPasswords, func(i, j int) bool {
-			return weakPasswords[i].strength.Score < weakPasswords[j].strength.Score
-		})
-
-		fmt.Println("═══════════════════════════════════════════════")
-		fmt.Println("           PASSWORD SECURITY AUDIT")
-		fmt.Println("═══════════════════════════════════════════════")
-		fmt.Printf("\nTotal passwords: %d\n", len(results))
-		fmt.Printf("Strong passwords (score 3-4): %d (%.1f%%)\n", len(strongPasswords), float64(len(strongPasswords))/float64(len(results))*100)
-		fmt.Printf("Moderate passwords (score 2): %d (%.1f%%)\n", len(moderatePasswords), float64(len(moderatePasswords))/float64(len(results))*100)
-		fmt.Printf("Weak passwords (score 0-1): %d (%.1f%%)\n", len(weakPasswords), float64(len(weakPasswords))/float64(len(results))*100)
-
-		if len(weakPasswords) > 0 {
-			fmt.Println("\n═══════════════════════════════════════════════")
-			fmt.Println("⚠️ WEAK PASSWORDS - IMMEDIATE ACTION REQUIRED")
-			fmt.Println("═══════════════════════════════════════════════")
-
-			for i, result := range weakPasswords {
-				fmt.Printf("\n%d. %s (%s)\n", i+1, result.entry.Service, result.entry.Username)
-				fmt.Printf("   Score: %d/4\n", result.strength.Score)
-				fmt.Printf("   Crack time: %s\n", result.strength.CrackTime)
-				if result.strength.Feedback != "" {
-					fmt.Printf("   Feedback: %s\n", result.strength.Feedback)
+
+			if len(overdue) > 0 {
+				fmt.Fprintln(a.prose(), "\n═══════════════════════════════════════════════")
+				fmt.Fprintln(a.prose(), "⚠️ OVERDUE FOR ROTATION - CHANGE THESE PASSWORDS")
+				fmt.Fprintln(a.prose(), "═══════════════════════════════════════════════")
+
+				for i, r := range overdue {
+					fmt.Fprintf(a.prose(), "\n%d. %s (%s)\n", i+1, r.entry.Service, r.entry.Username)
+					fmt.Fprintf(a.prose(), "   %s\n", capitalize(formatDue(r.rotation.DaysLeft(now))))
+					fmt.Fprintf(a.prose(), "   Rotation: %s\n", describeRotation(r.rotation))
 				}
 			}
-		}
-
-		if len(moderatePasswords) > 0 {
-			fmt.Println("\n═══════════════════════════════════════════════")
-			fmt.Println("MODERATE PASSWORDS - CONSIDER STRENGTHENING")
-			fmt.Println("═══════════════════════════════════════════════")
-
-			for i, result := range moderatePasswords {
-				fmt.Printf("\n%d. %s (%s)\n", i+1, result.entry.Service, result.entry.Username)
-				fmt.Printf("   Score: %d/4\n", result.strength.Score)
-				fmt.Printf("   Crack time: %s\n", result.strength.CrackTime)
-				if result.strength.Feedback != "" {
-					fmt.Printf("   Feedback: %s\n", result.strength.Feedback)
+
+			if len(moderatePasswords) > 0 {
+				fmt.Fprintln(a.prose(), "\n═══════════════════════════════════════════════")
+				fmt.Fprintln(a.prose(), "MODERATE PASSWORDS - CONSIDER STRENGTHENING")
+				fmt.Fprintln(a.prose(), "═══════════════════════════════════════════════")
+
+				for i, result := range moderatePasswords {
+					fmt.Fprintf(a.prose(), "\n%d. %s (%s)\n", i+1, result.entry.Service, result.entry.Username)
+					fmt.Fprintf(a.prose(), "   Score: %d/4\n", result.strength.Score)
+					fmt.Fprintf(a.prose(), "   Crack time: %s\n", result.strength.CrackTime)
+					if result.strength.Feedback != "" {
+						fmt.Fprintf(a.prose(), "   Feedback: %s\n", result.strength.Feedback)
+					}
 				}
 			}
-		}
 
-		if len(strongPasswords) > 0 {
-			fmt.Println("\n═══════════════════════════════════════════════")
-			fmt.Println("STRONG PASSWORDS")
-			fmt.Println("═══════════════════════════════════════════════")
+			if len(strongPasswords) > 0 {
+				fmt.Fprintln(a.prose(), "\n═══════════════════════════════════════════════")
+				fmt.Fprintln(a.prose(), "STRONG PASSWORDS")
+				fmt.Fprintln(a.prose(), "═══════════════════════════════════════════════")
 
-			for i, result := range strongPasswords {
-				fmt.Printf("\n%d. %s (%s)\n", i+1, result.entry.Service, result.entry.Username)
-				fmt.Printf("   Score: %d/4\n", result.strength.Score)
-				fmt.Printf("   Crack time: %s\n", result.strength.CrackTime)
+				for i, result := range strongPasswords {
+					fmt.Fprintf(a.prose(), "\n%d. %s (%s)\n", i+1, result.entry.Service, result.entry.Username)
+					fmt.Fprintf(a.prose(), "   Score: %d/4\n", result.strength.Score)
+					fmt.Fprintf(a.prose(), "   Crack time: %s\n", result.strength.CrackTime)
+				}
 			}
-		}
-	},
+			return nil
+		}),
+	}
+
+	addEntryFilterFlags(auditCmd)
+
+	return auditCmd
+}
+
+// auditOutput is what audit writes in structured output
+type auditOutput struct {
+	Total     int                   `json:"total"`
+	Strong    int                   `json:"strong"`
+	Moderate  int                   `json:"moderate"`
+	Weak      int                   `json:"weak"`
+	Overdue   int                   `json:"overdue"`
+	Passwords []auditPasswordOutput `json:"passwords"`
 }
 
-func init() {
-	rootCmd.AddCommand(auditCmd)
+// auditPasswordOutput is an audited password, weakest first
+type auditPasswordOutput struct {
+	UUID      string          `json:"uuid"`
+	Service   string          `json:"service"`
+	Username  string          `json:"username"`
+	Rating    string          `json:"rating"` // weak, moderate or strong
+	Score     int             `json:"score"`  // 0 to 4
+	CrackTime string          `json:"crack_time"`
+	Feedback  string          `json:"feedback"`
+	Rotation  *rotationOutput `json:"rotation"`
+	Overdue   bool            `json:"overdue"`
 }
@@ -2,122 +2,113 @@ package cmd
 
 import (
 	"fmt"
-	"os"
-	"syscall"
+	"strings"
 
 	"github.com/anmol7470/passvault/internal"
 	"github.com/spf13/cobra"
-	"golang.org/x/term"
 )
 
-var changeMasterPasswordCmd = &cobra.Command{
-	Use:   "change-master-password",
-	Short: "Change the master password",
-	Long:  `Change the master password. All stored passwords will be re-encrypted with the new master password.`,
-	Run: func(cmd *cobra.Command, args []string) {
-		fmt.Println("Changing master password...")
-
-		currentPassword, err := internal.PromptMasterPassword()
-		if err != nil {
-			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
-			os.Exit(1)
-		}
-
-		entries, err := internal.ListAllPasswords()
-		if err != nil {
-			fmt.Fprintf(os.Stderr, "Error loading passwords: %v\n", err)
-			os.Exit(1)
-		}
-
-		type DecryptedEntry struct {
-			ID       int
-			Service  string
-			Username string
-			Password string
-			Notes    string
-		}
-
-		var decryptedEntries []DecryptedEntry
-		for _, entry := range entries {
-			decrypted, err := internal.DecryptPassword(entry.EncryptedPassword, currentPassword)
+func newChangeMasterPasswordCmd(a *app) *cobra.Command {
+	changeMasterPasswordCmd := &cobra.Command{
+		Use:   "change-master-password",
+		Short: "Change the master password",
+		Long: `Change the master password. The vault key is re-wrapped under the new password and
+verified in a single transaction, so an interrupted change leaves the vault untouched.
+
+With --rotate-key a new vault key is generated as well, and every entry, revision and
+attachment is re-encrypted and verified under it before the change is committed. Recovery
+codes and escrow shares of the old vault key stop working.`,
+		RunE: a.run(func(cmd *cobra.Command, args []string) error {
+			rotateKey, _ := cmd.Flags().GetBool("rotate-key")
+
+			fmt.Fprintln(a.prose(), "Changing master password...")
+
+			currentPassword, err := a.vault.PromptMasterPassword()
+			if err != nil {
+				return authError(err)
+			}
+
+			vaultKey, err := a.vault.OpenVaultKey(currentPassword)
+			currentPassword.Destroy()
 			if err != nil {
-				fmt.Fprintf(os.Stderr, "Error decrypting password for %s: %v\n", entry.Service, err)
-				os.Exit(1)
+				return fail(authError(err), "unlocking vault")
 			}
-			decryptedEntries = append(decryptedEntries, DecryptedEntry{
-				ID:       entry.ID,
-				Service:  entry.Service,
-				Username: entry.Username,
-				Password: decrypted,
-				Notes:    entry.Notes,
-			})
-		}
-
-		fmt.Print("Enter new master password: ")
-		newPassword, err := term.ReadPassword(int(syscall.Stdin))
-		fmt.Println()
-		if err != nil {
-			fmt.Fprintf(os.Stderr, "Error reading password: %v\n", err)
-			os.Exit(1)
-		}
-
-		if len(newPassword) < 8 {
-			fmt.Fprintf(os.Stderr, "Error: master password must be at least 8 characters\n")
-			os.Exit(1)
-		}
-
-		fmt.Print("Confirm new master password: ")
-		confirmPassword, err := term.ReadPassword(int(syscall.Stdin))
-		fmt.Println()
-		if err != nil {
-			fmt.Fprintf(os.Stderr, "Error reading confirmation: %v\n", err)
-			os.Exit(1)
-		}
-
-		if string(newPassword) != string(confirmPassword) {
-			fmt.Fprintf(os.Stderr, "Error: passwords do not match\n")
-			os.Exit(1)
-		}
-
-		newPasswordStr := string(newPassword)
-
-		hashedPassword, err := internal.HashMasterPassword(newPasswordStr)
-		if err != nil {
-			fmt.Fprintf(os.Stderr, "Error hashing new password: %v\n", err)
-			os.Exit(1)
-		}
-
-		var reencryptedEntries []internal.PasswordEntry
-		for _, entry := range decryptedEntries {
-			encrypted, err := internal.EncryptPassword(entry.Password, newPasswordStr)
+			defer vaultKey.Destroy()
+
+			newPassword, err := internal.PromptNewMasterPassword("Enter new master password: ", "Confirm new master password: ")
 			if err != nil {
-				fmt.Fprintf(os.Stderr, "Error encrypting password for %s: %v\n", entry.Service, err)
-				os.Exit(1)
+				return err
 			}
-			reencryptedEntries = append(reencryptedEntries, internal.PasswordEntry{
-				ID:                entry.ID,
-				Service:           entry.Service,
-				Username:          entry.Username,
-				EncryptedPassword: encrypted,
-				Notes:             entry.Notes,
-			})
-		}
-
-		if err := internal.UpdateMasterPassword(hashedPassword); err != nil {
-			fmt.Fprintf(os.Stderr, "Error updating master password: %v\n", err)
-			os.Exit(1)
-		}
-
-		if err := internal.UpdateAllEncryptedPasswords(reencryptedEntries); err != nil {
-			fmt.Fprintf(os.Stderr, "Error updating encrypted passwords: %v\n", err)
-			os.Exit(1)
-		}
-
-		fmt.Printf("\nMaster password changed successfully!\n")
-		fmt.Printf("Re-encrypted %d passwords.\n", len(decryptedEntries))
-	},
-}
+			defer newPassword.Destroy()
+
+			// A vault that requires a key file keeps requiring it
+			keyFile, err := a.vault.LoadKeyFile()
+			if err != nil {
+				return err
+			}
+
+			masterSecret := internal.ComposeMasterSecret(newPassword, keyFile)
+			defer masterSecret.Destroy()
+			internal.Wipe(keyFile)
+
+			if !rotateKey {
+				if err := a.vault.ChangeMasterPassword(vaultKey, masterSecret); err != nil {
+					return fail(fmt.Errorf("%w. The vault has not been modified", err), "changing master password")
+				}
+
+				fmt.Fprintf(a.prose(), "\nMaster password changed successfully!\n")
+				return nil
+			}
+
+			recoveryCodes, err := a.vault.CountRecoveryCodes()
+			if err != nil {
+				return err
+			}
+
+			escrowed, err := a.vault.HasEscrowShares()
+			if err != nil {
+				return err
+			}
+
+			if recoveryCodes > 0 || escrowed {
+				fmt.Fprintln(a.prose(), "\n⚠️  Rotating the vault key invalidates everything that holds the old one:")
+				if recoveryCodes > 0 {
+					fmt.Fprintf(a.prose(), "  - your %d recovery codes\n", recoveryCodes)
+				}
+				if escrowed {
+					fmt.Fprintln(a.prose(), "  - every escrow share, which cannot be reissued without splitting the key again")
+				}
+				fmt.Fprint(a.prose(), "Rotate the vault key anyway? (yes/no): ")
+
+				confirmation, err := internal.PromptString("")
+				if err != nil {
+					return fail(err, "reading confirmation")
+				}
+				if strings.ToLower(strings.TrimSpace(confirmation)) != "yes" {
+					fmt.Fprintln(a.prose(), "Change cancelled. The vault has not been modified.")
+					return nil
+				}
+			}
+
+			newKey, err := a.vault.Rekey(vaultKey, masterSecret)
+			if err != nil {
+				return fail(fmt.Errorf("%w. The vault has not been modified", err), "changing master password")
+			}
+			newKey.Destroy()
+
+			fmt.Fprintf(a.prose(), "\nMaster password changed successfully!\n")
+			fmt.Fprintln(a.prose(), "The vault key has been rotated and all passwords re-encrypted.")
+			if recoveryCodes > 0 {
+				fmt.Fprintln(a.prose(), "Your old recovery codes no longer work. Run 'passvault recovery generate' to create new ones.")
+			}
+			if escrowed {
+				fmt.Fprintln(a.prose(), "Your old escrow shares no longer work. Run 'passvault escrow split' to issue new ones.")
+			}
+			return nil
+		}),
+	}
+
+	changeMasterPasswordCmd.Flags().Bool("rotate-key", false, "Also generate a new vault key and re-encrypt everything under it")
 
-func init() {
-	rootCmd.AddCommand(changeMasterPasswordCmd)
+	return changeMasterPasswordCmd
 }
@@ -0,0 +1,222 @@
+package cmd
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/anmol7470/passvault/internal"
+)
+
+const testMasterPassword = "correct horse battery staple"
+
+// testParams keep key derivation in tests fast
+var testParams = internal.Argon2Params{Time: 1, Memory: 8 * 1024, Threads: 1, KeyLen: 32}
+
+// newTestApp returns an app whose vault is kept in store, in memory, and is
+// unlocked with testMasterPassword. The home directory is a temporary one,
+// so the real configuration, vaults and agent are never touched.
+func newTestApp(t *testing.T) (*app, *internal.MemoryStore) {
+	t.Helper()
+	t.Setenv("HOME", t.TempDir())
+	t.Setenv("PASSVAULT_VAULT", "")
+	t.Setenv("PASSVAULT_OUTPUT", "")
+	t.Setenv("PASSVAULT_KEYFILE", "")
+
+	store := internal.NewMemoryStore()
+
+	vaultKey, err := internal.GenerateVaultKey(internal.CipherAESGCM)
+	if err != nil {
+		t.Fatalf("GenerateVaultKey: %v", err)
+	}
+	defer vaultKey.Destroy()
+
+	secret := internal.NewSecretBufferFrom([]byte(testMasterPassword))
+	defer secret.Destroy()
+	hashedPassword, err := internal.HashMasterPassword(secret, testParams)
+	if err != nil {
+		t.Fatalf("HashMasterPassword: %v", err)
+	}
+	wrappedKey, err := internal.WrapVaultKey(vaultKey, secret, testParams)
+	if err != nil {
+		t.Fatalf("WrapVaultKey: %v", err)
+	}
+	if err := store.InitializeVault(hashedPassword, wrappedKey); err != nil {
+		t.Fatalf("InitializeVault: %v", err)
+	}
+
+	a := &app{
+		openStore: func(path string) (internal.Store, error) { return store, nil },
+		output:    outputText,
+		readPassword: func() (*internal.SecretBuffer, error) {
+			return internal.NewSecretBufferFrom([]byte(testMasterPassword)), nil
+		},
+	}
+	return a, store
+}
+
+// execute runs passvault with args and returns what it wrote to standard
+// output and standard error, and the exit code it would exit with
+func execute(t *testing.T, a *app, args ...string) (stdout, stderr string, code int) {
+	t.Helper()
+	var out, errOut bytes.Buffer
+	a.stdout, a.stderr = &out, &errOut
+
+	rootCmd := newRootCmd(a)
+	rootCmd.SetArgs(args)
+	rootCmd.SetOut(&errOut)
+	rootCmd.SetErr(&errOut)
+	if err := rootCmd.Execute(); err != nil {
+		var commandErr *failure
+		if !errors.As(err, &commandErr) {
+			t.Fatalf("passvault %v: %v", args, err)
+		}
+		code = exitCode(err)
+	}
+	return out.String(), errOut.String(), code
+}
+
+// executeJSON runs passvault with args and --output json, expecting it to
+// succeed, and returns the document it wrote
+func executeJSON(t *testing.T, a *app, args ...string) map[string]any {
+	t.Helper()
+	stdout, stderr, code := execute(t, a, append(args, "--output", "json")...)
+	if code != 0 {
+		t.Fatalf("passvault %v exited with %d: %s", args, code, stderr)
+	}
+
+	var document map[string]any
+	if err := json.Unmarshal([]byte(stdout), &document); err != nil {
+		t.Fatalf("passvault %v wrote %q: %v", args, stdout, err)
+	}
+	return document
+}
+
+func TestAddAndGet(t *testing.T) {
+	a, store := newTestApp(t)
+
+	added := executeJSON(t, a, "add", "-s", "github", "-u", "me", "-p", "s3cret-Pa55word!", "-a", "gh", "--tag", "work")
+	if added["service"] != "github" || added["username"] != "me" || added["alias"] != "gh" {
+		t.Errorf("add wrote %v", added)
+	}
+	if _, ok := added["password"]; ok {
+		t.Error("add wrote the password")
+	}
+
+	entries, err := store.ListEntries()
+	if err != nil || len(entries) != 1 {
+		t.Fatalf("the store holds %d entries, %v, want 1", len(entries), err)
+	}
+
+	got := executeJSON(t, a, "get", "-q", "gh")
+	if got["password"] != "s3cret-Pa55word!" || got["uuid"] != added["uuid"] {
+		t.Errorf("get wrote %v", got)
+	}
+
+	_, stderr, code := execute(t, a, "add", "-s", "github", "-u", "me", "-p", "other", "--output", "json")
+	if code != exitConflict {
+		t.Errorf("adding the same account exited with %d, want %d: %s", code, exitConflict, stderr)
+	}
+
+	_, stderr, code = execute(t, a, "add", "-u", "me", "-p", "other", "--output", "json")
+	if code != exitUsage {
+		t.Errorf("add without --service exited with %d, want %d: %s", code, exitUsage, stderr)
+	}
+}
+
+func TestGetNotFound(t *testing.T) {
+	a, _ := newTestApp(t)
+	executeJSON(t, a, "add", "-s", "github", "-u", "me", "-p", "s3cret-Pa55word!")
+
+	_, stderr, code := execute(t, a, "get", "-q", "gitlab", "--output", "json")
+	if code != exitNotFound {
+		t.Errorf("get of a missing entry exited with %d, want %d: %s", code, exitNotFound, stderr)
+	}
+
+	// The prompt for the master password goes to standard error too
+	document, ok := strings.CutPrefix(stderr, "Enter master password: ")
+	if !ok {
+		t.Fatalf("get wrote %q to standard error, want the prompt first", stderr)
+	}
+	var output errorOutput
+	if err := json.Unmarshal([]byte(document), &output); err != nil || output.Error.Code != "not_found" {
+		t.Errorf("get wrote the error %q: %v", document, err)
+	}
+}
+
+func TestUnlockFails(t *testing.T) {
+	a, _ := newTestApp(t)
+	a.readPassword = func() (*internal.SecretBuffer, error) {
+		return internal.NewSecretBufferFrom([]byte("wrong password")), nil
+	}
+
+	_, stderr, code := execute(t, a, "add", "-s", "github", "-u", "me", "-p", "s3cret-Pa55word!", "--output", "json")
+	if code != exitAuth {
+		t.Errorf("add with the wrong master password exited with %d, want %d: %s", code, exitAuth, stderr)
+	}
+}
+
+func TestUpdate(t *testing.T) {
+	a, _ := newTestApp(t)
+	added := executeJSON(t, a, "add", "-s", "github", "-u", "me", "-p", "s3cret-Pa55word!")
+
+	updated := executeJSON(t, a, "update", "-q", "github", "-p", "n3w-Pa55word!", "--notes", "rotated", "--folder", "/work")
+	if updated["uuid"] != added["uuid"] || updated["notes"] != "rotated" || updated["folder"] != "/work" {
+		t.Errorf("update wrote %v", updated)
+	}
+	if _, ok := updated["password"]; ok {
+		t.Error("update wrote the password")
+	}
+
+	got := executeJSON(t, a, "get", "-q", "github")
+	if got["password"] != "n3w-Pa55word!" || got["username"] != "me" || got["notes"] != "rotated" {
+		t.Errorf("get after update wrote %v", got)
+	}
+
+	// Values not given with flags are kept
+	executeJSON(t, a, "update", "-q", "github", "--notes", "")
+	got = executeJSON(t, a, "get", "-q", "github")
+	if got["password"] != "n3w-Pa55word!" || got["notes"] != "" || got["folder"] != "/work" {
+		t.Errorf("get after the second update wrote %v", got)
+	}
+}
+
+func TestDelete(t *testing.T) {
+	a, store := newTestApp(t)
+	executeJSON(t, a, "add", "-s", "github", "-u", "me", "-p", "s3cret-Pa55word!")
+
+	_, stderr, code := execute(t, a, "delete", "-q", "github", "--output", "json")
+	if code != exitUsage {
+		t.Errorf("delete without --yes exited with %d, want %d: %s", code, exitUsage, stderr)
+	}
+
+	deleted := executeJSON(t, a, "delete", "-q", "github", "--yes")
+	if deleted["service"] != "github" || deleted["deleted_at"] == "" || deleted["deleted_at"] == nil {
+		t.Errorf("delete wrote %v", deleted)
+	}
+
+	if entries, err := store.ListEntries(); err != nil || len(entries) != 0 {
+		t.Errorf("the store lists %d entries after delete, %v, want 0", len(entries), err)
+	}
+
+	if _, stderr, code := execute(t, a, "get", "-q", "github", "--output", "json"); code != exitNotFound {
+		t.Errorf("get of a deleted entry exited with %d, want %d: %s", code, exitNotFound, stderr)
+	}
+
+	if stdout, stderr, code := execute(t, a, "trash", "list"); code != 0 || !strings.Contains(stdout, "github (me)") {
+		t.Errorf("trash list exited with %d and wrote %q: %s", code, stdout, stderr)
+	}
+
+	if _, stderr, code := execute(t, a, "trash", "purge", "--older-than", "soon"); code != exitUsage {
+		t.Errorf("trash purge with an invalid age exited with %d, want %d: %s", code, exitUsage, stderr)
+	}
+
+	if _, stderr, code := execute(t, a, "trash", "restore", "github"); code != 0 {
+		t.Fatalf("trash restore exited with %d: %s", code, stderr)
+	}
+	if got := executeJSON(t, a, "get", "-q", "github"); got["password"] != "s3cret-Pa55word!" {
+		t.Errorf("get after restore wrote %v", got)
+	}
+}
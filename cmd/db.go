@@ -0,0 +1,81 @@
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/anmol7470/passvault/internal"
+	"github.com/spf13/cobra"
+)
+
+func newDbCmd(a *app) *cobra.Command {
+	dbCmd := &cobra.Command{
+		Use:   "db",
+		Short: "Maintain the vault database",
+	}
+
+	dbCmd.AddCommand(newDbMigrateCmd(a))
+
+	return dbCmd
+}
+
+func newDbMigrateCmd(a *app) *cobra.Command {
+	dbMigrateCmd := &cobra.Command{
+		Use:   "migrate",
+		Short: "Upgrade the vault database to the latest schema",
+		Long: `Upgrade the vault database to the latest schema. Pending migrations are applied
+automatically whenever a vault is opened, after a backup copy of the database is saved
+next to it. Use --status to list the migrations and which of them have been applied.`,
+		RunE: a.run(func(cmd *cobra.Command, args []string) error {
+			showStatus, _ := cmd.Flags().GetBool("status")
+
+			migrator, ok := a.vault.Store().(internal.SchemaMigrator)
+			if !ok {
+				fmt.Fprintln(a.prose(), "This vault's store has no schema to migrate.")
+				return nil
+			}
+
+			statuses, err := migrator.MigrationStatus()
+			if err != nil {
+				return err
+			}
+
+			version := 0
+			for _, status := range statuses {
+				if status.AppliedAt != "" {
+					version = status.Version
+				}
+			}
+
+			if !showStatus {
+				fmt.Fprintf(a.prose(), "✓ Vault schema is up to date (version %d).\n", version)
+				return nil
+			}
+
+			fmt.Fprintf(a.prose(), "Vault: %s\n", a.vault.Store().Location())
+			fmt.Fprintf(a.prose(), "Schema version: %d (latest %d)\n\n", version, internal.LatestSchemaVersion())
+
+			for _, status := range statuses {
+				state := "pending"
+				if status.AppliedAt != "" {
+					state = "applied " + status.AppliedAt
+				}
+				fmt.Fprintf(a.prose(), "%3d  %-48s %s\n", status.Version, status.Name, state)
+			}
+
+			backup := ""
+			for _, status := range statuses {
+				if status.Backup != "" {
+					backup = status.Backup
+				}
+			}
+			if backup != "" {
+				fmt.Fprintf(a.prose(), "\nLast pre-migration backup: %s\n", backup)
+			}
+			return nil
+		}),
+	}
+
+	dbMigrateCmd.Flags().Bool("status", false, "List migrations and whether they have been applied")
+
+	return dbMigrateCmd
+}
@@ -2,59 +2,71 @@ package cmd
 
 import (
 	"fmt"
-	"os"
 	"strings"
 
 	"github.com/anmol7470/passvault/internal"
 	"github.com/spf13/cobra"
 )
 
-var deleteCmd = &cobra.Command{
-	Use:   "delete",
-	Short: "Delete a password entry",
-	Long:  `Search for a password entry and delete it after confirmation.`,
-	Run: func(cmd *cobra.Command, args []string) {
-		_, err := internal.PromptMasterPassword()
-		if err != nil {
-			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
-			os.Exit(1)
-		}
-
-		query, _ := cmd.Flags().GetString("query")
-		entry, err := internal.SearchAndSelectPassword(query)
-		if err != nil {
-			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
-			os.Exit(1)
-		}
-
-		fmt.Print("\n⚠️  WARNING: You are about to delete the following password:\n")
-		fmt.Printf("Service: %s\n", entry.Service)
-		fmt.Printf("Username: %s\n\n", entry.Username)
-		fmt.Print("Are you sure you want to delete this password? (yes/no): ")
-
-		confirmation, err := internal.PromptString("")
-		if err != nil {
-			fmt.Fprintf(os.Stderr, "Error reading confirmation: %v\n", err)
-			os.Exit(1)
-		}
-
-		confirmation = strings.ToLower(strings.TrimSpace(confirmation))
-		if confirmation != "yes" {
-			fmt.Println("Deletion cancelled.")
-			return
-		}
-
-		if err := internal.DeletePassword(entry.ID); err != nil {
-			fmt.Fprintf(os.Stderr, "Error deleting password: %v\n", err)
-			os.Exit(1)
-		}
-
-		fmt.Printf("\nPassword for %s (%s) deleted successfully!\n", entry.Service, entry.Username)
-	},
-}
+func newDeleteCmd(a *app) *cobra.Command {
+	deleteCmd := &cobra.Command{
+		Use:   "delete",
+		Short: "Delete a password entry",
+		Long: `Search for a password entry and move it to the trash after confirmation. Use 'passvault trash' to restore or purge deleted entries.
+
+With --output json or yaml, --query must find exactly one entry and --yes must be given, and
+the deleted entry is written out without its password.`,
+		RunE: a.run(func(cmd *cobra.Command, args []string) error {
+			yes, _ := cmd.Flags().GetBool("yes")
+			if a.structured() && !yes {
+				return a.missingFlag("yes")
+			}
+
+			vaultKey, err := a.vault.Unlock()
+			if err != nil {
+				return authError(err)
+			}
+			defer vaultKey.Destroy()
+
+			query, _ := cmd.Flags().GetString("query")
+			entry, err := a.searchEntry(query, vaultKey)
+			if err != nil {
+				return err
+			}
+
+			if !yes {
+				fmt.Fprint(a.prose(), "\n⚠️  WARNING: You are about to delete the following password:\n")
+				fmt.Fprintf(a.prose(), "Service: %s\n", entry.Service)
+				fmt.Fprintf(a.prose(), "Username: %s\n\n", entry.Username)
+				fmt.Fprint(a.prose(), "Are you sure you want to delete this password? (yes/no): ")
 
-func init() {
-	rootCmd.AddCommand(deleteCmd)
+				confirmation, err := internal.PromptString("")
+				if err != nil {
+					return fail(err, "reading confirmation")
+				}
+
+				confirmation = strings.ToLower(strings.TrimSpace(confirmation))
+				if confirmation != "yes" {
+					fmt.Fprintln(a.prose(), "Deletion cancelled.")
+					return nil
+				}
+			}
+
+			if err := a.vault.DeletePassword(entry.ID); err != nil {
+				return fail(err, "deleting password")
+			}
+
+			if a.structured() {
+				return renderSavedEntry(a, entry.UUID, vaultKey)
+			}
+
+			fmt.Fprintf(a.prose(), "\n%s moved to the trash. Restore it with 'passvault trash restore'.\n", capitalize(entryInSentence(entry)))
+			return nil
+		}),
+	}
 
 	deleteCmd.Flags().StringP("query", "q", "", "Search query for service or username")
+	deleteCmd.Flags().BoolP("yes", "y", false, "Delete without asking for confirmation")
+
+	return deleteCmd
 }
@@ -0,0 +1,7 @@
+//go:build !unix
+
+package cmd
+
+import "os/exec"
+
+func detachProcess(c *exec.Cmd) {}
@@ -0,0 +1,13 @@
+//go:build unix
+
+package cmd
+
+import (
+	"os/exec"
+	"syscall"
+)
+
+// detachProcess starts the process in its own session so it outlives the terminal
+func detachProcess(c *exec.Cmd) {
+	c.SysProcAttr = &syscall.SysProcAttr{Setsid: true}
+}
@@ -0,0 +1,179 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/anmol7470/passvault/internal"
+	"github.com/spf13/cobra"
+)
+
+func newEscrowCmd(a *app) *cobra.Command {
+	escrowCmd := &cobra.Command{
+		Use:   "escrow",
+		Short: "Split the vault key into shares for break-glass access",
+		Long: `Split the vault key into Shamir secret shares held by different people. Any threshold
+of them can reconstruct the vault key and set a new master password, while fewer reveal nothing.`,
+	}
+
+	escrowCmd.AddCommand(newEscrowSplitCmd(a))
+	escrowCmd.AddCommand(newEscrowCombineCmd(a))
+
+	return escrowCmd
+}
+
+func newEscrowSplitCmd(a *app) *cobra.Command {
+	escrowSplitCmd := &cobra.Command{
+		Use:   "split",
+		Short: "Split the vault key into shares",
+		RunE: a.run(func(cmd *cobra.Command, args []string) error {
+			shares, _ := cmd.Flags().GetInt("shares")
+			threshold, _ := cmd.Flags().GetInt("threshold")
+			outDir, _ := cmd.Flags().GetString("out-dir")
+
+			if threshold < 2 || threshold > shares || shares > 255 {
+				return usageError(errors.New("threshold must be at least 2 and no more than shares, which can be at most 255"))
+			}
+
+			vaultKey, err := a.vault.Unlock()
+			if err != nil {
+				return authError(err)
+			}
+			defer vaultKey.Destroy()
+
+			split, err := a.vault.SplitKey(vaultKey, shares, threshold)
+			if err != nil {
+				return fail(err, "splitting vault key")
+			}
+
+			if outDir == "" {
+				fmt.Fprintf(a.prose(), "\nVault key split into %d shares, any %d of which unlock the vault:\n\n", shares, threshold)
+				for _, share := range split {
+					fmt.Fprintf(a.prose(), "Share %d:\n%s\n\n", share.Index, share)
+				}
+			} else {
+				if err := os.MkdirAll(outDir, 0700); err != nil {
+					return fail(err, "creating output directory")
+				}
+
+				for _, share := range split {
+					path := filepath.Join(outDir, fmt.Sprintf("passvault-share-%d.txt", share.Index))
+					if err := writeNewFile(path, share.String()+"\n"); err != nil {
+						return fail(err, fmt.Sprintf("writing share %d", share.Index))
+					}
+					fmt.Fprintf(a.prose(), "✓ Share %d written to %s\n", share.Index, path)
+				}
+			}
+
+			fmt.Fprintln(a.prose(), "Give each share to a different person. Shares from earlier splits are of the same vault key")
+			fmt.Fprintln(a.prose(), "and still reconstruct it; only 'passvault change-master-password --rotate-key' revokes them.")
+			return nil
+		}),
+	}
+
+	escrowSplitCmd.Flags().IntP("shares", "n", 5, "Number of shares to create")
+	escrowSplitCmd.Flags().IntP("threshold", "k", 3, "Number of shares needed to reconstruct the vault key")
+	escrowSplitCmd.Flags().StringP("out-dir", "o", "", "Write each share to a file in this directory instead of printing")
+
+	return escrowSplitCmd
+}
+
+func newEscrowCombineCmd(a *app) *cobra.Command {
+	escrowCombineCmd := &cobra.Command{
+		Use:   "combine [share-file...]",
+		Short: "Reconstruct the vault key from shares and set a new master password",
+		Long: `Reconstruct the vault key from share files, or from shares typed in when no files are given,
+then set a new master password. If the vault required a key file, it no longer does afterwards.`,
+		RunE: a.run(func(cmd *cobra.Command, args []string) error {
+			isSet, err := a.vault.IsMasterPasswordSet()
+			if err != nil {
+				return err
+			}
+			if !isSet {
+				return errors.New("no vault has been set up yet")
+			}
+
+			var shares []internal.EscrowShare
+			if len(args) > 0 {
+				for _, path := range args {
+					data, err := os.ReadFile(path)
+					if err != nil {
+						return fail(err, "reading share")
+					}
+
+					share, err := internal.ParseEscrowShare(string(data))
+					if err != nil {
+						return fail(err, "in "+path)
+					}
+					shares = append(shares, share)
+				}
+			} else if shares, err = promptEscrowShares(a); err != nil {
+				return err
+			}
+
+			vaultKey, err := a.vault.CombineEscrowShares(shares)
+			if err != nil {
+				return err
+			}
+			defer vaultKey.Destroy()
+
+			fmt.Fprintln(a.prose(), "Vault key reconstructed. Choose a new master password.")
+
+			newPassword, err := internal.PromptNewMasterPassword("Enter new master password: ", "Confirm new master password: ")
+			if err != nil {
+				return err
+			}
+			defer newPassword.Destroy()
+
+			if err := a.vault.ResetMasterPassword(vaultKey, newPassword); err != nil {
+				return fail(fmt.Errorf("%w. The vault has not been modified", err), "setting master password")
+			}
+
+			fmt.Fprintln(a.prose(), "\n✓ Master password reset successfully!")
+			return nil
+		}),
+	}
+
+	return escrowCombineCmd
+}
+
+// promptEscrowShares reads shares from the terminal until the threshold of the first one is reached
+func promptEscrowShares(a *app) ([]internal.EscrowShare, error) {
+	var shares []internal.EscrowShare
+	for len(shares) == 0 || len(shares) < shares[0].Threshold {
+		line, err := internal.PromptString(fmt.Sprintf("Enter share %d: ", len(shares)+1))
+		if err != nil {
+			return nil, fail(err, "reading share")
+		}
+
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+
+		share, err := internal.ParseEscrowShare(line)
+		if err != nil {
+			fmt.Fprintf(a.stderr, "Error: %v\n", err)
+			continue
+		}
+		shares = append(shares, share)
+	}
+	return shares, nil
+}
+
+// writeNewFile writes content to a new file readable only by the owner, refusing to overwrite
+func writeNewFile(path, content string) error {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+
+	if _, err := file.WriteString(content); err != nil {
+		file.Close()
+		return err
+	}
+
+	return file.Close()
+}
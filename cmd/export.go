@@ -1,6 +1,7 @@
 package cmd
 
 import (
+	"bytes"
 	"encoding/csv"
 	"encoding/json"
 	"fmt"
@@ -8,6 +9,7 @@ import (
 	"os/exec"
 	"path/filepath"
 	"runtime"
+	"strconv"
 	"strings"
 	"time"
 
@@ -15,145 +17,323 @@ import (
 	"github.com/spf13/cobra"
 )
 
-var exportCmd = &cobra.Command{
-	Use:   "export",
-	Short: "Export all passwords to JSON or CSV format",
-	Long:  `Export all stored passwords in either JSON or CSV format.`,
-	Run: func(cmd *cobra.Command, args []string) {
-		masterPassword, err := internal.PromptMasterPassword()
-		if err != nil {
-			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
-			os.Exit(1)
-		}
-
-		entries, err := internal.ListAllPasswords()
-		if err != nil {
-			fmt.Fprintf(os.Stderr, "Error loading passwords: %v\n", err)
-			os.Exit(1)
-		}
-
-		if len(entries) == 0 {
-			fmt.Println("No passwords to export.")
-			return
-		}
-
-		useJSON, _ := cmd.Flags().GetBool("json")
-		useCSV, _ := cmd.Flags().GetBool("csv")
-
-		var format string
-		if useJSON {
-			format = "json"
-		} else if useCSV {
-			format = "csv"
-		} else {
-			fmt.Print("Export format (json/csv): ")
-			format, err = internal.PromptString("")
+// exportField is a custom field in an export, with its value in plaintext
+type exportField struct {
+	Name   string     `json:"name"`
+	Value  secretText `json:"value"`
+	Hidden bool       `json:"hidden,omitempty"`
+}
+
+// exportAttachment is a file attached to an entry in an export; its data is
+// base64-encoded in JSON
+type exportAttachment struct {
+	Name string `json:"name"`
+	Data []byte `json:"data"`
+}
+
+// exportEntry is an entry in an export. Password holds the main secret of
+// any type of entry, and the values of its type are among its fields.
+// Attachments are only exported to JSON.
+type exportEntry struct {
+	Type              string             `json:"type"`
+	Service           string             `json:"service"`
+	Username          string             `json:"username"`
+	Password          secretText         `json:"password"`
+	TOTP              secretText         `json:"totp,omitempty"`
+	Notes             string             `json:"notes,omitempty"`
+	Folder            string             `json:"folder,omitempty"`
+	Tags              []string           `json:"tags,omitempty"`
+	URLs              []string           `json:"urls,omitempty"`
+	URLMatch          string             `json:"url_match,omitempty"`
+	RotationDays      int                `json:"rotation_days,omitempty"`
+	PasswordChangedAt string             `json:"password_changed_at,omitempty"`
+	Fields            []exportField      `json:"fields,omitempty"`
+	Attachments       []exportAttachment `json:"attachments,omitempty"`
+}
+
+// wipe clears the secrets held by e
+func (e *exportEntry) wipe() {
+	internal.Wipe(e.Password)
+	internal.Wipe(e.TOTP)
+	for _, field := range e.Fields {
+		internal.Wipe(field.Value)
+	}
+	for _, attachment := range e.Attachments {
+		internal.Wipe(attachment.Data)
+	}
+}
+
+// exportOutput is what export writes in structured output. Path is empty
+// when there was nothing to export.
+type exportOutput struct {
+	Format             string   `json:"format"`
+	Path               string   `json:"path"`
+	Entries            int      `json:"entries"`
+	SkippedEntries     int      `json:"skipped_entries"`     // could not be decrypted
+	SkippedAttachments int      `json:"skipped_attachments"` // not written to CSV
+	Errors             []string `json:"errors"`              // what could not be decrypted
+}
+
+// exportColumns are the columns of a CSV export
+var exportColumns = []string{"Service", "Username", "Password", "TOTP", "Notes", "Folder", "Tags", "Fields", "Type", "URLs", "URL Match", "Rotation Days", "Password Changed At"}
+
+func newExportCmd(a *app) *cobra.Command {
+	exportCmd := &cobra.Command{
+		Use:   "export",
+		Short: "Export all passwords to JSON or CSV format",
+		Long: `Export all stored passwords in either JSON or CSV format. Each entry keeps its type,
+custom fields and TOTP secret. JSON exports also hold the files attached to entries.
+
+With --output json or yaml, --json or --csv must be given. The file is written as usual, and
+where it was written and how many entries it holds are written out instead of prose.
+
+Entries and values that cannot be decrypted are left out, and the export then fails once
+the rest has been written.`,
+		RunE: a.run(func(cmd *cobra.Command, args []string) error {
+			useJSON, _ := cmd.Flags().GetBool("json")
+			useCSV, _ := cmd.Flags().GetBool("csv")
+
+			var format string
+			if useJSON {
+				format = "json"
+			} else if useCSV {
+				format = "csv"
+			} else if a.structured() {
+				return usageError(fmt.Errorf("--json or --csv is required with --output %s", a.output))
+			}
+
+			vaultKey, err := a.vault.Unlock()
 			if err != nil {
-				fmt.Fprintf(os.Stderr, "Error reading format: %v\n", err)
-				os.Exit(1)
+				return authError(err)
 			}
-			format = strings.ToLower(strings.TrimSpace(format))
-		}
-
-		type ExportEntry struct {
-			Service  string `json:"service"`
-			Username string `json:"username"`
-			Password string `json:"password"`
-			Notes    string `json:"notes,omitempty"`
-		}
-
-		var exportEntries []ExportEntry
-		for _, entry := range entries {
-			decrypted, err := internal.DecryptPassword(entry.EncryptedPassword, masterPassword)
+			defer vaultKey.Destroy()
+
+			filter, err := entryFilterFromFlags(cmd)
 			if err != nil {
-				fmt.Fprintf(os.Stderr, "Error decrypting password for %s: %v\n", entry.Service, err)
-				continue
+				return usageError(err)
 			}
-			exportEntries = append(exportEntries, ExportEntry{
-				Service:  entry.Service,
-				Username: entry.Username,
-				Password: decrypted,
-				Notes:    entry.Notes,
-			})
-		}
-
-		cwd, err := os.Getwd()
-		if err != nil {
-			fmt.Fprintf(os.Stderr, "Error getting current directory: %v\n", err)
-			os.Exit(1)
-		}
-
-		timestamp := time.Now()
-		var filename string
-		var fullPath string
-
-		switch format {
-		case "json":
-			filename = fmt.Sprintf("passvault_export_%s.json", timestamp)
-			fullPath = filepath.Join(cwd, filename)
-
-			data, err := json.MarshalIndent(exportEntries, "", "  ")
+
+			entries, err := a.vault.FilterPasswords(filter, vaultKey)
 			if err != nil {
-				fmt.Fprintf(os.Stderr, "Error generating JSON: %v\n", err)
-				os.Exit(1)
+				return fail(err, "loading passwords")
 			}
 
-			if err := os.WriteFile(fullPath, data, 0600); err != nil {
-				fmt.Fprintf(os.Stderr, "Error writing file: %v\n", err)
-				os.Exit(1)
+			if len(entries) == 0 {
+				if a.structured() {
+					return a.render(exportOutput{Format: format})
+				}
+				fmt.Fprintln(a.prose(), "No passwords to export.")
+				return nil
 			}
 
-			absPath, _ := filepath.Abs(fullPath)
-			fmt.Printf("Exported %d passwords to: %s\n", len(exportEntries), absPath)
+			if format == "" {
+				fmt.Fprint(a.prose(), "Export format (json/csv): ")
+				format, err = internal.PromptString("")
+				if err != nil {
+					return fail(err, "reading format")
+				}
+				format = strings.ToLower(strings.TrimSpace(format))
+			}
 
-		case "csv":
-			filename = fmt.Sprintf("passvault_export_%s.csv", timestamp)
-			fullPath = filepath.Join(cwd, filename)
+			var exportEntries []exportEntry
+			defer func() {
+				for i := range exportEntries {
+					exportEntries[i].wipe()
+				}
+			}()
+			skippedAttachments := 0
 
-			file, err := os.OpenFile(fullPath, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0600)
-			if err != nil {
-				fmt.Fprintf(os.Stderr, "Error creating file: %v\n", err)
-				os.Exit(1)
+			// What cannot be decrypted is left out, and once the rest is
+			// written the export fails so that the gap is not missed
+			skippedEntries := 0
+			var exportErrors []string
+			exportError := func(err error) {
+				exportErrors = append(exportErrors, err.Error())
+				fmt.Fprintf(a.stderr, "Error %v\n", err)
 			}
-			defer file.Close()
 
-			writer := csv.NewWriter(file)
-			defer writer.Flush()
+			for _, entry := range entries {
+				decrypted, err := internal.DecryptPassword(entry.EncryptedPassword, vaultKey, entry.UUID)
+				if err != nil {
+					exportError(fmt.Errorf("decrypting password for %s: %w", entry.Service, err))
+					skippedEntries++
+					continue
+				}
+
+				var fields []exportField
+				for _, field := range entry.Fields {
+					value, err := internal.DecryptField(field, vaultKey, entry.UUID)
+					if err != nil {
+						exportError(fmt.Errorf("decrypting field %s of %s: %w", field.Name, entry.Service, err))
+						continue
+					}
+					fields = append(fields, exportField{Name: field.Name, Value: bytes.Clone(value.Bytes()), Hidden: field.Hidden})
+					value.Destroy()
+				}
+
+				var urlMatch string
+				if len(entry.URLs) > 0 {
+					urlMatch = string(entry.URLMatch)
+				}
+
+				var totpURI []byte
+				if entry.EncryptedTOTP != "" {
+					totp, err := internal.DecryptTOTP(entry.EncryptedTOTP, vaultKey, entry.UUID)
+					if err != nil {
+						exportError(fmt.Errorf("decrypting TOTP secret for %s: %w", entry.Service, err))
+					} else {
+						totpURI = totp.URI()
+						totp.Destroy()
+					}
+				}
+
+				var attachments []exportAttachment
+				listed, err := a.vault.ListAttachments(entry.UUID, vaultKey)
+				if err != nil {
+					exportError(fmt.Errorf("reading attachments of %s: %w", entry.Service, err))
+				}
+				for _, attachment := range listed {
+					if format != "json" {
+						skippedAttachments++
+						continue
+					}
+					data, err := a.vault.ReadAttachment(entry.UUID, attachment.Name, vaultKey)
+					if err != nil {
+						exportError(fmt.Errorf("decrypting attachment %s of %s: %w", attachment.Name, entry.Service, err))
+						continue
+					}
+					attachments = append(attachments, exportAttachment{Name: attachment.Name, Data: data})
+				}
+
+				exportEntries = append(exportEntries, exportEntry{
+					Type:              string(entry.Type),
+					Service:           entry.Service,
+					Username:          entry.Username,
+					Password:          bytes.Clone(decrypted.Bytes()),
+					TOTP:              totpURI,
+					Notes:             entry.Notes,
+					Folder:            entry.Folder,
+					Tags:              entry.Tags,
+					URLs:              entry.URLs,
+					URLMatch:          urlMatch,
+					RotationDays:      entry.RotationDays,
+					PasswordChangedAt: entry.PasswordChangedAt,
+					Fields:            fields,
+					Attachments:       attachments,
+				})
+				decrypted.Destroy()
+			}
 
-			if err := writer.Write([]string{"Service", "Username", "Password", "Notes"}); err != nil {
-				fmt.Fprintf(os.Stderr, "Error writing CSV header: %v\n", err)
-				os.Exit(1)
+			cwd, err := os.Getwd()
+			if err != nil {
+				return fail(err, "getting current directory")
 			}
 
-			for _, entry := range exportEntries {
-				if err := writer.Write([]string{entry.Service, entry.Username, entry.Password, entry.Notes}); err != nil {
-					fmt.Fprintf(os.Stderr, "Error writing CSV row: %v\n", err)
-					os.Exit(1)
+			timestamp := time.Now()
+			var filename string
+			var fullPath string
+
+			switch format {
+			case "json":
+				filename = fmt.Sprintf("passvault_export_%s.json", timestamp)
+				fullPath = filepath.Join(cwd, filename)
+
+				data, err := json.MarshalIndent(exportEntries, "", "  ")
+				if err != nil {
+					return fail(err, "generating JSON")
+				}
+
+				err = os.WriteFile(fullPath, data, 0600)
+				internal.Wipe(data)
+				if err != nil {
+					return fail(err, "writing file")
 				}
+
+			case "csv":
+				filename = fmt.Sprintf("passvault_export_%s.csv", timestamp)
+				fullPath = filepath.Join(cwd, filename)
+
+				file, err := os.OpenFile(fullPath, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0600)
+				if err != nil {
+					return fail(err, "creating file")
+				}
+
+				writer := csv.NewWriter(file)
+
+				if err := writer.Write(exportColumns); err != nil {
+					return fail(err, "writing CSV header")
+				}
+
+				for _, entry := range exportEntries {
+					// Custom fields don't fit in columns, so they are kept as JSON
+					// encoding/csv only writes strings, so the secrets of each
+					// row are copied into strings as it is written
+					fields := ""
+					if len(entry.Fields) > 0 {
+						data, err := json.Marshal(entry.Fields)
+						if err != nil {
+							return fail(err, "encoding fields")
+						}
+						fields = string(data)
+						internal.Wipe(data)
+					}
+
+					if err := writer.Write([]string{entry.Service, entry.Username, string(entry.Password), string(entry.TOTP), entry.Notes, entry.Folder, strings.Join(entry.Tags, ","), fields, entry.Type, strings.Join(entry.URLs, " "), entry.URLMatch, formatRotationDays(entry.RotationDays), entry.PasswordChangedAt}); err != nil {
+						return fail(err, "writing CSV row")
+					}
+				}
+
+				writer.Flush()
+				if err := writer.Error(); err != nil {
+					return fail(err, "writing file")
+				}
+				if err := file.Close(); err != nil {
+					return fail(err, "writing file")
+				}
+
+			default:
+				return usageError(fmt.Errorf("invalid format '%s'. Use 'json' or 'csv'", format))
+			}
+
+			var incomplete error
+			if len(exportErrors) > 0 {
+				incomplete = fmt.Errorf("the export is incomplete: what could not be decrypted was left out (%d errors)", len(exportErrors))
 			}
 
 			absPath, _ := filepath.Abs(fullPath)
-			fmt.Printf("Exported %d passwords to: %s\n", len(exportEntries), absPath)
-
-		default:
-			fmt.Fprintf(os.Stderr, "Error: invalid format '%s'. Use 'json' or 'csv'\n", format)
-			os.Exit(1)
-		}
-
-		fmt.Print("\nOpen the file? (yes/no): ")
-		openFile, err := internal.PromptString("")
-		if err != nil {
-			fmt.Fprintf(os.Stderr, "Error reading input: %v\n", err)
-			os.Exit(1)
-		}
-
-		openFile = strings.ToLower(strings.TrimSpace(openFile))
-		if openFile == "yes" {
-			if err := openFileInDefaultApp(fullPath); err != nil {
-				fmt.Fprintf(os.Stderr, "Error opening file: %v\n", err)
+			if a.structured() {
+				if err := a.render(exportOutput{Format: format, Path: absPath, Entries: len(exportEntries), SkippedEntries: skippedEntries, SkippedAttachments: skippedAttachments, Errors: nonNil(exportErrors)}); err != nil {
+					return err
+				}
+				return incomplete
+			}
+
+			fmt.Fprintf(a.prose(), "Exported %d passwords to: %s\n", len(exportEntries), absPath)
+			if skippedAttachments > 0 {
+				fmt.Fprintf(a.prose(), "%s not included: CSV exports cannot hold files. Use --json to include them.\n", capitalize(formatAttachmentCount(skippedAttachments)))
+			}
+
+			fmt.Fprint(a.prose(), "\nOpen the file? (yes/no): ")
+			openFile, err := internal.PromptString("")
+			if err != nil {
+				return fail(err, "reading input")
+			}
+
+			openFile = strings.ToLower(strings.TrimSpace(openFile))
+			if openFile == "yes" {
+				if err := openFileInDefaultApp(fullPath); err != nil {
+					fmt.Fprintf(a.stderr, "Error opening file: %v\n", err)
+				}
 			}
-		}
-	},
+			return incomplete
+		}),
+	}
+
+	exportCmd.Flags().Bool("json", false, "Export in JSON format")
+	exportCmd.Flags().Bool("csv", false, "Export in CSV format")
+	addEntryFilterFlags(exportCmd)
+
+	return exportCmd
 }
 
 func openFileInDefaultApp(filepath string) error {
@@ -173,9 +353,11 @@ func openFileInDefaultApp(filepath string) error {
 	return cmd.Start()
 }
 
-func init() {
-	rootCmd.AddCommand(exportCmd)
-
-	exportCmd.Flags().Bool("json", false, "Export in JSON format")
-	exportCmd.Flags().Bool("csv", false, "Export in CSV format")
+// formatRotationDays writes a rotation policy in a CSV export, leaving it
+// empty if there is none
+func formatRotationDays(days int) string {
+	if days == 0 {
+		return ""
+	}
+	return strconv.Itoa(days)
 }
@@ -0,0 +1,190 @@
+package cmd
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/anmol7470/passvault/internal"
+	"github.com/atotto/clipboard"
+	"github.com/spf13/cobra"
+)
+
+const maskedValue = "********"
+
+// addFieldFlags adds the --field and --hidden-field flags of commands that set
+// custom fields
+func addFieldFlags(cmd *cobra.Command) {
+	cmd.Flags().StringArray("field", nil, "Custom field as name=value (repeatable)")
+	cmd.Flags().StringArray("hidden-field", nil, "Hidden custom field as name=value, or just name to be prompted for the value (repeatable)")
+}
+
+// fieldFlagsChanged reports whether any custom field flags were given
+func fieldFlagsChanged(cmd *cobra.Command) bool {
+	return cmd.Flags().Changed("field") || cmd.Flags().Changed("hidden-field") || cmd.Flags().Changed("remove-field")
+}
+
+// applyFieldFlags returns fields with those named by --remove-field removed
+// and those given with --field and --hidden-field set
+func applyFieldFlags(cmd *cobra.Command, fields []internal.CustomField, vaultKey *internal.VaultKey, entryUUID string) ([]internal.CustomField, error) {
+	fields = slices.Clone(fields)
+
+	removed, _ := cmd.Flags().GetStringArray("remove-field")
+	for _, name := range removed {
+		var ok bool
+		if fields, ok = internal.RemoveField(fields, name); !ok {
+			return nil, fmt.Errorf("the entry has no field named %q", name)
+		}
+	}
+
+	visible, _ := cmd.Flags().GetStringArray("field")
+	for _, spec := range visible {
+		name, value, ok := strings.Cut(spec, "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid field %q: use name=value", spec)
+		}
+
+		field, err := internal.NewCustomField(name, []byte(value), false, vaultKey, entryUUID)
+		if err != nil {
+			return nil, err
+		}
+		fields = internal.SetField(fields, field)
+	}
+
+	hidden, _ := cmd.Flags().GetStringArray("hidden-field")
+	for _, spec := range hidden {
+		name, value, ok := strings.Cut(spec, "=")
+
+		var secret *internal.SecretBuffer
+		if ok {
+			secret = internal.NewSecretBufferFrom([]byte(value))
+		} else {
+			var err error
+			secret, err = internal.PromptSecret(fmt.Sprintf("Value of %s: ", name))
+			if err != nil {
+				return nil, fmt.Errorf("failed to read value of %s: %w", name, err)
+			}
+		}
+
+		field, err := internal.NewCustomField(name, secret.Bytes(), true, vaultKey, entryUUID)
+		secret.Destroy()
+		if err != nil {
+			return nil, err
+		}
+		fields = internal.SetField(fields, field)
+	}
+
+	return fields, nil
+}
+
+// promptFields lets the user change or remove the custom fields of an entry
+// and add new ones
+func promptFields(fields []internal.CustomField, vaultKey *internal.VaultKey, entryUUID string) ([]internal.CustomField, error) {
+	var updated []internal.CustomField
+
+	if len(fields) > 0 {
+		fmt.Println("Custom fields (Enter keeps a value, - removes the field)")
+	}
+
+	for _, field := range fields {
+		if !field.Hidden {
+			value, err := promptWithDefault(field.Name, field.Value)
+			if err != nil {
+				return nil, err
+			}
+			if value != "-" {
+				field.Value = value
+				updated = append(updated, field)
+			}
+			continue
+		}
+
+		value, err := internal.PromptSecret(fmt.Sprintf("%s [%s]: ", field.Name, maskedValue))
+		if err != nil {
+			return nil, err
+		}
+
+		switch string(value.Bytes()) {
+		case "":
+			updated = append(updated, field)
+		case "-":
+		default:
+			field, err = internal.NewCustomField(field.Name, value.Bytes(), true, vaultKey, entryUUID)
+			if err != nil {
+				value.Destroy()
+				return nil, err
+			}
+			updated = append(updated, field)
+		}
+		value.Destroy()
+	}
+
+	for {
+		name, err := internal.PromptString("New custom field name (Enter to finish): ")
+		if err != nil {
+			return nil, err
+		}
+		if name == "" {
+			return updated, nil
+		}
+		if internal.FindField(updated, name) >= 0 {
+			fmt.Printf("The entry already has a field named %s.\n", name)
+			continue
+		}
+
+		hide, err := internal.PromptString("Hide its value? (yes/no): ")
+		if err != nil {
+			return nil, err
+		}
+		hidden := strings.ToLower(hide) == "yes"
+
+		var value *internal.SecretBuffer
+		if hidden {
+			value, err = internal.PromptSecret("Value: ")
+		} else {
+			var input string
+			input, err = internal.PromptString("Value: ")
+			value = internal.NewSecretBufferFrom([]byte(input))
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		field, err := internal.NewCustomField(name, value.Bytes(), hidden, vaultKey, entryUUID)
+		value.Destroy()
+		if err != nil {
+			return nil, err
+		}
+		updated = append(updated, field)
+	}
+}
+
+// displayFieldValue returns the value of a field for display, masked if it
+// is hidden
+func displayFieldValue(field internal.CustomField) string {
+	if field.Hidden {
+		return maskedValue
+	}
+	return field.Value
+}
+
+// copyFieldToClipboard copies the value of the named custom field of an entry
+func copyFieldToClipboard(entry *internal.PasswordEntry, name string, vaultKey *internal.VaultKey) error {
+	i := internal.FindField(entry.Fields, name)
+	if i < 0 {
+		return fmt.Errorf("%s has no field named %q", entryTitle(entry), name)
+	}
+
+	value, err := internal.DecryptField(entry.Fields[i], vaultKey, entry.UUID)
+	if err != nil {
+		return err
+	}
+	defer value.Destroy()
+
+	if err := clipboard.WriteAll(string(value.Bytes())); err != nil {
+		return fmt.Errorf("error copying to clipboard: %w", err)
+	}
+
+	fmt.Printf("✓ %s of %s copied to clipboard!\n", entry.Fields[i].Name, entryTitle(entry))
+	return nil
+}
@@ -0,0 +1,56 @@
+package cmd
+
+import (
+	"strings"
+	"unicode"
+
+	"github.com/anmol7470/passvault/internal"
+	"github.com/spf13/cobra"
+)
+
+// addEntryFilterFlags adds the --tag and --folder flags of commands that work
+// on a selection of entries
+func addEntryFilterFlags(cmd *cobra.Command) {
+	cmd.Flags().StringSlice("tag", nil, "Only include entries with this tag (repeatable)")
+	cmd.Flags().String("folder", "", "Only include entries in this folder or its subfolders")
+	cmd.Flags().String("type", "", "Only include entries of this type ("+entryTypeNames()+")")
+}
+
+// entryFilterFromFlags reads the --tag, --folder and --type flags
+func entryFilterFromFlags(cmd *cobra.Command) (internal.EntryFilter, error) {
+	tags, _ := cmd.Flags().GetStringSlice("tag")
+	folder, _ := cmd.Flags().GetString("folder")
+	entryType, _ := cmd.Flags().GetString("type")
+
+	var filter internal.EntryFilter
+	var err error
+
+	if filter.Tags, err = internal.NormalizeTags(tags); err != nil {
+		return filter, err
+	}
+	if filter.Folder, err = internal.NormalizeFolder(folder); err != nil {
+		return filter, err
+	}
+	if entryType != "" {
+		if _, err := internal.SchemaOf(internal.EntryType(entryType)); err != nil {
+			return filter, err
+		}
+		filter.Type = internal.EntryType(entryType)
+	}
+
+	return filter, nil
+}
+
+// splitTags splits a list of tags typed at a prompt
+func splitTags(input string) []string {
+	return strings.FieldsFunc(input, func(r rune) bool { return r == ',' || unicode.IsSpace(r) })
+}
+
+// formatTags returns tags written as #tag, separated by spaces
+func formatTags(tags []string) string {
+	formatted := make([]string, len(tags))
+	for i, tag := range tags {
+		formatted[i] = "#" + tag
+	}
+	return strings.Join(formatted, " ")
+}
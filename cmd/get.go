@@ -2,101 +2,320 @@ package cmd
 
 import (
 	"fmt"
-	"os"
 	"strings"
+	"time"
 
 	"github.com/anmol7470/passvault/internal"
 	"github.com/atotto/clipboard"
 	"github.com/spf13/cobra"
 )
 
-var getCmd = &cobra.Command{
-	Use:   "get",
-	Short: "Search and retrieve a specific password",
-	Long:  `Search for passwords by service name or username.`,
-	Run: func(cmd *cobra.Command, args []string) {
-		masterPassword, err := internal.PromptMasterPassword()
-		if err != nil {
-			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
-			os.Exit(1)
-		}
+func newGetCmd(a *app) *cobra.Command {
+	getCmd := &cobra.Command{
+		Use:   "get",
+		Short: "Search and retrieve a specific password",
+		Long: `Search for passwords by service name or username. With --field, the value of that
+custom field is copied to the clipboard instead of the password. Entries of other types than
+logins are shown with the labels of their type, and their main secret, such as a card number
+or private key, is copied instead of a password.
 
-		query, _ := cmd.Flags().GetString("query")
-		if query == "" && len(args) > 0 {
-			query = args[0]
-		}
+With --url, the entries with a URL that matches the given page are searched instead, so
+passvault get --url https://gitlab.example.com/users/sign_in finds the entry saved for
+https://gitlab.example.com. Each entry matches pages of the same registrable domain, the
+same host, or starting with its URL, as set with --url-match when it was added or updated.
 
-		var entry *internal.PasswordEntry
+With --output json or yaml, the entry is written out with its password, the values of its
+hidden fields and its current TOTP code, and nothing is copied. With --field, only that field
+is written out. A search that matches several entries fails rather than asking which one.`,
+		RunE: a.run(func(cmd *cobra.Command, args []string) error {
+			vaultKey, err := a.vault.Unlock()
+			if err != nil {
+				return authError(err)
+			}
+			defer vaultKey.Destroy()
 
-		if query != "" {
-			aliasEntry, err := internal.GetPasswordByAlias(query)
+			query, _ := cmd.Flags().GetString("query")
+			if query == "" && len(args) > 0 {
+				query = args[0]
+			}
+			fieldName, _ := cmd.Flags().GetString("field")
+			pageURL, _ := cmd.Flags().GetString("url")
+
+			filter, err := entryFilterFromFlags(cmd)
 			if err != nil {
-				fmt.Fprintf(os.Stderr, "Error checking alias: %v\n", err)
-				os.Exit(1)
+				return usageError(err)
 			}
 
-			if aliasEntry != nil {
+			var entry *internal.PasswordEntry
+
+			if query != "" && pageURL == "" {
+				aliasEntry, err := a.vault.GetPasswordByAlias(query, vaultKey)
+				if err != nil {
+					return fail(err, "checking alias")
+				}
+
+				// An alias only names an entry that passes the filter flags;
+				// otherwise the query is searched for like any other
+				if aliasEntry != nil && !filter.Matches(*aliasEntry) {
+					aliasEntry = nil
+				}
+
+				if aliasEntry != nil && !a.structured() {
+					entry = aliasEntry
+					if fieldName != "" {
+						if err := copyFieldToClipboard(entry, fieldName, vaultKey); err != nil {
+							return err
+						}
+						return nil
+					}
+
+					schema := schemaOf(entry)
+					decryptedPassword, err := internal.DecryptPassword(entry.EncryptedPassword, vaultKey, entry.UUID)
+					if err != nil {
+						return fail(err, "decrypting password")
+					}
+					defer decryptedPassword.Destroy()
+
+					if decryptedPassword.Len() == 0 {
+						return fmt.Errorf("%s has no %s", entryTitle(entry), internal.LabelInSentence(schema.SecretLabel))
+					}
+
+					err = clipboard.WriteAll(string(decryptedPassword.Bytes()))
+					if err != nil {
+						return fail(err, "copying to clipboard")
+					}
+
+					fmt.Fprintf(a.prose(), "✓ %s for %s copied to clipboard!\n", schema.SecretLabel, entryTitle(entry))
+					return nil
+				}
 				entry = aliasEntry
-				decryptedPassword, err := internal.DecryptPassword(entry.EncryptedPassword, masterPassword)
+			}
+
+			if entry == nil {
+				switch {
+				case pageURL != "":
+					entry, err = selectURLPassword(a, pageURL, filter, query, vaultKey)
+				case filter.IsEmpty():
+					entry, err = a.searchEntry(query, vaultKey)
+				default:
+					entry, err = selectFilteredPassword(a, filter, query, vaultKey)
+				}
 				if err != nil {
-					fmt.Fprintf(os.Stderr, "Error decrypting password: %v\n", err)
-					os.Exit(1)
+					return err
 				}
+			}
+
+			if a.structured() {
+				return renderEntry(a, entry, fieldName, vaultKey)
+			}
+
+			if fieldName != "" {
+				if err := copyFieldToClipboard(entry, fieldName, vaultKey); err != nil {
+					return err
+				}
+				return nil
+			}
 
-				err = clipboard.WriteAll(decryptedPassword)
+			decryptedPassword, err := internal.DecryptPassword(entry.EncryptedPassword, vaultKey, entry.UUID)
+			if err != nil {
+				return fail(err, "decrypting password")
+			}
+			defer decryptedPassword.Destroy()
+
+			var totp *internal.TOTP
+			if entry.EncryptedTOTP != "" {
+				totp, err = internal.DecryptTOTP(entry.EncryptedTOTP, vaultKey, entry.UUID)
 				if err != nil {
-					fmt.Fprintf(os.Stderr, "Error copying to clipboard: %v\n", err)
-					os.Exit(1)
+					return err
 				}
+			}
 
-				fmt.Printf("✓ Password for %s (%s) copied to clipboard!\n", entry.Service, entry.Username)
-				return
+			policies, err := a.vault.RotationPolicies(vaultKey)
+			if err != nil {
+				return err
+			}
+
+			schema := schemaOf(entry)
+			var details strings.Builder
+			writeEntryDetails(&details, entry, decryptedPassword.Bytes(), totp, nil)
+			writeRotation(&details, entry, policies)
+			totp.Destroy()
+
+			fmt.Fprintf(a.prose(), "\n%s Retrieved\n", entryNoun(schema))
+			fmt.Fprint(a.prose(), details.String())
+
+			if decryptedPassword.Len() == 0 {
+				return nil
 			}
+
+			fmt.Fprintf(a.prose(), "\nCopy %s to clipboard? (yes/no): ", internal.LabelInSentence(schema.SecretLabel))
+			copyChoice, err := internal.PromptString("")
+			if err != nil {
+				return fail(err, "reading choice")
+			}
+
+			if strings.ToLower(copyChoice) == "yes" {
+				err = clipboard.WriteAll(string(decryptedPassword.Bytes()))
+				if err != nil {
+					return fail(err, "copying to clipboard")
+				}
+				fmt.Fprintf(a.prose(), "✓ %s copied to clipboard!\n", schema.SecretLabel)
+			}
+			return nil
+		}),
+	}
+
+	getCmd.Flags().StringP("query", "q", "", "Search query for service or username")
+	getCmd.Flags().String("field", "", "Copy the value of this custom field instead of the password")
+	getCmd.Flags().String("url", "", "Find the entries whose URLs match this page")
+	addEntryFilterFlags(getCmd)
+
+	return getCmd
+}
+
+// selectFilteredPassword lets the user pick one of the entries that pass
+// filter and match query, if any
+func selectFilteredPassword(a *app, filter internal.EntryFilter, query string, vaultKey *internal.VaultKey) (*internal.PasswordEntry, error) {
+	filter.Query = query
+
+	entries, err := a.vault.FilterPasswords(filter, vaultKey)
+	if err != nil {
+		return nil, fmt.Errorf("error searching passwords: %w", err)
+	}
+
+	if len(entries) == 0 {
+		return nil, fmt.Errorf("%w matching the given tags, folder, type and query", internal.ErrNoEntries)
+	}
+
+	return a.selectEntry(entries, query)
+}
+
+// selectURLPassword lets the user pick one of the entries with a URL that
+// matches pageURL and that pass filter and match query, if any
+func selectURLPassword(a *app, pageURL string, filter internal.EntryFilter, query string, vaultKey *internal.VaultKey) (*internal.PasswordEntry, error) {
+	filter.Query = query
+
+	entries, err := a.vault.FindPasswordsByURL(pageURL, vaultKey)
+	if err != nil {
+		return nil, fmt.Errorf("error searching passwords: %w", err)
+	}
+
+	var matches []internal.PasswordEntry
+	for _, entry := range entries {
+		if filter.Matches(entry) {
+			matches = append(matches, entry)
 		}
+	}
 
-		entry, err = internal.SearchAndSelectPassword(query)
-		if err != nil {
-			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
-			os.Exit(1)
+	if len(matches) == 0 {
+		return nil, fmt.Errorf("%w for %s", internal.ErrNoEntries, pageURL)
+	}
+
+	return a.selectEntry(matches, query)
+}
+
+// searchEntry returns the entry matching query. In text mode the user is
+// asked for a query if there is none and picks one of several matches; in
+// structured output both are errors.
+func (a *app) searchEntry(query string, vaultKey *internal.VaultKey) (*internal.PasswordEntry, error) {
+	if !a.structured() {
+		return a.vault.SearchAndSelectPassword(query, vaultKey)
+	}
+
+	if query == "" {
+		return nil, usageError(fmt.Errorf("a search query is required with --output %s; use --query", a.output))
+	}
+
+	entries, err := a.vault.SearchPasswords(query, vaultKey)
+	if err != nil {
+		return nil, fmt.Errorf("error searching passwords: %w", err)
+	}
+
+	if len(entries) == 0 {
+		return nil, fmt.Errorf("%w matching '%s'", internal.ErrNoEntries, query)
+	}
+
+	return a.selectEntry(entries, query)
+}
+
+// selectEntry lets the user pick one of entries, which matched query. In
+// structured output there is no one to ask, so several matches are an error.
+func (a *app) selectEntry(entries []internal.PasswordEntry, query string) (*internal.PasswordEntry, error) {
+	if !a.structured() || len(entries) == 1 {
+		return internal.SelectPassword(entries, query)
+	}
+
+	titles := make([]string, len(entries))
+	for i := range entries {
+		titles[i] = entryTitle(&entries[i])
+	}
+	return nil, &commandError{
+		exit: exitAmbiguous,
+		err:  fmt.Errorf("%d entries match '%s': %s; use a more specific query", len(entries), query, strings.Join(titles, ", ")),
+	}
+}
+
+// renderEntry writes an entry with its secrets in structured output, or just
+// the custom field named fieldName if it is set
+func renderEntry(a *app, entry *internal.PasswordEntry, fieldName string, vaultKey *internal.VaultKey) error {
+	if fieldName != "" {
+		i := internal.FindField(entry.Fields, fieldName)
+		if i < 0 {
+			return fmt.Errorf("%s has no field named %q", entryTitle(entry), fieldName)
 		}
 
-		decryptedPassword, err := internal.DecryptPassword(entry.EncryptedPassword, masterPassword)
+		value, err := internal.DecryptField(entry.Fields[i], vaultKey, entry.UUID)
 		if err != nil {
-			fmt.Fprintf(os.Stderr, "Error decrypting password: %v\n", err)
-			os.Exit(1)
+			return err
 		}
+		defer value.Destroy()
 
-		fmt.Println("\nPassword Retrieved")
-		fmt.Printf("Service: %s\n", entry.Service)
-		fmt.Printf("Username: %s\n", entry.Username)
-		fmt.Printf("Password: %s\n", decryptedPassword)
-		if entry.Notes != "" {
-			fmt.Printf("Notes: %s\n", entry.Notes)
-		}
-		if entry.Alias != "" {
-			fmt.Printf("Alias: %s\n", entry.Alias)
-		}
+		plaintext := secretText(value.Bytes())
+		return a.render(fieldOutput{Name: entry.Fields[i].Name, Hidden: entry.Fields[i].Hidden, Value: &plaintext})
+	}
+
+	attachments, err := a.vault.AttachmentCounts()
+	if err != nil {
+		return fail(err, "loading attachments")
+	}
 
-		fmt.Print("\nCopy password to clipboard? (yes/no): ")
-		copyChoice, err := internal.PromptString("")
+	policies, err := a.vault.RotationPolicies(vaultKey)
+	if err != nil {
+		return err
+	}
+
+	output := newEntryOutput(entry, attachments[entry.UUID], policies)
+
+	decryptedPassword, err := internal.DecryptPassword(entry.EncryptedPassword, vaultKey, entry.UUID)
+	if err != nil {
+		return fail(err, "decrypting password")
+	}
+	defer decryptedPassword.Destroy()
+	password := secretText(decryptedPassword.Bytes())
+	output.Password = &password
+
+	for i, field := range entry.Fields {
+		if !field.Hidden {
+			continue
+		}
+		value, err := internal.DecryptField(field, vaultKey, entry.UUID)
 		if err != nil {
-			fmt.Fprintf(os.Stderr, "Error reading choice: %v\n", err)
-			os.Exit(1)
+			return err
 		}
+		defer value.Destroy()
+		plaintext := secretText(value.Bytes())
+		output.Fields[i].Value = &plaintext
+	}
 
-		if strings.ToLower(copyChoice) == "yes" {
-			err = clipboard.WriteAll(decryptedPassword)
-			if err != nil {
-				fmt.Fprintf(os.Stderr, "Error copying to clipboard: %v\n", err)
-				os.Exit(1)
-			}
-			fmt.Println("✓ Password copied to clipboard!")
+	if entry.EncryptedTOTP != "" {
+		totp, err := internal.DecryptTOTP(entry.EncryptedTOTP, vaultKey, entry.UUID)
+		if err != nil {
+			return err
 		}
-	},
-}
+		now := time.Now()
+		output.TOTP = &totpOutput{Code: totp.Code(now), ExpiresIn: int(totp.Remaining(now).Seconds())}
+		totp.Destroy()
+	}
 
-func init() {
-	rootCmd.AddCommand(getCmd)
-
-	getCmd.Flags().StringP("query", "q", "", "Search query for service or username")
+	return a.render(output)
 }
@@ -0,0 +1,187 @@
+package cmd
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/anmol7470/passvault/internal"
+	"github.com/spf13/cobra"
+)
+
+func newHistoryCmd(a *app) *cobra.Command {
+	historyCmd := &cobra.Command{
+		Use:   "history [alias|query]",
+		Short: "Show previous versions of a password entry",
+		Long: `Show the previous passwords, usernames, notes and custom fields of an entry, newest
+first. A version is kept every time one of them changes. Use --limit to change how many
+versions are kept per entry.`,
+		Args: cobra.MaximumNArgs(1),
+		RunE: a.run(func(cmd *cobra.Command, args []string) error {
+			show, _ := cmd.Flags().GetBool("show")
+
+			vaultKey, err := a.vault.Unlock()
+			if err != nil {
+				return authError(err)
+			}
+			defer vaultKey.Destroy()
+
+			if cmd.Flags().Changed("limit") {
+				limit, _ := cmd.Flags().GetInt("limit")
+				if err := a.vault.SetHistoryLimit(limit); err != nil {
+					return err
+				}
+
+				if limit == 0 {
+					fmt.Fprintln(a.prose(), "✓ History turned off. Previous versions have been deleted.")
+				} else {
+					fmt.Fprintf(a.prose(), "✓ Keeping up to %d previous versions per entry.\n", limit)
+				}
+
+				if len(args) == 0 {
+					return nil
+				}
+			}
+
+			query := ""
+			if len(args) > 0 {
+				query = args[0]
+			}
+
+			entry, err := a.vault.FindPassword(query, vaultKey)
+			if err != nil {
+				return err
+			}
+
+			revisions, err := a.vault.PasswordHistory(entry.UUID, vaultKey)
+			if err != nil {
+				return fail(err, "reading history")
+			}
+
+			if len(revisions) == 0 {
+				fmt.Fprintf(a.prose(), "No previous versions of %s.\n", entryTitle(entry))
+				return nil
+			}
+
+			fmt.Fprintf(a.prose(), "Previous versions of %s, newest first:\n", entryTitle(entry))
+
+			schema := schemaOf(entry)
+			for i, revision := range revisions {
+				fmt.Fprintf(a.prose(), "\n%d. Saved %s, replaced %s\n", i+1, revision.SavedAt, revision.ReplacedAt)
+				if schema.UsernameLabel != "" {
+					fmt.Fprintf(a.prose(), "   %s: %s\n", schema.UsernameLabel, revision.Username)
+				}
+
+				if show {
+					password, err := internal.DecryptPassword(revision.EncryptedPassword, vaultKey, entry.UUID)
+					if err != nil {
+						return fail(err, "decrypting password")
+					}
+					fmt.Fprintf(a.prose(), "   %s: %s\n", schema.SecretLabel, password.Bytes())
+					password.Destroy()
+				} else {
+					fmt.Fprintf(a.prose(), "   %s: %s\n", schema.SecretLabel, maskedValue)
+				}
+
+				if revision.Notes != "" {
+					fmt.Fprintf(a.prose(), "   Notes: %s\n", revision.Notes)
+				}
+
+				for _, field := range revision.Fields {
+					label := field.Name
+					if typeField, ok := schema.Field(field.Name); ok {
+						label = typeField.Label
+					}
+
+					if !show || !field.Hidden {
+						fmt.Fprintf(a.prose(), "   %s: %s\n", label, displayFieldValue(field))
+						continue
+					}
+
+					value, err := internal.DecryptField(field, vaultKey, entry.UUID)
+					if err != nil {
+						return fail(err, "decrypting field")
+					}
+					fmt.Fprintf(a.prose(), "   %s: %s\n", label, value.Bytes())
+					value.Destroy()
+				}
+			}
+
+			fmt.Fprintln(a.prose(), "\nRoll back with 'passvault restore-version <alias|query> <number>'.")
+			return nil
+		}),
+	}
+
+	historyCmd.Flags().Bool("show", false, "Show previous passwords and hidden fields in plaintext")
+	historyCmd.Flags().Int("limit", internal.DefaultHistoryLimit, "Number of previous versions to keep per entry (0 turns history off)")
+
+	return historyCmd
+}
+
+func newRestoreVersionCmd(a *app) *cobra.Command {
+	restoreVersionCmd := &cobra.Command{
+		Use:   "restore-version <alias|query> [number]",
+		Short: "Roll a password entry back to a previous version",
+		Long: `Restore the password, username, notes and custom fields of an entry from a previous version,
+numbered as listed by 'passvault history' (1, the most recent, by default). The current
+version is kept in the history, so a restore can be undone.`,
+		Args: cobra.RangeArgs(1, 2),
+		RunE: a.run(func(cmd *cobra.Command, args []string) error {
+			number := 1
+			if len(args) == 2 {
+				var err error
+				number, err = strconv.Atoi(args[1])
+				if err != nil || number < 1 {
+					return usageError(fmt.Errorf("invalid version number %q", args[1]))
+				}
+			}
+
+			vaultKey, err := a.vault.Unlock()
+			if err != nil {
+				return authError(err)
+			}
+			defer vaultKey.Destroy()
+
+			entry, err := a.vault.FindPassword(args[0], vaultKey)
+			if err != nil {
+				return err
+			}
+
+			revisions, err := a.vault.PasswordHistory(entry.UUID, vaultKey)
+			if err != nil {
+				return fail(err, "reading history")
+			}
+
+			if number > len(revisions) {
+				return fmt.Errorf("%s has %d previous versions", entryTitle(entry), len(revisions))
+			}
+			revision := revisions[number-1]
+
+			fmt.Fprintf(a.prose(), "\nRestoring %s to the version saved %s:\n", entryTitle(entry), revision.SavedAt)
+			fmt.Fprintf(a.prose(), "Username: %s\n", revision.Username)
+			if revision.Notes != "" {
+				fmt.Fprintf(a.prose(), "Notes: %s\n", revision.Notes)
+			}
+			fmt.Fprint(a.prose(), "\nRestore this version? (yes/no): ")
+
+			confirmation, err := internal.PromptString("")
+			if err != nil {
+				return fail(err, "reading confirmation")
+			}
+
+			if strings.ToLower(strings.TrimSpace(confirmation)) != "yes" {
+				fmt.Fprintln(a.prose(), "Restore cancelled.")
+				return nil
+			}
+
+			if err := a.vault.RestoreRevision(*entry, revision, vaultKey); err != nil {
+				return fail(err, "restoring version")
+			}
+
+			fmt.Fprintf(a.prose(), "\n✓ %s (%s) restored. The replaced version is kept in its history.\n", entry.Service, revision.Username)
+			return nil
+		}),
+	}
+
+	return restoreVersionCmd
+}
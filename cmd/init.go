@@ -0,0 +1,36 @@
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/anmol7470/passvault/internal"
+	"github.com/spf13/cobra"
+)
+
+func newInitCmd(a *app) *cobra.Command {
+	initCmd := &cobra.Command{
+		Use:   "init",
+		Short: "Create a new vault",
+		Long: `Create a new vault by setting its master password and choosing the cipher its entries are encrypted with.
+Other commands create a vault with the default cipher on first use.`,
+		RunE: a.run(func(cmd *cobra.Command, args []string) error {
+			cipherName, _ := cmd.Flags().GetString("cipher")
+
+			suite, err := internal.ParseCipherSuite(cipherName)
+			if err != nil {
+				return err
+			}
+
+			if err := a.vault.Init(suite); err != nil {
+				return err
+			}
+
+			fmt.Fprintf(a.prose(), "Vault created. Entries will be encrypted with %s.\n", suite)
+			return nil
+		}),
+	}
+
+	initCmd.Flags().StringP("cipher", "c", internal.DefaultCipherSuite.String(), "Cipher for entries (aes-256-gcm or xchacha20-poly1305)")
+
+	return initCmd
+}
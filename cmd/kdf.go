@@ -0,0 +1,122 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"runtime"
+	"strings"
+	"time"
+
+	"github.com/anmol7470/passvault/internal"
+	"github.com/spf13/cobra"
+)
+
+func newKdfCmd(a *app) *cobra.Command {
+	kdfCmd := &cobra.Command{
+		Use:   "kdf",
+		Short: "Manage key derivation settings",
+		Long:  `Inspect and tune the Argon2id parameters used to protect the master password and vault key.`,
+	}
+
+	kdfCmd.AddCommand(newKdfTuneCmd(a))
+
+	return kdfCmd
+}
+
+func newKdfTuneCmd(a *app) *cobra.Command {
+	kdfTuneCmd := &cobra.Command{
+		Use:   "tune",
+		Short: "Benchmark Argon2id and raise its cost parameters",
+		Long: `Benchmark Argon2id on this machine, propose memory/time/threads parameters that
+hit the target unlock latency, and re-wrap the master password hash and vault key with them.`,
+		RunE: a.run(func(cmd *cobra.Command, args []string) error {
+			target, _ := cmd.Flags().GetDuration("target")
+			maxMemoryMB, _ := cmd.Flags().GetUint32("max-memory")
+			threads, _ := cmd.Flags().GetUint8("threads")
+			skipConfirm, _ := cmd.Flags().GetBool("yes")
+			allowWeaker, _ := cmd.Flags().GetBool("allow-weaker")
+
+			if target <= 0 {
+				return usageError(errors.New("target must be positive"))
+			}
+			if maxMemoryMB < 64 || maxMemoryMB > 4096 {
+				return usageError(errors.New("max-memory must be between 64 and 4096 MB"))
+			}
+			masterPassword, err := a.vault.PromptMasterPassword()
+			if err != nil {
+				return authError(err)
+			}
+			defer masterPassword.Destroy()
+
+			vaultKey, err := a.vault.OpenVaultKey(masterPassword)
+			if err != nil {
+				return fail(authError(err), "unlocking vault")
+			}
+			defer vaultKey.Destroy()
+
+			hashParams, keyParams, err := a.vault.CurrentKDFParams()
+			if err != nil {
+				return fail(err, "reading key derivation parameters")
+			}
+
+			fmt.Fprintln(a.prose(), "\nCurrent parameters")
+			fmt.Fprintf(a.prose(), "Master password hash: %s (%s)\n", formatArgon2Params(hashParams), internal.BenchmarkArgon2(hashParams).Round(time.Millisecond))
+			fmt.Fprintf(a.prose(), "Vault key wrapping:   %s (%s)\n", formatArgon2Params(keyParams), internal.BenchmarkArgon2(keyParams).Round(time.Millisecond))
+
+			// The search starts from the stronger of the current parameters
+			floor := internal.Argon2Params{
+				Time:    max(hashParams.Time, keyParams.Time),
+				Memory:  max(hashParams.Memory, keyParams.Memory),
+				Threads: max(hashParams.Threads, keyParams.Threads),
+			}
+			if threads == 0 {
+				threads = max(uint8(min(runtime.NumCPU(), 255)), floor.Threads)
+			}
+
+			// Unlocking runs two derivations: verifying the hash and unwrapping the vault key
+			fmt.Fprintf(a.prose(), "\nBenchmarking for a %s unlock...\n", target)
+			proposed, elapsed := internal.TuneArgon2(target/2, maxMemoryMB*1024, threads, floor)
+
+			fmt.Fprintln(a.prose(), "\nProposed parameters")
+			fmt.Fprintf(a.prose(), "%s (%s per derivation, ~%s per unlock)\n", formatArgon2Params(proposed), elapsed.Round(time.Millisecond), (2 * elapsed).Round(time.Millisecond))
+
+			if lowered := proposed.LoweredFrom(floor); len(lowered) > 0 {
+				if !allowWeaker {
+					return fmt.Errorf("the proposed parameters would weaken the vault by lowering its %s. Use --allow-weaker to apply them anyway", strings.Join(lowered, ", "))
+				}
+				fmt.Fprintf(a.prose(), "\n⚠️  The proposed parameters weaken the vault by lowering its %s.\n", strings.Join(lowered, ", "))
+			}
+
+			if !skipConfirm {
+				fmt.Fprint(a.prose(), "\nApply these parameters? (yes/no): ")
+				confirm, err := internal.PromptString("")
+				if err != nil {
+					return fail(err, "reading confirmation")
+				}
+				if strings.ToLower(strings.TrimSpace(confirm)) != "yes" {
+					fmt.Fprintln(a.prose(), "Tuning cancelled.")
+					return nil
+				}
+			}
+
+			if err := a.vault.SetKDFParams(vaultKey, masterPassword, proposed); err != nil {
+				return fail(err, "saving parameters")
+			}
+
+			fmt.Fprintln(a.prose(), "\n✓ Key derivation parameters updated successfully!")
+			return nil
+		}),
+	}
+
+	kdfTuneCmd.Flags().Duration("target", time.Second, "Target unlock latency")
+	kdfTuneCmd.Flags().Uint32("max-memory", 1024, "Maximum Argon2 memory in MB")
+	kdfTuneCmd.Flags().Uint8("threads", 0, "Argon2 parallelism (defaults to the number of CPUs)")
+	kdfTuneCmd.Flags().BoolP("yes", "y", false, "Apply the proposed parameters without asking")
+	kdfTuneCmd.Flags().Bool("allow-weaker", false, "Apply parameters that lower the memory, time or threads of the current ones")
+
+	return kdfTuneCmd
+}
+
+func formatArgon2Params(params internal.Argon2Params) string {
+	return fmt.Sprintf("memory=%d MB, time=%d, threads=%d", params.Memory/1024, params.Time, params.Threads)
+}
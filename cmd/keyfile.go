@@ -0,0 +1,143 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/anmol7470/passvault/internal"
+	"github.com/spf13/cobra"
+)
+
+func newKeyfileCmd(a *app) *cobra.Command {
+	keyfileCmd := &cobra.Command{
+		Use:   "keyfile",
+		Short: "Manage the key file required to unlock the vault",
+		Long: `Manage an optional key file that is combined with the master password to unlock the vault.
+Once a key file is set, the master password alone is no longer enough: pass the file with
+--keyfile or set PASSVAULT_KEYFILE every time the vault is unlocked.`,
+	}
+
+	keyfileCmd.AddCommand(newKeyfileCreateCmd(a))
+	keyfileCmd.AddCommand(newKeyfileRemoveCmd(a))
+	keyfileCmd.AddCommand(newKeyfileStatusCmd(a))
+
+	return keyfileCmd
+}
+
+func newKeyfileCreateCmd(a *app) *cobra.Command {
+	keyfileCreateCmd := &cobra.Command{
+		Use:   "create <path>",
+		Short: "Generate a key file and require it to unlock the vault",
+		Args:  cobra.ExactArgs(1),
+		RunE: a.run(func(cmd *cobra.Command, args []string) error {
+			path := args[0]
+
+			required, err := a.vault.IsKeyFileRequired()
+			if err != nil {
+				return err
+			}
+			if required {
+				return errors.New("this vault already requires a key file; run 'passvault keyfile remove' first")
+			}
+
+			password, keyFile, err := a.vault.PromptMasterCredentials()
+			if err != nil {
+				return authError(err)
+			}
+			defer password.Destroy()
+
+			masterSecret := internal.ComposeMasterSecret(password, keyFile)
+			internal.Wipe(keyFile)
+
+			vaultKey, err := a.vault.OpenVaultKey(masterSecret)
+			masterSecret.Destroy()
+			if err != nil {
+				return fail(authError(err), "unlocking vault")
+			}
+			defer vaultKey.Destroy()
+
+			newKeyFile, err := internal.CreateKeyFile(path)
+			if err != nil {
+				return err
+			}
+			defer internal.Wipe(newKeyFile)
+
+			if err := a.vault.SetKeyFile(vaultKey, password, newKeyFile); err != nil {
+				os.Remove(path)
+				return fail(fmt.Errorf("%w. The vault has not been modified", err), "updating vault")
+			}
+
+			fmt.Fprintf(a.prose(), "✓ Key file written to %s\n", path)
+			fmt.Fprintln(a.prose(), "The vault now requires this file to unlock. Pass it with --keyfile or set PASSVAULT_KEYFILE.")
+			fmt.Fprintln(a.prose(), "Keep a backup somewhere safe: without it the vault cannot be opened.")
+			return nil
+		}),
+	}
+
+	return keyfileCreateCmd
+}
+
+func newKeyfileRemoveCmd(a *app) *cobra.Command {
+	keyfileRemoveCmd := &cobra.Command{
+		Use:   "remove",
+		Short: "Stop requiring a key file to unlock the vault",
+		RunE: a.run(func(cmd *cobra.Command, args []string) error {
+			required, err := a.vault.IsKeyFileRequired()
+			if err != nil {
+				return err
+			}
+			if !required {
+				fmt.Fprintln(a.prose(), "This vault does not use a key file.")
+				return nil
+			}
+
+			password, keyFile, err := a.vault.PromptMasterCredentials()
+			if err != nil {
+				return authError(err)
+			}
+			defer password.Destroy()
+
+			masterSecret := internal.ComposeMasterSecret(password, keyFile)
+			internal.Wipe(keyFile)
+
+			vaultKey, err := a.vault.OpenVaultKey(masterSecret)
+			masterSecret.Destroy()
+			if err != nil {
+				return fail(authError(err), "unlocking vault")
+			}
+			defer vaultKey.Destroy()
+
+			if err := a.vault.SetKeyFile(vaultKey, password, nil); err != nil {
+				return fail(err, "updating vault")
+			}
+
+			fmt.Fprintln(a.prose(), "✓ The vault no longer requires a key file. The master password alone unlocks it.")
+			return nil
+		}),
+	}
+
+	return keyfileRemoveCmd
+}
+
+func newKeyfileStatusCmd(a *app) *cobra.Command {
+	keyfileStatusCmd := &cobra.Command{
+		Use:   "status",
+		Short: "Show whether the vault requires a key file",
+		RunE: a.run(func(cmd *cobra.Command, args []string) error {
+			required, err := a.vault.IsKeyFileRequired()
+			if err != nil {
+				return err
+			}
+
+			if required {
+				fmt.Fprintln(a.prose(), "Key file: required")
+			} else {
+				fmt.Fprintln(a.prose(), "Key file: not required")
+			}
+			return nil
+		}),
+	}
+
+	return keyfileStatusCmd
+}
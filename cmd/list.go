@@ -2,8 +2,8 @@ package cmd
 
 import (
 	"fmt"
-	"os"
 	"strings"
+	"time"
 
 	"github.com/anmol7470/passvault/internal"
 	tea "github.com/charmbracelet/bubbletea"
@@ -11,56 +11,111 @@ import (
 	"github.com/spf13/cobra"
 )
 
-var listCmd = &cobra.Command{
-	Use:   "list",
-	Short: "List all stored passwords interactively",
-	Long:  `Display an interactive list of all stored passwords with search functionality.`,
-	Run: func(cmd *cobra.Command, args []string) {
-		masterPassword, err := internal.PromptMasterPassword()
-		if err != nil {
-			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
-			os.Exit(1)
-		}
+func newListCmd(a *app) *cobra.Command {
+	listCmd := &cobra.Command{
+		Use:   "list",
+		Short: "List all stored passwords interactively",
+		Long: `Display an interactive list of all stored passwords with search functionality.
+In the search, #tag shows entries with a tag and /folder entries in a folder.
 
-		entries, err := internal.ListAllPasswords()
-		if err != nil {
-			fmt.Fprintf(os.Stderr, "Error loading passwords: %v\n", err)
-			os.Exit(1)
-		}
+With --output json or yaml, the entries are written out instead, without their passwords
+or the values of hidden fields.`,
+		RunE: a.run(func(cmd *cobra.Command, args []string) error {
+			vaultKey, err := a.vault.Unlock()
+			if err != nil {
+				return authError(err)
+			}
+			defer vaultKey.Destroy()
 
-		if len(entries) == 0 {
-			fmt.Println("No passwords stored yet. Use 'passvault add' to add one.")
-			return
-		}
+			filter, err := entryFilterFromFlags(cmd)
+			if err != nil {
+				return usageError(err)
+			}
 
-		p := tea.NewProgram(initialListModel(entries, masterPassword))
-		if _, err := p.Run(); err != nil {
-			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
-			os.Exit(1)
-		}
-	},
+			entries, err := a.vault.FilterPasswords(filter, vaultKey)
+			if err != nil {
+				return fail(err, "loading passwords")
+			}
+
+			if len(entries) == 0 && !a.structured() {
+				if !filter.IsEmpty() {
+					fmt.Fprintln(a.prose(), "No passwords match the given tags, folder and type.")
+					return nil
+				}
+				fmt.Fprintln(a.prose(), "No passwords stored yet. Use 'passvault add' to add one.")
+				return nil
+			}
+
+			attachments, err := a.vault.AttachmentCounts()
+			if err != nil {
+				return fail(err, "loading attachments")
+			}
+
+			policies, err := a.vault.RotationPolicies(vaultKey)
+			if err != nil {
+				return err
+			}
+
+			if a.structured() {
+				output := listOutput{Entries: []entryOutput{}}
+				for i := range entries {
+					output.Entries = append(output.Entries, newEntryOutput(&entries[i], attachments[entries[i].UUID], policies))
+				}
+				return a.render(output)
+			}
+
+			p := tea.NewProgram(initialListModel(entries, attachments, policies, vaultKey))
+			if _, err := p.Run(); err != nil {
+				return err
+			}
+			return nil
+		}),
+	}
+
+	addEntryFilterFlags(listCmd)
+
+	return listCmd
+}
+
+// listOutput is what list writes in structured output
+type listOutput struct {
+	Entries []entryOutput `json:"entries"`
 }
 
 type listModel struct {
-	entries        []internal.PasswordEntry
-	filteredItems  []internal.PasswordEntry
-	cursor         int
-	searchQuery    string
-	masterPassword string
-	viewMode       string
-	selectedEntry  *internal.PasswordEntry
-	decryptedPass  string
-	err            error
+	entries       []internal.PasswordEntry
+	attachments   map[string]int // number of attachments by entry UUID
+	policies      map[string]int // rotation policies of tags
+	filteredItems []internal.PasswordEntry
+	cursor        int
+	searchQuery   string
+	vaultKey      *internal.VaultKey
+	viewMode      string
+	selectedEntry *internal.PasswordEntry
+	decryptedPass *internal.SecretBuffer
+	fieldValues   []*internal.SecretBuffer // decrypted custom fields, while revealed
+	totp          *internal.TOTP
+	totpTicks     int // identifies the tick loop of the entry being shown
+	err           error
 }
 
-func initialListModel(entries []internal.PasswordEntry, masterPassword string) listModel {
+// totpTickMsg redraws the TOTP code of the entry being shown
+type totpTickMsg int
+
+func totpTick(id int) tea.Cmd {
+	return tea.Tick(time.Second, func(time.Time) tea.Msg { return totpTickMsg(id) })
+}
+
+func initialListModel(entries []internal.PasswordEntry, attachments, policies map[string]int, vaultKey *internal.VaultKey) listModel {
 	return listModel{
-		entries:        entries,
-		filteredItems:  entries,
-		cursor:         0,
-		searchQuery:    "",
-		masterPassword: masterPassword,
-		viewMode:       "list",
+		entries:       entries,
+		attachments:   attachments,
+		policies:      policies,
+		filteredItems: entries,
+		cursor:        0,
+		searchQuery:   "",
+		vaultKey:      vaultKey,
+		viewMode:      "list",
 	}
 }
 
@@ -70,6 +125,10 @@ func (m listModel) Init() tea.Cmd {
 
 func (m listModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 	switch msg := msg.(type) {
+	case totpTickMsg:
+		if m.viewMode == "detail" && m.totp != nil && int(msg) == m.totpTicks {
+			return m, totpTick(m.totpTicks)
+		}
 	case tea.KeyMsg:
 		switch m.viewMode {
 		case "list":
@@ -87,13 +146,29 @@ func (m listModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 			case "enter":
 				if len(m.filteredItems) > 0 {
 					selected := m.filteredItems[m.cursor]
-					decrypted, err := internal.DecryptPassword(selected.EncryptedPassword, m.masterPassword)
+					decrypted, err := internal.DecryptPassword(selected.EncryptedPassword, m.vaultKey, selected.UUID)
 					if err != nil {
 						m.err = err
-					} else {
-						m.selectedEntry = &selected
-						m.decryptedPass = decrypted
-						m.viewMode = "detail"
+						break
+					}
+
+					var totp *internal.TOTP
+					if selected.EncryptedTOTP != "" {
+						totp, err = internal.DecryptTOTP(selected.EncryptedTOTP, m.vaultKey, selected.UUID)
+						if err != nil {
+							decrypted.Destroy()
+							m.err = err
+							break
+						}
+					}
+
+					m.selectedEntry = &selected
+					m.decryptedPass = decrypted
+					m.viewMode = "detail"
+					if totp != nil {
+						m.totp = totp
+						m.totpTicks++
+						return m, totpTick(m.totpTicks)
 					}
 				}
 			case "backspace":
@@ -117,11 +192,24 @@ func (m listModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 		case "detail":
 			switch msg.String() {
 			case "ctrl+c", "q":
+				m.decryptedPass.Destroy()
+				m.totp.Destroy()
+				m.hideFields()
 				return m, tea.Quit
+			case "r":
+				if m.fieldValues != nil {
+					m.hideFields()
+				} else {
+					m.revealFields()
+				}
 			case "enter", "backspace", "esc":
 				m.viewMode = "list"
 				m.selectedEntry = nil
-				m.decryptedPass = ""
+				m.decryptedPass.Destroy()
+				m.decryptedPass = nil
+				m.totp.Destroy()
+				m.totp = nil
+				m.hideFields()
 				m.err = nil
 			}
 		}
@@ -129,15 +217,47 @@ func (m listModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 	return m, nil
 }
 
+// revealFields decrypts the custom fields of the selected entry for display
+func (m *listModel) revealFields() {
+	values := make([]*internal.SecretBuffer, len(m.selectedEntry.Fields))
+	for i, field := range m.selectedEntry.Fields {
+		value, err := internal.DecryptField(field, m.vaultKey, m.selectedEntry.UUID)
+		if err != nil {
+			for _, value := range values {
+				value.Destroy()
+			}
+			m.err = err
+			return
+		}
+		values[i] = value
+	}
+	m.fieldValues = values
+}
+
+// hideFields wipes the decrypted custom fields
+func (m *listModel) hideFields() {
+	for _, value := range m.fieldValues {
+		value.Destroy()
+	}
+	m.fieldValues = nil
+}
+
 func (m *listModel) filterItems() {
 	if m.searchQuery == "" {
 		m.filteredItems = m.entries
 		return
 	}
 
+	// #tag and /folder words narrow the list down; the rest is matched as text
+	filter := internal.ParseEntryFilter(m.searchQuery)
+	query := strings.ToLower(filter.Query)
+	filter.Query = ""
+
 	var filtered []internal.PasswordEntry
-	query := strings.ToLower(m.searchQuery)
 	for _, entry := range m.entries {
+		if !filter.Matches(entry) {
+			continue
+		}
 		if strings.Contains(strings.ToLower(entry.Service), query) ||
 			strings.Contains(strings.ToLower(entry.Username), query) ||
 			strings.Contains(strings.ToLower(entry.Alias), query) {
@@ -156,13 +276,16 @@ func (m listModel) View() string {
 
 func (m listModel) renderList() string {
 	mutedStyle := lipgloss.NewStyle().Foreground(lipgloss.Color("241"))
+	dueSoonStyle := lipgloss.NewStyle().Foreground(lipgloss.Color("214"))
+	overdueStyle := lipgloss.NewStyle().Foreground(lipgloss.Color("196"))
+	now := time.Now()
 
 	var s strings.Builder
 
 	if m.searchQuery != "" {
 		s.WriteString(fmt.Sprintf("Search: %s\n\n", m.searchQuery))
 	} else {
-		s.WriteString(mutedStyle.Render("Type to search...") + "\n\n")
+		s.WriteString(mutedStyle.Render("Type to search... (#tag and /folder filter)") + "\n\n")
 	}
 
 	if m.err != nil {
@@ -177,11 +300,39 @@ func (m listModel) renderList() string {
 			if i == m.cursor {
 				cursor = ">"
 			}
+			line := fmt.Sprintf("%s %s", cursor, entryTitle(&entry))
 			if entry.Alias != "" {
-				s.WriteString(fmt.Sprintf("%s %s (%s) [%s]\n", cursor, entry.Service, entry.Username, entry.Alias))
-			} else {
-				s.WriteString(fmt.Sprintf("%s %s (%s)\n", cursor, entry.Service, entry.Username))
+				line += fmt.Sprintf(" [%s]", entry.Alias)
+			}
+
+			var details []string
+			if entry.Type != internal.TypeLogin {
+				details = append(details, schemaOf(&entry).Description)
+			}
+			if entry.Folder != "" {
+				details = append(details, entry.Folder)
+			}
+			if len(entry.Tags) > 0 {
+				details = append(details, formatTags(entry.Tags))
+			}
+			if count := m.attachments[entry.UUID]; count > 0 {
+				details = append(details, formatAttachmentCount(count))
+			}
+			if len(details) > 0 {
+				line += " " + mutedStyle.Render(strings.Join(details, " "))
 			}
+			if rotation, ok := internal.RotationOf(entry, m.policies); ok {
+				daysLeft := rotation.DaysLeft(now)
+				badge := mutedStyle
+				switch {
+				case daysLeft < 0:
+					badge = overdueStyle
+				case daysLeft <= internal.DueSoonDays:
+					badge = dueSoonStyle
+				}
+				line += " " + badge.Render(formatDue(daysLeft))
+			}
+			s.WriteString(line + "\n")
 		}
 	}
 
@@ -196,24 +347,23 @@ func (m listModel) renderDetail() string {
 
 	var s strings.Builder
 
-	s.WriteString("Password Details\n\n")
+	s.WriteString(entryNoun(schemaOf(m.selectedEntry)) + " Details\n\n")
 
-	s.WriteString(fmt.Sprintf("Service: %s\n", m.selectedEntry.Service))
-	s.WriteString(fmt.Sprintf("Username: %s\n", m.selectedEntry.Username))
-	s.WriteString(fmt.Sprintf("Password: %s\n", m.decryptedPass))
-	if m.selectedEntry.Notes != "" {
-		s.WriteString(fmt.Sprintf("Notes: %s\n", m.selectedEntry.Notes))
+	writeEntryDetails(&s, m.selectedEntry, m.decryptedPass.Bytes(), m.totp, m.fieldValues)
+	writeRotation(&s, m.selectedEntry, m.policies)
+	if count := m.attachments[m.selectedEntry.UUID]; count > 0 {
+		s.WriteString(fmt.Sprintf("\nAttachments: %s (see 'passvault attach list')\n", formatAttachmentCount(count)))
 	}
-	if m.selectedEntry.Alias != "" {
-		s.WriteString(fmt.Sprintf("Alias: %s\n", m.selectedEntry.Alias))
+	if m.err != nil {
+		s.WriteString(fmt.Sprintf("\nError: %v\n", m.err))
 	}
 
 	s.WriteString("\n")
-	s.WriteString(mutedStyle.Render("Press enter to go back to list • q to quit"))
+	if len(m.selectedEntry.Fields) > 0 {
+		s.WriteString(mutedStyle.Render("Press enter to go back to list • r to reveal fields • q to quit"))
+	} else {
+		s.WriteString(mutedStyle.Render("Press enter to go back to list • q to quit"))
+	}
 
 	return s.String()
 }
-
-func init() {
-	rootCmd.AddCommand(listCmd)
-}
@@ -0,0 +1,101 @@
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/spf13/cobra"
+)
+
+func newMetadataCmd(a *app) *cobra.Command {
+	metadataCmd := &cobra.Command{
+		Use:   "metadata",
+		Short: "Manage encryption of entry metadata",
+		Long: `Manage whether entry types, service names, usernames, notes, aliases, tags, folders and URLs are stored encrypted.
+By default only passwords are encrypted; enabling metadata encryption seals the remaining fields too.`,
+	}
+
+	metadataCmd.AddCommand(newMetadataStatusCmd(a))
+	metadataCmd.AddCommand(newMetadataEnableCmd(a))
+	metadataCmd.AddCommand(newMetadataDisableCmd(a))
+
+	return metadataCmd
+}
+
+func newMetadataStatusCmd(a *app) *cobra.Command {
+	metadataStatusCmd := &cobra.Command{
+		Use:   "status",
+		Short: "Show whether entry metadata is encrypted",
+		RunE: a.run(func(cmd *cobra.Command, args []string) error {
+			encrypted, err := a.vault.IsMetadataEncrypted()
+			if err != nil {
+				return err
+			}
+
+			if encrypted {
+				fmt.Fprintln(a.prose(), "Metadata encryption: enabled")
+			} else {
+				fmt.Fprintln(a.prose(), "Metadata encryption: disabled")
+			}
+			return nil
+		}),
+	}
+
+	return metadataStatusCmd
+}
+
+func newMetadataEnableCmd(a *app) *cobra.Command {
+	metadataEnableCmd := &cobra.Command{
+		Use:   "enable",
+		Short: "Encrypt service, username, notes, alias, tags, folder and URLs of every entry",
+		RunE: a.run(func(cmd *cobra.Command, args []string) error {
+			return setMetadataEncryption(a, true)
+		}),
+	}
+
+	return metadataEnableCmd
+}
+
+func newMetadataDisableCmd(a *app) *cobra.Command {
+	metadataDisableCmd := &cobra.Command{
+		Use:   "disable",
+		Short: "Store service, username, notes, alias, tags, folder and URLs in plaintext again",
+		RunE: a.run(func(cmd *cobra.Command, args []string) error {
+			return setMetadataEncryption(a, false)
+		}),
+	}
+
+	return metadataDisableCmd
+}
+
+func setMetadataEncryption(a *app, enabled bool) error {
+	vaultKey, err := a.vault.Unlock()
+	if err != nil {
+		return authError(err)
+	}
+	defer vaultKey.Destroy()
+
+	encrypted, err := a.vault.IsMetadataEncrypted()
+	if err != nil {
+		return err
+	}
+
+	if encrypted == enabled {
+		if enabled {
+			fmt.Fprintln(a.prose(), "Metadata encryption is already enabled.")
+		} else {
+			fmt.Fprintln(a.prose(), "Metadata encryption is already disabled.")
+		}
+		return nil
+	}
+
+	if err := a.vault.SetMetadataEncryption(enabled, vaultKey); err != nil {
+		return fail(err, "updating entries")
+	}
+
+	if enabled {
+		fmt.Fprintln(a.prose(), "✓ Metadata encryption enabled. All entries have been re-encrypted.")
+	} else {
+		fmt.Fprintln(a.prose(), "✓ Metadata encryption disabled. All entries have been rewritten in plaintext.")
+	}
+	return nil
+}
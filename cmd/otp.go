@@ -0,0 +1,74 @@
+package cmd
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/anmol7470/passvault/internal"
+	"github.com/atotto/clipboard"
+	"github.com/spf13/cobra"
+)
+
+func newOtpCmd(a *app) *cobra.Command {
+	otpCmd := &cobra.Command{
+		Use:   "otp [alias|query]",
+		Short: "Print and copy the current TOTP code of an entry",
+		Long: `Print the current time-based one-time code of an entry and copy it to the clipboard.
+Add a TOTP secret to an entry with 'passvault add --totp' or 'passvault update --totp'.`,
+		Args: cobra.MaximumNArgs(1),
+		RunE: a.run(func(cmd *cobra.Command, args []string) error {
+			noCopy, _ := cmd.Flags().GetBool("no-copy")
+
+			vaultKey, err := a.vault.Unlock()
+			if err != nil {
+				return authError(err)
+			}
+			defer vaultKey.Destroy()
+
+			query := ""
+			if len(args) > 0 {
+				query = args[0]
+			}
+
+			entry, err := a.vault.FindPassword(query, vaultKey)
+			if err != nil {
+				return err
+			}
+
+			if entry.EncryptedTOTP == "" {
+				return fmt.Errorf("%s has no TOTP secret", entryTitle(entry))
+			}
+
+			totp, err := internal.DecryptTOTP(entry.EncryptedTOTP, vaultKey, entry.UUID)
+			if err != nil {
+				return err
+			}
+			defer totp.Destroy()
+
+			now := time.Now()
+			code := totp.Code(now)
+			fmt.Fprintf(a.prose(), "%s (valid for %s)\n", code, totp.Remaining(now))
+
+			if noCopy {
+				return nil
+			}
+
+			if err := clipboard.WriteAll(code); err != nil {
+				return fail(err, "copying to clipboard")
+			}
+			fmt.Fprintf(a.prose(), "✓ Code for %s copied to clipboard!\n", entryTitle(entry))
+			return nil
+		}),
+	}
+
+	otpCmd.Flags().Bool("no-copy", false, "Only print the code")
+
+	return otpCmd
+}
+
+// formatTOTPCode returns the current code of a TOTP with the seconds it
+// stays valid for
+func formatTOTPCode(totp *internal.TOTP) string {
+	now := time.Now()
+	return fmt.Sprintf("%s (%ds)", totp.Code(now), int(totp.Remaining(now).Seconds()))
+}
@@ -0,0 +1,538 @@
+package cmd
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/anmol7470/passvault/internal"
+	"github.com/spf13/cobra"
+	"gopkg.in/yaml.v3"
+)
+
+// Commands write prose for people unless --output asks for json or yaml.
+// Then list, get, audit, add, update, delete and export write a single
+// document to standard output, and everything else they write, prompts
+// included, goes to standard error. They also stop asking for what scripts
+// cannot answer: values come from flags, and a search that matches several
+// entries fails instead of showing the selection list. Errors are written
+// as a document on standard error, and exit codes tell the kinds of failure
+// apart in every output format.
+
+// outputFormat is how commands write their results
+type outputFormat string
+
+const (
+	outputText outputFormat = "text"
+	outputJSON outputFormat = "json"
+	outputYAML outputFormat = "yaml"
+)
+
+// parseOutputFormat returns the output format named by s; empty means text
+func parseOutputFormat(s string) (outputFormat, error) {
+	switch format := outputFormat(strings.ToLower(strings.TrimSpace(s))); format {
+	case "":
+		return outputText, nil
+	case outputText, outputJSON, outputYAML:
+		return format, nil
+	}
+	return "", fmt.Errorf("unknown output format %q (use text, json or yaml)", s)
+}
+
+// requestedOutput returns the output format asked for in args or by
+// PASSVAULT_OUTPUT, for errors cobra reports before the flags are parsed.
+// An unknown format is left for the command to report.
+func requestedOutput(args []string) outputFormat {
+	value := os.Getenv("PASSVAULT_OUTPUT")
+	for i, arg := range args {
+		if arg == "--" {
+			break
+		}
+		if v, ok := strings.CutPrefix(arg, "--output="); ok {
+			value = v
+		} else if arg == "--output" && i+1 < len(args) {
+			value = args[i+1]
+		}
+	}
+
+	format, err := parseOutputFormat(value)
+	if err != nil {
+		return outputText
+	}
+	return format
+}
+
+// Exit codes of the commands that report errors by kind
+const (
+	exitError     = 1 // any other failure
+	exitUsage     = 2 // an invalid command line, flag or value
+	exitNotFound  = 3 // no entry matched
+	exitAmbiguous = 4 // several entries matched where one was needed
+	exitAuth      = 5 // the vault could not be unlocked
+	exitConflict  = 6 // another entry has the same account or alias
+)
+
+// errorCodes name the exit codes in structured errors
+var errorCodes = map[int]string{
+	exitError:     "error",
+	exitUsage:     "usage",
+	exitNotFound:  "not_found",
+	exitAmbiguous: "ambiguous",
+	exitAuth:      "auth",
+	exitConflict:  "conflict",
+}
+
+// commandError is an error with the exit code of its kind
+type commandError struct {
+	exit int
+	err  error
+}
+
+func (e *commandError) Error() string { return e.err.Error() }
+func (e *commandError) Unwrap() error { return e.err }
+
+func usageError(err error) error { return &commandError{exit: exitUsage, err: err} }
+func authError(err error) error  { return &commandError{exit: exitAuth, err: err} }
+
+// exitCode returns the exit code for err
+func exitCode(err error) int {
+	var commandErr *commandError
+	switch {
+	case errors.As(err, &commandErr):
+		return commandErr.exit
+	case errors.Is(err, internal.ErrNoEntries):
+		return exitNotFound
+	case errors.Is(err, internal.ErrDuplicateEntry):
+		return exitConflict
+	}
+	return exitError
+}
+
+// missingFlag is the error for a value that is prompted for in text mode but
+// must be given with a flag in structured output
+func (a *app) missingFlag(name string) error {
+	return usageError(fmt.Errorf("--%s is required with --output %s", name, a.output))
+}
+
+// errorOutput is an error in structured output
+type errorOutput struct {
+	Error struct {
+		Code     string `json:"code"`
+		ExitCode int    `json:"exit_code"`
+		Message  string `json:"message"`
+	} `json:"error"`
+}
+
+// writeError writes an error in format to w
+func writeError(w io.Writer, format outputFormat, code int, message string) {
+	if format == outputText {
+		fmt.Fprintf(w, "Error: %s\n", message)
+		return
+	}
+
+	var output errorOutput
+	output.Error.Code = errorCodes[code]
+	output.Error.ExitCode = code
+	output.Error.Message = message
+	if err := writeStructured(w, format, output); err != nil {
+		fmt.Fprintf(w, "Error: %s\n", message)
+	}
+}
+
+// failure is the error of a command, with what the command was doing
+type failure struct {
+	doing string
+	err   error
+}
+
+func (f *failure) Error() string {
+	if f.doing == "" {
+		return f.err.Error()
+	}
+	return f.doing + ": " + f.err.Error()
+}
+
+func (f *failure) Unwrap() error { return f.err }
+
+// fail returns err as the error of a command. In text mode it is written as
+// "Error <doing>: <err>".
+func fail(err error, doing string) error {
+	return &failure{doing: doing, err: err}
+}
+
+// run wraps the RunE of a command. Cobra skips PersistentPostRun when a
+// command fails, so its error is reported and the vault closed here, after
+// the deferred calls of the command have run; Execute then exits with the
+// exit code of the error.
+func (a *app) run(run func(cmd *cobra.Command, args []string) error) func(cmd *cobra.Command, args []string) error {
+	return func(cmd *cobra.Command, args []string) error {
+		err := run(cmd, args)
+		if err == nil {
+			return nil
+		}
+
+		var commandErr *failure
+		if !errors.As(err, &commandErr) {
+			commandErr = &failure{err: err}
+		}
+		cmd.SilenceErrors = true
+		cmd.SilenceUsage = true
+		a.report(commandErr)
+		a.closeVault()
+		return commandErr
+	}
+}
+
+// report writes the error of a command to standard error
+func (a *app) report(f *failure) {
+	code := exitCode(f.err)
+	switch {
+	case a.structured() && f.doing != "":
+		writeError(a.stderr, a.output, code, f.doing+": "+f.err.Error())
+	case a.structured():
+		writeError(a.stderr, a.output, code, f.err.Error())
+	case f.doing != "":
+		fmt.Fprintf(a.stderr, "Error %s: %v\n", f.doing, f.err)
+	default:
+		fmt.Fprintf(a.stderr, "Error: %v\n", f.err)
+	}
+}
+
+// structured reports whether results are written as documents rather than
+// prose
+func (a *app) structured() bool {
+	return a.output != outputText
+}
+
+// prose returns where messages and prompts for people are written: standard
+// output, or standard error when standard output holds a document
+func (a *app) prose() io.Writer {
+	if a.structured() {
+		return a.stderr
+	}
+	return a.stdout
+}
+
+// render writes v to standard output as a document in the output format
+func (a *app) render(v any) error {
+	if err := writeStructured(a.stdout, a.output, v); err != nil {
+		return fail(err, "writing output")
+	}
+	return nil
+}
+
+// writeStructured writes v to w as JSON or YAML. YAML is converted from the
+// JSON, so both have the same keys in the same order.
+func writeStructured(w io.Writer, format outputFormat, v any) error {
+	if format == outputYAML {
+		return writeYAML(w, v)
+	}
+
+	var data bytes.Buffer
+	defer func() { internal.Wipe(data.Bytes()) }()
+	if err := encodeJSON(&data, v); err != nil {
+		return err
+	}
+	_, err := w.Write(data.Bytes())
+	return err
+}
+
+func encodeJSON(w io.Writer, v any) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetEscapeHTML(false)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(v); err != nil {
+		return fmt.Errorf("failed to encode output: %w", err)
+	}
+	return nil
+}
+
+// writeYAML writes v to w as YAML. Parsing the JSON would copy secrets into
+// the strings of the YAML nodes, so they are written as placeholders in it
+// and only put in place, from their bytes, in the finished document.
+func writeYAML(w io.Writer, v any) error {
+	nonce := make([]byte, 12)
+	if _, err := rand.Read(nonce); err != nil {
+		return err
+	}
+	placeholders := &secretPlaceholders{prefix: "passvault-secret-" + hex.EncodeToString(nonce) + "-"}
+	yamlSecrets = placeholders
+	defer func() { yamlSecrets = nil }()
+
+	var data bytes.Buffer
+	if err := encodeJSON(&data, v); err != nil {
+		return err
+	}
+
+	var document yaml.Node
+	if err := yaml.Unmarshal(data.Bytes(), &document); err != nil {
+		return fmt.Errorf("failed to encode output: %w", err)
+	}
+	blockStyle(&document)
+
+	var encoded bytes.Buffer
+	yamlEncoder := yaml.NewEncoder(&encoded)
+	yamlEncoder.SetIndent(2)
+	if err := yamlEncoder.Encode(&document); err != nil {
+		return fmt.Errorf("failed to encode output: %w", err)
+	}
+	if err := yamlEncoder.Close(); err != nil {
+		return fmt.Errorf("failed to encode output: %w", err)
+	}
+
+	output := placeholders.replace(encoded.Bytes())
+	defer internal.Wipe(output)
+	_, err := w.Write(output)
+	return err
+}
+
+// yamlSecrets is set while writeYAML encodes a document, to have secretText
+// write placeholders instead of secrets
+var yamlSecrets *secretPlaceholders
+
+// secretPlaceholders are the secrets of a YAML document and the placeholders
+// standing for them, the prefix followed by their index
+type secretPlaceholders struct {
+	prefix  string
+	secrets []secretText
+}
+
+const placeholderDigits = 8
+
+func (p *secretPlaceholders) add(s secretText) []byte {
+	placeholder := fmt.Appendf(nil, `"%s%0*d"`, p.prefix, placeholderDigits, len(p.secrets))
+	p.secrets = append(p.secrets, s)
+	return placeholder
+}
+
+// replace returns document with each placeholder replaced by its secret as
+// a double-quoted scalar
+func (p *secretPlaceholders) replace(document []byte) []byte {
+	output := make([]byte, 0, len(document))
+	prefix := []byte(p.prefix)
+	for {
+		i := bytes.Index(document, prefix)
+		end := i + len(prefix) + placeholderDigits
+		if i < 0 || end > len(document) {
+			return append(output, document...)
+		}
+
+		index, err := strconv.Atoi(string(document[i+len(prefix) : end]))
+		if err != nil || index >= len(p.secrets) {
+			output = append(output, document[:end]...)
+		} else {
+			output = append(output, document[:i]...)
+			output = p.secrets[index].appendQuoted(output)
+		}
+		document = document[end:]
+	}
+}
+
+// yaml11Bools are strings that YAML 1.1 parsers read as booleans, which are
+// quoted so those parsers read them as strings too
+var yaml11Bools = map[string]bool{"y": true, "yes": true, "n": true, "no": true, "on": true, "off": true}
+
+// blockStyle drops the flow style and quoting a node kept from JSON, so it is
+// written in plain block style; strings that need quotes still get them
+func blockStyle(node *yaml.Node) {
+	node.Style = 0
+	if node.Kind == yaml.ScalarNode && node.Tag == "!!str" && yaml11Bools[strings.ToLower(node.Value)] {
+		node.Style = yaml.DoubleQuotedStyle
+	}
+	for _, child := range node.Content {
+		blockStyle(child)
+	}
+}
+
+// entryOutput is an entry in structured output. The password, the values of
+// hidden fields and the TOTP code are only filled in by get.
+type entryOutput struct {
+	ID                int             `json:"id"`
+	UUID              string          `json:"uuid"`
+	Type              string          `json:"type"`
+	Service           string          `json:"service"`
+	Username          string          `json:"username"`
+	Password          *secretText     `json:"password,omitempty"`
+	HasTOTP           bool            `json:"has_totp"`
+	TOTP              *totpOutput     `json:"totp,omitempty"`
+	Notes             string          `json:"notes"`
+	Alias             string          `json:"alias"`
+	Folder            string          `json:"folder"`
+	Tags              []string        `json:"tags"`
+	URLs              []string        `json:"urls"`
+	URLMatch          string          `json:"url_match"`
+	Fields            []fieldOutput   `json:"fields"`
+	Attachments       int             `json:"attachments"`
+	Rotation          *rotationOutput `json:"rotation"`
+	CreatedAt         string          `json:"created_at"`
+	UpdatedAt         string          `json:"updated_at"`
+	PasswordChangedAt string          `json:"password_changed_at"`
+	DeletedAt         string          `json:"deleted_at,omitempty"` // set once delete moved it to the trash
+}
+
+// secretText is a secret in structured output. It refers to the bytes of a
+// SecretBuffer and is written as a JSON string straight from them, so it is
+// never copied into a Go string, which could not be wiped.
+type secretText []byte
+
+func (s secretText) MarshalJSON() ([]byte, error) {
+	if yamlSecrets != nil {
+		return yamlSecrets.add(s), nil
+	}
+	return s.appendQuoted(make([]byte, 0, len(s)+2)), nil
+}
+
+// appendQuoted appends s to b as a string that is valid in both JSON and
+// YAML, escaping what YAML does not allow to be written as is
+func (s secretText) appendQuoted(b []byte) []byte {
+	b = append(b, '"')
+	for i := 0; i < len(s); {
+		r, size := utf8.DecodeRune(s[i:])
+		switch {
+		case r == utf8.RuneError && size == 1:
+			b = append(b, `\ufffd`...)
+		case r == '"' || r == '\\':
+			b = append(b, '\\', byte(r))
+		case r < 0x20 || r >= 0x7f && r <= 0x9f || r == '\u2028' || r == '\u2029' || r == '\ufeff':
+			b = fmt.Appendf(b, `\u%04x`, r)
+		default:
+			b = append(b, s[i:i+size]...)
+		}
+		i += size
+	}
+	return append(b, '"')
+}
+
+// fieldOutput is a custom field in structured output
+type fieldOutput struct {
+	Name   string      `json:"name"`
+	Hidden bool        `json:"hidden"`
+	Value  *secretText `json:"value,omitempty"`
+}
+
+// totpOutput is the current TOTP code of an entry
+type totpOutput struct {
+	Code      string `json:"code"`
+	ExpiresIn int    `json:"expires_in"` // seconds
+}
+
+// rotationOutput is when the password of an entry is due to be changed
+type rotationOutput struct {
+	Days      int    `json:"days"`
+	Tag       string `json:"tag"` // empty for the entry's own policy
+	ChangedAt string `json:"changed_at"`
+	DueAt     string `json:"due_at"`
+	DaysLeft  int    `json:"days_left"` // negative once overdue
+}
+
+// newEntryOutput describes a decrypted entry without its secrets
+func newEntryOutput(entry *internal.PasswordEntry, attachments int, policies map[string]int) entryOutput {
+	output := entryOutput{
+		ID:                entry.ID,
+		UUID:              entry.UUID,
+		Type:              string(schemaOf(entry).Type),
+		Service:           entry.Service,
+		Username:          entry.Username,
+		HasTOTP:           entry.EncryptedTOTP != "",
+		Notes:             entry.Notes,
+		Alias:             entry.Alias,
+		Folder:            entry.Folder,
+		Tags:              nonNil(entry.Tags),
+		URLs:              nonNil(entry.URLs),
+		Fields:            []fieldOutput{},
+		Attachments:       attachments,
+		Rotation:          newRotationOutput(entry, policies),
+		CreatedAt:         outputTimestamp(entry.CreatedAt),
+		UpdatedAt:         outputTimestamp(entry.UpdatedAt),
+		PasswordChangedAt: outputTimestamp(entry.PasswordChangedAt),
+		DeletedAt:         outputTimestamp(entry.DeletedAt),
+	}
+
+	if len(entry.URLs) > 0 {
+		output.URLMatch = string(internal.MatchDomain)
+		if entry.URLMatch != "" {
+			output.URLMatch = string(entry.URLMatch)
+		}
+	}
+
+	for _, field := range entry.Fields {
+		fieldOut := fieldOutput{Name: field.Name, Hidden: field.Hidden}
+		if !field.Hidden {
+			value := secretText(field.Value)
+			fieldOut.Value = &value
+		}
+		output.Fields = append(output.Fields, fieldOut)
+	}
+
+	return output
+}
+
+// renderSavedEntry writes an entry as it was stored, without its secrets, in
+// structured output
+func renderSavedEntry(a *app, entryUUID string, vaultKey *internal.VaultKey) error {
+	entry, err := a.vault.GetPassword(entryUUID, vaultKey)
+	if err != nil {
+		return err
+	}
+	if entry == nil {
+		return fmt.Errorf("entry %s not found", entryUUID)
+	}
+
+	attachments, err := a.vault.AttachmentCounts()
+	if err != nil {
+		return fail(err, "loading attachments")
+	}
+
+	policies, err := a.vault.RotationPolicies(vaultKey)
+	if err != nil {
+		return err
+	}
+
+	return a.render(newEntryOutput(entry, attachments[entry.UUID], policies))
+}
+
+// newRotationOutput describes the rotation of an entry, or returns nil if no
+// policy applies to it
+func newRotationOutput(entry *internal.PasswordEntry, policies map[string]int) *rotationOutput {
+	rotation, ok := internal.RotationOf(*entry, policies)
+	if !ok {
+		return nil
+	}
+
+	output := &rotationOutput{
+		Days:     rotation.Days,
+		Tag:      rotation.Tag,
+		DueAt:    rotation.DueAt.UTC().Format(time.RFC3339),
+		DaysLeft: rotation.DaysLeft(time.Now()),
+	}
+	if !rotation.ChangedAt.IsZero() {
+		output.ChangedAt = rotation.ChangedAt.UTC().Format(time.RFC3339)
+	}
+	return output
+}
+
+// outputTimestamp writes a time as stored in the vault in RFC 3339, in UTC
+func outputTimestamp(value string) string {
+	t, err := internal.ParseTimestamp(value)
+	if err != nil {
+		return value
+	}
+	return t.UTC().Format(time.RFC3339)
+}
+
+// nonNil returns values, or an empty slice for nil so it is written as []
+func nonNil(values []string) []string {
+	if values == nil {
+		return []string{}
+	}
+	return values
+}
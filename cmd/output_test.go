@@ -0,0 +1,56 @@
+package cmd
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+
+	"gopkg.in/yaml.v3"
+)
+
+func TestWriteStructuredSecrets(t *testing.T) {
+	secrets := []string{
+		"hunter2",
+		`quote " and backslash \`,
+		"tab\tnewline\n",
+		"yes",
+		"ünïcödé   \u0085 \x7f",
+		"",
+	}
+
+	for _, format := range []outputFormat{outputJSON, outputYAML} {
+		for _, secret := range secrets {
+			password := secretText(secret)
+			output := entryOutput{Service: "example", Password: &password, Fields: []fieldOutput{}}
+
+			var buf bytes.Buffer
+			if err := writeStructured(&buf, format, output); err != nil {
+				t.Fatalf("writeStructured(%s, %q): %v", format, secret, err)
+			}
+
+			var decoded struct {
+				Service  string `json:"service" yaml:"service"`
+				Password string `json:"password" yaml:"password"`
+			}
+			var err error
+			if format == outputJSON {
+				err = json.Unmarshal(buf.Bytes(), &decoded)
+			} else {
+				err = yaml.Unmarshal(buf.Bytes(), &decoded)
+			}
+			if err != nil {
+				t.Fatalf("%s output for %q does not parse: %v\n%s", format, secret, err, buf.Bytes())
+			}
+			if decoded.Password != secret || decoded.Service != "example" {
+				t.Errorf("%s output for %q = %+v\n%s", format, secret, decoded, buf.Bytes())
+			}
+			if bytes.Contains(buf.Bytes(), []byte("passvault-secret-")) {
+				t.Errorf("%s output for %q kept a placeholder:\n%s", format, secret, buf.Bytes())
+			}
+		}
+	}
+
+	if yamlSecrets != nil {
+		t.Error("writeStructured left the YAML placeholders set")
+	}
+}
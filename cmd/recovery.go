@@ -0,0 +1,159 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/anmol7470/passvault/internal"
+	"github.com/spf13/cobra"
+)
+
+func newRecoveryCmd(a *app) *cobra.Command {
+	recoveryCmd := &cobra.Command{
+		Use:   "recovery",
+		Short: "Manage recovery codes for a forgotten master password",
+		Long: `Manage one-time recovery codes. Each code unlocks the vault on its own with 'passvault recover',
+which then sets a new master password.`,
+	}
+
+	recoveryCmd.AddCommand(newRecoveryGenerateCmd(a))
+	recoveryCmd.AddCommand(newRecoveryStatusCmd(a))
+
+	return recoveryCmd
+}
+
+func newRecoveryGenerateCmd(a *app) *cobra.Command {
+	recoveryGenerateCmd := &cobra.Command{
+		Use:   "generate",
+		Short: "Generate a new set of recovery codes",
+		Long:  `Generate a new set of one-time recovery codes. Any existing codes stop working.`,
+		RunE: a.run(func(cmd *cobra.Command, args []string) error {
+			count, _ := cmd.Flags().GetInt("count")
+
+			if count < 1 || count > internal.MaxRecoveryCodeCount {
+				return usageError(fmt.Errorf("count must be between 1 and %d", internal.MaxRecoveryCodeCount))
+			}
+
+			vaultKey, err := a.vault.Unlock()
+			if err != nil {
+				return authError(err)
+			}
+			defer vaultKey.Destroy()
+
+			existing, err := a.vault.CountRecoveryCodes()
+			if err != nil {
+				return err
+			}
+
+			if existing > 0 {
+				fmt.Fprintf(a.prose(), "\nThis vault has %d unused recovery code(s). Generating new codes invalidates them.\n", existing)
+				fmt.Fprint(a.prose(), "Continue? (yes/no): ")
+				confirm, err := internal.PromptString("")
+				if err != nil {
+					return fail(err, "reading confirmation")
+				}
+				if strings.ToLower(strings.TrimSpace(confirm)) != "yes" {
+					fmt.Fprintln(a.prose(), "Generation cancelled.")
+					return nil
+				}
+			}
+
+			codes, err := a.vault.GenerateRecoveryCodes(vaultKey, count)
+			if err != nil {
+				return fail(err, "generating recovery codes")
+			}
+
+			internal.PrintRecoveryCodes(codes)
+			return nil
+		}),
+	}
+
+	recoveryGenerateCmd.Flags().IntP("count", "n", internal.DefaultRecoveryCodeCount, "Number of recovery codes to generate")
+
+	return recoveryGenerateCmd
+}
+
+func newRecoveryStatusCmd(a *app) *cobra.Command {
+	recoveryStatusCmd := &cobra.Command{
+		Use:   "status",
+		Short: "Show how many unused recovery codes remain",
+		RunE: a.run(func(cmd *cobra.Command, args []string) error {
+			count, err := a.vault.CountRecoveryCodes()
+			if err != nil {
+				return err
+			}
+
+			if count == 0 {
+				fmt.Fprintln(a.prose(), "No recovery codes. Generate some with 'passvault recovery generate'.")
+				return nil
+			}
+
+			fmt.Fprintf(a.prose(), "Unused recovery codes: %d\n", count)
+			return nil
+		}),
+	}
+
+	return recoveryStatusCmd
+}
+
+func newRecoverCmd(a *app) *cobra.Command {
+	recoverCmd := &cobra.Command{
+		Use:   "recover",
+		Short: "Unlock the vault with a recovery code and set a new master password",
+		Long: `Unlock the vault with a one-time recovery code and set a new master password.
+The code is used up; the remaining codes keep working. If the vault required a key file,
+it no longer does afterwards.`,
+		RunE: a.run(func(cmd *cobra.Command, args []string) error {
+			isSet, err := a.vault.IsMasterPasswordSet()
+			if err != nil {
+				return err
+			}
+			if !isSet {
+				return errors.New("no vault has been set up yet")
+			}
+
+			code, err := internal.PromptString("Enter recovery code: ")
+			if err != nil {
+				return fail(err, "reading recovery code")
+			}
+
+			vaultKey, err := a.vault.UnlockWithRecoveryCode(code)
+			if err != nil {
+				return err
+			}
+			defer vaultKey.Destroy()
+
+			fmt.Fprintln(a.prose(), "Recovery code accepted. Choose a new master password.")
+
+			newPassword, err := internal.PromptNewMasterPassword("Enter new master password: ", "Confirm new master password: ")
+			if err != nil {
+				return err
+			}
+			defer newPassword.Destroy()
+
+			keyFileRequired, err := a.vault.IsKeyFileRequired()
+			if err != nil {
+				return err
+			}
+
+			if err := a.vault.Recover(vaultKey, code, newPassword); err != nil {
+				return fail(fmt.Errorf("%w. The vault has not been modified", err), "recovering vault")
+			}
+
+			remaining, err := a.vault.CountRecoveryCodes()
+			if err != nil {
+				return err
+			}
+
+			fmt.Fprintln(a.prose(), "\n✓ Master password reset successfully!")
+			if keyFileRequired {
+				fmt.Fprintln(a.prose(), "The vault no longer requires a key file. Run 'passvault keyfile create' to add a new one.")
+			}
+			fmt.Fprintf(a.prose(), "%d recovery code(s) remaining.\n", remaining)
+			return nil
+		}),
+	}
+
+	return recoverCmd
+}
@@ -1,20 +1,198 @@
 package cmd
 
 import (
+	"errors"
+	"fmt"
+	"io"
 	"os"
 
+	"github.com/anmol7470/passvault/internal"
 	"github.com/spf13/cobra"
 )
 
-var rootCmd = &cobra.Command{
-	Use:   "passvault",
-	Short: "A secure CLI-based password manager",
-	Long:  "PassVault is a secure command-line password manager built with Go.",
+// skipVaultAnnotation marks commands that do not open the selected vault
+const skipVaultAnnotation = "passvault/skip-vault"
+
+// StoreOpener opens the store of the vault database at path
+type StoreOpener func(path string) (internal.Store, error)
+
+// app is what the commands share: how to open a vault, and the vault selected
+// for this run, which is opened before the command runs
+type app struct {
+	openStore StoreOpener
+	vault     *internal.Vault
+	vaultName string
+	vaultPath string
+	output    outputFormat
+	stdout    io.Writer // where documents are written, and prose in text output
+	stderr    io.Writer // where errors are written, and prose in structured output
+
+	// readPassword reads the master password; nil reads it from the terminal
+	readPassword func() (*internal.SecretBuffer, error)
 }
 
-func Execute() {
-	err := rootCmd.Execute()
+// NewRootCmd returns the passvault command tree, opening vaults with openStore
+func NewRootCmd(openStore StoreOpener) *cobra.Command {
+	return newRootCmd(&app{openStore: openStore, output: outputText, stdout: os.Stdout, stderr: os.Stderr})
+}
+
+func newRootCmd(a *app) *cobra.Command {
+	rootCmd := &cobra.Command{
+		Use:   "passvault",
+		Short: "A secure CLI-based password manager",
+		Long:  "PassVault is a secure command-line password manager built with Go.",
+		PersistentPreRunE: a.run(func(cmd *cobra.Command, args []string) error {
+			output, err := selectedOutput(cmd)
+			if err != nil {
+				return usageError(err)
+			}
+			a.output = output
+
+			skipVault := cmd.Annotations[skipVaultAnnotation] != ""
+
+			name, path, err := internal.ResolveVault(selectedVault(cmd))
+			if err != nil && !skipVault {
+				return err
+			}
+			a.vaultName = name
+			a.vaultPath = path
+
+			if skipVault {
+				return nil
+			}
+
+			vault, err := a.openVault(path)
+			if err != nil {
+				return fmt.Errorf("Failed to initialize database: %w", err)
+			}
+
+			vault.KeyFilePath, _ = cmd.Flags().GetString("keyfile")
+			if vault.KeyFilePath == "" {
+				vault.KeyFilePath = os.Getenv("PASSVAULT_KEYFILE")
+			}
+			// Prompts for the master password are prose like any other
+			vault.Prompts = a.prose()
+			vault.ReadPassword = a.readPassword
+			a.vault = vault
+			return nil
+		}),
+		PersistentPostRun: func(cmd *cobra.Command, args []string) {
+			a.closeVault()
+		},
+	}
+
+	rootCmd.PersistentFlags().String("keyfile", "", "Key file for vaults that require one (or set PASSVAULT_KEYFILE)")
+	rootCmd.PersistentFlags().String("vault", "", "Name or database path of the vault to use (or set PASSVAULT_VAULT)")
+	rootCmd.PersistentFlags().String("output", string(outputText), "Output format of list, get, audit, add, update, delete and export: text, json or yaml (or set PASSVAULT_OUTPUT)")
+
+	rootCmd.AddCommand(
+		newInitCmd(a),
+		newAddCmd(a),
+		newListCmd(a),
+		newGetCmd(a),
+		newOtpCmd(a),
+		newUpdateCmd(a),
+		newDeleteCmd(a),
+		newHistoryCmd(a),
+		newRestoreVersionCmd(a),
+		newTrashCmd(a),
+		newAttachCmd(a),
+		newExportCmd(a),
+		newAuditCmd(a),
+		newDueCmd(a),
+		newRotationCmd(a),
+		newChangeMasterPasswordCmd(a),
+		newMetadataCmd(a),
+		newKeyfileCmd(a),
+		newRecoveryCmd(a),
+		newRecoverCmd(a),
+		newEscrowCmd(a),
+		newUnlockCmd(a),
+		newLockCmd(a),
+		newAgentCmd(a),
+		newVaultCmd(a),
+		newDbCmd(a),
+		newKdfCmd(a),
+		newResetCmd(a),
+	)
+
+	return rootCmd
+}
+
+// closeVault closes the vault opened for the command, if any
+func (a *app) closeVault() {
+	if a.vault == nil {
+		return
+	}
+	if err := a.vault.Close(); err != nil {
+		fmt.Fprintf(a.stderr, "Warning: Failed to close database: %v\n", err)
+	}
+	a.vault = nil
+}
+
+// openVault opens the vault whose database is at path
+func (a *app) openVault(path string) (*internal.Vault, error) {
+	store, err := a.openStore(path)
 	if err != nil {
-		os.Exit(1)
+		return nil, err
+	}
+	return internal.NewVault(store), nil
+}
+
+// selectedVault returns the vault chosen with --vault or PASSVAULT_VAULT, if any
+func selectedVault(cmd *cobra.Command) string {
+	vault, _ := cmd.Flags().GetString("vault")
+	if vault == "" {
+		vault = os.Getenv("PASSVAULT_VAULT")
+	}
+	return vault
+}
+
+// selectedOutput returns the output format chosen with --output or
+// PASSVAULT_OUTPUT
+func selectedOutput(cmd *cobra.Command) (outputFormat, error) {
+	output, _ := cmd.Flags().GetString("output")
+	if !cmd.Flags().Changed("output") && os.Getenv("PASSVAULT_OUTPUT") != "" {
+		output = os.Getenv("PASSVAULT_OUTPUT")
+	}
+	return parseOutputFormat(output)
+}
+
+// openSQLiteStore opens the vault databases of the passvault binary
+func openSQLiteStore(path string) (internal.Store, error) {
+	store, err := internal.OpenSQLiteStore(path)
+	if err != nil {
+		return nil, err
+	}
+
+	if backup := store.BackupPath(); backup != "" {
+		fmt.Fprintf(os.Stderr, "Vault database upgraded to schema version %d. A backup of the previous version was saved to %s\n", internal.LatestSchemaVersion(), backup)
+	}
+
+	return store, nil
+}
+
+func Execute() {
+	rootCmd := NewRootCmd(openSQLiteStore)
+
+	// Mistakes in the command line are found before any command runs, so the
+	// output format for them is looked up in the arguments
+	output := requestedOutput(os.Args[1:])
+	if output != outputText {
+		rootCmd.SilenceErrors = true
+		rootCmd.SilenceUsage = true
+	}
+
+	if err := rootCmd.Execute(); err != nil {
+		// Commands report their own errors, so only the exit code is left
+		var commandErr *failure
+		if errors.As(err, &commandErr) {
+			os.Exit(exitCode(err))
+		}
+
+		if output != outputText {
+			writeError(os.Stderr, output, exitUsage, err.Error())
+		}
+		os.Exit(exitUsage)
 	}
 }
@@ -0,0 +1,287 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/anmol7470/passvault/internal"
+	"github.com/spf13/cobra"
+)
+
+func newDueCmd(a *app) *cobra.Command {
+	dueCmd := &cobra.Command{
+		Use:   "due",
+		Short: "List passwords that are due to be changed",
+		Long: `List the entries whose passwords are overdue for rotation or due within --within, soonest
+first. A password is due a number of days after it last changed, as set on its entry with
+--rotate when it is added or updated, or on one of its tags with 'passvault rotation set'.
+Editing other values of an entry, such as its notes, does not count as a change.`,
+		RunE: a.run(func(cmd *cobra.Command, args []string) error {
+			withinFlag, _ := cmd.Flags().GetString("within")
+			within, err := parseAge(withinFlag)
+			if err != nil {
+				return err
+			}
+
+			vaultKey, err := a.vault.Unlock()
+			if err != nil {
+				return authError(err)
+			}
+			defer vaultKey.Destroy()
+
+			filter, err := entryFilterFromFlags(cmd)
+			if err != nil {
+				return err
+			}
+
+			entries, err := a.vault.FilterPasswords(filter, vaultKey)
+			if err != nil {
+				return fail(err, "fetching passwords")
+			}
+
+			policies, err := a.vault.RotationPolicies(vaultKey)
+			if err != nil {
+				return err
+			}
+
+			now := time.Now()
+			rotations := entryRotations(entries, policies)
+			if len(rotations) == 0 {
+				fmt.Fprintln(a.prose(), "No rotation policies apply to these entries. Set one with 'passvault rotation set' or 'passvault update --rotate'.")
+				return nil
+			}
+
+			due := slices.DeleteFunc(rotations, func(r entryRotation) bool { return r.rotation.DueAt.After(now.Add(within)) })
+			if len(due) == 0 {
+				fmt.Fprintf(a.prose(), "No passwords are due for rotation within %s.\n", withinFlag)
+				return nil
+			}
+
+			fmt.Fprintf(a.prose(), "Passwords due for rotation within %s, soonest first:\n\n", withinFlag)
+			for _, r := range due {
+				fmt.Fprintf(a.prose(), "%-20s %s  %s\n", formatDue(r.rotation.DaysLeft(now)), describeEntry(r.entry), describeRotation(r.rotation))
+			}
+			return nil
+		}),
+	}
+
+	dueCmd.Flags().String("within", fmt.Sprintf("%dd", internal.DueSoonDays), "Include passwords due within this long, such as 30d or 2w")
+	addEntryFilterFlags(dueCmd)
+
+	return dueCmd
+}
+
+func newRotationCmd(a *app) *cobra.Command {
+	rotationCmd := &cobra.Command{
+		Use:   "rotation",
+		Short: "Manage password rotation policies of tags",
+		Long: `Make the passwords of every entry with a tag due to be changed a number of days after they
+last changed. A policy set on an entry itself with --rotate wins over those of its tags, and
+of several tags the shortest policy applies. List what is due with 'passvault due'.`,
+	}
+
+	rotationCmd.AddCommand(newRotationListCmd(a))
+	rotationCmd.AddCommand(newRotationSetCmd(a))
+	rotationCmd.AddCommand(newRotationUnsetCmd(a))
+
+	return rotationCmd
+}
+
+func newRotationListCmd(a *app) *cobra.Command {
+	rotationListCmd := &cobra.Command{
+		Use:   "list",
+		Short: "List the rotation policies of tags and entries",
+		RunE: a.run(func(cmd *cobra.Command, args []string) error {
+			vaultKey, err := a.vault.Unlock()
+			if err != nil {
+				return authError(err)
+			}
+			defer vaultKey.Destroy()
+
+			policies, err := a.vault.RotationPolicies(vaultKey)
+			if err != nil {
+				return err
+			}
+
+			entries, err := a.vault.ListAllPasswords(vaultKey)
+			if err != nil {
+				return fail(err, "fetching passwords")
+			}
+
+			var ownPolicies []internal.PasswordEntry
+			for _, entry := range entries {
+				if entry.RotationDays > 0 {
+					ownPolicies = append(ownPolicies, entry)
+				}
+			}
+
+			if len(policies) == 0 && len(ownPolicies) == 0 {
+				fmt.Fprintln(a.prose(), "No rotation policies set. Add one with 'passvault rotation set <tag> <period>'.")
+				return nil
+			}
+
+			if len(policies) > 0 {
+				fmt.Fprintln(a.prose(), "Tags:")
+				tags := make([]string, 0, len(policies))
+				for tag := range policies {
+					tags = append(tags, tag)
+				}
+				sort.Strings(tags)
+				for _, tag := range tags {
+					fmt.Fprintf(a.prose(), "  %-24s every %s\n", "#"+tag, formatDays(policies[tag]))
+				}
+			}
+
+			if len(ownPolicies) > 0 {
+				if len(policies) > 0 {
+					fmt.Fprintln(a.prose())
+				}
+				fmt.Fprintln(a.prose(), "Entries:")
+				for _, entry := range ownPolicies {
+					fmt.Fprintf(a.prose(), "  %-24s every %s\n", describeEntry(entry), formatDays(entry.RotationDays))
+				}
+			}
+			return nil
+		}),
+	}
+
+	return rotationListCmd
+}
+
+func newRotationSetCmd(a *app) *cobra.Command {
+	rotationSetCmd := &cobra.Command{
+		Use:   "set <tag> <period>",
+		Short: "Make the passwords of entries with a tag due after a period, such as 90d",
+		Args:  cobra.ExactArgs(2),
+		RunE: a.run(func(cmd *cobra.Command, args []string) error {
+			days, err := parseRotationPeriod(args[1])
+			if err != nil {
+				return usageError(err)
+			}
+			if days == 0 {
+				return usageError(errors.New("the period must be at least a day; use 'passvault rotation unset' to remove a policy"))
+			}
+
+			vaultKey, err := a.vault.Unlock()
+			if err != nil {
+				return authError(err)
+			}
+			defer vaultKey.Destroy()
+
+			if err := a.vault.SetRotationPolicy(args[0], days, vaultKey); err != nil {
+				return err
+			}
+
+			fmt.Fprintf(a.prose(), "✓ Passwords of entries tagged #%s are now due every %s.\n", strings.ToLower(strings.TrimPrefix(args[0], "#")), formatDays(days))
+			return nil
+		}),
+	}
+
+	return rotationSetCmd
+}
+
+func newRotationUnsetCmd(a *app) *cobra.Command {
+	rotationUnsetCmd := &cobra.Command{
+		Use:   "unset <tag>",
+		Short: "Remove the rotation policy of a tag",
+		Args:  cobra.ExactArgs(1),
+		RunE: a.run(func(cmd *cobra.Command, args []string) error {
+			vaultKey, err := a.vault.Unlock()
+			if err != nil {
+				return authError(err)
+			}
+			defer vaultKey.Destroy()
+
+			if err := a.vault.SetRotationPolicy(args[0], 0, vaultKey); err != nil {
+				return err
+			}
+
+			fmt.Fprintf(a.prose(), "✓ Removed the rotation policy of #%s.\n", strings.ToLower(strings.TrimPrefix(args[0], "#")))
+			return nil
+		}),
+	}
+
+	return rotationUnsetCmd
+}
+
+// entryRotation is an entry with when its password is due to be changed
+type entryRotation struct {
+	entry    internal.PasswordEntry
+	rotation internal.Rotation
+}
+
+// entryRotations returns the entries a rotation policy applies to, soonest
+// due first
+func entryRotations(entries []internal.PasswordEntry, policies map[string]int) []entryRotation {
+	var rotations []entryRotation
+	for _, entry := range entries {
+		if rotation, ok := internal.RotationOf(entry, policies); ok {
+			rotations = append(rotations, entryRotation{entry: entry, rotation: rotation})
+		}
+	}
+
+	sort.SliceStable(rotations, func(i, j int) bool {
+		return rotations[i].rotation.DueAt.Before(rotations[j].rotation.DueAt)
+	})
+	return rotations
+}
+
+// parseRotationPeriod parses a number of days, such as 90, 90d or 12w
+func parseRotationPeriod(value string) (int, error) {
+	if days, err := strconv.Atoi(value); err == nil && days >= 0 {
+		return days, nil
+	}
+
+	const day = 24 * time.Hour
+	period, err := parseAge(value)
+	if err != nil || period%day != 0 {
+		return 0, fmt.Errorf("invalid rotation period %q: use a number of days (90d) or weeks (12w)", value)
+	}
+	return int(period / day), nil
+}
+
+// formatDays returns a number of days, such as "1 day" or "90 days"
+func formatDays(days int) string {
+	if days == 1 {
+		return "1 day"
+	}
+	return fmt.Sprintf("%d days", days)
+}
+
+// formatDue describes how soon a password is due to be changed, such as
+// "expires in 12 days" or "overdue by 3 days"
+func formatDue(daysLeft int) string {
+	switch {
+	case daysLeft < 0:
+		return "overdue by " + formatDays(-daysLeft)
+	case daysLeft == 0:
+		return "expires today"
+	}
+	return "expires in " + formatDays(daysLeft)
+}
+
+// writeRotation writes when the password of an entry is due to be changed,
+// if a rotation policy applies to it
+func writeRotation(s *strings.Builder, entry *internal.PasswordEntry, policies map[string]int) {
+	if rotation, ok := internal.RotationOf(*entry, policies); ok {
+		fmt.Fprintf(s, "Rotation: %s, %s\n", describeRotation(rotation), formatDue(rotation.DaysLeft(time.Now())))
+	}
+}
+
+// describeRotation describes the policy of a rotation and when the password
+// last changed
+func describeRotation(rotation internal.Rotation) string {
+	description := "every " + formatDays(rotation.Days)
+	if rotation.Tag != "" {
+		description += " (#" + rotation.Tag + ")"
+	}
+	if rotation.ChangedAt.IsZero() {
+		return description + ", never changed"
+	}
+	return description + ", changed " + rotation.ChangedAt.Local().Format(time.DateOnly)
+}
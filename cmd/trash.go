@@ -0,0 +1,196 @@
+package cmd
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/anmol7470/passvault/internal"
+	"github.com/spf13/cobra"
+)
+
+func newTrashCmd(a *app) *cobra.Command {
+	trashCmd := &cobra.Command{
+		Use:   "trash",
+		Short: "Manage deleted password entries",
+		Long: `Deleted entries are kept in the trash, hidden from list, get and search, until they
+are restored or purged.`,
+	}
+
+	trashCmd.AddCommand(newTrashListCmd(a))
+	trashCmd.AddCommand(newTrashRestoreCmd(a))
+	trashCmd.AddCommand(newTrashPurgeCmd(a))
+
+	return trashCmd
+}
+
+func newTrashListCmd(a *app) *cobra.Command {
+	trashListCmd := &cobra.Command{
+		Use:   "list",
+		Short: "List entries in the trash",
+		RunE: a.run(func(cmd *cobra.Command, args []string) error {
+			vaultKey, err := a.vault.Unlock()
+			if err != nil {
+				return authError(err)
+			}
+			defer vaultKey.Destroy()
+
+			entries, err := a.vault.ListTrash(vaultKey)
+			if err != nil {
+				return fail(err, "reading trash")
+			}
+
+			if len(entries) == 0 {
+				fmt.Fprintln(a.prose(), "The trash is empty.")
+				return nil
+			}
+
+			for _, entry := range entries {
+				fmt.Fprintf(a.prose(), "%-22s %s\n", entry.DeletedAt, describeEntry(entry))
+			}
+			return nil
+		}),
+	}
+
+	return trashListCmd
+}
+
+func newTrashRestoreCmd(a *app) *cobra.Command {
+	trashRestoreCmd := &cobra.Command{
+		Use:   "restore [query]",
+		Short: "Restore an entry from the trash",
+		Args:  cobra.MaximumNArgs(1),
+		RunE: a.run(func(cmd *cobra.Command, args []string) error {
+			vaultKey, err := a.vault.Unlock()
+			if err != nil {
+				return authError(err)
+			}
+			defer vaultKey.Destroy()
+
+			query := ""
+			if len(args) > 0 {
+				query = args[0]
+			}
+
+			entries, err := a.vault.ListTrash(vaultKey)
+			if err != nil {
+				return fail(err, "reading trash")
+			}
+
+			var matches []internal.PasswordEntry
+			for _, entry := range entries {
+				if query == "" || entry.Alias == query || strings.Contains(strings.ToLower(entry.Service+" "+entry.Username), strings.ToLower(query)) {
+					matches = append(matches, entry)
+				}
+			}
+
+			if len(matches) == 0 {
+				if query == "" {
+					fmt.Fprintln(a.prose(), "The trash is empty.")
+					return nil
+				}
+				return fmt.Errorf("no entries in the trash match '%s'", query)
+			}
+
+			entry, err := internal.SelectPassword(matches, query)
+			if err != nil {
+				return err
+			}
+
+			// Another entry may have taken its account or alias meanwhile
+			if err := a.vault.RestorePassword(entry.ID); err != nil {
+				return fail(err, "restoring password")
+			}
+
+			fmt.Fprintf(a.prose(), "✓ %s restored from the trash.\n", describeEntry(*entry))
+			return nil
+		}),
+	}
+
+	return trashRestoreCmd
+}
+
+func newTrashPurgeCmd(a *app) *cobra.Command {
+	trashPurgeCmd := &cobra.Command{
+		Use:   "purge",
+		Short: "Permanently delete entries in the trash",
+		Long:  `Permanently delete the entries in the trash, or only those deleted longer ago than --older-than, together with their history.`,
+		RunE: a.run(func(cmd *cobra.Command, args []string) error {
+			olderThanFlag, _ := cmd.Flags().GetString("older-than")
+
+			var olderThan time.Duration
+			if olderThanFlag != "" {
+				var err error
+				olderThan, err = parseAge(olderThanFlag)
+				if err != nil {
+					return usageError(err)
+				}
+			}
+
+			vaultKey, err := a.vault.Unlock()
+			if err != nil {
+				return authError(err)
+			}
+			defer vaultKey.Destroy()
+
+			if olderThanFlag != "" {
+				fmt.Fprintf(a.prose(), "Permanently delete entries that have been in the trash for more than %s? (yes/no): ", olderThanFlag)
+			} else {
+				fmt.Fprint(a.prose(), "Permanently delete every entry in the trash? (yes/no): ")
+			}
+
+			confirmation, err := internal.PromptString("")
+			if err != nil {
+				return fail(err, "reading confirmation")
+			}
+
+			if strings.ToLower(strings.TrimSpace(confirmation)) != "yes" {
+				fmt.Fprintln(a.prose(), "Purge cancelled.")
+				return nil
+			}
+
+			count, err := a.vault.PurgeTrash(olderThan)
+			if err != nil {
+				return fail(err, "purging trash")
+			}
+
+			fmt.Fprintf(a.prose(), "✓ %d entries permanently deleted.\n", count)
+			return nil
+		}),
+	}
+
+	trashPurgeCmd.Flags().String("older-than", "", "Only purge entries deleted longer ago than this, such as 30d or 12h")
+
+	return trashPurgeCmd
+}
+
+// describeEntry returns the service, username and alias of an entry for display
+func describeEntry(entry internal.PasswordEntry) string {
+	description := entryTitle(&entry)
+	if entry.Alias != "" {
+		description += fmt.Sprintf(" [%s]", entry.Alias)
+	}
+	return description
+}
+
+// parseAge parses a duration such as 30d, 2w or 12h
+func parseAge(value string) (time.Duration, error) {
+	units := map[string]time.Duration{"d": 24 * time.Hour, "w": 7 * 24 * time.Hour}
+
+	for suffix, unit := range units {
+		if number, ok := strings.CutSuffix(value, suffix); ok {
+			n, err := strconv.Atoi(number)
+			if err != nil || n < 0 {
+				return 0, fmt.Errorf("invalid duration %q", value)
+			}
+			return time.Duration(n) * unit, nil
+		}
+	}
+
+	duration, err := time.ParseDuration(value)
+	if err != nil || duration < 0 {
+		return 0, fmt.Errorf("invalid duration %q: use a number of days (30d), weeks (2w) or hours (12h)", value)
+	}
+	return duration, nil
+}
@@ -0,0 +1,316 @@
+package cmd
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+
+	"github.com/anmol7470/passvault/internal"
+)
+
+// entryTypeNames lists the entry types for help texts
+func entryTypeNames() string {
+	var names []string
+	for _, schema := range internal.EntrySchemas() {
+		names = append(names, string(schema.Type))
+	}
+	return strings.Join(names, ", ")
+}
+
+// schemaOf returns the schema of an entry's type
+func schemaOf(entry *internal.PasswordEntry) internal.EntrySchema {
+	schema, err := internal.SchemaOf(entry.Type)
+	if err != nil {
+		schema, _ = internal.SchemaOf(internal.TypeLogin)
+	}
+	return schema
+}
+
+// entryTitle names an entry by its service and username, if it has one
+func entryTitle(entry *internal.PasswordEntry) string {
+	if entry.Username == "" {
+		return entry.Service
+	}
+	return fmt.Sprintf("%s (%s)", entry.Service, entry.Username)
+}
+
+// entryInSentence names an entry in a sentence, such as "password for github
+// (me)" or "payment card Visa (Jane Doe)"
+func entryInSentence(entry *internal.PasswordEntry) string {
+	schema := schemaOf(entry)
+	if schema.Type == internal.TypeLogin {
+		return "password for " + entryTitle(entry)
+	}
+	return schema.Description + " " + entryTitle(entry)
+}
+
+// capitalize upper-cases the first letter of s
+func capitalize(s string) string {
+	if s == "" {
+		return s
+	}
+	return strings.ToUpper(s[:1]) + s[1:]
+}
+
+// entryNoun is what the values of entries of a type are called in headings
+func entryNoun(schema internal.EntrySchema) string {
+	if schema.Type == internal.TypeLogin {
+		return "Password"
+	}
+	return capitalize(schema.Description)
+}
+
+// readSecretFile reads the main secret of an entry, such as a private key,
+// from a file
+func readSecretFile(path string) (*internal.SecretBuffer, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", path, err)
+	}
+	defer internal.Wipe(data)
+
+	return internal.NewSecretBufferFrom(bytes.TrimRight(data, "\r\n")), nil
+}
+
+// promptMultiline reads lines up to one holding only a dot or the end of input
+func promptMultiline(label string) (*internal.SecretBuffer, error) {
+	fmt.Printf("%s (end with a line containing only .):\n", label)
+
+	var text []byte
+	reader := bufio.NewReader(os.Stdin)
+	for {
+		line, err := reader.ReadBytes('\n')
+		trimmed := bytes.TrimRight(line, "\r\n")
+		if string(trimmed) == "." {
+			internal.Wipe(line)
+			break
+		}
+		text = append(text, line...)
+		internal.Wipe(line)
+		if err != nil {
+			break
+		}
+	}
+
+	return internal.NewSecretBufferFrom(bytes.TrimRight(text, "\r\n")), nil
+}
+
+// promptTypeSecret reads the main secret of an entry of a type other than
+// login until it is valid. If the entry has a secret already, it is kept when
+// nothing is entered.
+func promptTypeSecret(schema internal.EntrySchema, current *internal.SecretBuffer) (*internal.SecretBuffer, error) {
+	hasCurrent := current != nil && current.Len() > 0
+
+	for {
+		var secret *internal.SecretBuffer
+		var err error
+
+		if schema.SecretMultiline {
+			if hasCurrent {
+				replace, err := internal.PromptString(fmt.Sprintf("Replace the %s? (yes/no): ", internal.LabelInSentence(schema.SecretLabel)))
+				if err != nil {
+					return nil, err
+				}
+				if strings.ToLower(replace) != "yes" {
+					return current.Clone(), nil
+				}
+			}
+			secret, err = promptMultiline(schema.SecretLabel)
+		} else {
+			prompt := schema.SecretLabel
+			if hasCurrent {
+				prompt += " [" + maskedValue + "]"
+			} else if !schema.SecretRequired {
+				prompt += " (optional)"
+			}
+			secret, err = internal.PromptSecret(prompt + ": ")
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		switch {
+		case hasCurrent && secret.Len() == 0:
+			secret.Destroy()
+			return current.Clone(), nil
+		case hasCurrent && !schema.SecretRequired && string(secret.Bytes()) == "-":
+			secret.Destroy()
+			return internal.NewSecretBuffer(0), nil
+		}
+
+		if err := schema.CheckSecret(secret.Bytes()); err != nil {
+			secret.Destroy()
+			fmt.Printf("%v\n", capitalize(err.Error()))
+			continue
+		}
+		return secret, nil
+	}
+}
+
+// promptTypeFields prompts for the fields of an entry's type until their
+// values are valid. Fields already set are skipped unless edit is true, in
+// which case Enter keeps their value and - removes them.
+func promptTypeFields(schema internal.EntrySchema, fields []internal.CustomField, vaultKey *internal.VaultKey, entryUUID string, edit bool) ([]internal.CustomField, error) {
+	fields = slices.Clone(fields)
+
+	for _, typeField := range schema.Fields {
+		i := internal.FindField(fields, typeField.Name)
+		if i >= 0 && !edit {
+			continue
+		}
+
+		prompt := typeField.Label
+		if typeField.Hint != "" {
+			prompt += " (" + typeField.Hint + ")"
+		}
+		if i >= 0 {
+			prompt += " [" + displayFieldValue(fields[i]) + "]"
+		} else if !typeField.Required {
+			prompt += " (optional)"
+		}
+		prompt += ": "
+
+		for {
+			var value *internal.SecretBuffer
+			var err error
+			if typeField.Hidden {
+				value, err = internal.PromptSecret(prompt)
+			} else {
+				var input string
+				input, err = internal.PromptString(prompt)
+				value = internal.NewSecretBufferFrom([]byte(input))
+			}
+			if err != nil {
+				return nil, fmt.Errorf("failed to read %s: %w", internal.LabelInSentence(typeField.Label), err)
+			}
+
+			if i >= 0 && (value.Len() == 0 || string(value.Bytes()) == "-") {
+				if value.Len() > 0 {
+					fields, _ = internal.RemoveField(fields, typeField.Name)
+				}
+				value.Destroy()
+				break
+			}
+
+			if err := typeField.Check(string(value.Bytes())); err != nil {
+				value.Destroy()
+				fmt.Printf("%v\n", capitalize(err.Error()))
+				continue
+			}
+
+			if value.Len() > 0 {
+				field, err := internal.NewCustomField(typeField.Name, value.Bytes(), typeField.Hidden, vaultKey, entryUUID)
+				if err != nil {
+					value.Destroy()
+					return nil, err
+				}
+				fields = internal.SetField(fields, field)
+			}
+			value.Destroy()
+			break
+		}
+	}
+
+	return fields, nil
+}
+
+// splitTypeFields separates the fields of an entry's type from its other
+// custom fields
+func splitTypeFields(schema internal.EntrySchema, fields []internal.CustomField) (typeFields, other []internal.CustomField) {
+	for _, field := range fields {
+		if _, ok := schema.Field(field.Name); ok {
+			typeFields = append(typeFields, field)
+		} else {
+			other = append(other, field)
+		}
+	}
+	return typeFields, other
+}
+
+// withSSHPublicKey adds the public key of an unencrypted private key to the
+// fields of an SSH key entry that has none
+func withSSHPublicKey(fields []internal.CustomField, privateKey []byte) ([]internal.CustomField, error) {
+	if internal.FindField(fields, "public_key") >= 0 {
+		return fields, nil
+	}
+
+	publicKey, err := internal.SSHPublicKey(privateKey)
+	if err != nil || publicKey == "" {
+		return fields, err
+	}
+	return append(fields, internal.CustomField{Name: "public_key", Value: publicKey}), nil
+}
+
+// writeEntryDetails writes the values of a decrypted entry labelled after its
+// type. Hidden custom fields are masked unless fieldValues holds their
+// decrypted values.
+func writeEntryDetails(s *strings.Builder, entry *internal.PasswordEntry, secret []byte, totp *internal.TOTP, fieldValues []*internal.SecretBuffer) {
+	schema := schemaOf(entry)
+	fieldValue := func(i int) string {
+		if fieldValues != nil {
+			return string(fieldValues[i].Bytes())
+		}
+		return displayFieldValue(entry.Fields[i])
+	}
+
+	fmt.Fprintf(s, "%s: %s\n", schema.ServiceLabel, entry.Service)
+	if schema.UsernameLabel != "" && (entry.Username != "" || schema.UsernameRequired) {
+		fmt.Fprintf(s, "%s: %s\n", schema.UsernameLabel, entry.Username)
+	}
+	switch {
+	case schema.SecretMultiline:
+		fmt.Fprintf(s, "%s:\n", schema.SecretLabel)
+		for line := range strings.SplitSeq(string(secret), "\n") {
+			fmt.Fprintf(s, "  %s\n", line)
+		}
+	case len(secret) > 0 || schema.SecretRequired:
+		fmt.Fprintf(s, "%s: %s\n", schema.SecretLabel, secret)
+	}
+	if totp != nil {
+		fmt.Fprintf(s, "TOTP: %s\n", formatTOTPCode(totp))
+	}
+	for _, typeField := range schema.Fields {
+		if i := internal.FindField(entry.Fields, typeField.Name); i >= 0 {
+			fmt.Fprintf(s, "%s: %s\n", typeField.Label, fieldValue(i))
+		}
+	}
+	switch {
+	case len(entry.URLs) == 1:
+		fmt.Fprintf(s, "URL: %s\n", entry.URLs[0])
+	case len(entry.URLs) > 1:
+		s.WriteString("URLs:\n")
+		for _, u := range entry.URLs {
+			fmt.Fprintf(s, "  %s\n", u)
+		}
+	}
+	if len(entry.URLs) > 0 && entry.URLMatch != "" && entry.URLMatch != internal.MatchDomain {
+		fmt.Fprintf(s, "URL match: %s\n", entry.URLMatch)
+	}
+	if entry.Notes != "" {
+		fmt.Fprintf(s, "Notes: %s\n", entry.Notes)
+	}
+	if entry.Alias != "" {
+		fmt.Fprintf(s, "Alias: %s\n", entry.Alias)
+	}
+	if entry.Folder != "" {
+		fmt.Fprintf(s, "Folder: %s\n", entry.Folder)
+	}
+	if len(entry.Tags) > 0 {
+		fmt.Fprintf(s, "Tags: %s\n", formatTags(entry.Tags))
+	}
+
+	header := false
+	for i, field := range entry.Fields {
+		if _, ok := schema.Field(field.Name); ok {
+			continue
+		}
+		if !header {
+			s.WriteString("Fields:\n")
+			header = true
+		}
+		fmt.Fprintf(s, "  %s: %s\n", field.Name, fieldValue(i))
+	}
+}
@@ -2,6 +2,7 @@ package cmd
 
 import (
 	"bufio"
+	"bytes"
 	"fmt"
 	"os"
 	"strings"
@@ -10,77 +11,296 @@ import (
 	"github.com/spf13/cobra"
 )
 
-var updateCmd = &cobra.Command{
-	Use:   "update",
-	Short: "Update an existing password entry",
-	Long:  `Search for a password entry and update its service, username, password, or notes.`,
-	Run: func(cmd *cobra.Command, args []string) {
-		masterPassword, err := internal.PromptMasterPassword()
-		if err != nil {
-			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
-			os.Exit(1)
-		}
+func newUpdateCmd(a *app) *cobra.Command {
+	updateCmd := &cobra.Command{
+		Use:   "update",
+		Short: "Update an existing password entry",
+		Long: `Search for a password entry and update its service, username, password, notes, alias, folder,
+tags, URLs, custom fields or TOTP secret. Custom fields are edited at the prompt unless --field, --hidden-field
+or --remove-field is given. Entries of other types than logins are edited with the prompts of their type.
+URLs are typed separated by spaces; - removes them all. Use --rotate to set how long after a
+change the password is due to be changed again; changing the password resets its age.
 
-		query, _ := cmd.Flags().GetString("query")
-		entry, err := internal.SearchAndSelectPassword(query)
-		if err != nil {
-			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
-			os.Exit(1)
-		}
+Values given with flags, such as --notes or --password, are not prompted for. With --output
+json or yaml, nothing is prompted for: --query must find exactly one entry, only the values
+given with flags change, and the updated entry is written out without its password.`,
+		RunE: a.run(func(cmd *cobra.Command, args []string) error {
+			vaultKey, err := a.vault.Unlock()
+			if err != nil {
+				return authError(err)
+			}
+			defer vaultKey.Destroy()
 
-		decryptedPassword, err := internal.DecryptPassword(entry.EncryptedPassword, masterPassword)
-		if err != nil {
-			fmt.Fprintf(os.Stderr, "Error decrypting password: %v\n", err)
-			os.Exit(1)
-		}
+			query, _ := cmd.Flags().GetString("query")
+			entry, err := a.searchEntry(query, vaultKey)
+			if err != nil {
+				return err
+			}
 
-		fmt.Printf("\nUpdating password for %s (%s)\n", entry.Service, entry.Username)
-		fmt.Println("Press Enter to keep current value")
-		fmt.Println()
+			schema := schemaOf(entry)
 
-		newService, err := promptWithDefault("Service", entry.Service)
-		if err != nil {
-			fmt.Fprintf(os.Stderr, "Error reading service: %v\n", err)
-			os.Exit(1)
-		}
+			decryptedPassword, err := internal.DecryptPassword(entry.EncryptedPassword, vaultKey, entry.UUID)
+			if err != nil {
+				return fail(err, "decrypting password")
+			}
+			defer decryptedPassword.Destroy()
 
-		newUsername, err := promptWithDefault("Username", entry.Username)
-		if err != nil {
-			fmt.Fprintf(os.Stderr, "Error reading username: %v\n", err)
-			os.Exit(1)
-		}
+			if !a.structured() {
+				fmt.Fprintf(a.prose(), "\nUpdating %s\n", entryInSentence(entry))
+				fmt.Fprintln(a.prose(), "Press Enter to keep current value")
+				fmt.Fprintln(a.prose())
+			}
 
-		newPassword, err := internal.PromptPasswordWithDefaultAndValidation("Password", decryptedPassword)
-		if err != nil {
-			fmt.Fprintf(os.Stderr, "Error reading password: %v\n", err)
-			os.Exit(1)
-		}
+			newService, err := updatedValue(a, cmd, "service", schema.ServiceLabel, entry.Service)
+			if err != nil {
+				return fail(err, "reading "+internal.LabelInSentence(schema.ServiceLabel))
+			}
 
-		newNotes, err := promptWithDefault("Notes", entry.Notes)
-		if err != nil {
-			fmt.Fprintf(os.Stderr, "Error reading notes: %v\n", err)
-			os.Exit(1)
-		}
+			newUsername := entry.Username
+			if schema.UsernameLabel != "" {
+				newUsername, err = updatedValue(a, cmd, "username", schema.UsernameLabel, entry.Username)
+				if err != nil {
+					return fail(err, "reading "+internal.LabelInSentence(schema.UsernameLabel))
+				}
+			} else if cmd.Flags().Changed("username") {
+				return usageError(fmt.Errorf("a %s has no username", schema.Description))
+			}
 
-		newAlias, err := promptWithDefault("Alias", entry.Alias)
-		if err != nil {
-			fmt.Fprintf(os.Stderr, "Error reading alias: %v\n", err)
-			os.Exit(1)
-		}
+			var newSecret *internal.SecretBuffer
+			password, _ := cmd.Flags().GetString("password")
+			switch secretFile, _ := cmd.Flags().GetString("secret-file"); {
+			case secretFile != "":
+				newSecret, err = readSecretFile(secretFile)
+				if err == nil {
+					err = schema.CheckSecret(newSecret.Bytes())
+				}
+			case cmd.Flags().Changed("password"):
+				newSecret = internal.NewSecretBufferFrom([]byte(password))
+				err = schema.CheckSecret(newSecret.Bytes())
+				if err != nil {
+					err = usageError(err)
+				}
+			case a.structured():
+				newSecret = decryptedPassword.Clone()
+			case schema.Type == internal.TypeLogin:
+				newSecret, err = internal.PromptPasswordWithDefaultAndValidation("Password")
+				if err == nil && newSecret == nil {
+					newSecret = decryptedPassword.Clone()
+				}
+			default:
+				newSecret, err = promptTypeSecret(schema, decryptedPassword)
+			}
+			if err != nil {
+				return fail(err, "reading "+internal.LabelInSentence(schema.SecretLabel))
+			}
+			defer newSecret.Destroy()
 
-		encryptedPassword, err := internal.EncryptPassword(newPassword, masterPassword)
-		if err != nil {
-			fmt.Fprintf(os.Stderr, "Error encrypting password: %v\n", err)
-			os.Exit(1)
-		}
+			newNotes, err := updatedValue(a, cmd, "notes", "Notes", entry.Notes)
+			if err != nil {
+				return fail(err, "reading notes")
+			}
+
+			newAlias, err := updatedValue(a, cmd, "alias", "Alias", entry.Alias)
+			if err != nil {
+				return fail(err, "reading alias")
+			}
+
+			newFolder, err := updatedValue(a, cmd, "folder", "Folder", entry.Folder)
+			if err != nil {
+				return fail(err, "reading folder")
+			}
+
+			newFolder, err = internal.NormalizeFolder(newFolder)
+			if err != nil {
+				return usageError(err)
+			}
+
+			newTags, _ := cmd.Flags().GetStringSlice("tag")
+			if !cmd.Flags().Changed("tag") {
+				newTags = entry.Tags
+				if !a.structured() {
+					tagInput, err := promptWithDefault("Tags", strings.Join(entry.Tags, ", "))
+					if err != nil {
+						return fail(err, "reading tags")
+					}
+					newTags = splitTags(tagInput)
+				}
+			}
+
+			newTags, err = internal.NormalizeTags(newTags)
+			if err != nil {
+				return usageError(err)
+			}
+
+			newURLs, _ := cmd.Flags().GetStringArray("url")
+			if !cmd.Flags().Changed("url") {
+				newURLs = entry.URLs
+				if !a.structured() {
+					urlInput, err := promptWithDefault("URLs", strings.Join(entry.URLs, " "))
+					if err != nil {
+						return fail(err, "reading URLs")
+					}
+					newURLs = nil
+					if urlInput != "-" {
+						newURLs = strings.Fields(urlInput)
+					}
+				}
+			}
+
+			newURLs, err = internal.NormalizeURLs(newURLs)
+			if err != nil {
+				return usageError(err)
+			}
+
+			newURLMatch := entry.URLMatch
+			if cmd.Flags().Changed("url-match") {
+				urlMatch, _ := cmd.Flags().GetString("url-match")
+				if newURLMatch, err = internal.ParseURLMatch(urlMatch); err != nil {
+					return usageError(err)
+				}
+			}
+
+			newRotationDays := entry.RotationDays
+			if rotate, _ := cmd.Flags().GetString("rotate"); cmd.Flags().Changed("rotate") {
+				if newRotationDays, err = parseRotationPeriod(rotate); err != nil {
+					return usageError(err)
+				}
+			}
+
+			var newFields []internal.CustomField
+			switch {
+			case fieldFlagsChanged(cmd):
+				newFields, err = applyFieldFlags(cmd, entry.Fields, vaultKey, entry.UUID)
+				if err != nil {
+					err = usageError(err)
+				}
+			case a.structured():
+				newFields = entry.Fields
+			default:
+				// The type's own fields are prompted for by name, before any others
+				typeFields, otherFields := splitTypeFields(schema, entry.Fields)
+				typeFields, err = promptTypeFields(schema, typeFields, vaultKey, entry.UUID, true)
+				if err == nil {
+					otherFields, err = promptFields(otherFields, vaultKey, entry.UUID)
+				}
+				newFields = append(typeFields, otherFields...)
+			}
+			if err == nil {
+				newFields, err = schema.ConformFields(newFields, vaultKey, entry.UUID)
+			}
+			if err != nil {
+				return fail(err, "updating custom fields")
+			}
+
+			encryptedTOTP, err := updatedTOTP(a, cmd, entry, vaultKey)
+			if err != nil {
+				return err
+			}
+
+			encryptedPassword, err := internal.EncryptSecret(newSecret.Bytes(), vaultKey, entry.UUID)
+			if err != nil {
+				return fail(err, "encrypting "+internal.LabelInSentence(schema.SecretLabel))
+			}
+
+			updated := internal.PasswordEntry{
+				ID:                entry.ID,
+				UUID:              entry.UUID,
+				Type:              entry.Type,
+				Service:           newService,
+				Username:          newUsername,
+				EncryptedPassword: encryptedPassword,
+				EncryptedTOTP:     encryptedTOTP,
+				Notes:             newNotes,
+				Alias:             newAlias,
+				Folder:            newFolder,
+				Tags:              newTags,
+				Fields:            newFields,
+				URLs:              newURLs,
+				URLMatch:          newURLMatch,
+				RotationDays:      newRotationDays,
+			}
+			if err := a.vault.UpdatePassword(updated, vaultKey); err != nil {
+				return fail(err, "updating password")
+			}
+
+			if a.structured() {
+				return renderSavedEntry(a, entry.UUID, vaultKey)
+			}
 
-		if err := internal.UpdatePassword(entry.ID, newService, newUsername, encryptedPassword, newNotes, newAlias); err != nil {
-			fmt.Fprintf(os.Stderr, "Error updating password: %v\n", err)
-			os.Exit(1)
+			fmt.Fprintf(a.prose(), "\n%s updated successfully!\n", capitalize(entryInSentence(&updated)))
+			return nil
+		}),
+	}
+
+	updateCmd.Flags().StringP("query", "q", "", "Search query for service or username")
+	updateCmd.Flags().StringP("service", "s", "", "Set the service name")
+	updateCmd.Flags().StringP("username", "u", "", "Set the username")
+	updateCmd.Flags().StringP("password", "p", "", "Set the password, or the main secret of another type of entry")
+	updateCmd.Flags().StringP("notes", "n", "", "Set the notes")
+	updateCmd.Flags().StringP("alias", "a", "", "Set the alias")
+	updateCmd.Flags().String("secret-file", "", "Replace the main secret, such as a note or private key, with the contents of this file")
+	updateCmd.Flags().StringSlice("tag", nil, "Replace the entry's tags (repeatable or comma-separated)")
+	updateCmd.Flags().String("folder", "", "Move the entry to this folder")
+	updateCmd.Flags().StringArray("url", nil, "Replace the entry's URLs (repeatable)")
+	updateCmd.Flags().String("url-match", "", "How the entry's URLs match a page: domain, host or prefix")
+	updateCmd.Flags().String("rotate", "", "Days after which the password is due to be changed, such as 90d (0 for none of its own)")
+	addFieldFlags(updateCmd)
+	updateCmd.Flags().StringArray("remove-field", nil, "Remove the custom field with this name (repeatable)")
+	updateCmd.Flags().String("totp", "", "Set the TOTP secret, as base32 or an otpauth:// URI")
+	updateCmd.Flags().Bool("remove-totp", false, "Remove the TOTP secret")
+
+	return updateCmd
+}
+
+// updatedTOTP returns the sealed TOTP secret an entry should have after an
+// update, from the --totp and --remove-totp flags or else the prompt. In
+// structured output it is kept unless the flags change it.
+func updatedTOTP(a *app, cmd *cobra.Command, entry *internal.PasswordEntry, vaultKey *internal.VaultKey) (string, error) {
+	if remove, _ := cmd.Flags().GetBool("remove-totp"); remove {
+		return "", nil
+	}
+	if cmd.Flags().Changed("totp") {
+		totp, _ := cmd.Flags().GetString("totp")
+		if totp == "" {
+			return "", nil
 		}
+		return internal.EncryptTOTP(totp, vaultKey, entry.UUID)
+	}
+	if a.structured() {
+		return entry.EncryptedTOTP, nil
+	}
 
-		fmt.Printf("\nPassword for %s (%s) updated successfully!\n", newService, newUsername)
-	},
+	prompt := "TOTP secret or otpauth URI (optional): "
+	if entry.EncryptedTOTP != "" {
+		prompt = "TOTP secret or otpauth URI [set, - removes]: "
+	}
+
+	input, err := internal.PromptSecret(prompt)
+	if err != nil {
+		return "", fmt.Errorf("failed to read TOTP secret: %w", err)
+	}
+	defer input.Destroy()
+
+	switch {
+	case input.Len() == 0:
+		return entry.EncryptedTOTP, nil
+	case bytes.Equal(input.Bytes(), []byte("-")):
+		return "", nil
+	}
+	return internal.EncryptTOTPBytes(input.Bytes(), vaultKey, entry.UUID)
+}
+
+// updatedValue returns the value given with flag, or else prompts for one
+// under label, keeping current if nothing is entered. In structured output
+// current is kept without prompting.
+func updatedValue(a *app, cmd *cobra.Command, flag, label, current string) (string, error) {
+	if cmd.Flags().Changed(flag) {
+		return cmd.Flags().GetString(flag)
+	}
+	if a.structured() {
+		return current, nil
+	}
+	return promptWithDefault(label, current)
 }
 
 func promptWithDefault(prompt, defaultValue string) (string, error) {
@@ -102,9 +322,3 @@ func promptWithDefault(prompt, defaultValue string) (string, error) {
 	}
 	return input, nil
 }
-
-func init() {
-	rootCmd.AddCommand(updateCmd)
-
-	updateCmd.Flags().StringP("query", "q", "", "Search query for service or username")
-}
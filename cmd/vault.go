@@ -0,0 +1,186 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/anmol7470/passvault/internal"
+	"github.com/spf13/cobra"
+)
+
+func newVaultCmd(a *app) *cobra.Command {
+	vaultCmd := &cobra.Command{
+		Use:   "vault",
+		Short: "Manage named vaults",
+		Long: `Manage named vaults, each kept in its own database file with its own master password.
+Every command uses the vault given with --vault or PASSVAULT_VAULT, or else the one chosen
+with 'passvault vault use'. --vault also accepts the path of a database file.`,
+	}
+
+	vaultCmd.AddCommand(newVaultCreateCmd(a))
+	vaultCmd.AddCommand(newVaultListCmd(a))
+	vaultCmd.AddCommand(newVaultUseCmd(a))
+
+	return vaultCmd
+}
+
+func newVaultCreateCmd(a *app) *cobra.Command {
+	vaultCreateCmd := &cobra.Command{
+		Use:         "create <name>",
+		Short:       "Create a named vault",
+		Long:        `Create a named vault and set its master password. If --path points to an existing vault, it is registered under the name instead.`,
+		Args:        cobra.ExactArgs(1),
+		Annotations: map[string]string{skipVaultAnnotation: "true"},
+		RunE: a.run(func(cmd *cobra.Command, args []string) error {
+			name := args[0]
+			path, _ := cmd.Flags().GetString("path")
+			cipherName, _ := cmd.Flags().GetString("cipher")
+
+			if err := internal.ValidateVaultName(name); err != nil {
+				return err
+			}
+
+			suite, err := internal.ParseCipherSuite(cipherName)
+			if err != nil {
+				return err
+			}
+
+			config, err := internal.LoadConfig()
+			if err != nil {
+				return err
+			}
+
+			if _, exists, _ := config.VaultPath(name); exists {
+				return fmt.Errorf("a vault named %q already exists", name)
+			}
+
+			if path == "" {
+				path, err = internal.DefaultVaultPath(name)
+			} else {
+				path, err = filepath.Abs(path)
+			}
+			if err != nil {
+				return err
+			}
+
+			_, statErr := os.Stat(path)
+			existing := statErr == nil
+			if statErr != nil && !errors.Is(statErr, os.ErrNotExist) {
+				return statErr
+			}
+
+			vault, err := a.openVault(path)
+			if err != nil {
+				return fail(err, "initializing database")
+			}
+			defer vault.Close()
+
+			isSet, err := vault.IsMasterPasswordSet()
+			if err != nil {
+				return err
+			}
+
+			if !isSet {
+				if err := vault.Init(suite); err != nil {
+					if !existing {
+						vault.Close()
+						os.Remove(path)
+					}
+					return err
+				}
+			}
+
+			config.Vaults[name] = path
+			if err := config.Save(); err != nil {
+				return err
+			}
+
+			if isSet {
+				fmt.Fprintf(a.prose(), "✓ Existing vault at %s registered as %q.\n", path, name)
+			} else {
+				fmt.Fprintf(a.prose(), "✓ Vault %q created at %s.\n", name, path)
+			}
+			fmt.Fprintf(a.prose(), "Use it with --vault %s, or make it the default with 'passvault vault use %s'.\n", name, name)
+			return nil
+		}),
+	}
+
+	vaultCreateCmd.Flags().String("path", "", "Database file for the vault (defaults to ~/.passvault/vaults/<name>.db)")
+	vaultCreateCmd.Flags().StringP("cipher", "c", internal.DefaultCipherSuite.String(), "Cipher for entries (aes-256-gcm or xchacha20-poly1305)")
+
+	return vaultCreateCmd
+}
+
+func newVaultListCmd(a *app) *cobra.Command {
+	vaultListCmd := &cobra.Command{
+		Use:         "list",
+		Short:       "List named vaults",
+		Annotations: map[string]string{skipVaultAnnotation: "true"},
+		RunE: a.run(func(cmd *cobra.Command, args []string) error {
+			config, err := internal.LoadConfig()
+			if err != nil {
+				return err
+			}
+
+			profiles, err := config.Profiles()
+			if err != nil {
+				return err
+			}
+
+			for _, profile := range profiles {
+				marker := " "
+				if profile.Name == a.vaultName {
+					marker = "*"
+				}
+				fmt.Fprintf(a.prose(), "%s %-16s %s\n", marker, profile.Name, profile.Path)
+			}
+
+			if a.vaultName == "" {
+				fmt.Fprintf(a.prose(), "\nIn use: %s\n", a.vaultPath)
+			}
+			return nil
+		}),
+	}
+
+	return vaultListCmd
+}
+
+func newVaultUseCmd(a *app) *cobra.Command {
+	vaultUseCmd := &cobra.Command{
+		Use:         "use <name>",
+		Short:       "Choose the vault commands use by default",
+		Args:        cobra.ExactArgs(1),
+		Annotations: map[string]string{skipVaultAnnotation: "true"},
+		RunE: a.run(func(cmd *cobra.Command, args []string) error {
+			name := args[0]
+
+			config, err := internal.LoadConfig()
+			if err != nil {
+				return err
+			}
+
+			if _, exists, _ := config.VaultPath(name); !exists {
+				return fmt.Errorf("unknown vault %q; create it with 'passvault vault create %s'", name, name)
+			}
+
+			config.Current = name
+			if name == internal.DefaultVaultName {
+				config.Current = ""
+			}
+
+			if err := config.Save(); err != nil {
+				return err
+			}
+
+			fmt.Fprintf(a.prose(), "✓ Now using vault %q.\n", name)
+			if os.Getenv("PASSVAULT_VAULT") != "" {
+				fmt.Fprintln(a.prose(), "PASSVAULT_VAULT is set and takes precedence in this shell.")
+			}
+			return nil
+		}),
+	}
+
+	return vaultUseCmd
+}
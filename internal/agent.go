@@ -0,0 +1,413 @@
+package internal
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// The agent is a background process that holds unlocked vault keys so that
+// commands do not have to prompt for the master password and run Argon2 each
+// time. It listens on a Unix socket in ~/.passvault, which only the owner can
+// reach, and forgets a key once it has not been used for the idle timeout.
+//
+// Requests and responses are single lines of JSON. A key is only handed out
+// for the vault database it was unlocked for, and only while that vault's
+// wrapped key is unchanged, so changing the master password, rotating the
+// vault key or resetting the vault makes the agent's copy unusable.
+const (
+	DefaultAgentTimeout = 15 * time.Minute
+	agentSocketName     = "agent.sock"
+	agentDialTimeout    = time.Second
+	maxAgentMessage     = 64 * 1024
+)
+
+// Agent operations
+const (
+	agentOpStatus = "status"
+	agentOpUnlock = "unlock"
+	agentOpGet    = "get"
+	agentOpLock   = "lock"
+	agentOpStop   = "stop"
+)
+
+type agentRequest struct {
+	Op         string        `json:"op"`
+	Vault      string        `json:"vault,omitempty"`
+	WrappedKey string        `json:"wrapped_key,omitempty"`
+	Key        []byte        `json:"key,omitempty"`
+	Cipher     CipherSuite   `json:"cipher,omitempty"`
+	Timeout    time.Duration `json:"timeout,omitempty"`
+}
+
+type agentResponse struct {
+	Error  string       `json:"error,omitempty"`
+	Key    []byte       `json:"key,omitempty"`
+	Cipher CipherSuite  `json:"cipher,omitempty"`
+	Vaults []AgentVault `json:"vaults,omitempty"`
+	PID    int          `json:"pid,omitempty"`
+}
+
+// AgentVault describes a vault the agent holds the key of
+type AgentVault struct {
+	Path    string        `json:"path"`
+	LocksIn time.Duration `json:"locks_in"`
+	Timeout time.Duration `json:"timeout"`
+}
+
+// AgentStatus is the state reported by a running agent
+type AgentStatus struct {
+	PID    int
+	Vaults []AgentVault
+}
+
+type agentEntry struct {
+	vaultKey   *VaultKey
+	wrappedKey string
+	timeout    time.Duration
+	lastUsed   time.Time
+	timer      *time.Timer
+}
+
+type agent struct {
+	mu       sync.Mutex
+	entries  map[string]*agentEntry
+	timeout  time.Duration
+	listener net.Listener
+}
+
+// AgentSocketPath returns the path of the agent's Unix socket
+func AgentSocketPath() (string, error) {
+	homeDir, err := os.UserHomeDir()
+	if err != nil {
+		return "", fmt.Errorf("failed to get home directory: %w", err)
+	}
+	return filepath.Join(homeDir, ".passvault", agentSocketName), nil
+}
+
+// RunAgent serves agent requests until the agent is stopped. Keys unlocked
+// without their own timeout are forgotten after timeout of inactivity.
+func RunAgent(timeout time.Duration) error {
+	if timeout <= 0 {
+		return errors.New("agent timeout must be positive")
+	}
+
+	socketPath, err := AgentSocketPath()
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(socketPath), 0700); err != nil {
+		return fmt.Errorf("failed to create agent directory: %w", err)
+	}
+
+	if IsAgentRunning() {
+		return errors.New("an agent is already running")
+	}
+
+	// A socket left behind by an agent that did not shut down cleanly
+	os.Remove(socketPath)
+
+	listener, err := net.Listen("unix", socketPath)
+	if err != nil {
+		return fmt.Errorf("failed to listen on %s: %w", socketPath, err)
+	}
+	defer os.Remove(socketPath)
+
+	if err := os.Chmod(socketPath, 0600); err != nil {
+		listener.Close()
+		return fmt.Errorf("failed to restrict agent socket: %w", err)
+	}
+
+	a := &agent{
+		entries:  make(map[string]*agentEntry),
+		timeout:  timeout,
+		listener: listener,
+	}
+	defer a.lockAll()
+
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return nil
+			}
+			return fmt.Errorf("failed to accept connection: %w", err)
+		}
+		go a.serve(conn)
+	}
+}
+
+func (a *agent) serve(conn net.Conn) {
+	defer conn.Close()
+
+	if !isSameUser(conn) {
+		return
+	}
+
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+	line, err := bufio.NewReaderSize(conn, maxAgentMessage).ReadSlice('\n')
+	if err != nil {
+		return
+	}
+
+	var req agentRequest
+	err = json.Unmarshal(line, &req)
+	Wipe(line)
+	if err != nil {
+		writeAgentMessage(conn, agentResponse{Error: "malformed request"})
+		return
+	}
+	defer Wipe(req.Key)
+
+	resp := a.handle(req)
+	writeAgentMessage(conn, resp)
+	Wipe(resp.Key)
+
+	// Stop only once the caller has its answer
+	if req.Op == agentOpStop {
+		a.listener.Close()
+	}
+}
+
+func (a *agent) handle(req agentRequest) agentResponse {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	switch req.Op {
+	case agentOpStatus:
+		resp := agentResponse{PID: os.Getpid()}
+		for path, entry := range a.entries {
+			resp.Vaults = append(resp.Vaults, AgentVault{
+				Path:    path,
+				LocksIn: entry.timeout - time.Since(entry.lastUsed),
+				Timeout: entry.timeout,
+			})
+		}
+		return resp
+
+	case agentOpUnlock:
+		if req.Vault == "" || req.WrappedKey == "" {
+			return agentResponse{Error: "vault and wrapped key are required"}
+		}
+
+		vaultKey := &VaultKey{key: NewSecretBufferFrom(req.Key), cipher: req.Cipher}
+		if !vaultKey.valid() {
+			vaultKey.Destroy()
+			return agentResponse{Error: "invalid vault key"}
+		}
+
+		timeout := req.Timeout
+		if timeout <= 0 {
+			timeout = a.timeout
+		}
+
+		a.lock(req.Vault)
+		entry := &agentEntry{
+			vaultKey:   vaultKey,
+			wrappedKey: req.WrappedKey,
+			timeout:    timeout,
+			lastUsed:   time.Now(),
+		}
+		vault := req.Vault
+		entry.timer = time.AfterFunc(timeout, func() {
+			a.mu.Lock()
+			defer a.mu.Unlock()
+			if a.entries[vault] == entry {
+				a.lock(vault)
+			}
+		})
+		a.entries[vault] = entry
+		return agentResponse{}
+
+	case agentOpGet:
+		entry, ok := a.entries[req.Vault]
+		if !ok {
+			return agentResponse{Error: "vault is locked"}
+		}
+
+		// The vault's credentials changed since it was unlocked
+		if entry.wrappedKey != req.WrappedKey {
+			a.lock(req.Vault)
+			return agentResponse{Error: "vault is locked"}
+		}
+
+		entry.lastUsed = time.Now()
+		entry.timer.Reset(entry.timeout)
+
+		key := make([]byte, entry.vaultKey.key.Len())
+		copy(key, entry.vaultKey.key.Bytes())
+		return agentResponse{Key: key, Cipher: entry.vaultKey.cipher}
+
+	case agentOpLock:
+		if req.Vault == "" {
+			a.lockAllLocked()
+		} else {
+			a.lock(req.Vault)
+		}
+		return agentResponse{}
+
+	case agentOpStop:
+		a.lockAllLocked()
+		return agentResponse{}
+
+	default:
+		return agentResponse{Error: fmt.Sprintf("unknown operation %q", req.Op)}
+	}
+}
+
+// lock forgets the key of one vault; a.mu must be held
+func (a *agent) lock(vault string) {
+	if entry, ok := a.entries[vault]; ok {
+		entry.timer.Stop()
+		entry.vaultKey.Destroy()
+		delete(a.entries, vault)
+	}
+}
+
+// lockAllLocked forgets every key; a.mu must be held
+func (a *agent) lockAllLocked() {
+	for vault := range a.entries {
+		a.lock(vault)
+	}
+}
+
+func (a *agent) lockAll() {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.lockAllLocked()
+}
+
+func writeAgentMessage(conn net.Conn, message any) error {
+	data, err := json.Marshal(message)
+	if err != nil {
+		return err
+	}
+	defer Wipe(data)
+
+	if _, err := conn.Write(data); err != nil {
+		return err
+	}
+	_, err = conn.Write([]byte{'\n'})
+	return err
+}
+
+// callAgent sends one request to the running agent and returns its response
+func callAgent(req agentRequest) (agentResponse, error) {
+	socketPath, err := AgentSocketPath()
+	if err != nil {
+		return agentResponse{}, err
+	}
+
+	conn, err := net.DialTimeout("unix", socketPath, agentDialTimeout)
+	if err != nil {
+		return agentResponse{}, fmt.Errorf("agent is not running")
+	}
+	defer conn.Close()
+
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+	if err := writeAgentMessage(conn, req); err != nil {
+		return agentResponse{}, fmt.Errorf("failed to send request to agent: %w", err)
+	}
+
+	line, err := bufio.NewReaderSize(conn, maxAgentMessage).ReadSlice('\n')
+	if err != nil {
+		return agentResponse{}, fmt.Errorf("failed to read agent response: %w", err)
+	}
+	defer Wipe(line)
+
+	var resp agentResponse
+	if err := json.Unmarshal(line, &resp); err != nil {
+		return agentResponse{}, fmt.Errorf("malformed agent response: %w", err)
+	}
+
+	if resp.Error != "" {
+		return resp, errors.New(resp.Error)
+	}
+
+	return resp, nil
+}
+
+// IsAgentRunning reports whether an agent is listening on the socket
+func IsAgentRunning() bool {
+	_, err := callAgent(agentRequest{Op: agentOpStatus})
+	return err == nil
+}
+
+// GetAgentStatus returns the state of the running agent
+func GetAgentStatus() (*AgentStatus, error) {
+	resp, err := callAgent(agentRequest{Op: agentOpStatus})
+	if err != nil {
+		return nil, err
+	}
+	return &AgentStatus{PID: resp.PID, Vaults: resp.Vaults}, nil
+}
+
+// AgentUnlock hands the vault key to the running agent, which keeps it until
+// it has been idle for timeout (the agent's default if 0)
+func (v *Vault) AgentUnlock(vaultKey *VaultKey, timeout time.Duration) error {
+	if !vaultKey.valid() {
+		return errors.New("invalid vault key length")
+	}
+
+	wrappedKey, err := v.store.GetWrappedVaultKey()
+	if err != nil {
+		return err
+	}
+
+	key := make([]byte, vaultKey.key.Len())
+	copy(key, vaultKey.key.Bytes())
+	defer Wipe(key)
+
+	_, err = callAgent(agentRequest{
+		Op:         agentOpUnlock,
+		Vault:      v.store.Location(),
+		WrappedKey: wrappedKey,
+		Key:        key,
+		Cipher:     vaultKey.cipher,
+		Timeout:    timeout,
+	})
+	return err
+}
+
+// agentVaultKey returns the vault key from the running agent, or nil if there
+// is no agent or it does not hold a current key for this vault
+func (v *Vault) agentVaultKey() *VaultKey {
+	wrappedKey, err := v.store.GetWrappedVaultKey()
+	if err != nil {
+		return nil
+	}
+
+	resp, err := callAgent(agentRequest{Op: agentOpGet, Vault: v.store.Location(), WrappedKey: wrappedKey})
+	if err != nil {
+		return nil
+	}
+
+	vaultKey := &VaultKey{key: NewSecretBufferFrom(resp.Key), cipher: resp.Cipher}
+	if !vaultKey.valid() {
+		vaultKey.Destroy()
+		return nil
+	}
+
+	return vaultKey
+}
+
+// LockAgent makes the running agent forget the vault key
+func (v *Vault) LockAgent() error {
+	_, err := callAgent(agentRequest{Op: agentOpLock, Vault: v.store.Location()})
+	return err
+}
+
+// StopAgent makes the running agent forget all keys and exit
+func StopAgent() error {
+	_, err := callAgent(agentRequest{Op: agentOpStop})
+	return err
+}
@@ -0,0 +1,35 @@
+//go:build linux
+
+package internal
+
+import (
+	"net"
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// isSameUser reports whether the process on the other end of a Unix socket
+// connection runs as the same user as the agent
+func isSameUser(conn net.Conn) bool {
+	unixConn, ok := conn.(*net.UnixConn)
+	if !ok {
+		return false
+	}
+
+	raw, err := unixConn.SyscallConn()
+	if err != nil {
+		return false
+	}
+
+	var cred *unix.Ucred
+	var credErr error
+	err = raw.Control(func(fd uintptr) {
+		cred, credErr = unix.GetsockoptUcred(int(fd), unix.SOL_SOCKET, unix.SO_PEERCRED)
+	})
+	if err != nil || credErr != nil {
+		return false
+	}
+
+	return int(cred.Uid) == os.Getuid()
+}
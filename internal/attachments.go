@@ -0,0 +1,328 @@
+package internal
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// An entry can carry small files, such as a recovery PDF, a certificate or a
+// license file. The contents of an attachment are split into chunks, each
+// encrypted on its own and bound to the entry, the attachment and its
+// position. The name, size and digest of the file are sealed together in the
+// attachment's info, so nothing about a file is stored in plaintext and
+// chunks that are dropped, reordered or swapped in from elsewhere are caught.
+const (
+	AttachmentChunkSize     = 64 * 1024
+	MaxAttachmentSize       = 10 * 1024 * 1024
+	MaxEntryAttachmentsSize = 25 * 1024 * 1024
+)
+
+// Attachment is a file of an entry in its stored form. Chunks is only filled
+// in when the contents are loaded.
+type Attachment struct {
+	ID            int
+	UUID          string
+	EntryUUID     string
+	EncryptedInfo string
+	Chunks        []string
+	CreatedAt     string
+}
+
+type attachmentInfo struct {
+	Name   string `json:"name"`
+	Size   int    `json:"size"`
+	Chunks int    `json:"chunks"`
+	SHA256 []byte `json:"sha256"`
+}
+
+// AttachmentInfo describes a decrypted attachment
+type AttachmentInfo struct {
+	ID        int
+	UUID      string
+	Name      string
+	Size      int
+	CreatedAt string
+}
+
+func attachmentAAD(entryUUID, attachmentUUID, part string) []byte {
+	return entryAAD(entryUUID, fieldAttachment+":"+attachmentUUID+":"+part)
+}
+
+// checkAttachmentName reports whether name can be the name of an attachment
+func checkAttachmentName(name string) error {
+	if strings.TrimSpace(name) == "" {
+		return errors.New("attachment name cannot be empty")
+	}
+	if name == "." || name == ".." || strings.ContainsAny(name, "/\\") {
+		return fmt.Errorf("invalid attachment name %q", name)
+	}
+	return nil
+}
+
+// AddAttachment encrypts data and attaches it to an entry under name. The
+// name must be unique within the entry, and the file must fit within
+// MaxAttachmentSize and the entry's attachments within MaxEntryAttachmentsSize.
+func (v *Vault) AddAttachment(entryUUID, name string, data []byte, vaultKey *VaultKey) (AttachmentInfo, error) {
+	if err := checkAttachmentName(name); err != nil {
+		return AttachmentInfo{}, err
+	}
+	if len(data) > MaxAttachmentSize {
+		return AttachmentInfo{}, fmt.Errorf("%s is %s; attachments can be at most %s", name, FormatSize(len(data)), FormatSize(MaxAttachmentSize))
+	}
+
+	existing, err := v.ListAttachments(entryUUID, vaultKey)
+	if err != nil {
+		return AttachmentInfo{}, err
+	}
+
+	total := len(data)
+	for _, attachment := range existing {
+		if attachment.Name == name {
+			return AttachmentInfo{}, fmt.Errorf("the entry already has an attachment named %q", name)
+		}
+		total += attachment.Size
+	}
+	if total > MaxEntryAttachmentsSize {
+		return AttachmentInfo{}, fmt.Errorf("the attachments of an entry can be at most %s in total", FormatSize(MaxEntryAttachmentsSize))
+	}
+
+	attachmentUUID, err := NewEntryUUID()
+	if err != nil {
+		return AttachmentInfo{}, err
+	}
+
+	attachment, err := sealAttachment(entryUUID, attachmentUUID, name, data, vaultKey)
+	if err != nil {
+		return AttachmentInfo{}, err
+	}
+
+	if err := v.store.AddAttachment(attachment); err != nil {
+		return AttachmentInfo{}, err
+	}
+
+	return AttachmentInfo{UUID: attachmentUUID, Name: name, Size: len(data)}, nil
+}
+
+// sealAttachment encrypts the chunks and info of an attachment
+func sealAttachment(entryUUID, attachmentUUID, name string, data []byte, vaultKey *VaultKey) (Attachment, error) {
+	if entryUUID == "" {
+		return Attachment{}, errors.New("entry UUID cannot be empty")
+	}
+
+	attachment := Attachment{UUID: attachmentUUID, EntryUUID: entryUUID}
+	for i, offset := 0, 0; offset < len(data) || i == 0; i, offset = i+1, offset+AttachmentChunkSize {
+		chunk := data[offset:min(offset+AttachmentChunkSize, len(data))]
+		sealed, err := encryptValue(chunk, vaultKey, attachmentAAD(entryUUID, attachmentUUID, "chunk:"+strconv.Itoa(i)))
+		if err != nil {
+			return Attachment{}, fmt.Errorf("failed to encrypt %s: %w", name, err)
+		}
+		attachment.Chunks = append(attachment.Chunks, sealed)
+	}
+
+	digest := sha256.Sum256(data)
+	info, err := json.Marshal(attachmentInfo{Name: name, Size: len(data), Chunks: len(attachment.Chunks), SHA256: digest[:]})
+	if err != nil {
+		return Attachment{}, fmt.Errorf("failed to encode attachment info: %w", err)
+	}
+	defer Wipe(info)
+
+	attachment.EncryptedInfo, err = encryptValue(info, vaultKey, attachmentAAD(entryUUID, attachmentUUID, "info"))
+	if err != nil {
+		return Attachment{}, fmt.Errorf("failed to encrypt attachment info: %w", err)
+	}
+
+	return attachment, nil
+}
+
+// openAttachmentInfo decrypts the name, size and digest of an attachment
+func openAttachmentInfo(attachment Attachment, vaultKey *VaultKey) (attachmentInfo, error) {
+	var info attachmentInfo
+
+	plaintext, err := decryptValue(attachment.EncryptedInfo, vaultKey, attachmentAAD(attachment.EntryUUID, attachment.UUID, "info"))
+	if err != nil {
+		return info, fmt.Errorf("failed to decrypt attachment %d: %w", attachment.ID, err)
+	}
+	defer Wipe(plaintext)
+
+	if err := json.Unmarshal(plaintext, &info); err != nil {
+		return info, fmt.Errorf("failed to decode attachment %d: %w", attachment.ID, err)
+	}
+	return info, nil
+}
+
+// openAttachment decrypts the contents of an attachment and checks them
+// against its info
+func openAttachment(attachment Attachment, info attachmentInfo, vaultKey *VaultKey) ([]byte, error) {
+	if len(attachment.Chunks) != info.Chunks {
+		return nil, fmt.Errorf("attachment %s is damaged: expected %d chunks, found %d", info.Name, info.Chunks, len(attachment.Chunks))
+	}
+
+	data := make([]byte, 0, info.Size)
+	for i, sealed := range attachment.Chunks {
+		chunk, err := decryptValue(sealed, vaultKey, attachmentAAD(attachment.EntryUUID, attachment.UUID, "chunk:"+strconv.Itoa(i)))
+		if err != nil {
+			Wipe(data)
+			return nil, fmt.Errorf("failed to decrypt attachment %s: %w", info.Name, err)
+		}
+		data = append(data, chunk...)
+		Wipe(chunk)
+	}
+
+	digest := sha256.Sum256(data)
+	if len(data) != info.Size || !bytes.Equal(digest[:], info.SHA256) {
+		Wipe(data)
+		return nil, fmt.Errorf("attachment %s is damaged: its contents do not match", info.Name)
+	}
+
+	return data, nil
+}
+
+// ListAttachments returns the attachments of an entry in the order they were
+// added
+func (v *Vault) ListAttachments(entryUUID string, vaultKey *VaultKey) ([]AttachmentInfo, error) {
+	stored, err := v.store.ListAttachments(entryUUID)
+	if err != nil {
+		return nil, err
+	}
+
+	attachments := make([]AttachmentInfo, 0, len(stored))
+	for _, attachment := range stored {
+		info, err := openAttachmentInfo(attachment, vaultKey)
+		if err != nil {
+			return nil, err
+		}
+		attachments = append(attachments, AttachmentInfo{
+			ID:        attachment.ID,
+			UUID:      attachment.UUID,
+			Name:      info.Name,
+			Size:      info.Size,
+			CreatedAt: attachment.CreatedAt,
+		})
+	}
+
+	return attachments, nil
+}
+
+// findAttachment returns the attachment of an entry with the given name
+func (v *Vault) findAttachment(entryUUID, name string, vaultKey *VaultKey) (AttachmentInfo, error) {
+	attachments, err := v.ListAttachments(entryUUID, vaultKey)
+	if err != nil {
+		return AttachmentInfo{}, err
+	}
+
+	for _, attachment := range attachments {
+		if attachment.Name == name {
+			return attachment, nil
+		}
+	}
+	return AttachmentInfo{}, fmt.Errorf("the entry has no attachment named %q", name)
+}
+
+// ReadAttachment returns the contents of the attachment of an entry with the
+// given name. The caller wipes the returned slice when done with it.
+func (v *Vault) ReadAttachment(entryUUID, name string, vaultKey *VaultKey) ([]byte, error) {
+	found, err := v.findAttachment(entryUUID, name, vaultKey)
+	if err != nil {
+		return nil, err
+	}
+
+	attachment, err := v.store.GetAttachment(found.ID)
+	if err != nil {
+		return nil, err
+	}
+	if attachment == nil {
+		return nil, fmt.Errorf("the entry has no attachment named %q", name)
+	}
+
+	info, err := openAttachmentInfo(*attachment, vaultKey)
+	if err != nil {
+		return nil, err
+	}
+
+	return openAttachment(*attachment, info, vaultKey)
+}
+
+// RemoveAttachment permanently deletes the attachment of an entry with the
+// given name
+func (v *Vault) RemoveAttachment(entryUUID, name string, vaultKey *VaultKey) error {
+	attachment, err := v.findAttachment(entryUUID, name, vaultKey)
+	if err != nil {
+		return err
+	}
+	return v.store.DeleteAttachment(attachment.ID)
+}
+
+// AttachmentCounts returns how many attachments each entry has, by entry
+// UUID. Counting needs no decryption, so it does not take the vault key.
+func (v *Vault) AttachmentCounts() (map[string]int, error) {
+	attachments, err := v.store.ListAllAttachments()
+	if err != nil {
+		return nil, err
+	}
+
+	counts := make(map[string]int)
+	for _, attachment := range attachments {
+		counts[attachment.EntryUUID]++
+	}
+	return counts, nil
+}
+
+// rekeyAttachments re-encrypts every attachment under newKey
+func (v *Vault) rekeyAttachments(vaultKey, newKey *VaultKey) ([]Attachment, error) {
+	stored, err := v.store.ListAllAttachments()
+	if err != nil {
+		return nil, err
+	}
+
+	attachments := make([]Attachment, 0, len(stored))
+	for _, listed := range stored {
+		attachment, err := v.store.GetAttachment(listed.ID)
+		if err != nil {
+			return nil, err
+		}
+		if attachment == nil {
+			return nil, fmt.Errorf("attachment %d not found", listed.ID)
+		}
+
+		info, err := openAttachmentInfo(*attachment, vaultKey)
+		if err != nil {
+			return nil, err
+		}
+
+		data, err := openAttachment(*attachment, info, vaultKey)
+		if err != nil {
+			return nil, err
+		}
+
+		rekeyed, err := sealAttachment(attachment.EntryUUID, attachment.UUID, info.Name, data, newKey)
+		Wipe(data)
+		if err != nil {
+			return nil, err
+		}
+		rekeyed.ID = attachment.ID
+		attachments = append(attachments, rekeyed)
+	}
+
+	return attachments, nil
+}
+
+// FormatSize returns a number of bytes in a human-readable form, such as 1.5 MB
+func FormatSize(size int) string {
+	const unit = 1024
+	if size < unit {
+		return fmt.Sprintf("%d B", size)
+	}
+
+	value, exponent := float64(size)/unit, 0
+	for value >= unit && exponent < 2 {
+		value /= unit
+		exponent++
+	}
+	number := strings.TrimSuffix(strconv.FormatFloat(value, 'f', 1, 64), ".0")
+	return fmt.Sprintf("%s %cB", number, "KMG"[exponent])
+}
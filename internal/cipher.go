@@ -0,0 +1,106 @@
+package internal
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/chacha20poly1305"
+)
+
+// CipherSuite identifies the AEAD a vault encrypts its entries with. The
+// suite of every ciphertext is recorded in its envelope header.
+type CipherSuite byte
+
+const (
+	CipherAESGCM            CipherSuite = CipherSuite(cipherAESGCM)
+	CipherXChaCha20Poly1305 CipherSuite = CipherSuite(cipherXChaCha20Poly1305)
+)
+
+// DefaultCipherSuite is used for vaults created without choosing a suite
+const DefaultCipherSuite = CipherAESGCM
+
+func (c CipherSuite) String() string {
+	switch c {
+	case CipherAESGCM:
+		return "aes-256-gcm"
+	case CipherXChaCha20Poly1305:
+		return "xchacha20-poly1305"
+	default:
+		return fmt.Sprintf("unknown (%d)", byte(c))
+	}
+}
+
+// ParseCipherSuite returns the cipher suite with the given name
+func ParseCipherSuite(name string) (CipherSuite, error) {
+	switch strings.ToLower(strings.TrimSpace(name)) {
+	case "aes-256-gcm", "aes":
+		return CipherAESGCM, nil
+	case "xchacha20-poly1305", "xchacha20", "xchacha":
+		return CipherXChaCha20Poly1305, nil
+	default:
+		return 0, fmt.Errorf("unknown cipher %q (use aes-256-gcm or xchacha20-poly1305)", name)
+	}
+}
+
+func (c CipherSuite) newAEAD(key []byte) (cipher.AEAD, error) {
+	switch c {
+	case CipherAESGCM:
+		block, err := aes.NewCipher(key)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create cipher: %w", err)
+		}
+
+		gcm, err := cipher.NewGCM(block)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create GCM: %w", err)
+		}
+		return gcm, nil
+	case CipherXChaCha20Poly1305:
+		aead, err := chacha20poly1305.NewX(key)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create XChaCha20-Poly1305: %w", err)
+		}
+		return aead, nil
+	default:
+		return nil, fmt.Errorf("unsupported cipher %d", byte(c))
+	}
+}
+
+// seal encrypts plaintext with a random nonce and returns nonce + ciphertext
+func (c CipherSuite) seal(key, plaintext, aad []byte) ([]byte, error) {
+	aead, err := c.newAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, fmt.Errorf("failed to generate nonce: %w", err)
+	}
+
+	return aead.Seal(nonce, nonce, plaintext, aad), nil
+}
+
+// open decrypts nonce + ciphertext produced by seal
+func (c CipherSuite) open(key, data, aad []byte) ([]byte, error) {
+	aead, err := c.newAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+
+	nonceSize := aead.NonceSize()
+	if len(data) < nonceSize+aead.Overhead() {
+		return nil, errors.New("invalid encrypted value format")
+	}
+
+	plaintext, err := aead.Open(nil, data[:nonceSize], data[nonceSize:], aad)
+	if err != nil {
+		return nil, fmt.Errorf("decryption failed: %w", err)
+	}
+
+	return plaintext, nil
+}
@@ -1,8 +1,6 @@
 package internal
 
 import (
-	"crypto/aes"
-	"crypto/cipher"
 	"crypto/rand"
 	"crypto/subtle"
 	"encoding/base64"
@@ -13,64 +11,97 @@ import (
 )
 
 const (
-	// Argon2 parameters for master password hashing
-	hashTime    = 1
-	hashMemory  = 64 * 1024 // 64 MB
-	hashThreads = 4
-	hashKeyLen  = 32
-
-	// Argon2 parameters for encryption key derivation
-	encTime    = 3
-	encMemory  = 64 * 1024 // 64 MB
-	encThreads = 4
-	encKeyLen  = 32
-
-	saltLen = 16
+	saltLen     = 16
+	vaultKeyLen = 32
 )
 
-func HashMasterPassword(password string) (string, error) {
-	if password == "" {
-		return "", errors.New("password cannot be empty")
-	}
+var (
+	// DefaultHashParams are the Argon2 parameters used for new master password hashes
+	DefaultHashParams = Argon2Params{Time: 1, Memory: 64 * 1024, Threads: 4, KeyLen: 32}
+
+	// DefaultKeyParams are the Argon2 parameters used to derive the key that wraps the vault key
+	DefaultKeyParams = Argon2Params{Time: 3, Memory: 64 * 1024, Threads: 4, KeyLen: 32}
+
+	// Parameters of values written before the envelope format, which did not record them
+	legacyHashParams = Argon2Params{Time: 1, Memory: 64 * 1024, Threads: 4, KeyLen: 32}
+	legacyKeyParams  = Argon2Params{Time: 3, Memory: 64 * 1024, Threads: 4, KeyLen: 32}
+)
 
-	// Generate a random salt
+func deriveKey(password []byte, salt []byte, params Argon2Params) []byte {
+	return argon2.IDKey(password, salt, params.Time, params.Memory, params.Threads, params.KeyLen)
+}
+
+func newSalt() ([]byte, error) {
 	salt := make([]byte, saltLen)
 	if _, err := rand.Read(salt); err != nil {
-		return "", fmt.Errorf("failed to generate salt: %w", err)
+		return nil, fmt.Errorf("failed to generate salt: %w", err)
+	}
+	return salt, nil
+}
+
+// HashMasterPassword hashes the master password with Argon2id using the given parameters
+func HashMasterPassword(password *SecretBuffer, params Argon2Params) (string, error) {
+	if password.Len() == 0 {
+		return "", errors.New("password cannot be empty")
+	}
+	if err := params.validate(); err != nil {
+		return "", err
+	}
+
+	salt, err := newSalt()
+	if err != nil {
+		return "", err
 	}
 
-	// Hash the password using Argon2id
-	hash := argon2.IDKey([]byte(password), salt, hashTime, hashMemory, hashThreads, hashKeyLen)
+	hash := deriveKey(password.Bytes(), salt, params)
 
-	// Combine salt + hash and encode as base64
-	combined := append(salt, hash...)
-	return base64.StdEncoding.EncodeToString(combined), nil
+	return envelope{
+		kdf:     kdfArgon2id,
+		params:  params,
+		salt:    salt,
+		cipher:  cipherNone,
+		payload: hash,
+	}.encode(), nil
 }
 
-func VerifyMasterPassword(password, encodedHash string) error {
-	if password == "" {
+func VerifyMasterPassword(password *SecretBuffer, encodedHash string) error {
+	if password.Len() == 0 {
 		return errors.New("password cannot be empty")
 	}
 	if encodedHash == "" {
 		return errors.New("hash cannot be empty")
 	}
 
-	// Decode the base64 hash
-	combined, err := base64.StdEncoding.DecodeString(encodedHash)
-	if err != nil {
-		return fmt.Errorf("failed to decode hash: %w", err)
+	var salt, storedHash []byte
+	params := legacyHashParams
+
+	if isEnvelope(encodedHash) {
+		e, err := parseEnvelope(encodedHash)
+		if err != nil {
+			return err
+		}
+		if e.kdf != kdfArgon2id || e.cipher != cipherNone {
+			return errors.New("invalid hash format")
+		}
+		salt, storedHash, params = e.salt, e.payload, e.params
+	} else {
+		// Legacy format: base64(salt + hash)
+		combined, err := base64.StdEncoding.DecodeString(encodedHash)
+		if err != nil {
+			return fmt.Errorf("failed to decode hash: %w", err)
+		}
+		if len(combined) != saltLen+int(params.KeyLen) {
+			return errors.New("invalid hash format")
+		}
+		salt, storedHash = combined[:saltLen], combined[saltLen:]
 	}
 
-	if len(combined) != saltLen+hashKeyLen {
+	if len(storedHash) != int(params.KeyLen) {
 		return errors.New("invalid hash format")
 	}
 
-	// Extract salt and hash
-	salt := combined[:saltLen]
-	storedHash := combined[saltLen:]
-
-	// Hash the provided password with the same salt
-	computedHash := argon2.IDKey([]byte(password), salt, hashTime, hashMemory, hashThreads, hashKeyLen)
+	// Hash the provided password with the same salt and parameters
+	computedHash := deriveKey(password.Bytes(), salt, params)
 
 	// Use constant-time comparison to prevent timing attacks
 	if subtle.ConstantTimeCompare(storedHash, computedHash) != 1 {
@@ -80,104 +111,273 @@ func VerifyMasterPassword(password, encodedHash string) error {
 	return nil
 }
 
-// EncryptPassword encrypts a password using AES-256-GCM with a key derived from the master password
-func EncryptPassword(password, masterPassword string) (string, error) {
-	if password == "" {
-		return "", errors.New("password cannot be empty")
+// VaultKey is the unlocked data-encryption key of a vault together with the
+// cipher suite its entries are encrypted with
+type VaultKey struct {
+	key    *SecretBuffer
+	cipher CipherSuite
+}
+
+// Cipher returns the cipher suite the vault encrypts entries with
+func (k *VaultKey) Cipher() CipherSuite {
+	return k.cipher
+}
+
+func (k *VaultKey) valid() bool {
+	return k != nil && k.key.Len() == vaultKeyLen
+}
+
+// Destroy wipes the vault key from memory; the key cannot be used afterwards
+func (k *VaultKey) Destroy() {
+	if k != nil {
+		k.key.Destroy()
+	}
+}
+
+// GenerateVaultKey returns a new random data-encryption key for the vault
+func GenerateVaultKey(suite CipherSuite) (*VaultKey, error) {
+	if _, err := suite.newAEAD(make([]byte, vaultKeyLen)); err != nil {
+		return nil, err
+	}
+
+	key := NewSecretBuffer(vaultKeyLen)
+	if _, err := rand.Read(key.Bytes()); err != nil {
+		key.Destroy()
+		return nil, fmt.Errorf("failed to generate vault key: %w", err)
 	}
-	if masterPassword == "" {
+	return &VaultKey{key: key, cipher: suite}, nil
+}
+
+// WrapVaultKey encrypts the vault key with a key-encryption key derived from
+// the master password, using the vault's cipher suite
+func WrapVaultKey(vaultKey *VaultKey, masterPassword *SecretBuffer, params Argon2Params) (string, error) {
+	if !vaultKey.valid() {
+		return "", errors.New("invalid vault key length")
+	}
+	if masterPassword.Len() == 0 {
 		return "", errors.New("master password cannot be empty")
 	}
+	if err := params.validate(); err != nil {
+		return "", err
+	}
+	if params.KeyLen != 32 {
+		return "", errors.New("key-encryption key must be 32 bytes")
+	}
 
-	// Generate a random salt for key derivation
-	salt := make([]byte, saltLen)
-	if _, err := rand.Read(salt); err != nil {
-		return "", fmt.Errorf("failed to generate salt: %w", err)
+	salt, err := newSalt()
+	if err != nil {
+		return "", err
 	}
 
-	// Derive encryption key from master password using Argon2id
-	key := argon2.IDKey([]byte(masterPassword), salt, encTime, encMemory, encThreads, encKeyLen)
+	kek := deriveKey(masterPassword.Bytes(), salt, params)
+	defer Wipe(kek)
 
-	// Create AES cipher
-	block, err := aes.NewCipher(key)
+	sealed, err := vaultKey.cipher.seal(kek, vaultKey.key.Bytes(), nil)
 	if err != nil {
-		return "", fmt.Errorf("failed to create cipher: %w", err)
+		return "", err
 	}
 
-	// Create GCM mode
-	gcm, err := cipher.NewGCM(block)
+	return envelope{
+		kdf:     kdfArgon2id,
+		params:  params,
+		salt:    salt,
+		cipher:  byte(vaultKey.cipher),
+		payload: sealed,
+	}.encode(), nil
+}
+
+// UnwrapVaultKey decrypts a wrapped vault key using the master password. The
+// cipher suite recorded in the wrapped key becomes the vault's suite.
+func UnwrapVaultKey(wrappedKey string, masterPassword *SecretBuffer) (*VaultKey, error) {
+	if wrappedKey == "" {
+		return nil, errors.New("wrapped key cannot be empty")
+	}
+	if masterPassword.Len() == 0 {
+		return nil, errors.New("master password cannot be empty")
+	}
+
+	var salt, sealed []byte
+	params := legacyKeyParams
+	suite := CipherAESGCM
+
+	if isEnvelope(wrappedKey) {
+		e, err := parseEnvelope(wrappedKey)
+		if err != nil {
+			return nil, err
+		}
+		if e.kdf != kdfArgon2id || e.cipher == cipherNone || e.params.KeyLen != 32 {
+			return nil, errors.New("invalid wrapped key format")
+		}
+		salt, sealed, params, suite = e.salt, e.payload, e.params, CipherSuite(e.cipher)
+	} else {
+		// Legacy format: base64(salt + nonce + ciphertext)
+		combined, err := base64.StdEncoding.DecodeString(wrappedKey)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode wrapped key: %w", err)
+		}
+		if len(combined) < saltLen {
+			return nil, errors.New("invalid wrapped key format")
+		}
+		salt, sealed = combined[:saltLen], combined[saltLen:]
+	}
+
+	kek := deriveKey(masterPassword.Bytes(), salt, params)
+	defer Wipe(kek)
+
+	key, err := suite.open(kek, sealed, nil)
 	if err != nil {
-		return "", fmt.Errorf("failed to create GCM: %w", err)
+		return nil, fmt.Errorf("failed to unwrap vault key: %w", err)
 	}
 
-	// Generate a random nonce
-	nonce := make([]byte, gcm.NonceSize())
-	if _, err := rand.Read(nonce); err != nil {
-		return "", fmt.Errorf("failed to generate nonce: %w", err)
+	if len(key) != vaultKeyLen {
+		Wipe(key)
+		return nil, errors.New("invalid vault key length")
 	}
 
-	// Encrypt the password
-	ciphertext := gcm.Seal(nil, nonce, []byte(password), nil)
+	return &VaultKey{key: NewSecretBufferFrom(key), cipher: suite}, nil
+}
+
+// ErrTampered is returned when a ciphertext fails authentication against the
+// entry and field it is bound to
+var ErrTampered = errors.New("ciphertext failed authentication: the entry may have been tampered with or moved from another entry")
+
+// Entry fields whose ciphertexts are bound to the entry via additional authenticated data
+const (
+	fieldPassword   = "password"
+	fieldMetadata   = "metadata"
+	fieldHistory    = "history"
+	fieldCustom     = "field"
+	fieldTOTP       = "totp"
+	fieldAttachment = "attachment"
+)
+
+// entryAAD returns the additional authenticated data binding a ciphertext to
+// one field of one entry, so it cannot be swapped onto another row or field
+func entryAAD(entryUUID, field string) []byte {
+	return []byte("passvault:entry:" + entryUUID + ":" + field)
+}
 
-	// Combine salt + nonce + ciphertext and encode as base64
-	combined := append(salt, nonce...)
-	combined = append(combined, ciphertext...)
-	return base64.StdEncoding.EncodeToString(combined), nil
+// EncryptPassword encrypts a password with the vault key's cipher suite,
+// binding the ciphertext to the entry it belongs to
+func EncryptPassword(password []byte, vaultKey *VaultKey, entryUUID string) (string, error) {
+	if len(password) == 0 {
+		return "", errors.New("password cannot be empty")
+	}
+	if entryUUID == "" {
+		return "", errors.New("entry UUID cannot be empty")
+	}
+	return encryptValue(password, vaultKey, entryAAD(entryUUID, fieldPassword))
 }
 
-// DecryptPassword decrypts a password using AES-256-GCM with a key derived from the master password
-func DecryptPassword(encryptedPassword, masterPassword string) (string, error) {
+// DecryptPassword decrypts a password with the vault key's cipher suite,
+// returning ErrTampered if it does not belong to the given entry. The caller
+// destroys the returned buffer when done with the password.
+func DecryptPassword(encryptedPassword string, vaultKey *VaultKey, entryUUID string) (*SecretBuffer, error) {
 	if encryptedPassword == "" {
-		return "", errors.New("encrypted password cannot be empty")
+		return nil, errors.New("encrypted password cannot be empty")
 	}
-	if masterPassword == "" {
-		return "", errors.New("master password cannot be empty")
+	if entryUUID == "" {
+		return nil, errors.New("entry UUID cannot be empty")
 	}
 
-	// Decode the base64 encrypted password
-	combined, err := base64.StdEncoding.DecodeString(encryptedPassword)
+	plaintext, err := decryptValue(encryptedPassword, vaultKey, entryAAD(entryUUID, fieldPassword))
 	if err != nil {
-		return "", fmt.Errorf("failed to decode encrypted password: %w", err)
+		return nil, err
 	}
 
-	// Minimum length check: salt (16) + nonce (12) + at least some ciphertext + tag (16)
-	if len(combined) < saltLen+12+16 {
-		return "", errors.New("invalid encrypted password format")
+	return NewSecretBufferFrom(plaintext), nil
+}
+
+// encryptValue seals plaintext under the vault key with the vault's cipher
+// suite in an envelope. A non-nil aad binds the ciphertext to it.
+func encryptValue(plaintext []byte, vaultKey *VaultKey, aad []byte) (string, error) {
+	if !vaultKey.valid() {
+		return "", errors.New("invalid vault key length")
 	}
 
-	// Extract salt
-	salt := combined[:saltLen]
-	remaining := combined[saltLen:]
+	sealed, err := vaultKey.cipher.seal(vaultKey.key.Bytes(), plaintext, aad)
+	if err != nil {
+		return "", err
+	}
+
+	var flags byte
+	if aad != nil {
+		flags |= flagBound
+	}
 
-	// Derive encryption key from master password using the same salt
-	key := argon2.IDKey([]byte(masterPassword), salt, encTime, encMemory, encThreads, encKeyLen)
+	return envelope{
+		flags:   flags,
+		kdf:     kdfNone,
+		cipher:  byte(vaultKey.cipher),
+		payload: sealed,
+	}.encode(), nil
+}
 
-	// Create AES cipher
-	block, err := aes.NewCipher(key)
-	if err != nil {
-		return "", fmt.Errorf("failed to create cipher: %w", err)
+// decryptValue opens a value sealed by encryptValue, using the cipher suite
+// recorded in its envelope. With a non-nil aad the value must be bound to it;
+// with a nil aad it must be an unbound value, such as a bare base64 AES-GCM
+// nonce + ciphertext written before the envelope format existed.
+func decryptValue(encoded string, vaultKey *VaultKey, aad []byte) ([]byte, error) {
+	if !vaultKey.valid() {
+		return nil, errors.New("invalid vault key length")
+	}
+
+	var sealed []byte
+	bound := false
+	suite := CipherAESGCM
+
+	if isEnvelope(encoded) {
+		e, err := parseEnvelope(encoded)
+		if err != nil {
+			return nil, err
+		}
+		if e.kdf != kdfNone || e.cipher == cipherNone {
+			return nil, errors.New("invalid encrypted value format")
+		}
+		sealed = e.payload
+		bound = e.flags&flagBound != 0
+		suite = CipherSuite(e.cipher)
+	} else {
+		combined, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode encrypted value: %w", err)
+		}
+		sealed = combined
 	}
 
-	// Create GCM mode
-	gcm, err := cipher.NewGCM(block)
+	if bound != (aad != nil) {
+		return nil, ErrTampered
+	}
+
+	plaintext, err := suite.open(vaultKey.key.Bytes(), sealed, aad)
+	if err != nil && aad != nil {
+		return nil, ErrTampered
+	}
+
+	return plaintext, err
+}
+
+// decryptLegacyPassword decrypts an entry written before the vault key existed,
+// where every entry carried its own salt and derived its key from the master password
+func decryptLegacyPassword(encryptedPassword string, masterPassword *SecretBuffer) (*SecretBuffer, error) {
+	combined, err := base64.StdEncoding.DecodeString(encryptedPassword)
 	if err != nil {
-		return "", fmt.Errorf("failed to create GCM: %w", err)
+		return nil, fmt.Errorf("failed to decode encrypted password: %w", err)
 	}
 
-	nonceSize := gcm.NonceSize()
-	if len(remaining) < nonceSize {
-		return "", errors.New("invalid encrypted password format")
+	// Minimum length check: salt (16) + nonce (12) + tag (16)
+	if len(combined) < saltLen+12+16 {
+		return nil, errors.New("invalid encrypted password format")
 	}
 
-	// Extract nonce and ciphertext
-	nonce := remaining[:nonceSize]
-	ciphertext := remaining[nonceSize:]
+	salt := combined[:saltLen]
+	key := deriveKey(masterPassword.Bytes(), salt, legacyKeyParams)
+	defer Wipe(key)
 
-	// Decrypt the password
-	plaintext, err := gcm.Open(nil, nonce, ciphertext, nil)
+	plaintext, err := CipherAESGCM.open(key, combined[saltLen:], nil)
 	if err != nil {
-		return "", fmt.Errorf("decryption failed: %w", err)
+		return nil, err
 	}
 
-	return string(plaintext), nil
+	return NewSecretBufferFrom(plaintext), nil
 }
@@ -2,100 +2,72 @@ package internal
 
 import (
 	"database/sql"
+	"errors"
 	"fmt"
 	"os"
 	"path/filepath"
 	"strings"
+	"time"
 
-	_ "github.com/mattn/go-sqlite3"
+	"github.com/mattn/go-sqlite3"
 )
 
-var DB *sql.DB
+// SQLiteStore is a Store backed by a SQLite database file
+type SQLiteStore struct {
+	db         *sql.DB
+	path       string
+	backupPath string
+}
 
-func InitDB() error {
-	homeDir, err := os.UserHomeDir()
-	if err != nil {
-		return fmt.Errorf("failed to get home directory: %w", err)
-	}
+var _ Store = (*SQLiteStore)(nil)
 
-	passvaultDir := filepath.Join(homeDir, ".passvault")
-	if err := os.MkdirAll(passvaultDir, 0700); err != nil {
-		return fmt.Errorf("failed to create .passvault directory: %w", err)
+// OpenSQLiteStore opens the vault database at path, creating it if needed and
+// migrating it to the latest schema
+func OpenSQLiteStore(path string) (*SQLiteStore, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return nil, fmt.Errorf("failed to create vault directory: %w", err)
 	}
 
-	dbPath := filepath.Join(passvaultDir, "passvault.db")
-
-	db, err := sql.Open("sqlite3", dbPath)
+	db, err := sql.Open("sqlite3", path)
 	if err != nil {
-		return fmt.Errorf("failed to open database: %w", err)
+		return nil, fmt.Errorf("failed to open database: %w", err)
 	}
 
 	if err := db.Ping(); err != nil {
-		return fmt.Errorf("failed to ping database: %w", err)
+		db.Close()
+		return nil, fmt.Errorf("failed to ping database: %w", err)
 	}
 
-	DB = db
+	s := &SQLiteStore{db: db, path: path}
 
-	if err := createTables(); err != nil {
-		return fmt.Errorf("failed to create tables: %w", err)
+	if err := s.migrate(); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to migrate database: %w", err)
 	}
 
-	return nil
+	return s, nil
 }
 
-func createTables() error {
-	masterPasswordTable := `
-	CREATE TABLE IF NOT EXISTS master_password (
-		id INTEGER PRIMARY KEY CHECK (id = 1),
-		password_hash TEXT NOT NULL,
-		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
-		updated_at DATETIME DEFAULT CURRENT_TIMESTAMP
-	);
-	`
-
-	passwordsTable := `
-	CREATE TABLE IF NOT EXISTS passwords (
-		id INTEGER PRIMARY KEY AUTOINCREMENT,
-		service TEXT NOT NULL,
-		username TEXT NOT NULL,
-		encrypted_password TEXT NOT NULL,
-		notes TEXT,
-		alias TEXT UNIQUE,
-		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
-		updated_at DATETIME DEFAULT CURRENT_TIMESTAMP,
-		UNIQUE(service, username)
-	);
-	`
-
-	if _, err := DB.Exec(masterPasswordTable); err != nil {
-		return fmt.Errorf("failed to create master_password table: %w", err)
-	}
-
-	if _, err := DB.Exec(passwordsTable); err != nil {
-		return fmt.Errorf("failed to create passwords table: %w", err)
-	}
-
-	return nil
+// Location returns the path of the database file
+func (s *SQLiteStore) Location() string {
+	return s.path
 }
 
-func CloseDB() error {
-	if DB != nil {
-		return DB.Close()
-	}
-	return nil
+func (s *SQLiteStore) Close() error {
+	return s.db.Close()
 }
 
-func IsMasterPasswordSet() (bool, error) {
+func (s *SQLiteStore) IsMasterPasswordSet() (bool, error) {
 	var count int
-	err := DB.QueryRow("SELECT COUNT(*) FROM master_password").Scan(&count)
+	err := s.db.QueryRow("SELECT COUNT(*) FROM master_password").Scan(&count)
 	if err != nil {
 		return false, fmt.Errorf("failed to check master password: %w", err)
 	}
 	return count > 0, nil
 }
 
-func SetMasterPassword(hashedPassword string) error {
-	isSet, err := IsMasterPasswordSet()
+func (s *SQLiteStore) InitializeVault(hashedPassword, wrappedKey string) error {
+	isSet, err := s.IsMasterPasswordSet()
 	if err != nil {
 		return err
 	}
@@ -104,17 +76,31 @@ func SetMasterPassword(hashedPassword string) error {
 		return fmt.Errorf("master password is already set")
 	}
 
-	_, err = DB.Exec("INSERT INTO master_password (id, password_hash) VALUES (1, ?)", hashedPassword)
+	tx, err := s.db.Begin()
 	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec("INSERT INTO master_password (id, password_hash) VALUES (1, ?)", hashedPassword); err != nil {
 		return fmt.Errorf("failed to set master password: %w", err)
 	}
 
+	// A vault key left behind by an interrupted reset is replaced
+	if _, err := tx.Exec("INSERT OR REPLACE INTO vault_key (id, wrapped_key) VALUES (1, ?)", wrappedKey); err != nil {
+		return fmt.Errorf("failed to store vault key: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
 	return nil
 }
 
-func GetMasterPasswordHash() (string, error) {
+func (s *SQLiteStore) GetMasterPasswordHash() (string, error) {
 	var hash string
-	err := DB.QueryRow("SELECT password_hash FROM master_password WHERE id = 1").Scan(&hash)
+	err := s.db.QueryRow("SELECT password_hash FROM master_password WHERE id = 1").Scan(&hash)
 	if err != nil {
 		if err == sql.ErrNoRows {
 			return "", fmt.Errorf("master password not set")
@@ -124,8 +110,51 @@ func GetMasterPasswordHash() (string, error) {
 	return hash, nil
 }
 
-func UpdateMasterPassword(hashedPassword string) error {
-	result, err := DB.Exec("UPDATE master_password SET password_hash = ?, updated_at = CURRENT_TIMESTAMP WHERE id = 1", hashedPassword)
+func (s *SQLiteStore) IsVaultKeySet() (bool, error) {
+	var count int
+	err := s.db.QueryRow("SELECT COUNT(*) FROM vault_key").Scan(&count)
+	if err != nil {
+		return false, fmt.Errorf("failed to check vault key: %w", err)
+	}
+	return count > 0, nil
+}
+
+func (s *SQLiteStore) GetWrappedVaultKey() (string, error) {
+	var wrappedKey string
+	err := s.db.QueryRow("SELECT wrapped_key FROM vault_key WHERE id = 1").Scan(&wrappedKey)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return "", fmt.Errorf("vault key not set")
+		}
+		return "", fmt.Errorf("failed to get vault key: %w", err)
+	}
+	return wrappedKey, nil
+}
+
+func (s *SQLiteStore) UpdateMasterCredentials(hashedPassword, wrappedKey string, settings map[string]string) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	if err := updateMasterCredentialsTx(tx, hashedPassword, wrappedKey); err != nil {
+		return err
+	}
+
+	if err := setSettingsTx(tx, settings); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	return nil
+}
+
+func updateMasterCredentialsTx(tx *sql.Tx, hashedPassword, wrappedKey string) error {
+	result, err := tx.Exec("UPDATE master_password SET password_hash = ?, updated_at = CURRENT_TIMESTAMP WHERE id = 1", hashedPassword)
 	if err != nil {
 		return fmt.Errorf("failed to update master password: %w", err)
 	}
@@ -139,177 +168,268 @@ func UpdateMasterPassword(hashedPassword string) error {
 		return fmt.Errorf("master password not found")
 	}
 
-	return nil
-}
+	result, err = tx.Exec("UPDATE vault_key SET wrapped_key = ?, updated_at = CURRENT_TIMESTAMP WHERE id = 1", wrappedKey)
+	if err != nil {
+		return fmt.Errorf("failed to update vault key: %w", err)
+	}
 
-func AddPassword(service, username, encryptedPassword, notes, alias string) error {
-	_, err := DB.Exec(
-		"INSERT INTO passwords (service, username, encrypted_password, notes, alias) VALUES (?, ?, ?, ?, ?)",
-		service, username, encryptedPassword, notes, alias,
-	)
+	rowsAffected, err = result.RowsAffected()
 	if err != nil {
-		return fmt.Errorf("failed to add password: %w", err)
+		return fmt.Errorf("failed to check rows affected: %w", err)
+	}
+
+	if rowsAffected == 0 {
+		return fmt.Errorf("vault key not found")
 	}
-	return nil
-}
 
-type PasswordEntry struct {
-	ID                int
-	Service           string
-	Username          string
-	EncryptedPassword string
-	Notes             string
-	Alias             string
-	CreatedAt         string
-	UpdatedAt         string
+	return nil
 }
 
-func ListAllPasswords() ([]PasswordEntry, error) {
-	rows, err := DB.Query("SELECT id, service, username, encrypted_password, notes, alias, created_at, updated_at FROM passwords ORDER BY service, username")
+func (s *SQLiteStore) MigrateToVaultKey(wrappedKey string, entries []PasswordEntry) error {
+	tx, err := s.db.Begin()
 	if err != nil {
-		return nil, fmt.Errorf("failed to query passwords: %w", err)
+		return fmt.Errorf("failed to begin transaction: %w", err)
 	}
-	defer rows.Close()
+	defer tx.Rollback()
 
-	var entries []PasswordEntry
-	for rows.Next() {
-		var entry PasswordEntry
-		var alias sql.NullString
-		if err := rows.Scan(&entry.ID, &entry.Service, &entry.Username, &entry.EncryptedPassword, &entry.Notes, &alias, &entry.CreatedAt, &entry.UpdatedAt); err != nil {
-			return nil, fmt.Errorf("failed to scan password entry: %w", err)
-		}
-		if alias.Valid {
-			entry.Alias = alias.String
+	if _, err := tx.Exec("INSERT INTO vault_key (id, wrapped_key) VALUES (1, ?)", wrappedKey); err != nil {
+		return fmt.Errorf("failed to store vault key: %w", err)
+	}
+
+	for _, entry := range entries {
+		_, err := tx.Exec(
+			"UPDATE passwords SET uuid = ?, encrypted_password = ? WHERE id = ?",
+			entry.UUID, entry.EncryptedPassword, entry.ID,
+		)
+		if err != nil {
+			return fmt.Errorf("failed to update password for %s: %w", entry.Service, err)
 		}
-		entries = append(entries, entry)
 	}
 
-	if err := rows.Err(); err != nil {
-		return nil, fmt.Errorf("error iterating passwords: %w", err)
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
 	}
 
-	return entries, nil
+	return nil
 }
 
-func SearchPasswords(query string) ([]PasswordEntry, error) {
-	searchPattern := "%" + strings.ToLower(query) + "%"
-	rows, err := DB.Query(
-		"SELECT id, service, username, encrypted_password, notes, alias, created_at, updated_at FROM passwords WHERE LOWER(service) LIKE ? OR LOWER(username) LIKE ? OR LOWER(notes) LIKE ? OR LOWER(alias) LIKE ? ORDER BY service, username",
-		searchPattern, searchPattern, searchPattern, searchPattern,
-	)
+func (s *SQLiteStore) Rekey(hashedPassword, wrappedKey string, entries []PasswordEntry, revisions []EntryRevision, attachments []Attachment, settings map[string]string, check RekeyCheck) error {
+	tx, err := s.db.Begin()
 	if err != nil {
-		return nil, fmt.Errorf("failed to search passwords: %w", err)
+		return fmt.Errorf("failed to begin transaction: %w", err)
 	}
-	defer rows.Close()
+	defer tx.Rollback()
 
-	var entries []PasswordEntry
-	for rows.Next() {
-		var entry PasswordEntry
-		var alias sql.NullString
-		if err := rows.Scan(&entry.ID, &entry.Service, &entry.Username, &entry.EncryptedPassword, &entry.Notes, &alias, &entry.CreatedAt, &entry.UpdatedAt); err != nil {
-			return nil, fmt.Errorf("failed to scan password entry: %w", err)
-		}
-		if alias.Valid {
-			entry.Alias = alias.String
-		}
-		entries = append(entries, entry)
+	if _, err := tx.Exec("UPDATE master_password SET password_hash = ?, updated_at = CURRENT_TIMESTAMP WHERE id = 1", hashedPassword); err != nil {
+		return fmt.Errorf("failed to update master password: %w", err)
 	}
 
-	if err := rows.Err(); err != nil {
-		return nil, fmt.Errorf("error iterating passwords: %w", err)
+	if _, err := tx.Exec("UPDATE vault_key SET wrapped_key = ?, updated_at = CURRENT_TIMESTAMP WHERE id = 1", wrappedKey); err != nil {
+		return fmt.Errorf("failed to update vault key: %w", err)
 	}
 
-	return entries, nil
-}
+	if _, err := tx.Exec("DELETE FROM recovery_codes"); err != nil {
+		return fmt.Errorf("failed to delete recovery codes: %w", err)
+	}
 
-func GetPasswordByAlias(alias string) (*PasswordEntry, error) {
-	var entry PasswordEntry
-	var aliasNull sql.NullString
-	err := DB.QueryRow(
-		"SELECT id, service, username, encrypted_password, notes, alias, created_at, updated_at FROM passwords WHERE alias = ?",
-		alias,
-	).Scan(&entry.ID, &entry.Service, &entry.Username, &entry.EncryptedPassword, &entry.Notes, &aliasNull, &entry.CreatedAt, &entry.UpdatedAt)
+	if err := setSettingsTx(tx, settings); err != nil {
+		return err
+	}
+
+	if err := rewriteEntriesTx(tx, entries); err != nil {
+		return err
+	}
+
+	for _, revision := range revisions {
+		if _, err := tx.Exec("UPDATE password_history SET encrypted_password = ?, encrypted_data = ? WHERE id = ?", revision.EncryptedPassword, revision.EncryptedData, revision.ID); err != nil {
+			return fmt.Errorf("failed to update password history: %w", err)
+		}
+	}
 
+	rows, err := tx.Query("SELECT " + revisionColumns + " FROM password_history ORDER BY id")
 	if err != nil {
-		if err == sql.ErrNoRows {
-			return nil, nil
+		return fmt.Errorf("failed to query password history: %w", err)
+	}
+
+	rewrittenRevisions, err := scanRevisions(rows)
+	if err != nil {
+		return err
+	}
+
+	for _, revision := range rewrittenRevisions {
+		if err := check.Revision(revision); err != nil {
+			return fmt.Errorf("verification failed for revision %d: %w", revision.ID, err)
 		}
-		return nil, fmt.Errorf("failed to get password by alias: %w", err)
 	}
 
-	if aliasNull.Valid {
-		entry.Alias = aliasNull.String
+	if len(rewrittenRevisions) != len(revisions) {
+		return fmt.Errorf("verification failed: expected %d revisions, found %d", len(revisions), len(rewrittenRevisions))
 	}
 
-	return &entry, nil
-}
+	for _, attachment := range attachments {
+		if _, err := tx.Exec("UPDATE attachments SET encrypted_info = ? WHERE id = ?", attachment.EncryptedInfo, attachment.ID); err != nil {
+			return fmt.Errorf("failed to update attachment: %w", err)
+		}
+		if err := setAttachmentChunksTx(tx, int64(attachment.ID), attachment.Chunks); err != nil {
+			return err
+		}
+	}
 
-func UpdatePassword(id int, service, username, encryptedPassword, notes, alias string) error {
-	result, err := DB.Exec(
-		"UPDATE passwords SET service = ?, username = ?, encrypted_password = ?, notes = ?, alias = ?, updated_at = CURRENT_TIMESTAMP WHERE id = ?",
-		service, username, encryptedPassword, notes, alias, id,
-	)
+	rows, err = tx.Query("SELECT " + attachmentColumns + " FROM attachments ORDER BY id")
 	if err != nil {
-		return fmt.Errorf("failed to update password: %w", err)
+		return fmt.Errorf("failed to query attachments: %w", err)
 	}
 
-	rowsAffected, err := result.RowsAffected()
+	rewrittenAttachments, err := scanAttachments(rows)
 	if err != nil {
-		return fmt.Errorf("failed to check rows affected: %w", err)
+		return err
 	}
 
-	if rowsAffected == 0 {
-		return fmt.Errorf("password entry not found")
+	for _, attachment := range rewrittenAttachments {
+		if err := loadAttachmentChunks(tx, &attachment); err != nil {
+			return err
+		}
+		if err := check.Attachment(attachment); err != nil {
+			return fmt.Errorf("verification failed for attachment %d: %w", attachment.ID, err)
+		}
 	}
 
-	return nil
-}
+	if len(rewrittenAttachments) != len(attachments) {
+		return fmt.Errorf("verification failed: expected %d attachments, found %d", len(attachments), len(rewrittenAttachments))
+	}
 
-func DeletePassword(id int) error {
-	result, err := DB.Exec("DELETE FROM passwords WHERE id = ?", id)
+	rows, err = tx.Query("SELECT " + passwordColumns + " FROM passwords")
 	if err != nil {
-		return fmt.Errorf("failed to delete password: %w", err)
+		return fmt.Errorf("failed to query passwords: %w", err)
 	}
 
-	rowsAffected, err := result.RowsAffected()
+	rewritten, err := scanPasswordEntries(rows)
 	if err != nil {
-		return fmt.Errorf("failed to check rows affected: %w", err)
+		return err
 	}
 
-	if rowsAffected == 0 {
-		return fmt.Errorf("password entry not found")
+	if err := loadFields(tx, rewritten); err != nil {
+		return err
 	}
 
-	return nil
-}
+	for _, entry := range rewritten {
+		if err := check.Entry(entry); err != nil {
+			return fmt.Errorf("verification failed for entry %d: %w", entry.ID, err)
+		}
+	}
 
-func ResetDatabase() error {
-	if _, err := DB.Exec("DELETE FROM passwords"); err != nil {
-		return fmt.Errorf("failed to delete passwords: %w", err)
+	if len(rewritten) != len(entries) {
+		return fmt.Errorf("verification failed: expected %d entries, found %d", len(entries), len(rewritten))
 	}
 
-	if _, err := DB.Exec("DELETE FROM master_password"); err != nil {
-		return fmt.Errorf("failed to delete master password: %w", err)
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
 	}
 
 	return nil
 }
 
-func UpdateAllEncryptedPasswords(entries []PasswordEntry) error {
-	tx, err := DB.Begin()
+func (s *SQLiteStore) RewriteEntries(entries []PasswordEntry, settings map[string]string) error {
+	tx, err := s.db.Begin()
 	if err != nil {
 		return fmt.Errorf("failed to begin transaction: %w", err)
 	}
 	defer tx.Rollback()
 
+	if err := rewriteEntriesTx(tx, entries); err != nil {
+		return err
+	}
+
+	if err := setSettingsTx(tx, settings); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	return nil
+}
+
+// rewriteEntriesTx stores new values for every column of existing entries
+func rewriteEntriesTx(tx *sql.Tx, entries []PasswordEntry) error {
+	// Blind indexes change with the vault key and the metadata mode, so clear
+	// the unique columns first to keep rewritten rows from colliding with the
+	// old values of rows not yet rewritten
 	for _, entry := range entries {
-		_, err := tx.Exec(
-			"UPDATE passwords SET encrypted_password = ?, updated_at = CURRENT_TIMESTAMP WHERE id = ?",
-			entry.EncryptedPassword, entry.ID,
+		if _, err := tx.Exec("UPDATE passwords SET service = 'pending:' || id, alias = NULL WHERE id = ?", entry.ID); err != nil {
+			return fmt.Errorf("failed to prepare passwords: %w", err)
+		}
+	}
+
+	for _, entry := range entries {
+		result, err := tx.Exec(
+			"UPDATE passwords SET uuid = ?, type = ?, service = ?, username = ?, encrypted_password = ?, encrypted_totp = ?, notes = ?, alias = ?, folder = ?, url_match = ?, rotation_days = ?, encrypted_metadata = ? WHERE id = ?",
+			nullIfEmpty(entry.UUID), nullIfEmpty(string(entry.Type)), entry.Service, entry.Username, entry.EncryptedPassword, nullIfEmpty(entry.EncryptedTOTP), entry.Notes, nullIfEmpty(entry.Alias), nullIfEmpty(entry.Folder), nullIfEmpty(string(entry.URLMatch)), nullIfZero(entry.RotationDays), nullIfEmpty(entry.EncryptedMetadata), entry.ID,
 		)
 		if err != nil {
-			return fmt.Errorf("failed to update password for %s: %w", entry.Service, err)
+			return fmt.Errorf("failed to update password entry %d: %w", entry.ID, err)
+		}
+
+		rowsAffected, err := result.RowsAffected()
+		if err != nil {
+			return fmt.Errorf("failed to check rows affected: %w", err)
+		}
+
+		if rowsAffected == 0 {
+			return fmt.Errorf("password entry %d not found", entry.ID)
+		}
+
+		if err := setEntryTagsTx(tx, int64(entry.ID), entry.Tags); err != nil {
+			return err
+		}
+
+		if err := setEntryFieldsTx(tx, int64(entry.ID), entry.Fields); err != nil {
+			return err
 		}
+
+		if err := setEntryURLsTx(tx, int64(entry.ID), entry.URLs); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (s *SQLiteStore) AddEntry(entry PasswordEntry) error {
+	if entry.UUID == "" {
+		return fmt.Errorf("entry UUID cannot be empty")
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	result, err := tx.Exec(
+		"INSERT INTO passwords (uuid, type, service, username, encrypted_password, encrypted_totp, notes, alias, folder, url_match, rotation_days, encrypted_metadata, password_changed_at) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, COALESCE(?, CURRENT_TIMESTAMP))",
+		entry.UUID, nullIfEmpty(string(entry.Type)), entry.Service, entry.Username, entry.EncryptedPassword, nullIfEmpty(entry.EncryptedTOTP), entry.Notes, nullIfEmpty(entry.Alias), nullIfEmpty(entry.Folder), nullIfEmpty(string(entry.URLMatch)), nullIfZero(entry.RotationDays), nullIfEmpty(entry.EncryptedMetadata), nullIfEmpty(entry.PasswordChangedAt),
+	)
+	if err != nil {
+		return fmt.Errorf("failed to add password: %w", duplicateEntry(err))
+	}
+
+	id, err := result.LastInsertId()
+	if err != nil {
+		return fmt.Errorf("failed to add password: %w", err)
+	}
+
+	if err := setEntryTagsTx(tx, id, entry.Tags); err != nil {
+		return err
+	}
+
+	if err := setEntryFieldsTx(tx, id, entry.Fields); err != nil {
+		return err
+	}
+
+	if err := setEntryURLsTx(tx, id, entry.URLs); err != nil {
+		return err
 	}
 
 	if err := tx.Commit(); err != nil {
@@ -318,3 +438,856 @@ func UpdateAllEncryptedPasswords(entries []PasswordEntry) error {
 
 	return nil
 }
+
+const passwordColumns = "id, uuid, type, service, username, encrypted_password, encrypted_totp, notes, alias, folder, url_match, rotation_days, encrypted_metadata, created_at, updated_at, password_changed_at, deleted_at"
+
+type rowScanner interface {
+	Scan(dest ...any) error
+}
+
+func scanPasswordEntry(scanner rowScanner) (PasswordEntry, error) {
+	var entry PasswordEntry
+	var entryUUID, entryType, totp, notes, alias, folder, urlMatch, metadata, passwordChangedAt, deletedAt sql.NullString
+	var rotationDays sql.NullInt64
+	if err := scanner.Scan(&entry.ID, &entryUUID, &entryType, &entry.Service, &entry.Username, &entry.EncryptedPassword, &totp, &notes, &alias, &folder, &urlMatch, &rotationDays, &metadata, &entry.CreatedAt, &entry.UpdatedAt, &passwordChangedAt, &deletedAt); err != nil {
+		return entry, err
+	}
+	entry.Folder = folder.String
+	entry.DeletedAt = deletedAt.String
+	entry.UUID = entryUUID.String
+	entry.Type = EntryType(entryType.String)
+	entry.EncryptedTOTP = totp.String
+	entry.Notes = notes.String
+	entry.Alias = alias.String
+	entry.URLMatch = URLMatch(urlMatch.String)
+	entry.RotationDays = int(rotationDays.Int64)
+	entry.PasswordChangedAt = passwordChangedAt.String
+	entry.EncryptedMetadata = metadata.String
+	return entry, nil
+}
+
+func scanPasswordEntries(rows *sql.Rows) ([]PasswordEntry, error) {
+	defer rows.Close()
+
+	var entries []PasswordEntry
+	for rows.Next() {
+		entry, err := scanPasswordEntry(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan password entry: %w", err)
+		}
+		entries = append(entries, entry)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating passwords: %w", err)
+	}
+
+	return entries, nil
+}
+
+// entriesWithDetails scans entries from rows and loads their tags and
+// custom fields
+func (s *SQLiteStore) entriesWithDetails(rows *sql.Rows) ([]PasswordEntry, error) {
+	entries, err := scanPasswordEntries(rows)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := loadDetails(s.db, entries); err != nil {
+		return nil, err
+	}
+
+	return entries, nil
+}
+
+// loadDetails fills in the tags, custom fields and URLs of entries
+func loadDetails(q querier, entries []PasswordEntry) error {
+	if err := loadTags(q, entries); err != nil {
+		return err
+	}
+	if err := loadFields(q, entries); err != nil {
+		return err
+	}
+	return loadURLs(q, entries)
+}
+
+type querier interface {
+	Query(query string, args ...any) (*sql.Rows, error)
+}
+
+// loadTags fills in the tags of entries
+func loadTags(q querier, entries []PasswordEntry) error {
+	if len(entries) == 0 {
+		return nil
+	}
+
+	rows, err := q.Query("SELECT entry_tags.entry_id, tags.name FROM entry_tags JOIN tags ON tags.id = entry_tags.tag_id ORDER BY tags.name")
+	if err != nil {
+		return fmt.Errorf("failed to query tags: %w", err)
+	}
+	defer rows.Close()
+
+	tags := make(map[int][]string)
+	for rows.Next() {
+		var entryID int
+		var name string
+		if err := rows.Scan(&entryID, &name); err != nil {
+			return fmt.Errorf("failed to scan tag: %w", err)
+		}
+		tags[entryID] = append(tags[entryID], name)
+	}
+
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("error iterating tags: %w", err)
+	}
+
+	for i := range entries {
+		entries[i].Tags = tags[entries[i].ID]
+	}
+
+	return nil
+}
+
+// setEntryTagsTx replaces the tags of an entry and drops tags no entry uses
+func setEntryTagsTx(tx *sql.Tx, entryID int64, tags []string) error {
+	if _, err := tx.Exec("DELETE FROM entry_tags WHERE entry_id = ?", entryID); err != nil {
+		return fmt.Errorf("failed to update tags: %w", err)
+	}
+
+	for _, tag := range tags {
+		if _, err := tx.Exec("INSERT OR IGNORE INTO tags (name) VALUES (?)", tag); err != nil {
+			return fmt.Errorf("failed to save tag %s: %w", tag, err)
+		}
+		if _, err := tx.Exec("INSERT INTO entry_tags (entry_id, tag_id) SELECT ?, id FROM tags WHERE name = ?", entryID, tag); err != nil {
+			return fmt.Errorf("failed to save tag %s: %w", tag, err)
+		}
+	}
+
+	return deleteUnusedTagsTx(tx)
+}
+
+// loadFields fills in the custom fields of entries
+func loadFields(q querier, entries []PasswordEntry) error {
+	if len(entries) == 0 {
+		return nil
+	}
+
+	rows, err := q.Query("SELECT entry_id, name, value, hidden FROM entry_fields ORDER BY entry_id, position")
+	if err != nil {
+		return fmt.Errorf("failed to query custom fields: %w", err)
+	}
+	defer rows.Close()
+
+	fields := make(map[int][]CustomField)
+	for rows.Next() {
+		var entryID int
+		var field CustomField
+		if err := rows.Scan(&entryID, &field.Name, &field.Value, &field.Hidden); err != nil {
+			return fmt.Errorf("failed to scan custom field: %w", err)
+		}
+		fields[entryID] = append(fields[entryID], field)
+	}
+
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("error iterating custom fields: %w", err)
+	}
+
+	for i := range entries {
+		entries[i].Fields = fields[entries[i].ID]
+	}
+
+	return nil
+}
+
+// setEntryFieldsTx replaces the custom fields of an entry
+func setEntryFieldsTx(tx *sql.Tx, entryID int64, fields []CustomField) error {
+	if _, err := tx.Exec("DELETE FROM entry_fields WHERE entry_id = ?", entryID); err != nil {
+		return fmt.Errorf("failed to update custom fields: %w", err)
+	}
+
+	for position, field := range fields {
+		if _, err := tx.Exec(
+			"INSERT INTO entry_fields (entry_id, position, name, value, hidden) VALUES (?, ?, ?, ?, ?)",
+			entryID, position, field.Name, field.Value, field.Hidden,
+		); err != nil {
+			return fmt.Errorf("failed to save custom field %q: %w", field.Name, err)
+		}
+	}
+
+	return nil
+}
+
+// loadURLs fills in the URLs of entries
+func loadURLs(q querier, entries []PasswordEntry) error {
+	if len(entries) == 0 {
+		return nil
+	}
+
+	rows, err := q.Query("SELECT entry_id, url FROM entry_urls ORDER BY entry_id, position")
+	if err != nil {
+		return fmt.Errorf("failed to query URLs: %w", err)
+	}
+	defer rows.Close()
+
+	urls := make(map[int][]string)
+	for rows.Next() {
+		var entryID int
+		var u string
+		if err := rows.Scan(&entryID, &u); err != nil {
+			return fmt.Errorf("failed to scan URL: %w", err)
+		}
+		urls[entryID] = append(urls[entryID], u)
+	}
+
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("error iterating URLs: %w", err)
+	}
+
+	for i := range entries {
+		entries[i].URLs = urls[entries[i].ID]
+	}
+
+	return nil
+}
+
+// setEntryURLsTx replaces the URLs of an entry
+func setEntryURLsTx(tx *sql.Tx, entryID int64, urls []string) error {
+	if _, err := tx.Exec("DELETE FROM entry_urls WHERE entry_id = ?", entryID); err != nil {
+		return fmt.Errorf("failed to update URLs: %w", err)
+	}
+
+	for position, u := range urls {
+		if _, err := tx.Exec("INSERT INTO entry_urls (entry_id, position, url) VALUES (?, ?, ?)", entryID, position, u); err != nil {
+			return fmt.Errorf("failed to save URL %s: %w", u, err)
+		}
+	}
+
+	return nil
+}
+
+func deleteUnusedTagsTx(tx *sql.Tx) error {
+	if _, err := tx.Exec("DELETE FROM tags WHERE id NOT IN (SELECT tag_id FROM entry_tags)"); err != nil {
+		return fmt.Errorf("failed to delete unused tags: %w", err)
+	}
+	return nil
+}
+
+func (s *SQLiteStore) ListEntries() ([]PasswordEntry, error) {
+	rows, err := s.db.Query("SELECT " + passwordColumns + " FROM passwords WHERE deleted_at IS NULL ORDER BY service, username")
+	if err != nil {
+		return nil, fmt.Errorf("failed to query passwords: %w", err)
+	}
+	return s.entriesWithDetails(rows)
+}
+
+func (s *SQLiteStore) SearchEntries(query string) ([]PasswordEntry, error) {
+	searchPattern := "%" + strings.ToLower(query) + "%"
+	rows, err := s.db.Query(
+		"SELECT "+passwordColumns+" FROM passwords WHERE deleted_at IS NULL AND (LOWER(service) LIKE ? OR LOWER(username) LIKE ? OR LOWER(notes) LIKE ? OR LOWER(alias) LIKE ?) ORDER BY service, username",
+		searchPattern, searchPattern, searchPattern, searchPattern,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to search passwords: %w", err)
+	}
+	return s.entriesWithDetails(rows)
+}
+
+func (s *SQLiteStore) GetEntryByAlias(alias string) (*PasswordEntry, error) {
+	entry, err := scanPasswordEntry(s.db.QueryRow("SELECT "+passwordColumns+" FROM passwords WHERE alias = ? AND deleted_at IS NULL", alias))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to get password by alias: %w", err)
+	}
+	return s.entryWithDetails(entry)
+}
+
+// entryWithDetails loads the tags and custom fields of a single entry
+func (s *SQLiteStore) entryWithDetails(entry PasswordEntry) (*PasswordEntry, error) {
+	entries := []PasswordEntry{entry}
+	if err := loadDetails(s.db, entries); err != nil {
+		return nil, err
+	}
+	return &entries[0], nil
+}
+
+func (s *SQLiteStore) GetEntry(entryUUID string) (*PasswordEntry, error) {
+	entry, err := scanPasswordEntry(s.db.QueryRow("SELECT "+passwordColumns+" FROM passwords WHERE uuid = ?", entryUUID))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to get password entry: %w", err)
+	}
+	return s.entryWithDetails(entry)
+}
+
+func (s *SQLiteStore) UpdateEntry(entry PasswordEntry, revision *EntryRevision, keep int) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	result, err := tx.Exec(
+		"UPDATE passwords SET type = ?, service = ?, username = ?, encrypted_password = ?, encrypted_totp = ?, notes = ?, alias = ?, folder = ?, url_match = ?, rotation_days = ?, encrypted_metadata = ?, updated_at = CURRENT_TIMESTAMP, password_changed_at = COALESCE(?, password_changed_at) WHERE id = ?",
+		nullIfEmpty(string(entry.Type)), entry.Service, entry.Username, entry.EncryptedPassword, nullIfEmpty(entry.EncryptedTOTP), entry.Notes, nullIfEmpty(entry.Alias), nullIfEmpty(entry.Folder), nullIfEmpty(string(entry.URLMatch)), nullIfZero(entry.RotationDays), nullIfEmpty(entry.EncryptedMetadata), nullIfEmpty(entry.PasswordChangedAt), entry.ID,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to update password: %w", duplicateEntry(err))
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to check rows affected: %w", err)
+	}
+
+	if rowsAffected == 0 {
+		return fmt.Errorf("password entry not found")
+	}
+
+	if err := setEntryTagsTx(tx, int64(entry.ID), entry.Tags); err != nil {
+		return err
+	}
+
+	if err := setEntryFieldsTx(tx, int64(entry.ID), entry.Fields); err != nil {
+		return err
+	}
+
+	if err := setEntryURLsTx(tx, int64(entry.ID), entry.URLs); err != nil {
+		return err
+	}
+
+	if revision != nil {
+		if _, err := tx.Exec(
+			"INSERT INTO password_history (entry_uuid, encrypted_password, encrypted_data) VALUES (?, ?, ?)",
+			revision.EntryUUID, revision.EncryptedPassword, revision.EncryptedData,
+		); err != nil {
+			return fmt.Errorf("failed to save previous version: %w", err)
+		}
+
+		if err := pruneRevisionsTx(tx, keep, revision.EntryUUID); err != nil {
+			return err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	return nil
+}
+
+func (s *SQLiteStore) TrashEntry(id int) error {
+	result, err := s.db.Exec("UPDATE passwords SET deleted_at = CURRENT_TIMESTAMP WHERE id = ? AND deleted_at IS NULL", id)
+	if err != nil {
+		return fmt.Errorf("failed to delete password: %w", err)
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to check rows affected: %w", err)
+	}
+
+	if rowsAffected == 0 {
+		return fmt.Errorf("password entry not found")
+	}
+
+	return nil
+}
+
+func (s *SQLiteStore) ListTrash() ([]PasswordEntry, error) {
+	rows, err := s.db.Query("SELECT " + passwordColumns + " FROM passwords WHERE deleted_at IS NOT NULL ORDER BY deleted_at DESC, id DESC")
+	if err != nil {
+		return nil, fmt.Errorf("failed to query trash: %w", err)
+	}
+	return s.entriesWithDetails(rows)
+}
+
+func (s *SQLiteStore) RestoreEntry(id int) error {
+	result, err := s.db.Exec("UPDATE passwords SET deleted_at = NULL WHERE id = ? AND deleted_at IS NOT NULL", id)
+	if err != nil {
+		return fmt.Errorf("failed to restore password: %w", duplicateEntry(err))
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to check rows affected: %w", err)
+	}
+
+	if rowsAffected == 0 {
+		return fmt.Errorf("password entry not found in trash")
+	}
+
+	return nil
+}
+
+func (s *SQLiteStore) PurgeTrash(olderThan time.Duration) (int, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return 0, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	cutoff := fmt.Sprintf("-%d seconds", int64(olderThan.Seconds()))
+	purged := "SELECT uuid FROM passwords WHERE deleted_at IS NOT NULL AND deleted_at <= datetime('now', ?)"
+
+	if _, err := tx.Exec("DELETE FROM password_history WHERE entry_uuid IN ("+purged+")", cutoff); err != nil {
+		return 0, fmt.Errorf("failed to delete password history: %w", err)
+	}
+
+	if _, err := tx.Exec("DELETE FROM attachment_chunks WHERE attachment_id IN (SELECT id FROM attachments WHERE entry_uuid IN ("+purged+"))", cutoff); err != nil {
+		return 0, fmt.Errorf("failed to delete attachments: %w", err)
+	}
+
+	if _, err := tx.Exec("DELETE FROM attachments WHERE entry_uuid IN ("+purged+")", cutoff); err != nil {
+		return 0, fmt.Errorf("failed to delete attachments: %w", err)
+	}
+
+	if _, err := tx.Exec("DELETE FROM entry_tags WHERE entry_id IN (SELECT id FROM passwords WHERE deleted_at IS NOT NULL AND deleted_at <= datetime('now', ?))", cutoff); err != nil {
+		return 0, fmt.Errorf("failed to delete tags: %w", err)
+	}
+
+	if _, err := tx.Exec("DELETE FROM entry_fields WHERE entry_id IN (SELECT id FROM passwords WHERE deleted_at IS NOT NULL AND deleted_at <= datetime('now', ?))", cutoff); err != nil {
+		return 0, fmt.Errorf("failed to delete custom fields: %w", err)
+	}
+
+	if _, err := tx.Exec("DELETE FROM entry_urls WHERE entry_id IN (SELECT id FROM passwords WHERE deleted_at IS NOT NULL AND deleted_at <= datetime('now', ?))", cutoff); err != nil {
+		return 0, fmt.Errorf("failed to delete URLs: %w", err)
+	}
+
+	result, err := tx.Exec("DELETE FROM passwords WHERE deleted_at IS NOT NULL AND deleted_at <= datetime('now', ?)", cutoff)
+	if err != nil {
+		return 0, fmt.Errorf("failed to purge trash: %w", err)
+	}
+
+	count, err := result.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("failed to check rows affected: %w", err)
+	}
+
+	if err := deleteUnusedTagsTx(tx); err != nil {
+		return 0, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	return int(count), nil
+}
+
+const revisionColumns = "id, entry_uuid, encrypted_password, encrypted_data, created_at"
+
+func scanRevisions(rows *sql.Rows) ([]EntryRevision, error) {
+	defer rows.Close()
+
+	var revisions []EntryRevision
+	for rows.Next() {
+		var revision EntryRevision
+		if err := rows.Scan(&revision.ID, &revision.EntryUUID, &revision.EncryptedPassword, &revision.EncryptedData, &revision.CreatedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan password history: %w", err)
+		}
+		revisions = append(revisions, revision)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating password history: %w", err)
+	}
+
+	return revisions, nil
+}
+
+func (s *SQLiteStore) ListRevisions(entryUUID string) ([]EntryRevision, error) {
+	rows, err := s.db.Query("SELECT "+revisionColumns+" FROM password_history WHERE entry_uuid = ? ORDER BY id DESC", entryUUID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query password history: %w", err)
+	}
+	return scanRevisions(rows)
+}
+
+func (s *SQLiteStore) ListAllRevisions() ([]EntryRevision, error) {
+	rows, err := s.db.Query("SELECT " + revisionColumns + " FROM password_history ORDER BY id")
+	if err != nil {
+		return nil, fmt.Errorf("failed to query password history: %w", err)
+	}
+	return scanRevisions(rows)
+}
+
+func (s *SQLiteStore) PruneRevisions(keep int) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	if err := pruneRevisionsTx(tx, keep, ""); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	return nil
+}
+
+// pruneRevisionsTx drops all but the newest keep revisions of the entry with
+// the given UUID, or of every entry if entryUUID is empty
+func pruneRevisionsTx(tx *sql.Tx, keep int, entryUUID string) error {
+	_, err := tx.Exec(`
+	DELETE FROM password_history WHERE (? = '' OR entry_uuid = ?) AND id NOT IN (
+		SELECT id FROM password_history AS newer
+		WHERE newer.entry_uuid = password_history.entry_uuid
+		ORDER BY newer.id DESC LIMIT ?
+	)`, entryUUID, entryUUID, keep)
+	if err != nil {
+		return fmt.Errorf("failed to prune password history: %w", err)
+	}
+	return nil
+}
+
+func (s *SQLiteStore) AddAttachment(attachment Attachment) error {
+	if attachment.UUID == "" || attachment.EntryUUID == "" {
+		return fmt.Errorf("attachment and entry UUIDs cannot be empty")
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	result, err := tx.Exec("INSERT INTO attachments (uuid, entry_uuid, encrypted_info) VALUES (?, ?, ?)", attachment.UUID, attachment.EntryUUID, attachment.EncryptedInfo)
+	if err != nil {
+		return fmt.Errorf("failed to add attachment: %w", err)
+	}
+
+	id, err := result.LastInsertId()
+	if err != nil {
+		return fmt.Errorf("failed to add attachment: %w", err)
+	}
+
+	if err := setAttachmentChunksTx(tx, id, attachment.Chunks); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	return nil
+}
+
+// setAttachmentChunksTx replaces the chunks of an attachment
+func setAttachmentChunksTx(tx *sql.Tx, attachmentID int64, chunks []string) error {
+	if _, err := tx.Exec("DELETE FROM attachment_chunks WHERE attachment_id = ?", attachmentID); err != nil {
+		return fmt.Errorf("failed to update attachment: %w", err)
+	}
+
+	for position, chunk := range chunks {
+		if _, err := tx.Exec("INSERT INTO attachment_chunks (attachment_id, position, data) VALUES (?, ?, ?)", attachmentID, position, chunk); err != nil {
+			return fmt.Errorf("failed to save attachment: %w", err)
+		}
+	}
+
+	return nil
+}
+
+const attachmentColumns = "id, uuid, entry_uuid, encrypted_info, created_at"
+
+func scanAttachments(rows *sql.Rows) ([]Attachment, error) {
+	defer rows.Close()
+
+	var attachments []Attachment
+	for rows.Next() {
+		var attachment Attachment
+		if err := rows.Scan(&attachment.ID, &attachment.UUID, &attachment.EntryUUID, &attachment.EncryptedInfo, &attachment.CreatedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan attachment: %w", err)
+		}
+		attachments = append(attachments, attachment)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating attachments: %w", err)
+	}
+
+	return attachments, nil
+}
+
+func (s *SQLiteStore) ListAttachments(entryUUID string) ([]Attachment, error) {
+	rows, err := s.db.Query("SELECT "+attachmentColumns+" FROM attachments WHERE entry_uuid = ? ORDER BY id", entryUUID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query attachments: %w", err)
+	}
+	return scanAttachments(rows)
+}
+
+func (s *SQLiteStore) ListAllAttachments() ([]Attachment, error) {
+	rows, err := s.db.Query("SELECT " + attachmentColumns + " FROM attachments ORDER BY id")
+	if err != nil {
+		return nil, fmt.Errorf("failed to query attachments: %w", err)
+	}
+	return scanAttachments(rows)
+}
+
+func (s *SQLiteStore) GetAttachment(id int) (*Attachment, error) {
+	var attachment Attachment
+	err := s.db.QueryRow("SELECT "+attachmentColumns+" FROM attachments WHERE id = ?", id).Scan(&attachment.ID, &attachment.UUID, &attachment.EntryUUID, &attachment.EncryptedInfo, &attachment.CreatedAt)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to get attachment: %w", err)
+	}
+
+	if err := loadAttachmentChunks(s.db, &attachment); err != nil {
+		return nil, err
+	}
+
+	return &attachment, nil
+}
+
+// loadAttachmentChunks fills in the chunks of an attachment
+func loadAttachmentChunks(q querier, attachment *Attachment) error {
+	rows, err := q.Query("SELECT data FROM attachment_chunks WHERE attachment_id = ? ORDER BY position", attachment.ID)
+	if err != nil {
+		return fmt.Errorf("failed to query attachment chunks: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var chunk string
+		if err := rows.Scan(&chunk); err != nil {
+			return fmt.Errorf("failed to scan attachment chunk: %w", err)
+		}
+		attachment.Chunks = append(attachment.Chunks, chunk)
+	}
+
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("error iterating attachment chunks: %w", err)
+	}
+
+	return nil
+}
+
+func (s *SQLiteStore) DeleteAttachment(id int) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec("DELETE FROM attachment_chunks WHERE attachment_id = ?", id); err != nil {
+		return fmt.Errorf("failed to delete attachment: %w", err)
+	}
+
+	result, err := tx.Exec("DELETE FROM attachments WHERE id = ?", id)
+	if err != nil {
+		return fmt.Errorf("failed to delete attachment: %w", err)
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to check rows affected: %w", err)
+	}
+
+	if rowsAffected == 0 {
+		return fmt.Errorf("attachment not found")
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	return nil
+}
+
+func (s *SQLiteStore) ReplaceRecoveryCodes(codes map[string]string) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec("DELETE FROM recovery_codes"); err != nil {
+		return fmt.Errorf("failed to delete recovery codes: %w", err)
+	}
+
+	for codeHash, wrappedKey := range codes {
+		if _, err := tx.Exec("INSERT INTO recovery_codes (code_hash, wrapped_key) VALUES (?, ?)", codeHash, wrappedKey); err != nil {
+			return fmt.Errorf("failed to store recovery code: %w", err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	return nil
+}
+
+func (s *SQLiteStore) CountRecoveryCodes() (int, error) {
+	var count int
+	err := s.db.QueryRow("SELECT COUNT(*) FROM recovery_codes").Scan(&count)
+	if err != nil {
+		return 0, fmt.Errorf("failed to count recovery codes: %w", err)
+	}
+	return count, nil
+}
+
+func (s *SQLiteStore) GetRecoveryCode(codeHash string) (int, string, error) {
+	var id int
+	var wrappedKey string
+	err := s.db.QueryRow("SELECT id, wrapped_key FROM recovery_codes WHERE code_hash = ?", codeHash).Scan(&id, &wrappedKey)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return 0, "", ErrInvalidRecoveryCode
+		}
+		return 0, "", fmt.Errorf("failed to get recovery code: %w", err)
+	}
+	return id, wrappedKey, nil
+}
+
+func (s *SQLiteStore) ConsumeRecoveryCode(id int, hashedPassword, wrappedKey string, settings map[string]string) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	if err := updateMasterCredentialsTx(tx, hashedPassword, wrappedKey); err != nil {
+		return err
+	}
+
+	if err := setSettingsTx(tx, settings); err != nil {
+		return err
+	}
+
+	result, err := tx.Exec("DELETE FROM recovery_codes WHERE id = ?", id)
+	if err != nil {
+		return fmt.Errorf("failed to delete recovery code: %w", err)
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to check rows affected: %w", err)
+	}
+
+	if rowsAffected == 0 {
+		return ErrInvalidRecoveryCode
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	return nil
+}
+
+func (s *SQLiteStore) GetSetting(key string) (string, error) {
+	var value string
+	err := s.db.QueryRow("SELECT value FROM settings WHERE key = ?", key).Scan(&value)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return "", nil
+		}
+		return "", fmt.Errorf("failed to get setting %s: %w", key, err)
+	}
+	return value, nil
+}
+
+func (s *SQLiteStore) SetSetting(key, value string) error {
+	_, err := s.db.Exec("INSERT INTO settings (key, value) VALUES (?, ?) ON CONFLICT(key) DO UPDATE SET value = excluded.value", key, value)
+	if err != nil {
+		return fmt.Errorf("failed to save setting %s: %w", key, err)
+	}
+	return nil
+}
+
+// setSettingsTx saves settings within tx; an empty value removes the setting
+func setSettingsTx(tx *sql.Tx, settings map[string]string) error {
+	for key, value := range settings {
+		var err error
+		if value == "" {
+			_, err = tx.Exec("DELETE FROM settings WHERE key = ?", key)
+		} else {
+			_, err = tx.Exec("INSERT INTO settings (key, value) VALUES (?, ?) ON CONFLICT(key) DO UPDATE SET value = excluded.value", key, value)
+		}
+		if err != nil {
+			return fmt.Errorf("failed to save setting %s: %w", key, err)
+		}
+	}
+	return nil
+}
+
+func (s *SQLiteStore) Reset() error {
+	if _, err := s.db.Exec("DELETE FROM passwords"); err != nil {
+		return fmt.Errorf("failed to delete passwords: %w", err)
+	}
+
+	if _, err := s.db.Exec("DELETE FROM password_history"); err != nil {
+		return fmt.Errorf("failed to delete password history: %w", err)
+	}
+
+	if _, err := s.db.Exec("DELETE FROM entry_tags"); err != nil {
+		return fmt.Errorf("failed to delete tags: %w", err)
+	}
+
+	if _, err := s.db.Exec("DELETE FROM tags"); err != nil {
+		return fmt.Errorf("failed to delete tags: %w", err)
+	}
+
+	if _, err := s.db.Exec("DELETE FROM entry_fields"); err != nil {
+		return fmt.Errorf("failed to delete custom fields: %w", err)
+	}
+
+	if _, err := s.db.Exec("DELETE FROM entry_urls"); err != nil {
+		return fmt.Errorf("failed to delete URLs: %w", err)
+	}
+
+	if _, err := s.db.Exec("DELETE FROM attachment_chunks"); err != nil {
+		return fmt.Errorf("failed to delete attachments: %w", err)
+	}
+
+	if _, err := s.db.Exec("DELETE FROM attachments"); err != nil {
+		return fmt.Errorf("failed to delete attachments: %w", err)
+	}
+
+	if _, err := s.db.Exec("DELETE FROM recovery_codes"); err != nil {
+		return fmt.Errorf("failed to delete recovery codes: %w", err)
+	}
+
+	if _, err := s.db.Exec("DELETE FROM settings"); err != nil {
+		return fmt.Errorf("failed to delete settings: %w", err)
+	}
+
+	if _, err := s.db.Exec("DELETE FROM vault_key"); err != nil {
+		return fmt.Errorf("failed to delete vault key: %w", err)
+	}
+
+	if _, err := s.db.Exec("DELETE FROM master_password"); err != nil {
+		return fmt.Errorf("failed to delete master password: %w", err)
+	}
+
+	return nil
+}
+
+func nullIfEmpty(value string) sql.NullString {
+	return sql.NullString{String: value, Valid: value != ""}
+}
+
+// duplicateEntry returns ErrDuplicateEntry for err if it is a violation of
+// the uniqueness of accounts and aliases, and err otherwise
+func duplicateEntry(err error) error {
+	var sqliteErr sqlite3.Error
+	if errors.As(err, &sqliteErr) && sqliteErr.ExtendedCode == sqlite3.ErrConstraintUnique {
+		return fmt.Errorf("%w (%v)", ErrDuplicateEntry, err)
+	}
+	return err
+}
+
+func nullIfZero(value int) sql.NullInt64 {
+	return sql.NullInt64{Int64: int64(value), Valid: value != 0}
+}
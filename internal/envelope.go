@@ -0,0 +1,194 @@
+package internal
+
+import (
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Every value written by passvault is wrapped in a self-describing envelope:
+//
+//	$pv$ + base64( version | flags | kdf id | [kdf params | salt] | cipher id | payload )
+//
+// The prefix cannot occur in the bare base64 blobs written by older versions,
+// so both formats can be told apart and coexist in the same vault. Version 1
+// envelopes have no flags byte.
+const (
+	envelopePrefix  = "$pv$"
+	envelopeVersion = 2
+)
+
+// Envelope flags
+const (
+	// flagBound marks a ciphertext whose additional authenticated data binds it to an entry field
+	flagBound byte = 1 << 0
+)
+
+// Key derivation functions
+const (
+	kdfNone     byte = 0
+	kdfArgon2id byte = 1
+)
+
+// Ciphers
+const (
+	cipherNone              byte = 0
+	cipherAESGCM            byte = 1
+	cipherXChaCha20Poly1305 byte = 2
+)
+
+// Upper bounds on stored KDF parameters, so a tampered vault cannot make an
+// unlock allocate unbounded memory or spin forever.
+const (
+	maxArgon2Time   = 64
+	maxArgon2Memory = 4 * 1024 * 1024 // 4 GB
+)
+
+// Argon2Params are the cost parameters of an Argon2id derivation
+type Argon2Params struct {
+	Time    uint32
+	Memory  uint32 // in KiB
+	Threads uint8
+	KeyLen  uint32
+}
+
+type envelope struct {
+	flags   byte
+	kdf     byte
+	params  Argon2Params
+	salt    []byte
+	cipher  byte
+	payload []byte
+}
+
+func isEnvelope(encoded string) bool {
+	return strings.HasPrefix(encoded, envelopePrefix)
+}
+
+func (e envelope) encode() string {
+	buf := []byte{envelopeVersion, e.flags, e.kdf}
+
+	if e.kdf == kdfArgon2id {
+		buf = binary.BigEndian.AppendUint32(buf, e.params.Time)
+		buf = binary.BigEndian.AppendUint32(buf, e.params.Memory)
+		buf = append(buf, e.params.Threads, byte(e.params.KeyLen), byte(len(e.salt)))
+		buf = append(buf, e.salt...)
+	}
+
+	buf = append(buf, e.cipher)
+	buf = append(buf, e.payload...)
+
+	return envelopePrefix + base64.StdEncoding.EncodeToString(buf)
+}
+
+func parseEnvelope(encoded string) (envelope, error) {
+	var e envelope
+
+	if !isEnvelope(encoded) {
+		return e, errors.New("not a passvault envelope")
+	}
+
+	data, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(encoded, envelopePrefix))
+	if err != nil {
+		return e, fmt.Errorf("failed to decode envelope: %w", err)
+	}
+
+	if len(data) < 3 {
+		return e, errors.New("invalid envelope format")
+	}
+
+	switch data[0] {
+	case 1:
+		data = data[1:]
+	case envelopeVersion:
+		e.flags = data[1]
+		data = data[2:]
+	default:
+		return e, fmt.Errorf("unsupported envelope version %d", data[0])
+	}
+
+	if len(data) < 2 {
+		return e, errors.New("invalid envelope format")
+	}
+
+	e.kdf = data[0]
+	data = data[1:]
+
+	switch e.kdf {
+	case kdfNone:
+	case kdfArgon2id:
+		// time (4) + memory (4) + threads (1) + key length (1) + salt length (1)
+		if len(data) < 11 {
+			return e, errors.New("invalid envelope format")
+		}
+		e.params = Argon2Params{
+			Time:    binary.BigEndian.Uint32(data[0:4]),
+			Memory:  binary.BigEndian.Uint32(data[4:8]),
+			Threads: data[8],
+			KeyLen:  uint32(data[9]),
+		}
+		if err := e.params.validate(); err != nil {
+			return e, err
+		}
+
+		saltSize := int(data[10])
+		data = data[11:]
+		if len(data) < saltSize {
+			return e, errors.New("invalid envelope format")
+		}
+		e.salt = data[:saltSize]
+		data = data[saltSize:]
+	default:
+		return e, fmt.Errorf("unsupported key derivation function %d", e.kdf)
+	}
+
+	if len(data) < 1 {
+		return e, errors.New("invalid envelope format")
+	}
+
+	e.cipher = data[0]
+	if e.cipher != cipherNone && e.cipher != cipherAESGCM && e.cipher != cipherXChaCha20Poly1305 {
+		return e, fmt.Errorf("unsupported cipher %d", e.cipher)
+	}
+	e.payload = data[1:]
+
+	return e, nil
+}
+
+func (p Argon2Params) validate() error {
+	if p.Time < 1 || p.Time > maxArgon2Time {
+		return fmt.Errorf("invalid Argon2 time parameter %d", p.Time)
+	}
+	if p.Threads < 1 {
+		return errors.New("invalid Argon2 threads parameter 0")
+	}
+	if p.Memory < 8*uint32(p.Threads) || p.Memory > maxArgon2Memory {
+		return fmt.Errorf("invalid Argon2 memory parameter %d", p.Memory)
+	}
+	if p.KeyLen < 16 || p.KeyLen > 64 {
+		return fmt.Errorf("invalid Argon2 key length %d", p.KeyLen)
+	}
+	return nil
+}
+
+// parseKDFParams returns the Argon2 parameters recorded in an encoded master
+// password hash or wrapped vault key. Values written before the envelope
+// format existed report the legacy parameters they were created with.
+func parseKDFParams(encoded string, legacy Argon2Params) (Argon2Params, error) {
+	if !isEnvelope(encoded) {
+		return legacy, nil
+	}
+
+	e, err := parseEnvelope(encoded)
+	if err != nil {
+		return Argon2Params{}, err
+	}
+
+	if e.kdf != kdfArgon2id {
+		return Argon2Params{}, errors.New("value has no key derivation parameters")
+	}
+
+	return e.params, nil
+}
@@ -0,0 +1,213 @@
+package internal
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func testVaultKey(t *testing.T, suite CipherSuite) *VaultKey {
+	t.Helper()
+	vaultKey, err := GenerateVaultKey(suite)
+	if err != nil {
+		t.Fatalf("GenerateVaultKey: %v", err)
+	}
+	t.Cleanup(vaultKey.Destroy)
+	return vaultKey
+}
+
+// rawEnvelope returns the bytes inside an encoded envelope
+func rawEnvelope(t *testing.T, encoded string) []byte {
+	t.Helper()
+	data, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(encoded, envelopePrefix))
+	if err != nil {
+		t.Fatalf("decode envelope: %v", err)
+	}
+	return data
+}
+
+func encodeRaw(data []byte) string {
+	return envelopePrefix + base64.StdEncoding.EncodeToString(data)
+}
+
+func TestEnvelopeRoundTrip(t *testing.T) {
+	tests := []struct {
+		name string
+		env  envelope
+	}{
+		{"value", envelope{flags: flagBound, kdf: kdfNone, cipher: cipherAESGCM, payload: []byte("sealed")}},
+		{"unbound value", envelope{kdf: kdfNone, cipher: cipherXChaCha20Poly1305, payload: []byte("sealed")}},
+		{"empty payload", envelope{kdf: kdfNone, cipher: cipherAESGCM}},
+		{"hash", envelope{
+			kdf:     kdfArgon2id,
+			params:  Argon2Params{Time: 2, Memory: 19 * 1024, Threads: 1, KeyLen: 32},
+			salt:    bytes.Repeat([]byte{7}, saltLen),
+			cipher:  cipherNone,
+			payload: bytes.Repeat([]byte{9}, 32),
+		}},
+		{"wrapped key", envelope{
+			kdf:     kdfArgon2id,
+			params:  DefaultKeyParams,
+			salt:    bytes.Repeat([]byte{1}, saltLen),
+			cipher:  cipherXChaCha20Poly1305,
+			payload: []byte("wrapped"),
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			encoded := tt.env.encode()
+			if !isEnvelope(encoded) {
+				t.Fatalf("encoded value %q has no envelope prefix", encoded)
+			}
+
+			got, err := parseEnvelope(encoded)
+			if err != nil {
+				t.Fatalf("parseEnvelope: %v", err)
+			}
+			if got.flags != tt.env.flags || got.kdf != tt.env.kdf || got.cipher != tt.env.cipher || got.params != tt.env.params {
+				t.Errorf("parseEnvelope = %+v, want %+v", got, tt.env)
+			}
+			if !bytes.Equal(got.salt, tt.env.salt) || !bytes.Equal(got.payload, tt.env.payload) {
+				t.Errorf("salt and payload = %x %x, want %x %x", got.salt, got.payload, tt.env.salt, tt.env.payload)
+			}
+		})
+	}
+}
+
+func TestParseEnvelopeVersion1(t *testing.T) {
+	// Version 1 envelopes have no flags byte
+	got, err := parseEnvelope(encodeRaw([]byte{1, kdfNone, cipherAESGCM, 'x'}))
+	if err != nil {
+		t.Fatalf("parseEnvelope: %v", err)
+	}
+	if got.flags != 0 || got.kdf != kdfNone || got.cipher != cipherAESGCM || string(got.payload) != "x" {
+		t.Errorf("parseEnvelope = %+v", got)
+	}
+}
+
+func TestParseEnvelopeInvalid(t *testing.T) {
+	valid := envelope{
+		kdf:     kdfArgon2id,
+		params:  DefaultHashParams,
+		salt:    bytes.Repeat([]byte{1}, saltLen),
+		cipher:  cipherNone,
+		payload: bytes.Repeat([]byte{2}, 32),
+	}
+	raw := rawEnvelope(t, valid.encode())
+
+	withByte := func(i int, b byte) string {
+		data := bytes.Clone(raw)
+		data[i] = b
+		return encodeRaw(data)
+	}
+
+	tests := []struct {
+		name    string
+		encoded string
+	}{
+		{"no prefix", base64.StdEncoding.EncodeToString(raw)},
+		{"bad base64", envelopePrefix + "!!!"},
+		{"empty", envelopePrefix},
+		{"version 0", withByte(0, 0)},
+		{"unknown version", withByte(0, envelopeVersion+1)},
+		{"unknown kdf", withByte(2, 9)},
+		{"unknown cipher", withByte(3+11+saltLen, 9)},
+		{"time 0", withByte(6, 0)},
+		{"threads 0", withByte(3+8, 0)},
+		{"key length too short", withByte(3+9, 8)},
+		{"salt longer than data", withByte(3+10, 255)},
+		{"memory too large", encodeRaw(append(append(bytes.Clone(raw[:7]), 0xff, 0xff, 0xff, 0xff), raw[11:]...))},
+	}
+
+	// Every envelope cut short before its cipher byte is invalid
+	for n := 0; n <= 3+11+saltLen; n++ {
+		tests = append(tests, struct {
+			name    string
+			encoded string
+		}{"truncated", encodeRaw(raw[:n])})
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if e, err := parseEnvelope(tt.encoded); err == nil {
+				t.Errorf("parseEnvelope(%q) = %+v, want an error", tt.encoded, e)
+			}
+		})
+	}
+}
+
+func TestEncryptValueRoundTrip(t *testing.T) {
+	for _, suite := range []CipherSuite{CipherAESGCM, CipherXChaCha20Poly1305} {
+		t.Run(suite.String(), func(t *testing.T) {
+			vaultKey := testVaultKey(t, suite)
+			aad := entryAAD("entry-1", fieldPassword)
+
+			encoded, err := encryptValue([]byte("hunter2"), vaultKey, aad)
+			if err != nil {
+				t.Fatalf("encryptValue: %v", err)
+			}
+
+			plaintext, err := decryptValue(encoded, vaultKey, aad)
+			if err != nil {
+				t.Fatalf("decryptValue: %v", err)
+			}
+			if string(plaintext) != "hunter2" {
+				t.Errorf("decryptValue = %q, want %q", plaintext, "hunter2")
+			}
+		})
+	}
+}
+
+func TestDecryptValueTampered(t *testing.T) {
+	vaultKey := testVaultKey(t, CipherAESGCM)
+	aad := entryAAD("entry-1", fieldPassword)
+
+	encoded, err := encryptValue([]byte("hunter2"), vaultKey, aad)
+	if err != nil {
+		t.Fatalf("encryptValue: %v", err)
+	}
+	raw := rawEnvelope(t, encoded)
+
+	flipped := func(i int) string {
+		data := bytes.Clone(raw)
+		data[i] ^= 1
+		return encodeRaw(data)
+	}
+
+	tests := []struct {
+		name    string
+		encoded string
+		aad     []byte
+	}{
+		{"payload bit flipped", flipped(len(raw) - 1), aad},
+		{"nonce bit flipped", flipped(4), aad},
+		{"bound flag cleared", flipped(1), aad},
+		{"other entry", encoded, entryAAD("entry-2", fieldPassword)},
+		{"other field", encoded, entryAAD("entry-1", fieldTOTP)},
+		{"read unbound", encoded, nil},
+		{"payload truncated", encodeRaw(raw[:len(raw)-1]), aad},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := decryptValue(tt.encoded, vaultKey, tt.aad); !errors.Is(err, ErrTampered) {
+				t.Errorf("decryptValue error = %v, want ErrTampered", err)
+			}
+		})
+	}
+}
+
+func TestDecryptValueOtherKey(t *testing.T) {
+	aad := entryAAD("entry-1", fieldPassword)
+	encoded, err := encryptValue([]byte("hunter2"), testVaultKey(t, CipherAESGCM), aad)
+	if err != nil {
+		t.Fatalf("encryptValue: %v", err)
+	}
+
+	if _, err := decryptValue(encoded, testVaultKey(t, CipherAESGCM), aad); err == nil {
+		t.Error("decryptValue with another vault key succeeded")
+	}
+}
@@ -0,0 +1,238 @@
+package internal
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Escrow splits the vault key into Shamir shares so that any threshold of
+// them can reconstruct it and set a new master password. Every share is
+// tagged with the vault id, its index and the threshold, and carries a short
+// key check value so shares of another vault or of a rotated key are rejected.
+const (
+	settingVaultID     = "vault_id"
+	settingEscrowCheck = "escrow_key_check"
+	escrowSharePrefix  = "pvshare"
+	escrowShareVersion = 1
+	keyCheckInfo       = "passvault key check v1"
+)
+
+// EscrowShare is one Shamir share of the vault key
+type EscrowShare struct {
+	VaultID   string
+	Index     int
+	Threshold int
+	check     string
+	data      []byte
+}
+
+// String encodes the share as a single line of text
+func (s EscrowShare) String() string {
+	return fmt.Sprintf("%s:%d:%s:%d:%d:%s:%s", escrowSharePrefix, escrowShareVersion, s.VaultID, s.Threshold, s.Index, s.check, hex.EncodeToString(s.data))
+}
+
+// ParseEscrowShare decodes a share produced by EscrowShare.String
+func ParseEscrowShare(encoded string) (EscrowShare, error) {
+	parts := strings.Split(strings.TrimSpace(encoded), ":")
+	if len(parts) != 7 || parts[0] != escrowSharePrefix {
+		return EscrowShare{}, errors.New("not a passvault escrow share")
+	}
+
+	if parts[1] != strconv.Itoa(escrowShareVersion) {
+		return EscrowShare{}, fmt.Errorf("unsupported escrow share version %s", parts[1])
+	}
+
+	threshold, err := strconv.Atoi(parts[3])
+	if err != nil || threshold < 2 || threshold > 255 {
+		return EscrowShare{}, errors.New("invalid share threshold")
+	}
+
+	index, err := strconv.Atoi(parts[4])
+	if err != nil || index < 1 || index > 255 {
+		return EscrowShare{}, errors.New("invalid share index")
+	}
+
+	data, err := hex.DecodeString(parts[6])
+	if err != nil || len(data) != vaultKeyLen {
+		return EscrowShare{}, errors.New("invalid share data")
+	}
+
+	return EscrowShare{
+		VaultID:   parts[2],
+		Index:     index,
+		Threshold: threshold,
+		check:     parts[5],
+		data:      data,
+	}, nil
+}
+
+// ID returns the identifier of the vault, creating it on first use
+func (v *Vault) ID() (string, error) {
+	vaultID, err := v.store.GetSetting(settingVaultID)
+	if err != nil || vaultID != "" {
+		return vaultID, err
+	}
+
+	vaultID, err = NewEntryUUID()
+	if err != nil {
+		return "", err
+	}
+
+	if err := v.store.SetSetting(settingVaultID, vaultID); err != nil {
+		return "", err
+	}
+
+	return vaultID, nil
+}
+
+func keyCheckValue(key []byte) string {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(keyCheckInfo))
+	return hex.EncodeToString(mac.Sum(nil)[:8])
+}
+
+// SplitKey splits the vault key into shares of which any threshold can
+// reconstruct it
+func (v *Vault) SplitKey(vaultKey *VaultKey, shares, threshold int) ([]EscrowShare, error) {
+	if !vaultKey.valid() {
+		return nil, errors.New("invalid vault key length")
+	}
+
+	vaultID, err := v.ID()
+	if err != nil {
+		return nil, err
+	}
+
+	parts, err := splitSecret(vaultKey.key.Bytes(), shares, threshold)
+	if err != nil {
+		return nil, err
+	}
+
+	check := keyCheckValue(vaultKey.key.Bytes())
+	if err := v.store.SetSetting(settingEscrowCheck, check); err != nil {
+		return nil, err
+	}
+
+	result := make([]EscrowShare, len(parts))
+	for i, data := range parts {
+		result[i] = EscrowShare{
+			VaultID:   vaultID,
+			Index:     i + 1,
+			Threshold: threshold,
+			check:     check,
+			data:      data,
+		}
+	}
+
+	return result, nil
+}
+
+// HasEscrowShares reports whether the vault key has been split into escrow
+// shares
+func (v *Vault) HasEscrowShares() (bool, error) {
+	check, err := v.store.GetSetting(settingEscrowCheck)
+	if err != nil {
+		return false, err
+	}
+	return check != "", nil
+}
+
+// CombineEscrowShares reconstructs the vault key from at least threshold
+// shares of this vault's current key
+func (v *Vault) CombineEscrowShares(shares []EscrowShare) (*VaultKey, error) {
+	if len(shares) == 0 {
+		return nil, errors.New("no shares given")
+	}
+
+	vaultID, err := v.store.GetSetting(settingVaultID)
+	if err != nil {
+		return nil, err
+	}
+
+	expectedCheck, err := v.store.GetSetting(settingEscrowCheck)
+	if err != nil {
+		return nil, err
+	}
+
+	if vaultID == "" || expectedCheck == "" {
+		return nil, errors.New("no shares of the current vault key exist: it was never split, or has been rotated since")
+	}
+
+	first := shares[0]
+	points := make(map[byte][]byte, len(shares))
+	for _, share := range shares {
+		if share.VaultID != vaultID {
+			return nil, fmt.Errorf("share %d belongs to another vault", share.Index)
+		}
+		if share.Threshold != first.Threshold || share.check != first.check {
+			return nil, fmt.Errorf("share %d comes from a different split", share.Index)
+		}
+		if _, ok := points[byte(share.Index)]; ok {
+			return nil, fmt.Errorf("share %d was given more than once", share.Index)
+		}
+		points[byte(share.Index)] = share.data
+	}
+
+	if first.check != expectedCheck {
+		return nil, errors.New("the shares are of a vault key that has since been rotated")
+	}
+
+	if len(points) < first.Threshold {
+		return nil, fmt.Errorf("%d of %d required shares given", len(points), first.Threshold)
+	}
+
+	combined, err := combineShares(points)
+	if err != nil {
+		return nil, err
+	}
+	key := NewSecretBufferFrom(combined)
+
+	if subtle.ConstantTimeCompare([]byte(keyCheckValue(key.Bytes())), []byte(expectedCheck)) != 1 {
+		key.Destroy()
+		return nil, errors.New("the shares do not reconstruct the vault key")
+	}
+
+	suite, err := v.cipherSuite()
+	if err != nil {
+		key.Destroy()
+		return nil, err
+	}
+
+	return &VaultKey{key: key, cipher: suite}, nil
+}
+
+// cipherSuite returns the cipher suite recorded in the wrapped vault key
+func (v *Vault) cipherSuite() (CipherSuite, error) {
+	wrappedKey, err := v.store.GetWrappedVaultKey()
+	if err != nil {
+		return 0, err
+	}
+
+	if !isEnvelope(wrappedKey) {
+		return CipherAESGCM, nil
+	}
+
+	e, err := parseEnvelope(wrappedKey)
+	if err != nil {
+		return 0, err
+	}
+
+	return CipherSuite(e.cipher), nil
+}
+
+// ResetMasterPassword sets a new master password for a vault whose key was
+// recovered without the old one. A key file requirement is removed.
+func (v *Vault) ResetMasterPassword(vaultKey *VaultKey, newMasterPassword *SecretBuffer) error {
+	hashedPassword, wrappedKey, err := v.masterCredentials(vaultKey, newMasterPassword)
+	if err != nil {
+		return err
+	}
+
+	return v.store.UpdateMasterCredentials(hashedPassword, wrappedKey, map[string]string{settingKeyFileFPR: ""})
+}
@@ -0,0 +1,168 @@
+package internal
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestEscrowShareEncoding(t *testing.T) {
+	vault, vaultKey, _ := newTestVault(t, NewMemoryStore(), "password")
+
+	shares, err := vault.SplitKey(vaultKey, 3, 2)
+	if err != nil {
+		t.Fatalf("SplitKey: %v", err)
+	}
+
+	for _, share := range shares {
+		parsed, err := ParseEscrowShare(share.String() + "\n")
+		if err != nil {
+			t.Fatalf("ParseEscrowShare: %v", err)
+		}
+		if parsed.String() != share.String() {
+			t.Errorf("ParseEscrowShare = %s, want %s", parsed, share)
+		}
+	}
+
+	fields := strings.Split(shares[0].String(), ":")
+	with := func(i int, value string) string {
+		changed := append([]string(nil), fields...)
+		changed[i] = value
+		return strings.Join(changed, ":")
+	}
+
+	invalid := map[string]string{
+		"prefix":         with(0, "share"),
+		"version":        with(1, "2"),
+		"threshold 1":    with(3, "1"),
+		"threshold":      with(3, "x"),
+		"index 0":        with(4, "0"),
+		"index 256":      with(4, "256"),
+		"data not hex":   with(6, "zz"),
+		"data too short": with(6, fields[6][2:]),
+		"missing field":  strings.Join(fields[:6], ":"),
+	}
+	for name, encoded := range invalid {
+		t.Run(name, func(t *testing.T) {
+			if _, err := ParseEscrowShare(encoded); err == nil {
+				t.Errorf("ParseEscrowShare(%q) succeeded", encoded)
+			}
+		})
+	}
+}
+
+func TestCombineEscrowShares(t *testing.T) {
+	vault, vaultKey, _ := newTestVault(t, NewMemoryStore(), "password")
+
+	const n, k = 4, 3
+	shares, err := vault.SplitKey(vaultKey, n, k)
+	if err != nil {
+		t.Fatalf("SplitKey: %v", err)
+	}
+
+	for mask := 1; mask < 1<<n; mask++ {
+		var picked []EscrowShare
+		for i, share := range shares {
+			if mask&(1<<i) != 0 {
+				picked = append(picked, share)
+			}
+		}
+
+		combined, err := vault.CombineEscrowShares(picked)
+		if len(picked) < k {
+			if err == nil {
+				combined.Destroy()
+				t.Errorf("CombineEscrowShares accepted %d of %d shares (%b)", len(picked), k, mask)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("CombineEscrowShares(%b): %v", mask, err)
+			continue
+		}
+		if !combined.key.Equal(vaultKey.key) || combined.cipher != vaultKey.cipher {
+			t.Errorf("CombineEscrowShares(%b) did not reconstruct the vault key", mask)
+		}
+		combined.Destroy()
+	}
+}
+
+// Splitting again does not revoke earlier shares: they are shares of the same
+// key. Only rotating the vault key does.
+func TestCombineEscrowSharesOfEarlierSplit(t *testing.T) {
+	vault, vaultKey, _ := newTestVault(t, NewMemoryStore(), "password")
+
+	earlier, err := vault.SplitKey(vaultKey, 3, 2)
+	if err != nil {
+		t.Fatalf("SplitKey: %v", err)
+	}
+	if _, err := vault.SplitKey(vaultKey, 3, 2); err != nil {
+		t.Fatalf("SplitKey: %v", err)
+	}
+
+	combined, err := vault.CombineEscrowShares(earlier[1:])
+	if err != nil {
+		t.Fatalf("CombineEscrowShares: %v", err)
+	}
+	defer combined.Destroy()
+	if !combined.key.Equal(vaultKey.key) {
+		t.Error("CombineEscrowShares did not reconstruct the vault key")
+	}
+}
+
+func TestCombineEscrowSharesRejects(t *testing.T) {
+	vault, vaultKey, _ := newTestVault(t, NewMemoryStore(), "password")
+	other, otherKey, _ := newTestVault(t, NewMemoryStore(), "password")
+
+	shares, err := vault.SplitKey(vaultKey, 3, 2)
+	if err != nil {
+		t.Fatalf("SplitKey: %v", err)
+	}
+	foreign, err := other.SplitKey(otherKey, 3, 2)
+	if err != nil {
+		t.Fatalf("SplitKey: %v", err)
+	}
+
+	rotatedVault, rotatedKey, _ := newTestVault(t, NewMemoryStore(), "password")
+	rotated, err := rotatedVault.SplitKey(rotatedKey, 3, 2)
+	if err != nil {
+		t.Fatalf("SplitKey: %v", err)
+	}
+	newSecret := NewSecretBufferFrom([]byte("new password"))
+	defer newSecret.Destroy()
+	newKey, err := rotatedVault.Rekey(rotatedKey, newSecret)
+	if err != nil {
+		t.Fatalf("Rekey: %v", err)
+	}
+	newKey.Destroy()
+
+	again, err := vault.SplitKey(vaultKey, 3, 2)
+	if err != nil {
+		t.Fatalf("SplitKey: %v", err)
+	}
+
+	tampered := shares[1]
+	tampered.data = append([]byte(nil), tampered.data...)
+	tampered.data[0] ^= 1
+
+	tests := []struct {
+		name   string
+		vault  *Vault
+		shares []EscrowShare
+	}{
+		{"none", vault, nil},
+		{"another vault", vault, []EscrowShare{shares[0], foreign[1]}},
+		{"rotated key", rotatedVault, rotated[:2]},
+		{"mixed splits", vault, []EscrowShare{shares[0], again[1]}},
+		{"duplicate", vault, []EscrowShare{shares[0], shares[0]}},
+		{"tampered", vault, []EscrowShare{shares[0], tampered}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if combined, err := tt.vault.CombineEscrowShares(tt.shares); err == nil {
+				combined.Destroy()
+				t.Error("CombineEscrowShares succeeded")
+			}
+		})
+	}
+}
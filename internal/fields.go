@@ -0,0 +1,160 @@
+package internal
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Besides its password, an entry can have any number of custom fields, such
+// as an API secret, a PIN or the answer to a security question, kept in the
+// order they were added. The value of a hidden field is encrypted like the
+// password and bound to the entry and the field's name; visible values are
+// stored like the notes, and sealed with them when metadata encryption is on.
+
+// CustomField is a named value of an entry. Value holds the plaintext of a
+// visible field and the ciphertext of a hidden one.
+type CustomField struct {
+	Name   string `json:"name"`
+	Value  string `json:"value"`
+	Hidden bool   `json:"hidden,omitempty"`
+}
+
+func fieldAAD(entryUUID, name string) []byte {
+	return entryAAD(entryUUID, fieldCustom+":"+name)
+}
+
+// NewCustomField returns a field of the given entry, encrypting its value if
+// it is hidden
+func NewCustomField(name string, value []byte, hidden bool, vaultKey *VaultKey, entryUUID string) (CustomField, error) {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return CustomField{}, errors.New("field name cannot be empty")
+	}
+
+	if !hidden {
+		return CustomField{Name: name, Value: string(value)}, nil
+	}
+
+	if len(value) == 0 {
+		return CustomField{}, fmt.Errorf("value of hidden field %q cannot be empty", name)
+	}
+	if entryUUID == "" {
+		return CustomField{}, errors.New("entry UUID cannot be empty")
+	}
+
+	sealed, err := encryptValue(value, vaultKey, fieldAAD(entryUUID, name))
+	if err != nil {
+		return CustomField{}, fmt.Errorf("failed to encrypt field %q: %w", name, err)
+	}
+	return CustomField{Name: name, Value: sealed, Hidden: true}, nil
+}
+
+// DecryptField returns the value of a field of the given entry, decrypting it
+// if it is hidden. The caller destroys the returned buffer when done with it.
+func DecryptField(field CustomField, vaultKey *VaultKey, entryUUID string) (*SecretBuffer, error) {
+	if !field.Hidden {
+		return NewSecretBufferFrom([]byte(field.Value)), nil
+	}
+
+	plaintext, err := decryptValue(field.Value, vaultKey, fieldAAD(entryUUID, field.Name))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt field %q: %w", field.Name, err)
+	}
+	return NewSecretBufferFrom(plaintext), nil
+}
+
+// FindField returns the index of the field with the given name, ignoring
+// case, or -1 if there is none
+func FindField(fields []CustomField, name string) int {
+	for i, field := range fields {
+		if strings.EqualFold(field.Name, strings.TrimSpace(name)) {
+			return i
+		}
+	}
+	return -1
+}
+
+// SetField replaces the field with the same name, keeping its position, or
+// adds field after the others
+func SetField(fields []CustomField, field CustomField) []CustomField {
+	if i := FindField(fields, field.Name); i >= 0 {
+		fields[i] = field
+		return fields
+	}
+	return append(fields, field)
+}
+
+// RemoveField removes the field with the given name, reporting whether there
+// was one
+func RemoveField(fields []CustomField, name string) ([]CustomField, bool) {
+	i := FindField(fields, name)
+	if i < 0 {
+		return fields, false
+	}
+	return append(fields[:i], fields[i+1:]...), true
+}
+
+// rekeyFields returns fields with their hidden values re-encrypted under newKey
+func rekeyFields(fields []CustomField, entryUUID string, vaultKey, newKey *VaultKey) ([]CustomField, error) {
+	if len(fields) == 0 {
+		return fields, nil
+	}
+
+	rekeyed := make([]CustomField, len(fields))
+	for i, field := range fields {
+		if !field.Hidden {
+			rekeyed[i] = field
+			continue
+		}
+
+		value, err := DecryptField(field, vaultKey, entryUUID)
+		if err != nil {
+			return nil, err
+		}
+		rekeyed[i], err = NewCustomField(field.Name, value.Bytes(), true, newKey, entryUUID)
+		value.Destroy()
+		if err != nil {
+			return nil, err
+		}
+	}
+	return rekeyed, nil
+}
+
+// fieldsEqual reports whether two sets of fields of an entry have the same
+// names, visibility and values in the same order
+func fieldsEqual(a, b []CustomField, entryUUID string, vaultKey *VaultKey) (bool, error) {
+	if len(a) != len(b) {
+		return false, nil
+	}
+
+	for i := range a {
+		if a[i].Name != b[i].Name || a[i].Hidden != b[i].Hidden {
+			return false, nil
+		}
+		if !a[i].Hidden {
+			if a[i].Value != b[i].Value {
+				return false, nil
+			}
+			continue
+		}
+
+		first, err := DecryptField(a[i], vaultKey, entryUUID)
+		if err != nil {
+			return false, err
+		}
+		second, err := DecryptField(b[i], vaultKey, entryUUID)
+		if err != nil {
+			first.Destroy()
+			return false, err
+		}
+		equal := first.Equal(second)
+		first.Destroy()
+		second.Destroy()
+
+		if !equal {
+			return false, nil
+		}
+	}
+	return true, nil
+}
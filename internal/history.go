@@ -0,0 +1,223 @@
+package internal
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+)
+
+// Whenever the password, username, notes or custom fields of an entry change,
+// the version being replaced is kept in its history. A revision keeps the
+// password ciphertext the entry had; its username, notes and custom fields
+// are sealed together with the time the version was saved, bound to the entry
+// like its other fields.
+const (
+	settingHistoryLimit = "history_limit"
+	DefaultHistoryLimit = 10
+)
+
+type revisionData struct {
+	Username string        `json:"username"`
+	Notes    string        `json:"notes,omitempty"`
+	Fields   []CustomField `json:"fields,omitempty"`
+	SavedAt  string        `json:"saved_at"`
+}
+
+// PasswordRevision is a decrypted previous version of an entry
+type PasswordRevision struct {
+	ID                int
+	Username          string
+	Notes             string
+	Fields            []CustomField
+	EncryptedPassword string
+	SavedAt           string // when the version was saved
+	ReplacedAt        string // when a newer version replaced it
+}
+
+// HistoryLimit returns how many previous versions are kept for each entry
+func (v *Vault) HistoryLimit() (int, error) {
+	value, err := v.store.GetSetting(settingHistoryLimit)
+	if err != nil {
+		return 0, err
+	}
+	if value == "" {
+		return DefaultHistoryLimit, nil
+	}
+
+	limit, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid history limit %q: %w", value, err)
+	}
+	return limit, nil
+}
+
+// SetHistoryLimit changes how many previous versions are kept for each entry,
+// dropping older versions beyond the new limit. A limit of 0 turns history off.
+func (v *Vault) SetHistoryLimit(limit int) error {
+	if limit < 0 {
+		return fmt.Errorf("history limit cannot be negative")
+	}
+
+	if err := v.store.SetSetting(settingHistoryLimit, strconv.Itoa(limit)); err != nil {
+		return err
+	}
+
+	return v.store.PruneRevisions(limit)
+}
+
+// PasswordHistory returns the previous versions of an entry, newest first
+func (v *Vault) PasswordHistory(entryUUID string, vaultKey *VaultKey) ([]PasswordRevision, error) {
+	stored, err := v.store.ListRevisions(entryUUID)
+	if err != nil {
+		return nil, err
+	}
+
+	revisions := make([]PasswordRevision, 0, len(stored))
+	for _, revision := range stored {
+		data, err := openRevision(revision, vaultKey)
+		if err != nil {
+			return nil, err
+		}
+
+		revisions = append(revisions, PasswordRevision{
+			ID:                revision.ID,
+			Username:          data.Username,
+			Notes:             data.Notes,
+			Fields:            data.Fields,
+			EncryptedPassword: revision.EncryptedPassword,
+			SavedAt:           data.SavedAt,
+			ReplacedAt:        revision.CreatedAt,
+		})
+	}
+
+	return revisions, nil
+}
+
+// RestoreRevision rolls the password, username, notes and custom fields of an
+// entry back to a previous version. The version being replaced is kept in the
+// history, so a restore can itself be undone.
+func (v *Vault) RestoreRevision(entry PasswordEntry, revision PasswordRevision, vaultKey *VaultKey) error {
+	entry.Username = revision.Username
+	entry.Notes = revision.Notes
+	entry.Fields = revision.Fields
+	entry.EncryptedPassword = revision.EncryptedPassword
+
+	return v.UpdatePassword(entry, vaultKey)
+}
+
+// revisionOf returns the revision recording current before it is replaced by
+// updated, or nil if history is off or its password, username, notes and
+// custom fields are unchanged
+func (v *Vault) revisionOf(current *PasswordEntry, updated PasswordEntry, vaultKey *VaultKey) (*EntryRevision, int, error) {
+	limit, err := v.HistoryLimit()
+	if err != nil || limit == 0 {
+		return nil, 0, err
+	}
+
+	if err := openMetadata(current, vaultKey); err != nil {
+		return nil, 0, err
+	}
+
+	sameFields, err := fieldsEqual(current.Fields, updated.Fields, current.UUID, vaultKey)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	if current.Username == updated.Username && current.Notes == updated.Notes && sameFields {
+		oldPassword, err := DecryptPassword(current.EncryptedPassword, vaultKey, current.UUID)
+		if err != nil {
+			return nil, 0, err
+		}
+		defer oldPassword.Destroy()
+
+		newPassword, err := DecryptPassword(updated.EncryptedPassword, vaultKey, updated.UUID)
+		if err != nil {
+			return nil, 0, err
+		}
+		defer newPassword.Destroy()
+
+		if oldPassword.Equal(newPassword) {
+			return nil, 0, nil
+		}
+	}
+
+	sealed, err := sealRevision(revisionData{
+		Username: current.Username,
+		Notes:    current.Notes,
+		Fields:   current.Fields,
+		SavedAt:  current.UpdatedAt,
+	}, current.UUID, vaultKey)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	return &EntryRevision{
+		EntryUUID:         current.UUID,
+		EncryptedPassword: current.EncryptedPassword,
+		EncryptedData:     sealed,
+	}, limit, nil
+}
+
+func sealRevision(data revisionData, entryUUID string, vaultKey *VaultKey) (string, error) {
+	plaintext, err := json.Marshal(data)
+	if err != nil {
+		return "", fmt.Errorf("failed to encode revision: %w", err)
+	}
+
+	sealed, err := encryptValue(plaintext, vaultKey, entryAAD(entryUUID, fieldHistory))
+	if err != nil {
+		return "", fmt.Errorf("failed to encrypt revision: %w", err)
+	}
+	return sealed, nil
+}
+
+func openRevision(revision EntryRevision, vaultKey *VaultKey) (revisionData, error) {
+	var data revisionData
+
+	plaintext, err := decryptValue(revision.EncryptedData, vaultKey, entryAAD(revision.EntryUUID, fieldHistory))
+	if err != nil {
+		return data, fmt.Errorf("failed to decrypt revision %d: %w", revision.ID, err)
+	}
+
+	if err := json.Unmarshal(plaintext, &data); err != nil {
+		return data, fmt.Errorf("failed to decode revision %d: %w", revision.ID, err)
+	}
+	return data, nil
+}
+
+// rekeyRevisions re-encrypts every revision under newKey
+func (v *Vault) rekeyRevisions(vaultKey, newKey *VaultKey) ([]EntryRevision, error) {
+	revisions, err := v.store.ListAllRevisions()
+	if err != nil {
+		return nil, err
+	}
+
+	for i, revision := range revisions {
+		data, err := openRevision(revision, vaultKey)
+		if err != nil {
+			return nil, err
+		}
+
+		password, err := DecryptPassword(revision.EncryptedPassword, vaultKey, revision.EntryUUID)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decrypt revision %d: %w", revision.ID, err)
+		}
+		revisions[i].EncryptedPassword, err = EncryptSecret(password.Bytes(), newKey, revision.EntryUUID)
+		password.Destroy()
+		if err != nil {
+			return nil, fmt.Errorf("failed to encrypt revision %d: %w", revision.ID, err)
+		}
+
+		data.Fields, err = rekeyFields(data.Fields, revision.EntryUUID, vaultKey, newKey)
+		if err != nil {
+			return nil, fmt.Errorf("failed to re-encrypt revision %d: %w", revision.ID, err)
+		}
+
+		revisions[i].EncryptedData, err = sealRevision(data, revision.EntryUUID, newKey)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return revisions, nil
+}
@@ -0,0 +1,95 @@
+package internal
+
+import (
+	"crypto/rand"
+	"fmt"
+	"time"
+)
+
+// Bounds for the Argon2 tuning search
+const (
+	minTuneMemory = 64 * 1024 // 64 MB, the defaults never go below this
+	maxTuneTime   = 10
+)
+
+// BenchmarkArgon2 measures how long a single Argon2id derivation takes with the given parameters
+func BenchmarkArgon2(params Argon2Params) time.Duration {
+	salt := make([]byte, saltLen)
+	rand.Read(salt)
+
+	start := time.Now()
+	deriveKey([]byte("passvault-benchmark"), salt, params)
+	return time.Since(start)
+}
+
+// TuneArgon2 searches for Argon2id parameters whose derivation takes about
+// target on this machine. The search starts from the memory and passes of
+// floor, so the current parameters are not lowered. Memory is raised first,
+// doubling up to maxMemory (in KiB), then the number of passes is increased
+// until the target is reached. It returns the chosen parameters and their
+// measured duration.
+func TuneArgon2(target time.Duration, maxMemory uint32, threads uint8, floor Argon2Params) (Argon2Params, time.Duration) {
+	params := Argon2Params{Time: max(1, floor.Time), Memory: max(minTuneMemory, floor.Memory), Threads: threads, KeyLen: 32}
+	if maxMemory < params.Memory {
+		params.Memory = maxMemory
+	}
+
+	elapsed := BenchmarkArgon2(params)
+
+	for elapsed < target && params.Memory*2 <= maxMemory {
+		next := params
+		next.Memory *= 2
+
+		nextElapsed := BenchmarkArgon2(next)
+		if nextElapsed > target {
+			break
+		}
+		params, elapsed = next, nextElapsed
+	}
+
+	for elapsed < target && params.Time < maxTuneTime {
+		next := params
+		next.Time++
+
+		nextElapsed := BenchmarkArgon2(next)
+		if nextElapsed > target {
+			break
+		}
+		params, elapsed = next, nextElapsed
+	}
+
+	return params, elapsed
+}
+
+// LoweredFrom returns the names of the parameters of p that are lower than in
+// current. Each is compared on its own, as raising one does not make up for
+// lowering another.
+func (p Argon2Params) LoweredFrom(current Argon2Params) []string {
+	var lowered []string
+	if p.Memory < current.Memory {
+		lowered = append(lowered, "memory")
+	}
+	if p.Time < current.Time {
+		lowered = append(lowered, "time")
+	}
+	if p.Threads < current.Threads {
+		lowered = append(lowered, "threads")
+	}
+	return lowered
+}
+
+// SetKDFParams re-hashes the master secret and re-wraps the vault key with
+// new Argon2 parameters
+func (v *Vault) SetKDFParams(vaultKey *VaultKey, masterSecret *SecretBuffer, params Argon2Params) error {
+	hashedPassword, err := HashMasterPassword(masterSecret, params)
+	if err != nil {
+		return fmt.Errorf("failed to hash master password: %w", err)
+	}
+
+	wrappedKey, err := WrapVaultKey(vaultKey, masterSecret, params)
+	if err != nil {
+		return fmt.Errorf("failed to wrap vault key: %w", err)
+	}
+
+	return v.store.UpdateMasterCredentials(hashedPassword, wrappedKey, nil)
+}
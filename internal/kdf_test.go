@@ -0,0 +1,40 @@
+package internal
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestLoweredFrom(t *testing.T) {
+	current := Argon2Params{Time: 3, Memory: 64 * 1024, Threads: 4, KeyLen: 32}
+
+	tests := []struct {
+		name     string
+		proposed Argon2Params
+		want     []string
+	}{
+		{"same", current, nil},
+		{"all raised", Argon2Params{Time: 4, Memory: 128 * 1024, Threads: 8}, nil},
+		{"memory lowered", Argon2Params{Time: 3, Memory: 32 * 1024, Threads: 4}, []string{"memory"}},
+		{"time lowered for more memory", Argon2Params{Time: 1, Memory: 1024 * 1024, Threads: 4}, []string{"time"}},
+		{"threads lowered", Argon2Params{Time: 3, Memory: 64 * 1024, Threads: 1}, []string{"threads"}},
+		{"all lowered", Argon2Params{Time: 1, Memory: 8 * 1024, Threads: 1}, []string{"memory", "time", "threads"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.proposed.LoweredFrom(current); !slices.Equal(got, tt.want) {
+				t.Errorf("LoweredFrom = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTuneArgon2KeepsFloor(t *testing.T) {
+	floor := Argon2Params{Time: 2, Memory: minTuneMemory, Threads: 1}
+
+	params, _ := TuneArgon2(0, minTuneMemory, 1, floor)
+	if lowered := params.LoweredFrom(floor); lowered != nil {
+		t.Errorf("TuneArgon2 = %+v, lowers %v of %+v", params, lowered, floor)
+	}
+}
@@ -0,0 +1,154 @@
+package internal
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+)
+
+// A key file is a second unlock factor: its contents are mixed into the
+// master secret that the master password hash and the key-encryption key are
+// derived from, so the database and the master password alone cannot unlock
+// the vault. A short fingerprint of the key file is stored in the settings
+// table so that a missing or wrong key file can be reported clearly.
+const (
+	keyFileSize       = 64
+	minKeyFileSize    = 32
+	maxKeyFileSize    = 1024 * 1024
+	settingKeyFileFPR = "keyfile_fingerprint"
+)
+
+// CreateKeyFile writes a new random key file to path, refusing to overwrite an existing file
+func CreateKeyFile(path string) ([]byte, error) {
+	data := make([]byte, keyFileSize)
+	if _, err := rand.Read(data); err != nil {
+		return nil, fmt.Errorf("failed to generate key file: %w", err)
+	}
+
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0400)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create key file: %w", err)
+	}
+
+	if _, err := file.Write(data); err != nil {
+		file.Close()
+		os.Remove(path)
+		return nil, fmt.Errorf("failed to write key file: %w", err)
+	}
+
+	if err := file.Close(); err != nil {
+		os.Remove(path)
+		return nil, fmt.Errorf("failed to write key file: %w", err)
+	}
+
+	return data, nil
+}
+
+// ReadKeyFile reads and sanity-checks a key file
+func ReadKeyFile(path string) ([]byte, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read key file: %w", err)
+	}
+
+	if info.Size() < minKeyFileSize || info.Size() > maxKeyFileSize {
+		return nil, fmt.Errorf("key file must be between %d bytes and 1 MB", minKeyFileSize)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read key file: %w", err)
+	}
+
+	return data, nil
+}
+
+func keyFileFingerprint(keyFile []byte) string {
+	sum := sha256.Sum256(append([]byte("passvault key file fingerprint:"), keyFile...))
+	return hex.EncodeToString(sum[:8])
+}
+
+// ComposeMasterSecret combines the master password with a key file into the
+// secret keys are derived from, returned in a new buffer the caller destroys:
+// HMAC-SHA256 of the password keyed with the SHA-256 of the key file. Without
+// a key file it is a copy of the password itself.
+func ComposeMasterSecret(password *SecretBuffer, keyFile []byte) *SecretBuffer {
+	if keyFile == nil {
+		return password.Clone()
+	}
+
+	digest := sha256.Sum256(keyFile)
+	defer Wipe(digest[:])
+
+	mac := hmac.New(sha256.New, digest[:])
+	mac.Write(password.Bytes())
+	sum := mac.Sum(nil)
+	defer Wipe(sum)
+
+	secret := NewSecretBuffer(len(sum))
+	copy(secret.Bytes(), sum)
+
+	return secret
+}
+
+// IsKeyFileRequired reports whether unlocking the vault needs a key file
+func (v *Vault) IsKeyFileRequired() (bool, error) {
+	fingerprint, err := v.store.GetSetting(settingKeyFileFPR)
+	if err != nil {
+		return false, err
+	}
+	return fingerprint != "", nil
+}
+
+// LoadKeyFile returns the contents of the key file the vault requires,
+// read from v.KeyFilePath, or nil if the vault does not use a key file
+func (v *Vault) LoadKeyFile() ([]byte, error) {
+	fingerprint, err := v.store.GetSetting(settingKeyFileFPR)
+	if err != nil {
+		return nil, err
+	}
+
+	if fingerprint == "" {
+		return nil, nil
+	}
+
+	if v.KeyFilePath == "" {
+		return nil, errors.New("this vault requires a key file: pass --keyfile or set PASSVAULT_KEYFILE")
+	}
+
+	keyFile, err := ReadKeyFile(v.KeyFilePath)
+	if err != nil {
+		return nil, err
+	}
+
+	if subtle.ConstantTimeCompare([]byte(keyFileFingerprint(keyFile)), []byte(fingerprint)) != 1 {
+		return nil, fmt.Errorf("key file %s does not belong to this vault", v.KeyFilePath)
+	}
+
+	return keyFile, nil
+}
+
+// SetKeyFile re-derives the master password hash and wrapped vault key from
+// the master password combined with keyFile, or from the password alone when
+// keyFile is nil, and records whether the vault requires a key file
+func (v *Vault) SetKeyFile(vaultKey *VaultKey, password *SecretBuffer, keyFile []byte) error {
+	masterSecret := ComposeMasterSecret(password, keyFile)
+	defer masterSecret.Destroy()
+
+	hashedPassword, wrappedKey, err := v.masterCredentials(vaultKey, masterSecret)
+	if err != nil {
+		return err
+	}
+
+	fingerprint := ""
+	if keyFile != nil {
+		fingerprint = keyFileFingerprint(keyFile)
+	}
+
+	return v.store.UpdateMasterCredentials(hashedPassword, wrappedKey, map[string]string{settingKeyFileFPR: fingerprint})
+}
@@ -0,0 +1,50 @@
+package internal
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"testing"
+)
+
+func TestComposeMasterSecret(t *testing.T) {
+	password := NewSecretBufferFrom([]byte("correct horse"))
+	defer password.Destroy()
+	keyFile := bytes.Repeat([]byte{0x5a}, keyFileSize)
+
+	t.Run("without key file", func(t *testing.T) {
+		secret := ComposeMasterSecret(password, nil)
+		defer secret.Destroy()
+		if !secret.Equal(password) {
+			t.Errorf("ComposeMasterSecret = %q, want the password", secret.Bytes())
+		}
+	})
+
+	t.Run("with key file", func(t *testing.T) {
+		digest := sha256.Sum256(keyFile)
+		mac := hmac.New(sha256.New, digest[:])
+		mac.Write([]byte("correct horse"))
+
+		secret := ComposeMasterSecret(password, keyFile)
+		defer secret.Destroy()
+		if !bytes.Equal(secret.Bytes(), mac.Sum(nil)) {
+			t.Errorf("ComposeMasterSecret = %x, want HMAC-SHA256(SHA-256(key file), password)", secret.Bytes())
+		}
+	})
+
+	t.Run("factors are not interchangeable", func(t *testing.T) {
+		otherFile := bytes.Clone(keyFile)
+		otherFile[0] ^= 1
+		otherPassword := NewSecretBufferFrom([]byte("correct horsf"))
+		defer otherPassword.Destroy()
+
+		secret := ComposeMasterSecret(password, keyFile)
+		defer secret.Destroy()
+		for _, other := range []*SecretBuffer{ComposeMasterSecret(password, otherFile), ComposeMasterSecret(otherPassword, keyFile)} {
+			if other.Equal(secret) {
+				t.Error("a different password or key file composed the same secret")
+			}
+			other.Destroy()
+		}
+	})
+}
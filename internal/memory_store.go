@@ -0,0 +1,711 @@
+package internal
+
+import (
+	"fmt"
+	"maps"
+	"slices"
+	"sort"
+	"sync"
+	"time"
+)
+
+// MemoryStore is a Store that keeps a vault in memory only, for tests and for
+// embedding passvault where nothing should touch the disk
+type MemoryStore struct {
+	mu   sync.Mutex
+	data memoryData
+}
+
+// memoryData is everything a MemoryStore holds; it is copied to give changes
+// of several parts all-or-nothing semantics
+type memoryData struct {
+	passwordHash   string
+	wrappedKey     string
+	entries        []PasswordEntry
+	nextEntryID    int
+	settings       map[string]string
+	recoveryCodes  []memoryRecoveryCode
+	nextCodeID     int
+	revisions      []EntryRevision
+	nextRevision   int
+	attachments    []Attachment
+	nextAttachment int
+}
+
+type memoryRecoveryCode struct {
+	id         int
+	codeHash   string
+	wrappedKey string
+}
+
+var _ Store = (*MemoryStore)(nil)
+
+// NewMemoryStore returns an empty in-memory store
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{data: memoryData{
+		settings:       make(map[string]string),
+		nextEntryID:    1,
+		nextCodeID:     1,
+		nextRevision:   1,
+		nextAttachment: 1,
+	}}
+}
+
+func (d memoryData) clone() memoryData {
+	d.entries = slices.Clone(d.entries)
+	d.settings = maps.Clone(d.settings)
+	d.recoveryCodes = slices.Clone(d.recoveryCodes)
+	d.revisions = slices.Clone(d.revisions)
+	d.attachments = slices.Clone(d.attachments)
+	return d
+}
+
+// update applies fn to a copy of the store's data and keeps the copy only if
+// fn succeeds
+func (s *MemoryStore) update(fn func(d *memoryData) error) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	d := s.data.clone()
+	if err := fn(&d); err != nil {
+		return err
+	}
+	s.data = d
+	return nil
+}
+
+func memoryTimestamp() string {
+	return time.Now().UTC().Format(time.RFC3339)
+}
+
+// Location identifies the store within this process
+func (s *MemoryStore) Location() string {
+	return fmt.Sprintf("memory:%p", s)
+}
+
+func (s *MemoryStore) Close() error {
+	return nil
+}
+
+func (s *MemoryStore) IsMasterPasswordSet() (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.data.passwordHash != "", nil
+}
+
+func (s *MemoryStore) GetMasterPasswordHash() (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.data.passwordHash == "" {
+		return "", fmt.Errorf("master password not set")
+	}
+	return s.data.passwordHash, nil
+}
+
+func (s *MemoryStore) IsVaultKeySet() (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.data.wrappedKey != "", nil
+}
+
+func (s *MemoryStore) GetWrappedVaultKey() (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.data.wrappedKey == "" {
+		return "", fmt.Errorf("vault key not set")
+	}
+	return s.data.wrappedKey, nil
+}
+
+func (s *MemoryStore) InitializeVault(hashedPassword, wrappedKey string) error {
+	return s.update(func(d *memoryData) error {
+		if d.passwordHash != "" {
+			return fmt.Errorf("master password is already set")
+		}
+		d.passwordHash = hashedPassword
+		d.wrappedKey = wrappedKey
+		return nil
+	})
+}
+
+func (s *MemoryStore) UpdateMasterCredentials(hashedPassword, wrappedKey string, settings map[string]string) error {
+	return s.update(func(d *memoryData) error {
+		if err := d.setCredentials(hashedPassword, wrappedKey); err != nil {
+			return err
+		}
+		d.setSettings(settings)
+		return nil
+	})
+}
+
+func (d *memoryData) setCredentials(hashedPassword, wrappedKey string) error {
+	if d.passwordHash == "" {
+		return fmt.Errorf("master password not found")
+	}
+	if d.wrappedKey == "" {
+		return fmt.Errorf("vault key not found")
+	}
+	d.passwordHash = hashedPassword
+	d.wrappedKey = wrappedKey
+	return nil
+}
+
+func (d *memoryData) setSettings(settings map[string]string) {
+	for key, value := range settings {
+		if value == "" {
+			delete(d.settings, key)
+		} else {
+			d.settings[key] = value
+		}
+	}
+}
+
+func (s *MemoryStore) MigrateToVaultKey(wrappedKey string, entries []PasswordEntry) error {
+	return s.update(func(d *memoryData) error {
+		if d.wrappedKey != "" {
+			return fmt.Errorf("failed to store vault key: vault key already set")
+		}
+		d.wrappedKey = wrappedKey
+
+		for _, entry := range entries {
+			i := d.entryIndex(entry.ID)
+			if i < 0 {
+				return fmt.Errorf("failed to update password for %s: password entry not found", entry.Service)
+			}
+			d.entries[i].UUID = entry.UUID
+			d.entries[i].EncryptedPassword = entry.EncryptedPassword
+		}
+		return d.checkUnique()
+	})
+}
+
+func (s *MemoryStore) Rekey(hashedPassword, wrappedKey string, entries []PasswordEntry, revisions []EntryRevision, attachments []Attachment, settings map[string]string, check RekeyCheck) error {
+	return s.update(func(d *memoryData) error {
+		d.passwordHash = hashedPassword
+		d.wrappedKey = wrappedKey
+		d.recoveryCodes = nil
+		d.setSettings(settings)
+
+		if err := d.rewriteEntries(entries); err != nil {
+			return err
+		}
+
+		for _, revision := range revisions {
+			i := slices.IndexFunc(d.revisions, func(stored EntryRevision) bool { return stored.ID == revision.ID })
+			if i < 0 {
+				return fmt.Errorf("revision %d not found", revision.ID)
+			}
+			d.revisions[i].EncryptedPassword = revision.EncryptedPassword
+			d.revisions[i].EncryptedData = revision.EncryptedData
+		}
+
+		for _, revision := range d.revisions {
+			if err := check.Revision(revision); err != nil {
+				return fmt.Errorf("verification failed for revision %d: %w", revision.ID, err)
+			}
+		}
+
+		if len(d.revisions) != len(revisions) {
+			return fmt.Errorf("verification failed: expected %d revisions, found %d", len(revisions), len(d.revisions))
+		}
+
+		for _, attachment := range attachments {
+			i := d.attachmentIndex(attachment.ID)
+			if i < 0 {
+				return fmt.Errorf("attachment %d not found", attachment.ID)
+			}
+			d.attachments[i].EncryptedInfo = attachment.EncryptedInfo
+			d.attachments[i].Chunks = slices.Clone(attachment.Chunks)
+		}
+
+		for _, attachment := range d.attachments {
+			if err := check.Attachment(attachment); err != nil {
+				return fmt.Errorf("verification failed for attachment %d: %w", attachment.ID, err)
+			}
+		}
+
+		if len(d.attachments) != len(attachments) {
+			return fmt.Errorf("verification failed: expected %d attachments, found %d", len(attachments), len(d.attachments))
+		}
+
+		for _, entry := range d.entries {
+			if err := check.Entry(entry); err != nil {
+				return fmt.Errorf("verification failed for entry %d: %w", entry.ID, err)
+			}
+		}
+
+		if len(d.entries) != len(entries) {
+			return fmt.Errorf("verification failed: expected %d entries, found %d", len(entries), len(d.entries))
+		}
+
+		return nil
+	})
+}
+
+func (s *MemoryStore) RewriteEntries(entries []PasswordEntry, settings map[string]string) error {
+	return s.update(func(d *memoryData) error {
+		if err := d.rewriteEntries(entries); err != nil {
+			return err
+		}
+		d.setSettings(settings)
+		return nil
+	})
+}
+
+func (d *memoryData) rewriteEntries(entries []PasswordEntry) error {
+	for _, entry := range entries {
+		i := d.entryIndex(entry.ID)
+		if i < 0 {
+			return fmt.Errorf("password entry %d not found", entry.ID)
+		}
+
+		stored := &d.entries[i]
+		stored.UUID = entry.UUID
+		stored.Type = entry.Type
+		stored.Service = entry.Service
+		stored.Username = entry.Username
+		stored.EncryptedPassword = entry.EncryptedPassword
+		stored.EncryptedTOTP = entry.EncryptedTOTP
+		stored.Notes = entry.Notes
+		stored.Alias = entry.Alias
+		stored.Folder = entry.Folder
+		stored.Tags = slices.Clone(entry.Tags)
+		stored.Fields = slices.Clone(entry.Fields)
+		stored.URLs = slices.Clone(entry.URLs)
+		stored.URLMatch = entry.URLMatch
+		stored.RotationDays = entry.RotationDays
+		stored.EncryptedMetadata = entry.EncryptedMetadata
+	}
+	return d.checkUnique()
+}
+
+func (s *MemoryStore) Reset() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.data = memoryData{
+		settings:       make(map[string]string),
+		nextEntryID:    s.data.nextEntryID,
+		nextCodeID:     s.data.nextCodeID,
+		nextRevision:   s.data.nextRevision,
+		nextAttachment: s.data.nextAttachment,
+	}
+	return nil
+}
+
+func (d *memoryData) entryIndex(id int) int {
+	return slices.IndexFunc(d.entries, func(entry PasswordEntry) bool { return entry.ID == id })
+}
+
+// checkUnique enforces the uniqueness the SQLite schema does: of the UUID,
+// and of the alias and the service and username together among entries not
+// in the trash
+func (d *memoryData) checkUnique() error {
+	uuids := make(map[string]bool)
+	aliases := make(map[string]bool)
+	accounts := make(map[[2]string]bool)
+
+	for _, entry := range d.entries {
+		if entry.UUID != "" {
+			if uuids[entry.UUID] {
+				return fmt.Errorf("entry UUID %s is already in use", entry.UUID)
+			}
+			uuids[entry.UUID] = true
+		}
+
+		// Entries in the trash may share an account or alias with live ones
+		if entry.DeletedAt != "" {
+			continue
+		}
+
+		account := [2]string{entry.Service, entry.Username}
+		if accounts[account] {
+			return fmt.Errorf("%w: %s (%s)", ErrDuplicateEntry, entry.Service, entry.Username)
+		}
+		accounts[account] = true
+
+		if entry.Alias != "" {
+			if aliases[entry.Alias] {
+				return fmt.Errorf("%w: alias %s", ErrDuplicateEntry, entry.Alias)
+			}
+			aliases[entry.Alias] = true
+		}
+	}
+
+	return nil
+}
+
+func (s *MemoryStore) AddEntry(entry PasswordEntry) error {
+	if entry.UUID == "" {
+		return fmt.Errorf("entry UUID cannot be empty")
+	}
+
+	return s.update(func(d *memoryData) error {
+		entry.ID = d.nextEntryID
+		entry.Tags = slices.Clone(entry.Tags)
+		entry.Fields = slices.Clone(entry.Fields)
+		entry.URLs = slices.Clone(entry.URLs)
+		entry.CreatedAt = memoryTimestamp()
+		entry.UpdatedAt = entry.CreatedAt
+		if entry.PasswordChangedAt == "" {
+			entry.PasswordChangedAt = entry.CreatedAt
+		}
+		d.entries = append(d.entries, entry)
+
+		if err := d.checkUnique(); err != nil {
+			return fmt.Errorf("failed to add password: %w", err)
+		}
+
+		d.nextEntryID++
+		return nil
+	})
+}
+
+func (s *MemoryStore) GetEntry(entryUUID string) (*PasswordEntry, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, entry := range s.data.entries {
+		if entry.UUID == entryUUID {
+			return &entry, nil
+		}
+	}
+	return nil, nil
+}
+
+func (s *MemoryStore) GetEntryByAlias(alias string) (*PasswordEntry, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, entry := range s.data.entries {
+		if entry.Alias != "" && entry.Alias == alias && entry.DeletedAt == "" {
+			return &entry, nil
+		}
+	}
+	return nil, nil
+}
+
+func (s *MemoryStore) ListEntries() ([]PasswordEntry, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var entries []PasswordEntry
+	for _, entry := range s.data.entries {
+		if entry.DeletedAt == "" {
+			entries = append(entries, entry)
+		}
+	}
+	sortEntries(entries)
+	return entries, nil
+}
+
+func (s *MemoryStore) SearchEntries(query string) ([]PasswordEntry, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var entries []PasswordEntry
+	for _, entry := range s.data.entries {
+		if entry.DeletedAt == "" && matchesQuery(entry, query) {
+			entries = append(entries, entry)
+		}
+	}
+	sortEntries(entries)
+	return entries, nil
+}
+
+func (s *MemoryStore) UpdateEntry(entry PasswordEntry, revision *EntryRevision, keep int) error {
+	return s.update(func(d *memoryData) error {
+		i := d.entryIndex(entry.ID)
+		if i < 0 {
+			return fmt.Errorf("password entry not found")
+		}
+
+		stored := &d.entries[i]
+		stored.Type = entry.Type
+		stored.Service = entry.Service
+		stored.Username = entry.Username
+		stored.EncryptedPassword = entry.EncryptedPassword
+		stored.EncryptedTOTP = entry.EncryptedTOTP
+		stored.Notes = entry.Notes
+		stored.Alias = entry.Alias
+		stored.Folder = entry.Folder
+		stored.Tags = slices.Clone(entry.Tags)
+		stored.Fields = slices.Clone(entry.Fields)
+		stored.URLs = slices.Clone(entry.URLs)
+		stored.URLMatch = entry.URLMatch
+		stored.RotationDays = entry.RotationDays
+		stored.EncryptedMetadata = entry.EncryptedMetadata
+		stored.UpdatedAt = memoryTimestamp()
+		if entry.PasswordChangedAt != "" {
+			stored.PasswordChangedAt = entry.PasswordChangedAt
+		}
+
+		if err := d.checkUnique(); err != nil {
+			return fmt.Errorf("failed to update password: %w", err)
+		}
+
+		if revision != nil {
+			stored := *revision
+			stored.ID = d.nextRevision
+			stored.CreatedAt = memoryTimestamp()
+			d.revisions = append(d.revisions, stored)
+			d.nextRevision++
+			d.pruneRevisions(keep, revision.EntryUUID)
+		}
+		return nil
+	})
+}
+
+func (s *MemoryStore) TrashEntry(id int) error {
+	return s.update(func(d *memoryData) error {
+		i := d.entryIndex(id)
+		if i < 0 || d.entries[i].DeletedAt != "" {
+			return fmt.Errorf("password entry not found")
+		}
+		d.entries[i].DeletedAt = memoryTimestamp()
+		return nil
+	})
+}
+
+func (s *MemoryStore) ListTrash() ([]PasswordEntry, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var entries []PasswordEntry
+	for _, entry := range slices.Backward(s.data.entries) {
+		if entry.DeletedAt != "" {
+			entries = append(entries, entry)
+		}
+	}
+	sort.SliceStable(entries, func(i, j int) bool { return entries[i].DeletedAt > entries[j].DeletedAt })
+	return entries, nil
+}
+
+func (s *MemoryStore) RestoreEntry(id int) error {
+	return s.update(func(d *memoryData) error {
+		i := d.entryIndex(id)
+		if i < 0 || d.entries[i].DeletedAt == "" {
+			return fmt.Errorf("password entry not found in trash")
+		}
+		d.entries[i].DeletedAt = ""
+
+		if err := d.checkUnique(); err != nil {
+			return fmt.Errorf("failed to restore password: %w", err)
+		}
+		return nil
+	})
+}
+
+func (s *MemoryStore) PurgeTrash(olderThan time.Duration) (int, error) {
+	cutoff := time.Now().Add(-olderThan)
+	purged := 0
+
+	err := s.update(func(d *memoryData) error {
+		var kept []PasswordEntry
+		removed := make(map[string]bool)
+		for _, entry := range d.entries {
+			deletedAt, err := time.Parse(time.RFC3339, entry.DeletedAt)
+			if entry.DeletedAt == "" || err != nil || deletedAt.After(cutoff) {
+				kept = append(kept, entry)
+				continue
+			}
+			removed[entry.UUID] = true
+			purged++
+		}
+
+		d.entries = kept
+		d.revisions = slices.DeleteFunc(d.revisions, func(revision EntryRevision) bool {
+			return removed[revision.EntryUUID]
+		})
+		d.attachments = slices.DeleteFunc(d.attachments, func(attachment Attachment) bool {
+			return removed[attachment.EntryUUID]
+		})
+		return nil
+	})
+
+	return purged, err
+}
+
+func (s *MemoryStore) ListRevisions(entryUUID string) ([]EntryRevision, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var revisions []EntryRevision
+	for _, revision := range slices.Backward(s.data.revisions) {
+		if revision.EntryUUID == entryUUID {
+			revisions = append(revisions, revision)
+		}
+	}
+	return revisions, nil
+}
+
+func (s *MemoryStore) ListAllRevisions() ([]EntryRevision, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return slices.Clone(s.data.revisions), nil
+}
+
+func (s *MemoryStore) PruneRevisions(keep int) error {
+	return s.update(func(d *memoryData) error {
+		d.pruneRevisions(keep, "")
+		return nil
+	})
+}
+
+// pruneRevisions drops all but the newest keep revisions of the entry with
+// the given UUID, or of every entry if entryUUID is empty
+func (d *memoryData) pruneRevisions(keep int, entryUUID string) {
+	kept := make(map[string]int)
+	var revisions []EntryRevision
+	for _, revision := range slices.Backward(d.revisions) {
+		if entryUUID != "" && revision.EntryUUID != entryUUID || kept[revision.EntryUUID] < keep {
+			revisions = append(revisions, revision)
+			kept[revision.EntryUUID]++
+		}
+	}
+	slices.Reverse(revisions)
+	d.revisions = revisions
+}
+
+func (s *MemoryStore) AddAttachment(attachment Attachment) error {
+	if attachment.UUID == "" || attachment.EntryUUID == "" {
+		return fmt.Errorf("attachment and entry UUIDs cannot be empty")
+	}
+
+	return s.update(func(d *memoryData) error {
+		if slices.ContainsFunc(d.attachments, func(stored Attachment) bool { return stored.UUID == attachment.UUID }) {
+			return fmt.Errorf("failed to add attachment: attachment UUID %s is already in use", attachment.UUID)
+		}
+
+		attachment.ID = d.nextAttachment
+		attachment.Chunks = slices.Clone(attachment.Chunks)
+		attachment.CreatedAt = memoryTimestamp()
+		d.attachments = append(d.attachments, attachment)
+		d.nextAttachment++
+		return nil
+	})
+}
+
+func (d *memoryData) attachmentIndex(id int) int {
+	return slices.IndexFunc(d.attachments, func(attachment Attachment) bool { return attachment.ID == id })
+}
+
+// listAttachments returns the attachments for which keep is true, without
+// their chunks
+func (s *MemoryStore) listAttachments(keep func(Attachment) bool) []Attachment {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var attachments []Attachment
+	for _, attachment := range s.data.attachments {
+		if keep(attachment) {
+			attachment.Chunks = nil
+			attachments = append(attachments, attachment)
+		}
+	}
+	return attachments
+}
+
+func (s *MemoryStore) ListAttachments(entryUUID string) ([]Attachment, error) {
+	return s.listAttachments(func(attachment Attachment) bool { return attachment.EntryUUID == entryUUID }), nil
+}
+
+func (s *MemoryStore) ListAllAttachments() ([]Attachment, error) {
+	return s.listAttachments(func(Attachment) bool { return true }), nil
+}
+
+func (s *MemoryStore) GetAttachment(id int) (*Attachment, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	i := s.data.attachmentIndex(id)
+	if i < 0 {
+		return nil, nil
+	}
+	attachment := s.data.attachments[i]
+	attachment.Chunks = slices.Clone(attachment.Chunks)
+	return &attachment, nil
+}
+
+func (s *MemoryStore) DeleteAttachment(id int) error {
+	return s.update(func(d *memoryData) error {
+		i := d.attachmentIndex(id)
+		if i < 0 {
+			return fmt.Errorf("attachment not found")
+		}
+		d.attachments = slices.Delete(d.attachments, i, i+1)
+		return nil
+	})
+}
+
+func (s *MemoryStore) GetSetting(key string) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.data.settings[key], nil
+}
+
+func (s *MemoryStore) SetSetting(key, value string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.data.settings[key] = value
+	return nil
+}
+
+func (s *MemoryStore) ReplaceRecoveryCodes(codes map[string]string) error {
+	return s.update(func(d *memoryData) error {
+		d.recoveryCodes = nil
+		for codeHash, wrappedKey := range codes {
+			d.recoveryCodes = append(d.recoveryCodes, memoryRecoveryCode{
+				id:         d.nextCodeID,
+				codeHash:   codeHash,
+				wrappedKey: wrappedKey,
+			})
+			d.nextCodeID++
+		}
+		return nil
+	})
+}
+
+func (s *MemoryStore) CountRecoveryCodes() (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.data.recoveryCodes), nil
+}
+
+func (s *MemoryStore) GetRecoveryCode(codeHash string) (int, string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, code := range s.data.recoveryCodes {
+		if code.codeHash == codeHash {
+			return code.id, code.wrappedKey, nil
+		}
+	}
+	return 0, "", ErrInvalidRecoveryCode
+}
+
+func (s *MemoryStore) ConsumeRecoveryCode(id int, hashedPassword, wrappedKey string, settings map[string]string) error {
+	return s.update(func(d *memoryData) error {
+		if err := d.setCredentials(hashedPassword, wrappedKey); err != nil {
+			return err
+		}
+		d.setSettings(settings)
+
+		i := slices.IndexFunc(d.recoveryCodes, func(code memoryRecoveryCode) bool { return code.id == id })
+		if i < 0 {
+			return ErrInvalidRecoveryCode
+		}
+		d.recoveryCodes = slices.Delete(d.recoveryCodes, i, i+1)
+		return nil
+	})
+}
+
+// sortEntries orders entries by service and username, as the SQLite store does
+func sortEntries(entries []PasswordEntry) {
+	sort.SliceStable(entries, func(i, j int) bool {
+		if entries[i].Service != entries[j].Service {
+			return entries[i].Service < entries[j].Service
+		}
+		return entries[i].Username < entries[j].Username
+	})
+}
@@ -0,0 +1,165 @@
+package internal
+
+import (
+	"crypto/hkdf"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// When metadata encryption is enabled, the type, service, username, notes,
+// alias, folder, tags, custom fields, URLs and rotation policy of an entry are
+// sealed together in the encrypted_metadata column. The service, username and
+// alias columns then hold keyed blind indexes (HMAC-SHA256 under a key derived
+// from the vault key) so exact alias lookups and the uniqueness constraints
+// keep working without revealing the values; the other fields are only used
+// after decryption, so they are not stored in the clear at all.
+const (
+	settingEncryptedMetadata = "encrypted_metadata"
+	blindIndexInfo           = "passvault blind index v1"
+)
+
+type entryMetadata struct {
+	Type     EntryType     `json:"type,omitempty"`
+	Service  string        `json:"service"`
+	Username string        `json:"username"`
+	Notes    string        `json:"notes,omitempty"`
+	Alias    string        `json:"alias,omitempty"`
+	Folder   string        `json:"folder,omitempty"`
+	Tags     []string      `json:"tags,omitempty"`
+	Fields   []CustomField `json:"fields,omitempty"`
+	URLs     []string      `json:"urls,omitempty"`
+	URLMatch URLMatch      `json:"url_match,omitempty"`
+	Rotation int           `json:"rotation_days,omitempty"`
+}
+
+// IsMetadataEncrypted reports whether entry metadata is stored encrypted
+func (v *Vault) IsMetadataEncrypted() (bool, error) {
+	value, err := v.store.GetSetting(settingEncryptedMetadata)
+	if err != nil {
+		return false, err
+	}
+	return value == "1", nil
+}
+
+func blindIndexKey(vaultKey *VaultKey) ([]byte, error) {
+	if !vaultKey.valid() {
+		return nil, fmt.Errorf("invalid vault key length")
+	}
+
+	key, err := hkdf.Key(sha256.New, vaultKey.key.Bytes(), nil, blindIndexInfo, 32)
+	if err != nil {
+		return nil, fmt.Errorf("failed to derive blind index key: %w", err)
+	}
+	return key, nil
+}
+
+func blindIndex(indexKey []byte, value string) string {
+	mac := hmac.New(sha256.New, indexKey)
+	mac.Write([]byte(value))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// encodeEntry returns entry in the form it is stored in, sealing its
+// metadata when encrypted is true
+func encodeEntry(entry PasswordEntry, vaultKey *VaultKey, encrypted bool) (PasswordEntry, error) {
+	entry.EncryptedMetadata = ""
+	if !encrypted {
+		return entry, nil
+	}
+
+	indexKey, err := blindIndexKey(vaultKey)
+	if err != nil {
+		return PasswordEntry{}, err
+	}
+
+	plaintext, err := json.Marshal(entryMetadata{
+		Type:     entry.Type,
+		Service:  entry.Service,
+		Username: entry.Username,
+		Notes:    entry.Notes,
+		Alias:    entry.Alias,
+		Folder:   entry.Folder,
+		Tags:     entry.Tags,
+		Fields:   entry.Fields,
+		URLs:     entry.URLs,
+		URLMatch: entry.URLMatch,
+		Rotation: entry.RotationDays,
+	})
+	if err != nil {
+		return PasswordEntry{}, fmt.Errorf("failed to encode metadata: %w", err)
+	}
+
+	sealed, err := encryptValue(plaintext, vaultKey, entryAAD(entry.UUID, fieldMetadata))
+	if err != nil {
+		return PasswordEntry{}, fmt.Errorf("failed to encrypt metadata: %w", err)
+	}
+
+	entry.Service = blindIndex(indexKey, entry.Service)
+	entry.Username = blindIndex(indexKey, entry.Username)
+	entry.Type = ""
+	entry.Notes = ""
+	entry.Folder = ""
+	entry.Tags = nil
+	entry.Fields = nil
+	entry.URLs = nil
+	entry.URLMatch = ""
+	entry.RotationDays = 0
+	if entry.Alias != "" {
+		entry.Alias = blindIndex(indexKey, entry.Alias)
+	}
+	entry.EncryptedMetadata = sealed
+
+	return entry, nil
+}
+
+// openMetadata replaces the blind indexes of a stored entry with its
+// decrypted metadata. Entries stored without a type are logins.
+func openMetadata(entry *PasswordEntry, vaultKey *VaultKey) error {
+	if entry.EncryptedMetadata == "" {
+		if entry.Type == "" {
+			entry.Type = TypeLogin
+		}
+		return nil
+	}
+
+	plaintext, err := decryptValue(entry.EncryptedMetadata, vaultKey, entryAAD(entry.UUID, fieldMetadata))
+	if err != nil {
+		return fmt.Errorf("failed to decrypt metadata: %w", err)
+	}
+
+	var metadata entryMetadata
+	if err := json.Unmarshal(plaintext, &metadata); err != nil {
+		return fmt.Errorf("failed to decode metadata: %w", err)
+	}
+
+	entry.Type = metadata.Type
+	if entry.Type == "" {
+		entry.Type = TypeLogin
+	}
+	entry.Service = metadata.Service
+	entry.Username = metadata.Username
+	entry.Notes = metadata.Notes
+	entry.Alias = metadata.Alias
+	entry.Folder = metadata.Folder
+	entry.Tags = metadata.Tags
+	entry.Fields = metadata.Fields
+	entry.URLs = metadata.URLs
+	entry.URLMatch = metadata.URLMatch
+	entry.RotationDays = metadata.Rotation
+
+	return nil
+}
+
+// matchesQuery reports whether the entry's service, username, notes or alias
+// contain query, ignoring case
+func matchesQuery(entry PasswordEntry, query string) bool {
+	query = strings.ToLower(query)
+	return strings.Contains(strings.ToLower(entry.Service), query) ||
+		strings.Contains(strings.ToLower(entry.Username), query) ||
+		strings.Contains(strings.ToLower(entry.Notes), query) ||
+		strings.Contains(strings.ToLower(entry.Alias), query)
+}
@@ -0,0 +1,485 @@
+package internal
+
+import (
+	"database/sql"
+	"fmt"
+	"os"
+	"time"
+)
+
+// migration is one forward change to the vault database schema. Migrations
+// are applied in order and each is recorded in the schema_version table.
+// Databases from before schema versioning may already have some of the
+// changes, so every migration must succeed on a schema that already has it.
+type migration struct {
+	version int
+	name    string
+	up      func(tx *sql.Tx) error
+}
+
+var migrations = []migration{
+	{1, "create master_password and passwords tables", migrateInitialTables},
+	{2, "create vault_key and settings tables", migrateVaultKeyTables},
+	{3, "add encrypted_metadata column to passwords", migrateEncryptedMetadata},
+	{4, "add uuid column to passwords", migrateEntryUUIDs},
+	{5, "create recovery_codes table", migrateRecoveryCodes},
+	{6, "create password_history table", migratePasswordHistory},
+	{7, "add deleted_at column to passwords for the trash", migrateTrash},
+	{8, "add folders and tags", migrateTagsAndFolders},
+	{9, "create entry_fields table", migrateCustomFields},
+	{10, "add encrypted_totp column to passwords", migrateTOTP},
+	{11, "add type column to passwords", migrateEntryTypes},
+	{12, "create attachments and attachment_chunks tables", migrateAttachments},
+	{13, "add entry_urls table and url_match column", migrateURLs},
+	{14, "add password_changed_at and rotation_days columns", migrateRotation},
+}
+
+// LatestSchemaVersion is the schema version this build of passvault writes
+func LatestSchemaVersion() int {
+	return migrations[len(migrations)-1].version
+}
+
+// MigrationStatus describes a schema migration and whether it has been applied
+type MigrationStatus struct {
+	Version   int
+	Name      string
+	AppliedAt string // empty if the migration is pending
+	Backup    string // backup taken before the migration was applied, if any
+}
+
+// SchemaMigrator is implemented by stores whose schema is versioned
+type SchemaMigrator interface {
+	// MigrationStatus returns every known migration in order
+	MigrationStatus() ([]MigrationStatus, error)
+}
+
+var _ SchemaMigrator = (*SQLiteStore)(nil)
+
+// migrate applies pending migrations in a single transaction, first copying
+// the database aside unless it is new
+func (s *SQLiteStore) migrate() error {
+	if _, err := s.db.Exec(`
+	CREATE TABLE IF NOT EXISTS schema_version (
+		version INTEGER PRIMARY KEY,
+		name TEXT NOT NULL,
+		backup TEXT,
+		applied_at DATETIME DEFAULT CURRENT_TIMESTAMP
+	);
+	`); err != nil {
+		return fmt.Errorf("failed to create schema_version table: %w", err)
+	}
+
+	current, err := s.schemaVersion()
+	if err != nil {
+		return err
+	}
+
+	if current > LatestSchemaVersion() {
+		return fmt.Errorf("vault schema version %d is newer than this passvault supports (%d); upgrade passvault", current, LatestSchemaVersion())
+	}
+	if current == LatestSchemaVersion() {
+		return nil
+	}
+
+	var tables int
+	if err := s.db.QueryRow("SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name NOT IN ('schema_version', 'sqlite_sequence')").Scan(&tables); err != nil {
+		return fmt.Errorf("failed to inspect database: %w", err)
+	}
+
+	backup := ""
+	if tables > 0 {
+		backup, err = s.backup(current)
+		if err != nil {
+			return err
+		}
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	for _, m := range migrations {
+		if m.version <= current {
+			continue
+		}
+		if err := m.up(tx); err != nil {
+			return fmt.Errorf("failed to apply migration %d (%s): %w", m.version, m.name, err)
+		}
+		if _, err := tx.Exec("INSERT INTO schema_version (version, name, backup) VALUES (?, ?, ?)", m.version, m.name, nullIfEmpty(backup)); err != nil {
+			return fmt.Errorf("failed to record migration %d: %w", m.version, err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit migrations: %w", err)
+	}
+
+	s.backupPath = backup
+	return nil
+}
+
+// schemaVersion returns the version of the last applied migration, or 0
+func (s *SQLiteStore) schemaVersion() (int, error) {
+	var version sql.NullInt64
+	if err := s.db.QueryRow("SELECT MAX(version) FROM schema_version").Scan(&version); err != nil {
+		return 0, fmt.Errorf("failed to read schema version: %w", err)
+	}
+	return int(version.Int64), nil
+}
+
+// backup copies the database next to itself before it is migrated
+func (s *SQLiteStore) backup(version int) (string, error) {
+	path := fmt.Sprintf("%s.v%d-%s.bak", s.path, version, time.Now().Format("20060102-150405"))
+
+	if _, err := s.db.Exec("VACUUM INTO ?", path); err != nil {
+		return "", fmt.Errorf("failed to back up database before migrating: %w", err)
+	}
+	if err := os.Chmod(path, 0600); err != nil {
+		return "", fmt.Errorf("failed to back up database before migrating: %w", err)
+	}
+
+	return path, nil
+}
+
+// BackupPath returns the backup taken when the store was opened, or "" if no
+// migration was needed
+func (s *SQLiteStore) BackupPath() string {
+	return s.backupPath
+}
+
+func (s *SQLiteStore) MigrationStatus() ([]MigrationStatus, error) {
+	rows, err := s.db.Query("SELECT version, applied_at, backup FROM schema_version")
+	if err != nil {
+		return nil, fmt.Errorf("failed to read schema versions: %w", err)
+	}
+	defer rows.Close()
+
+	type applied struct {
+		at     string
+		backup string
+	}
+	done := make(map[int]applied)
+	for rows.Next() {
+		var version int
+		var at string
+		var backup sql.NullString
+		if err := rows.Scan(&version, &at, &backup); err != nil {
+			return nil, fmt.Errorf("failed to read schema versions: %w", err)
+		}
+		done[version] = applied{at: at, backup: backup.String}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read schema versions: %w", err)
+	}
+
+	statuses := make([]MigrationStatus, 0, len(migrations))
+	for _, m := range migrations {
+		status := MigrationStatus{Version: m.version, Name: m.name}
+		if a, ok := done[m.version]; ok {
+			status.AppliedAt = a.at
+			status.Backup = a.backup
+		}
+		statuses = append(statuses, status)
+	}
+
+	return statuses, nil
+}
+
+func migrateInitialTables(tx *sql.Tx) error {
+	if _, err := tx.Exec(`
+	CREATE TABLE IF NOT EXISTS master_password (
+		id INTEGER PRIMARY KEY CHECK (id = 1),
+		password_hash TEXT NOT NULL,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		updated_at DATETIME DEFAULT CURRENT_TIMESTAMP
+	);
+	`); err != nil {
+		return fmt.Errorf("failed to create master_password table: %w", err)
+	}
+
+	if _, err := tx.Exec(`
+	CREATE TABLE IF NOT EXISTS passwords (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		service TEXT NOT NULL,
+		username TEXT NOT NULL,
+		encrypted_password TEXT NOT NULL,
+		notes TEXT,
+		alias TEXT UNIQUE,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		updated_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		UNIQUE(service, username)
+	);
+	`); err != nil {
+		return fmt.Errorf("failed to create passwords table: %w", err)
+	}
+
+	return nil
+}
+
+func migrateVaultKeyTables(tx *sql.Tx) error {
+	if _, err := tx.Exec(`
+	CREATE TABLE IF NOT EXISTS vault_key (
+		id INTEGER PRIMARY KEY CHECK (id = 1),
+		wrapped_key TEXT NOT NULL,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		updated_at DATETIME DEFAULT CURRENT_TIMESTAMP
+	);
+	`); err != nil {
+		return fmt.Errorf("failed to create vault_key table: %w", err)
+	}
+
+	if _, err := tx.Exec(`
+	CREATE TABLE IF NOT EXISTS settings (
+		key TEXT PRIMARY KEY,
+		value TEXT NOT NULL
+	);
+	`); err != nil {
+		return fmt.Errorf("failed to create settings table: %w", err)
+	}
+
+	return nil
+}
+
+func migrateEncryptedMetadata(tx *sql.Tx) error {
+	return addColumnIfMissing(tx, "passwords", "encrypted_metadata", "TEXT")
+}
+
+func migrateEntryUUIDs(tx *sql.Tx) error {
+	if err := addColumnIfMissing(tx, "passwords", "uuid", "TEXT"); err != nil {
+		return err
+	}
+
+	if _, err := tx.Exec("CREATE UNIQUE INDEX IF NOT EXISTS idx_passwords_uuid ON passwords(uuid)"); err != nil {
+		return fmt.Errorf("failed to create uuid index: %w", err)
+	}
+
+	return nil
+}
+
+func migrateRecoveryCodes(tx *sql.Tx) error {
+	if _, err := tx.Exec(`
+	CREATE TABLE IF NOT EXISTS recovery_codes (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		code_hash TEXT NOT NULL UNIQUE,
+		wrapped_key TEXT NOT NULL,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+	);
+	`); err != nil {
+		return fmt.Errorf("failed to create recovery_codes table: %w", err)
+	}
+
+	return nil
+}
+
+func migratePasswordHistory(tx *sql.Tx) error {
+	if _, err := tx.Exec(`
+	CREATE TABLE IF NOT EXISTS password_history (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		entry_uuid TEXT NOT NULL,
+		encrypted_password TEXT NOT NULL,
+		encrypted_data TEXT NOT NULL,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+	);
+	`); err != nil {
+		return fmt.Errorf("failed to create password_history table: %w", err)
+	}
+
+	if _, err := tx.Exec("CREATE INDEX IF NOT EXISTS idx_password_history_entry ON password_history(entry_uuid)"); err != nil {
+		return fmt.Errorf("failed to create password_history index: %w", err)
+	}
+
+	return nil
+}
+
+// migrateTrash rebuilds the passwords table with a deleted_at column. Entries
+// in the trash keep their service, username and alias, so uniqueness moves
+// from the table to partial indexes covering only entries not in the trash.
+func migrateTrash(tx *sql.Tx) error {
+	statements := []string{
+		`CREATE TABLE passwords_new (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			uuid TEXT,
+			service TEXT NOT NULL,
+			username TEXT NOT NULL,
+			encrypted_password TEXT NOT NULL,
+			notes TEXT,
+			alias TEXT,
+			encrypted_metadata TEXT,
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			updated_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			deleted_at DATETIME
+		)`,
+		`INSERT INTO passwords_new (id, uuid, service, username, encrypted_password, notes, alias, encrypted_metadata, created_at, updated_at)
+			SELECT id, uuid, service, username, encrypted_password, notes, alias, encrypted_metadata, created_at, updated_at FROM passwords`,
+		"DROP TABLE passwords",
+		"ALTER TABLE passwords_new RENAME TO passwords",
+		"CREATE UNIQUE INDEX idx_passwords_uuid ON passwords(uuid)",
+		"CREATE UNIQUE INDEX idx_passwords_account ON passwords(service, username) WHERE deleted_at IS NULL",
+		"CREATE UNIQUE INDEX idx_passwords_alias ON passwords(alias) WHERE deleted_at IS NULL",
+	}
+
+	for _, statement := range statements {
+		if _, err := tx.Exec(statement); err != nil {
+			return fmt.Errorf("failed to rebuild passwords table: %w", err)
+		}
+	}
+
+	return nil
+}
+
+func migrateTagsAndFolders(tx *sql.Tx) error {
+	if err := addColumnIfMissing(tx, "passwords", "folder", "TEXT"); err != nil {
+		return err
+	}
+
+	statements := []string{
+		`CREATE TABLE IF NOT EXISTS tags (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			name TEXT NOT NULL UNIQUE
+		)`,
+		`CREATE TABLE IF NOT EXISTS entry_tags (
+			entry_id INTEGER NOT NULL,
+			tag_id INTEGER NOT NULL,
+			PRIMARY KEY (entry_id, tag_id)
+		)`,
+		"CREATE INDEX IF NOT EXISTS idx_entry_tags_tag ON entry_tags(tag_id)",
+	}
+
+	for _, statement := range statements {
+		if _, err := tx.Exec(statement); err != nil {
+			return fmt.Errorf("failed to create tag tables: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// migrateCustomFields adds the table holding the custom fields of entries in
+// order; the value of a hidden field is its ciphertext
+func migrateCustomFields(tx *sql.Tx) error {
+	if _, err := tx.Exec(`
+	CREATE TABLE IF NOT EXISTS entry_fields (
+		entry_id INTEGER NOT NULL,
+		position INTEGER NOT NULL,
+		name TEXT NOT NULL,
+		value TEXT NOT NULL,
+		hidden INTEGER NOT NULL DEFAULT 0,
+		PRIMARY KEY (entry_id, position)
+	);
+	`); err != nil {
+		return fmt.Errorf("failed to create entry_fields table: %w", err)
+	}
+
+	return nil
+}
+
+func migrateTOTP(tx *sql.Tx) error {
+	return addColumnIfMissing(tx, "passwords", "encrypted_totp", "TEXT")
+}
+
+// migrateEntryTypes adds the type of entries; existing entries, which have
+// none, are logins
+func migrateEntryTypes(tx *sql.Tx) error {
+	return addColumnIfMissing(tx, "passwords", "type", "TEXT")
+}
+
+// migrateAttachments adds the tables holding the files attached to entries;
+// the contents of a file are kept as encrypted chunks in order
+func migrateAttachments(tx *sql.Tx) error {
+	statements := []string{
+		`CREATE TABLE IF NOT EXISTS attachments (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			uuid TEXT NOT NULL UNIQUE,
+			entry_uuid TEXT NOT NULL,
+			encrypted_info TEXT NOT NULL,
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+		)`,
+		"CREATE INDEX IF NOT EXISTS idx_attachments_entry ON attachments(entry_uuid)",
+		`CREATE TABLE IF NOT EXISTS attachment_chunks (
+			attachment_id INTEGER NOT NULL,
+			position INTEGER NOT NULL,
+			data TEXT NOT NULL,
+			PRIMARY KEY (attachment_id, position)
+		)`,
+	}
+
+	for _, statement := range statements {
+		if _, err := tx.Exec(statement); err != nil {
+			return fmt.Errorf("failed to create attachment tables: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// migrateURLs adds the URLs of entries, in order, and how each entry's URLs
+// are matched
+func migrateURLs(tx *sql.Tx) error {
+	if err := addColumnIfMissing(tx, "passwords", "url_match", "TEXT"); err != nil {
+		return err
+	}
+
+	if _, err := tx.Exec(`
+	CREATE TABLE IF NOT EXISTS entry_urls (
+		entry_id INTEGER NOT NULL,
+		position INTEGER NOT NULL,
+		url TEXT NOT NULL,
+		PRIMARY KEY (entry_id, position)
+	);
+	`); err != nil {
+		return fmt.Errorf("failed to create entry_urls table: %w", err)
+	}
+
+	return nil
+}
+
+// migrateRotation records when the password of each entry last changed,
+// starting from its last update, and adds per-entry rotation policies
+func migrateRotation(tx *sql.Tx) error {
+	if err := addColumnIfMissing(tx, "passwords", "password_changed_at", "DATETIME"); err != nil {
+		return err
+	}
+	if err := addColumnIfMissing(tx, "passwords", "rotation_days", "INTEGER"); err != nil {
+		return err
+	}
+
+	if _, err := tx.Exec("UPDATE passwords SET password_changed_at = COALESCE(updated_at, created_at) WHERE password_changed_at IS NULL"); err != nil {
+		return fmt.Errorf("failed to set password change times: %w", err)
+	}
+
+	return nil
+}
+
+// addColumnIfMissing adds a column to a table created by an older version
+func addColumnIfMissing(tx *sql.Tx, table, column, definition string) error {
+	rows, err := tx.Query(fmt.Sprintf("PRAGMA table_info(%s)", table))
+	if err != nil {
+		return fmt.Errorf("failed to inspect %s table: %w", table, err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var cid, notNull, pk int
+		var name, colType string
+		var defaultValue sql.NullString
+		if err := rows.Scan(&cid, &name, &colType, &notNull, &defaultValue, &pk); err != nil {
+			return fmt.Errorf("failed to inspect %s table: %w", table, err)
+		}
+		if name == column {
+			return nil
+		}
+	}
+
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("failed to inspect %s table: %w", table, err)
+	}
+	rows.Close()
+
+	if _, err := tx.Exec(fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", table, column, definition)); err != nil {
+		return fmt.Errorf("failed to add %s column to %s table: %w", column, table, err)
+	}
+
+	return nil
+}
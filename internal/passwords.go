@@ -0,0 +1,273 @@
+package internal
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+var (
+	// ErrNoEntries is returned when no entries match a search
+	ErrNoEntries = errors.New("no passwords found")
+
+	// ErrDuplicateEntry is returned when an entry would have the service and
+	// username or the alias of another entry
+	ErrDuplicateEntry = errors.New("an entry with the same service and username or alias already exists")
+)
+
+func (v *Vault) AddPassword(entry PasswordEntry, vaultKey *VaultKey) error {
+	if entry.UUID == "" {
+		return fmt.Errorf("entry UUID cannot be empty")
+	}
+	if err := checkEntry(entry); err != nil {
+		return err
+	}
+
+	encrypted, err := v.IsMetadataEncrypted()
+	if err != nil {
+		return err
+	}
+
+	stored, err := encodeEntry(entry, vaultKey, encrypted)
+	if err != nil {
+		return err
+	}
+
+	return v.store.AddEntry(stored)
+}
+
+func (v *Vault) ListAllPasswords(vaultKey *VaultKey) ([]PasswordEntry, error) {
+	entries, err := v.store.ListEntries()
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range entries {
+		if err := openMetadata(&entries[i], vaultKey); err != nil {
+			return nil, fmt.Errorf("failed to open password entry %d: %w", entries[i].ID, err)
+		}
+	}
+
+	// Blind indexes sort meaninglessly, so order by the decrypted values
+	sortEntries(entries)
+
+	return entries, nil
+}
+
+func (v *Vault) SearchPasswords(query string, vaultKey *VaultKey) ([]PasswordEntry, error) {
+	encrypted, err := v.IsMetadataEncrypted()
+	if err != nil {
+		return nil, err
+	}
+
+	if !encrypted {
+		entries, err := v.store.SearchEntries(query)
+		if err != nil {
+			return nil, err
+		}
+		for i := range entries {
+			if err := openMetadata(&entries[i], vaultKey); err != nil {
+				return nil, err
+			}
+		}
+		return entries, nil
+	}
+
+	// Encrypted metadata can only be searched after decrypting it in memory
+	entries, err := v.ListAllPasswords(vaultKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to search passwords: %w", err)
+	}
+
+	var matches []PasswordEntry
+	for _, entry := range entries {
+		if matchesQuery(entry, query) {
+			matches = append(matches, entry)
+		}
+	}
+	return matches, nil
+}
+
+func (v *Vault) GetPasswordByAlias(alias string, vaultKey *VaultKey) (*PasswordEntry, error) {
+	encrypted, err := v.IsMetadataEncrypted()
+	if err != nil {
+		return nil, err
+	}
+
+	lookup := alias
+	if encrypted {
+		indexKey, err := blindIndexKey(vaultKey)
+		if err != nil {
+			return nil, err
+		}
+		lookup = blindIndex(indexKey, alias)
+	}
+
+	entry, err := v.store.GetEntryByAlias(lookup)
+	if err != nil || entry == nil {
+		return nil, err
+	}
+
+	if err := openMetadata(entry, vaultKey); err != nil {
+		return nil, err
+	}
+
+	return entry, nil
+}
+
+// GetPassword returns the entry with the given UUID, or nil if there is none
+func (v *Vault) GetPassword(entryUUID string, vaultKey *VaultKey) (*PasswordEntry, error) {
+	entry, err := v.store.GetEntry(entryUUID)
+	if err != nil || entry == nil {
+		return nil, err
+	}
+
+	if err := openMetadata(entry, vaultKey); err != nil {
+		return nil, err
+	}
+
+	return entry, nil
+}
+
+// FindPassword returns the entry whose alias is query, or else lets the user
+// pick one of the entries matching it
+func (v *Vault) FindPassword(query string, vaultKey *VaultKey) (*PasswordEntry, error) {
+	if query != "" {
+		entry, err := v.GetPasswordByAlias(query, vaultKey)
+		if err != nil {
+			return nil, fmt.Errorf("error checking alias: %w", err)
+		}
+		if entry != nil {
+			return entry, nil
+		}
+	}
+
+	return v.SearchAndSelectPassword(query, vaultKey)
+}
+
+// UpdatePassword stores new values for an entry, keeping the version it
+// replaces in the entry's history. The time its password changed is only
+// moved on when the secret itself is different.
+func (v *Vault) UpdatePassword(entry PasswordEntry, vaultKey *VaultKey) error {
+	if err := checkEntry(entry); err != nil {
+		return err
+	}
+
+	encrypted, err := v.IsMetadataEncrypted()
+	if err != nil {
+		return err
+	}
+
+	current, err := v.store.GetEntry(entry.UUID)
+	if err != nil {
+		return err
+	}
+	if current == nil || current.ID != entry.ID {
+		return fmt.Errorf("password entry not found")
+	}
+
+	changed, err := passwordChanged(current, entry, vaultKey)
+	if err != nil {
+		return err
+	}
+	entry.PasswordChangedAt = current.PasswordChangedAt
+	if changed {
+		entry.PasswordChangedAt = timestamp(time.Now())
+	}
+
+	revision, keep, err := v.revisionOf(current, entry, vaultKey)
+	if err != nil {
+		return fmt.Errorf("failed to record previous version: %w", err)
+	}
+
+	stored, err := encodeEntry(entry, vaultKey, encrypted)
+	if err != nil {
+		return err
+	}
+
+	return v.store.UpdateEntry(stored, revision, keep)
+}
+
+// checkEntry reports whether an entry holds what its type requires
+func checkEntry(entry PasswordEntry) error {
+	schema, err := SchemaOf(entry.Type)
+	if err != nil {
+		return err
+	}
+	return schema.Check(entry)
+}
+
+// DeletePassword moves an entry to the trash
+func (v *Vault) DeletePassword(id int) error {
+	return v.store.TrashEntry(id)
+}
+
+// ListTrash returns the entries in the trash, most recently deleted first
+func (v *Vault) ListTrash(vaultKey *VaultKey) ([]PasswordEntry, error) {
+	entries, err := v.store.ListTrash()
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range entries {
+		if err := openMetadata(&entries[i], vaultKey); err != nil {
+			return nil, fmt.Errorf("failed to open password entry %d: %w", entries[i].ID, err)
+		}
+	}
+
+	return entries, nil
+}
+
+// RestorePassword takes an entry out of the trash
+func (v *Vault) RestorePassword(id int) error {
+	return v.store.RestoreEntry(id)
+}
+
+// PurgeTrash permanently deletes the entries that have been in the trash for
+// at least olderThan, returning how many were deleted
+func (v *Vault) PurgeTrash(olderThan time.Duration) (int, error) {
+	return v.store.PurgeTrash(olderThan)
+}
+
+// allPasswords returns every entry, including those in the trash
+func (v *Vault) allPasswords(vaultKey *VaultKey) ([]PasswordEntry, error) {
+	entries, err := v.ListAllPasswords(vaultKey)
+	if err != nil {
+		return nil, err
+	}
+
+	trash, err := v.ListTrash(vaultKey)
+	if err != nil {
+		return nil, err
+	}
+
+	return append(entries, trash...), nil
+}
+
+// SetMetadataEncryption switches the vault between plaintext and encrypted
+// metadata, rewriting every entry at once
+func (v *Vault) SetMetadataEncryption(enabled bool, vaultKey *VaultKey) error {
+	entries, err := v.allPasswords(vaultKey)
+	if err != nil {
+		return err
+	}
+
+	for i, entry := range entries {
+		entries[i], err = encodeEntry(entry, vaultKey, enabled)
+		if err != nil {
+			return err
+		}
+	}
+
+	value := "0"
+	if enabled {
+		value = "1"
+	}
+
+	return v.store.RewriteEntries(entries, map[string]string{settingEncryptedMetadata: value})
+}
+
+// Reset deletes every entry, the master password and all settings of the vault
+func (v *Vault) Reset() error {
+	return v.store.Reset()
+}
@@ -0,0 +1,47 @@
+package internal
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+)
+
+func TestRestorePasswordConflict(t *testing.T) {
+	stores := map[string]func(t *testing.T) Store{
+		"memory": func(t *testing.T) Store { return NewMemoryStore() },
+		"sqlite": func(t *testing.T) Store {
+			store, err := OpenSQLiteStore(filepath.Join(t.TempDir(), "vault.db"))
+			if err != nil {
+				t.Fatalf("OpenSQLiteStore: %v", err)
+			}
+			t.Cleanup(func() { store.Close() })
+			return store
+		},
+	}
+
+	for name, newStore := range stores {
+		t.Run(name, func(t *testing.T) {
+			vault, vaultKey, entry := newTestVault(t, newStore(t), "password")
+			if err := vault.DeletePassword(entry.ID); err != nil {
+				t.Fatalf("DeletePassword: %v", err)
+			}
+
+			// A new entry takes the account of the one in the trash
+			replacement := PasswordEntry{Type: TypeLogin, Service: entry.Service, Username: entry.Username}
+			var err error
+			if replacement.UUID, err = NewEntryUUID(); err != nil {
+				t.Fatalf("NewEntryUUID: %v", err)
+			}
+			if replacement.EncryptedPassword, err = EncryptPassword([]byte("third"), vaultKey, replacement.UUID); err != nil {
+				t.Fatalf("EncryptPassword: %v", err)
+			}
+			if err := vault.AddPassword(replacement, vaultKey); err != nil {
+				t.Fatalf("AddPassword: %v", err)
+			}
+
+			if err := vault.RestorePassword(entry.ID); !errors.Is(err, ErrDuplicateEntry) {
+				t.Errorf("RestorePassword error = %v, want ErrDuplicateEntry", err)
+			}
+		})
+	}
+}
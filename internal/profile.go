@@ -0,0 +1,209 @@
+package internal
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// Vaults are SQLite files. Besides the default vault in ~/.passvault, named
+// vaults can be registered in ~/.passvault/config.json, which also records the
+// vault commands use when none is selected with --vault or PASSVAULT_VAULT.
+const (
+	DefaultVaultName = "default"
+	configFileName   = "config.json"
+	vaultsDirName    = "vaults"
+)
+
+var vaultNamePattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9_-]{0,63}$`)
+
+// Config holds the named vaults and the one in use
+type Config struct {
+	Current string            `json:"current,omitempty"`
+	Vaults  map[string]string `json:"vaults,omitempty"`
+}
+
+// VaultProfile is a named vault and the path of its database
+type VaultProfile struct {
+	Name string
+	Path string
+}
+
+// PassvaultDir returns ~/.passvault, where the default vault and the config live
+func PassvaultDir() (string, error) {
+	homeDir, err := os.UserHomeDir()
+	if err != nil {
+		return "", fmt.Errorf("failed to get home directory: %w", err)
+	}
+	return filepath.Join(homeDir, ".passvault"), nil
+}
+
+// LoadConfig reads the config file, returning an empty config if there is none
+func LoadConfig() (*Config, error) {
+	dir, err := PassvaultDir()
+	if err != nil {
+		return nil, err
+	}
+
+	config := &Config{Vaults: make(map[string]string)}
+
+	data, err := os.ReadFile(filepath.Join(dir, configFileName))
+	if errors.Is(err, os.ErrNotExist) {
+		return config, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read config: %w", err)
+	}
+
+	if err := json.Unmarshal(data, config); err != nil {
+		return nil, fmt.Errorf("failed to parse config: %w", err)
+	}
+	if config.Vaults == nil {
+		config.Vaults = make(map[string]string)
+	}
+
+	return config, nil
+}
+
+// Save writes the config file, replacing it atomically
+func (c *Config) Save() error {
+	dir, err := PassvaultDir()
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return fmt.Errorf("failed to create .passvault directory: %w", err)
+	}
+
+	data, err := json.MarshalIndent(c, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode config: %w", err)
+	}
+
+	tmp, err := os.CreateTemp(dir, configFileName+".*")
+	if err != nil {
+		return fmt.Errorf("failed to write config: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(append(data, '\n')); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write config: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to write config: %w", err)
+	}
+
+	if err := os.Rename(tmp.Name(), filepath.Join(dir, configFileName)); err != nil {
+		return fmt.Errorf("failed to write config: %w", err)
+	}
+
+	return nil
+}
+
+// VaultPath returns the database path of a named vault
+func (c *Config) VaultPath(name string) (string, bool, error) {
+	if path, ok := c.Vaults[name]; ok {
+		return path, true, nil
+	}
+
+	if name == DefaultVaultName {
+		path, err := DefaultVaultPath(DefaultVaultName)
+		return path, true, err
+	}
+
+	return "", false, nil
+}
+
+// Profiles returns the named vaults sorted by name, always including the default vault
+func (c *Config) Profiles() ([]VaultProfile, error) {
+	names := []string{DefaultVaultName}
+	for name := range c.Vaults {
+		if name != DefaultVaultName {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names[1:])
+
+	profiles := make([]VaultProfile, 0, len(names))
+	for _, name := range names {
+		path, _, err := c.VaultPath(name)
+		if err != nil {
+			return nil, err
+		}
+		profiles = append(profiles, VaultProfile{Name: name, Path: path})
+	}
+
+	return profiles, nil
+}
+
+// ValidateVaultName checks that name can be used for a named vault
+func ValidateVaultName(name string) error {
+	if !vaultNamePattern.MatchString(name) {
+		return fmt.Errorf("invalid vault name %q: use letters, digits, '-' and '_'", name)
+	}
+	return nil
+}
+
+// DefaultVaultPath returns where the database of a named vault is kept unless
+// another path is given when it is created
+func DefaultVaultPath(name string) (string, error) {
+	dir, err := PassvaultDir()
+	if err != nil {
+		return "", err
+	}
+
+	if name == DefaultVaultName {
+		return filepath.Join(dir, "passvault.db"), nil
+	}
+	return filepath.Join(dir, vaultsDirName, name+".db"), nil
+}
+
+// isVaultPath reports whether a vault selector is a file path rather than a name
+func isVaultPath(selector string) bool {
+	return strings.ContainsRune(selector, '/') ||
+		strings.ContainsRune(selector, filepath.Separator) ||
+		strings.HasSuffix(selector, ".db")
+}
+
+// ResolveVault returns the name and database path of the vault selected by
+// selector, which is a vault name or a path to a database file. An empty
+// selector picks the vault chosen with 'passvault vault use', or the default.
+func ResolveVault(selector string) (string, string, error) {
+	if selector != "" && isVaultPath(selector) {
+		path, err := filepath.Abs(selector)
+		if err != nil {
+			return "", "", fmt.Errorf("failed to resolve vault path: %w", err)
+		}
+		return "", path, nil
+	}
+
+	config, err := LoadConfig()
+	if err != nil {
+		return "", "", err
+	}
+
+	name := selector
+	if name == "" {
+		name = config.Current
+	}
+	if name == "" {
+		name = DefaultVaultName
+	}
+
+	path, ok, err := config.VaultPath(name)
+	if err != nil {
+		return "", "", err
+	}
+	if !ok {
+		return "", "", fmt.Errorf("unknown vault %q; create it with 'passvault vault create %s'", name, name)
+	}
+
+	return name, path, nil
+}
@@ -0,0 +1,146 @@
+package internal
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base32"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// A recovery code is a random one-time secret that wraps the vault key on its
+// own, independently of the master password and key file. Only a hash of each
+// code is stored, used to find the wrapped key it opens.
+const (
+	DefaultRecoveryCodeCount = 10
+	MaxRecoveryCodeCount     = 50
+	recoveryCodeBytes        = 15
+	recoveryCodeGroup        = 4
+)
+
+// ErrInvalidRecoveryCode is returned for a recovery code that does not exist or was already used
+var ErrInvalidRecoveryCode = errors.New("invalid or already used recovery code")
+
+// GenerateRecoveryCodes creates count new recovery codes for the vault,
+// replacing any existing ones, and returns them formatted for printing
+func (v *Vault) GenerateRecoveryCodes(vaultKey *VaultKey, count int) ([]string, error) {
+	if count < 1 || count > MaxRecoveryCodeCount {
+		return nil, fmt.Errorf("number of recovery codes must be between 1 and %d", MaxRecoveryCodeCount)
+	}
+
+	codes := make([]string, 0, count)
+	wrapped := make(map[string]string, count)
+
+	for len(codes) < count {
+		raw := make([]byte, recoveryCodeBytes)
+		if _, err := rand.Read(raw); err != nil {
+			return nil, fmt.Errorf("failed to generate recovery code: %w", err)
+		}
+
+		code := base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(raw)
+		Wipe(raw)
+
+		secret := NewSecretBufferFrom([]byte(code))
+		wrappedKey, err := WrapVaultKey(vaultKey, secret, DefaultKeyParams)
+		secret.Destroy()
+		if err != nil {
+			return nil, err
+		}
+
+		wrapped[recoveryCodeHash(code)] = wrappedKey
+		codes = append(codes, formatRecoveryCode(code))
+	}
+
+	if err := v.store.ReplaceRecoveryCodes(wrapped); err != nil {
+		return nil, err
+	}
+
+	return codes, nil
+}
+
+// UnlockWithRecoveryCode returns the vault key wrapped by a recovery code
+// without using up the code
+func (v *Vault) UnlockWithRecoveryCode(code string) (*VaultKey, error) {
+	normalized := normalizeRecoveryCode(code)
+
+	_, wrappedKey, err := v.store.GetRecoveryCode(recoveryCodeHash(normalized))
+	if err != nil {
+		return nil, err
+	}
+
+	secret := NewSecretBufferFrom([]byte(normalized))
+	defer secret.Destroy()
+
+	vaultKey, err := UnwrapVaultKey(wrappedKey, secret)
+	if err != nil {
+		return nil, ErrInvalidRecoveryCode
+	}
+
+	return vaultKey, nil
+}
+
+// Recover sets a new master password for a vault unlocked with a
+// recovery code and uses up the code. The vault key and the remaining codes
+// stay the same; a key file requirement is removed, since the new master
+// password is all that is known to the user.
+func (v *Vault) Recover(vaultKey *VaultKey, code string, newMasterPassword *SecretBuffer) error {
+	id, _, err := v.store.GetRecoveryCode(recoveryCodeHash(normalizeRecoveryCode(code)))
+	if err != nil {
+		return err
+	}
+
+	hashedPassword, wrappedKey, err := v.masterCredentials(vaultKey, newMasterPassword)
+	if err != nil {
+		return err
+	}
+
+	return v.store.ConsumeRecoveryCode(id, hashedPassword, wrappedKey, map[string]string{settingKeyFileFPR: ""})
+}
+
+// CountRecoveryCodes returns the number of unused recovery codes
+func (v *Vault) CountRecoveryCodes() (int, error) {
+	return v.store.CountRecoveryCodes()
+}
+
+// PrintRecoveryCodes prints recovery codes with instructions for keeping them
+func PrintRecoveryCodes(codes []string) {
+	printRecoveryCodes(os.Stdout, codes)
+}
+
+func printRecoveryCodes(w io.Writer, codes []string) {
+	fmt.Fprintln(w, "\nRecovery codes (each can be used once with 'passvault recover'):")
+	fmt.Fprintln(w)
+	for i, code := range codes {
+		fmt.Fprintf(w, "  %2d. %s\n", i+1, code)
+	}
+	fmt.Fprintln(w, "\nPrint or write these down and store them somewhere safe and offline.")
+	fmt.Fprintln(w, "They will not be shown again.")
+}
+
+func recoveryCodeHash(normalizedCode string) string {
+	sum := sha256.Sum256([]byte("passvault recovery code:" + normalizedCode))
+	return hex.EncodeToString(sum[:])
+}
+
+// normalizeRecoveryCode strips separators and case so codes can be typed loosely
+func normalizeRecoveryCode(code string) string {
+	code = strings.ToUpper(code)
+	return strings.Map(func(r rune) rune {
+		if r == '-' || r == ' ' {
+			return -1
+		}
+		return r
+	}, code)
+}
+
+func formatRecoveryCode(code string) string {
+	var groups []string
+	for i := 0; i < len(code); i += recoveryCodeGroup {
+		groups = append(groups, code[i:min(i+recoveryCodeGroup, len(code))])
+	}
+	return strings.Join(groups, "-")
+}
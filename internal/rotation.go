@@ -0,0 +1,189 @@
+package internal
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"time"
+)
+
+// Passwords can be given a rotation policy: the number of days after which
+// they are due to be changed. A policy is set on an entry itself or on a tag,
+// for every entry with that tag; an entry's own policy wins over those of its
+// tags, and of several tags the shortest applies. The age of a password counts
+// from when its secret last changed, which editing the notes or other values
+// of an entry does not reset. Tag policies name tags, which are metadata, so
+// they are sealed under the vault key.
+const (
+	settingRotationPolicies = "rotation_policies"
+
+	// DueSoonDays is how many days ahead passwords are shown as due soon
+	DueSoonDays = 14
+)
+
+// Rotation is when the password of an entry is due to be changed
+type Rotation struct {
+	Days      int    // days between changes
+	Tag       string // tag the policy is set on, or "" for the entry's own
+	ChangedAt time.Time
+	DueAt     time.Time
+}
+
+// DaysLeft returns the number of whole days left at now until the password
+// is due, or a negative number once it is overdue
+func (r Rotation) DaysLeft(now time.Time) int {
+	return int(math.Floor(r.DueAt.Sub(now).Hours() / 24))
+}
+
+// RotationOf returns the rotation of a decrypted entry under the given tag
+// policies, or false if no policy applies to it
+func RotationOf(entry PasswordEntry, policies map[string]int) (Rotation, bool) {
+	rotation := Rotation{Days: entry.RotationDays}
+	if rotation.Days == 0 {
+		for _, tag := range entry.Tags {
+			if days := policies[tag]; days > 0 && (rotation.Days == 0 || days < rotation.Days) {
+				rotation.Days, rotation.Tag = days, tag
+			}
+		}
+	}
+	if rotation.Days == 0 {
+		return rotation, false
+	}
+
+	rotation.ChangedAt = passwordChangedAt(entry)
+	rotation.DueAt = rotation.ChangedAt.AddDate(0, 0, rotation.Days)
+	return rotation, true
+}
+
+// passwordChangedAt returns when the secret of an entry last changed. Entries
+// stored before this was recorded fall back to their last update.
+func passwordChangedAt(entry PasswordEntry) time.Time {
+	for _, value := range []string{entry.PasswordChangedAt, entry.UpdatedAt, entry.CreatedAt} {
+		if changed, err := ParseTimestamp(value); err == nil {
+			return changed
+		}
+	}
+	return time.Time{}
+}
+
+// ParseTimestamp reads a time as stored in the vault, either RFC 3339 or
+// SQLite's YYYY-MM-DD HH:MM:SS in UTC
+func ParseTimestamp(value string) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	return time.ParseInLocation(time.DateTime, value, time.UTC)
+}
+
+// timestamp formats a time the way the vault stores it
+func timestamp(t time.Time) string {
+	return t.UTC().Format(time.RFC3339)
+}
+
+// passwordChanged reports whether updated holds a different secret than
+// current
+func passwordChanged(current *PasswordEntry, updated PasswordEntry, vaultKey *VaultKey) (bool, error) {
+	oldPassword, err := DecryptPassword(current.EncryptedPassword, vaultKey, current.UUID)
+	if err != nil {
+		return false, err
+	}
+	defer oldPassword.Destroy()
+
+	newPassword, err := DecryptPassword(updated.EncryptedPassword, vaultKey, updated.UUID)
+	if err != nil {
+		return false, err
+	}
+	defer newPassword.Destroy()
+
+	return !oldPassword.Equal(newPassword), nil
+}
+
+func rotationPoliciesAAD() []byte {
+	return []byte("passvault:setting:" + settingRotationPolicies)
+}
+
+// RotationPolicies returns the rotation policies set on tags, in days by tag
+func (v *Vault) RotationPolicies(vaultKey *VaultKey) (map[string]int, error) {
+	sealed, err := v.store.GetSetting(settingRotationPolicies)
+	if err != nil {
+		return nil, err
+	}
+	return openRotationPolicies(sealed, vaultKey)
+}
+
+func openRotationPolicies(sealed string, vaultKey *VaultKey) (map[string]int, error) {
+	policies := make(map[string]int)
+	if sealed == "" {
+		return policies, nil
+	}
+
+	plaintext, err := decryptValue(sealed, vaultKey, rotationPoliciesAAD())
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt rotation policies: %w", err)
+	}
+
+	if err := json.Unmarshal(plaintext, &policies); err != nil {
+		return nil, fmt.Errorf("failed to decode rotation policies: %w", err)
+	}
+	return policies, nil
+}
+
+// sealRotationPolicies encrypts tag policies, or returns "" if there are none
+func sealRotationPolicies(policies map[string]int, vaultKey *VaultKey) (string, error) {
+	if len(policies) == 0 {
+		return "", nil
+	}
+
+	plaintext, err := json.Marshal(policies)
+	if err != nil {
+		return "", fmt.Errorf("failed to encode rotation policies: %w", err)
+	}
+
+	sealed, err := encryptValue(plaintext, vaultKey, rotationPoliciesAAD())
+	if err != nil {
+		return "", fmt.Errorf("failed to encrypt rotation policies: %w", err)
+	}
+	return sealed, nil
+}
+
+// SetRotationPolicy makes the passwords of entries with a tag due to be
+// changed every days days; 0 removes the tag's policy
+func (v *Vault) SetRotationPolicy(tag string, days int, vaultKey *VaultKey) error {
+	if days < 0 {
+		return fmt.Errorf("rotation period cannot be negative")
+	}
+
+	tags, err := NormalizeTags([]string{tag})
+	if err != nil {
+		return err
+	}
+	if len(tags) != 1 {
+		return fmt.Errorf("tag cannot be empty")
+	}
+
+	policies, err := v.RotationPolicies(vaultKey)
+	if err != nil {
+		return err
+	}
+
+	if days == 0 {
+		delete(policies, tags[0])
+	} else {
+		policies[tags[0]] = days
+	}
+
+	sealed, err := sealRotationPolicies(policies, vaultKey)
+	if err != nil {
+		return err
+	}
+	return v.store.SetSetting(settingRotationPolicies, sealed)
+}
+
+// rekeyRotationPolicies re-encrypts the tag policies under newKey
+func (v *Vault) rekeyRotationPolicies(vaultKey, newKey *VaultKey) (string, error) {
+	policies, err := v.RotationPolicies(vaultKey)
+	if err != nil {
+		return "", err
+	}
+	return sealRotationPolicies(policies, newKey)
+}
@@ -0,0 +1,78 @@
+package internal
+
+import (
+	"crypto/subtle"
+	"runtime"
+)
+
+// SecretBuffer holds sensitive bytes such as the master password, the vault
+// key or a decrypted password. Its memory is locked against being swapped to
+// disk where the platform allows, and is wiped when the buffer is destroyed.
+// A destroyed buffer is empty; destroying it again is a no-op.
+type SecretBuffer struct {
+	data   []byte
+	locked bool
+}
+
+// NewSecretBuffer returns a zeroed buffer of the given size
+func NewSecretBuffer(size int) *SecretBuffer {
+	data := make([]byte, size)
+	return &SecretBuffer{data: data, locked: lockMemory(data)}
+}
+
+// NewSecretBufferFrom moves b into a new buffer and wipes b
+func NewSecretBufferFrom(b []byte) *SecretBuffer {
+	s := NewSecretBuffer(len(b))
+	copy(s.data, b)
+	Wipe(b)
+	return s
+}
+
+// Bytes returns the contents of the buffer. The slice is only valid until
+// the buffer is destroyed and must not be retained or converted to a string
+// that outlives it.
+func (s *SecretBuffer) Bytes() []byte {
+	if s == nil {
+		return nil
+	}
+	return s.data
+}
+
+// Len returns the number of bytes in the buffer
+func (s *SecretBuffer) Len() int {
+	if s == nil {
+		return 0
+	}
+	return len(s.data)
+}
+
+// Equal reports in constant time whether two buffers hold the same bytes
+func (s *SecretBuffer) Equal(other *SecretBuffer) bool {
+	return subtle.ConstantTimeCompare(s.Bytes(), other.Bytes()) == 1
+}
+
+// Clone returns a copy of the buffer in new locked memory
+func (s *SecretBuffer) Clone() *SecretBuffer {
+	c := NewSecretBuffer(s.Len())
+	copy(c.data, s.Bytes())
+	return c
+}
+
+// Destroy wipes the buffer and releases its memory lock
+func (s *SecretBuffer) Destroy() {
+	if s == nil || s.data == nil {
+		return
+	}
+	Wipe(s.data)
+	if s.locked {
+		unlockMemory(s.data)
+	}
+	s.data = nil
+	s.locked = false
+}
+
+// Wipe overwrites b with zeros
+func Wipe(b []byte) {
+	clear(b)
+	runtime.KeepAlive(b)
+}
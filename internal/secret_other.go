@@ -0,0 +1,10 @@
+//go:build !unix
+
+package internal
+
+// Memory locking is not supported on this platform; buffers are only wiped
+func lockMemory(b []byte) bool {
+	return false
+}
+
+func unlockMemory(b []byte) {}
@@ -0,0 +1,18 @@
+//go:build unix
+
+package internal
+
+import "golang.org/x/sys/unix"
+
+// lockMemory keeps b out of swap. Failure (for example when RLIMIT_MEMLOCK is
+// exhausted) is not fatal: the buffer is still wiped on release.
+func lockMemory(b []byte) bool {
+	if len(b) == 0 {
+		return false
+	}
+	return unix.Mlock(b) == nil
+}
+
+func unlockMemory(b []byte) {
+	unix.Munlock(b)
+}
@@ -0,0 +1,104 @@
+package internal
+
+import (
+	"crypto/rand"
+	"errors"
+	"fmt"
+)
+
+// Shamir's secret sharing over GF(2^8) with the AES polynomial. Each byte of
+// the secret is the constant term of its own random polynomial of degree
+// threshold-1; share i holds the polynomials evaluated at x = i.
+
+func gfMul(a, b byte) byte {
+	var product byte
+	for b > 0 {
+		if b&1 != 0 {
+			product ^= a
+		}
+		carry := a & 0x80
+		a <<= 1
+		if carry != 0 {
+			a ^= 0x1b
+		}
+		b >>= 1
+	}
+	return product
+}
+
+// gfInv returns the multiplicative inverse of a non-zero element, a^254
+func gfInv(a byte) byte {
+	result := byte(1)
+	for i := 0; i < 254; i++ {
+		result = gfMul(result, a)
+	}
+	return result
+}
+
+// splitSecret splits secret into shares of which any threshold reconstruct
+// it. The share for x = i+1 is returned at index i.
+func splitSecret(secret []byte, shares, threshold int) ([][]byte, error) {
+	if threshold < 2 || threshold > shares || shares > 255 {
+		return nil, errors.New("threshold must be at least 2 and no more than the number of shares, which is at most 255")
+	}
+
+	coefficients := make([]byte, (threshold-1)*len(secret))
+	if _, err := rand.Read(coefficients); err != nil {
+		return nil, fmt.Errorf("failed to generate share polynomials: %w", err)
+	}
+
+	result := make([][]byte, shares)
+	for i := range result {
+		x := byte(i + 1)
+		share := make([]byte, len(secret))
+		for j, s := range secret {
+			// Horner's method, highest coefficient first
+			var y byte
+			for k := threshold - 2; k >= 0; k-- {
+				y = gfMul(y, x) ^ coefficients[k*len(secret)+j]
+			}
+			share[j] = gfMul(y, x) ^ s
+		}
+		result[i] = share
+	}
+
+	return result, nil
+}
+
+// combineShares reconstructs a secret from shares keyed by their x coordinate
+// using Lagrange interpolation at x = 0
+func combineShares(shares map[byte][]byte) ([]byte, error) {
+	if len(shares) < 2 {
+		return nil, errors.New("at least two shares are required")
+	}
+
+	length := -1
+	for x, share := range shares {
+		if x == 0 {
+			return nil, errors.New("invalid share index 0")
+		}
+		if length == -1 {
+			length = len(share)
+		} else if len(share) != length {
+			return nil, errors.New("shares have different lengths")
+		}
+	}
+
+	secret := make([]byte, length)
+	for xi, share := range shares {
+		// Lagrange basis polynomial for xi evaluated at 0
+		basis := byte(1)
+		for xj := range shares {
+			if xj == xi {
+				continue
+			}
+			basis = gfMul(basis, gfMul(xj, gfInv(xi^xj)))
+		}
+
+		for i, y := range share {
+			secret[i] ^= gfMul(y, basis)
+		}
+	}
+
+	return secret, nil
+}
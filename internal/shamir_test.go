@@ -0,0 +1,125 @@
+package internal
+
+import (
+	"bytes"
+	"crypto/rand"
+	"fmt"
+	"math/bits"
+	"testing"
+)
+
+func TestGFArithmetic(t *testing.T) {
+	// FIPS-197 section 4.2
+	if got := gfMul(0x57, 0x83); got != 0xc1 {
+		t.Errorf("gfMul(0x57, 0x83) = %#x, want 0xc1", got)
+	}
+	if got := gfMul(0x57, 0x13); got != 0xfe {
+		t.Errorf("gfMul(0x57, 0x13) = %#x, want 0xfe", got)
+	}
+
+	for a := 1; a < 256; a++ {
+		if got := gfMul(byte(a), gfInv(byte(a))); got != 1 {
+			t.Errorf("%#x * gfInv(%#x) = %#x, want 1", a, a, got)
+		}
+		if got := gfMul(byte(a), 1); got != byte(a) {
+			t.Errorf("%#x * 1 = %#x", a, got)
+		}
+		if got := gfMul(byte(a), 0); got != 0 {
+			t.Errorf("%#x * 0 = %#x", a, got)
+		}
+	}
+}
+
+// subsets returns the shares picked by every non-empty subset of n shares,
+// keyed by the subset's bit mask
+func subsets(shares [][]byte) map[int]map[byte][]byte {
+	result := make(map[int]map[byte][]byte)
+	for mask := 1; mask < 1<<len(shares); mask++ {
+		picked := make(map[byte][]byte)
+		for i, share := range shares {
+			if mask&(1<<i) != 0 {
+				picked[byte(i+1)] = share
+			}
+		}
+		result[mask] = picked
+	}
+	return result
+}
+
+func TestSplitCombine(t *testing.T) {
+	tests := []struct{ shares, threshold int }{
+		{2, 2}, {3, 2}, {3, 3}, {5, 3}, {6, 4}, {7, 7}, {8, 5},
+	}
+
+	for _, tt := range tests {
+		t.Run(fmt.Sprintf("%d of %d", tt.threshold, tt.shares), func(t *testing.T) {
+			secret := make([]byte, vaultKeyLen)
+			if _, err := rand.Read(secret); err != nil {
+				t.Fatal(err)
+			}
+
+			shares, err := splitSecret(secret, tt.shares, tt.threshold)
+			if err != nil {
+				t.Fatalf("splitSecret: %v", err)
+			}
+			if len(shares) != tt.shares {
+				t.Fatalf("got %d shares, want %d", len(shares), tt.shares)
+			}
+
+			for mask, picked := range subsets(shares) {
+				size := bits.OnesCount(uint(mask))
+				combined, err := combineShares(picked)
+
+				switch {
+				case size >= tt.threshold:
+					if err != nil {
+						t.Errorf("combineShares(%b): %v", mask, err)
+					} else if !bytes.Equal(combined, secret) {
+						t.Errorf("combineShares(%b) did not reconstruct the secret", mask)
+					}
+				case size >= 2:
+					// Fewer than threshold shares interpolate a different polynomial
+					if err == nil && bytes.Equal(combined, secret) {
+						t.Errorf("combineShares(%b) reconstructed the secret from %d of %d shares", mask, size, tt.threshold)
+					}
+				default:
+					if err == nil {
+						t.Errorf("combineShares(%b) accepted a single share", mask)
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestSplitSecretInvalid(t *testing.T) {
+	tests := []struct{ shares, threshold int }{
+		{1, 1}, {3, 1}, {2, 3}, {256, 2}, {0, 0},
+	}
+
+	for _, tt := range tests {
+		if _, err := splitSecret([]byte("secret"), tt.shares, tt.threshold); err == nil {
+			t.Errorf("splitSecret(%d shares, threshold %d) succeeded", tt.shares, tt.threshold)
+		}
+	}
+}
+
+func TestCombineSharesInvalid(t *testing.T) {
+	tests := []struct {
+		name   string
+		shares map[byte][]byte
+	}{
+		{"none", map[byte][]byte{}},
+		{"one", map[byte][]byte{1: {1, 2}}},
+		{"index 0", map[byte][]byte{0: {1, 2}, 1: {3, 4}}},
+		{"different lengths", map[byte][]byte{1: {1, 2}, 2: {3}}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := combineShares(tt.shares); err == nil {
+				t.Error("combineShares succeeded")
+			}
+		})
+	}
+}
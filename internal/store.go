@@ -0,0 +1,174 @@
+package internal
+
+import "time"
+
+// Store persists a vault: its master credentials, its entries, settings and
+// recovery codes. A store never sees plaintext passwords or the vault key; the
+// Vault built on top of it does all encryption. Entries passed to and returned
+// by a store are in their stored form, so when metadata encryption is on their
+// service, username and alias hold blind indexes and their metadata is sealed
+// in EncryptedMetadata.
+//
+// Methods that change several things do so atomically: either all changes are
+// stored or none are.
+type Store interface {
+	// Location identifies the vault, such as the path of its database file
+	Location() string
+	Close() error
+
+	IsMasterPasswordSet() (bool, error)
+	GetMasterPasswordHash() (string, error)
+	IsVaultKeySet() (bool, error)
+	GetWrappedVaultKey() (string, error)
+
+	// InitializeVault stores the master password hash and wrapped vault key
+	// of a vault that has no master password yet
+	InitializeVault(hashedPassword, wrappedKey string) error
+
+	// UpdateMasterCredentials replaces the master password hash and the
+	// wrapped vault key together and saves the given settings with them; an
+	// empty value removes a setting
+	UpdateMasterCredentials(hashedPassword, wrappedKey string, settings map[string]string) error
+
+	// MigrateToVaultKey stores the first wrapped vault key of a legacy vault
+	// together with its entries re-encrypted under it
+	MigrateToVaultKey(wrappedKey string, entries []PasswordEntry) error
+
+	// Rekey stores new master credentials, every entry, revision and
+	// attachment re-encrypted under a new vault key and the given settings,
+	// and deletes the recovery codes, which wrap the old key. Each stored
+	// entry, revision and attachment is read back and passed to check before
+	// committing; any error leaves the vault as it was.
+	Rekey(hashedPassword, wrappedKey string, entries []PasswordEntry, revisions []EntryRevision, attachments []Attachment, settings map[string]string, check RekeyCheck) error
+
+	// Reset deletes everything in the vault
+	Reset() error
+
+	AddEntry(entry PasswordEntry) error
+
+	// GetEntry returns the entry with the given UUID, even if it is in the
+	// trash, or nil if there is none
+	GetEntry(entryUUID string) (*PasswordEntry, error)
+
+	// GetEntryByAlias returns the entry with the stored alias, or nil if there is none
+	GetEntryByAlias(alias string) (*PasswordEntry, error)
+
+	// ListEntries returns the entries that are not in the trash
+	ListEntries() ([]PasswordEntry, error)
+
+	// SearchEntries returns the entries not in the trash whose stored
+	// service, username, notes or alias contain query, ignoring case
+	SearchEntries(query string) ([]PasswordEntry, error)
+
+	// UpdateEntry stores new values for an entry. A non-nil revision, the
+	// version being replaced, is added to the entry's history, of which only
+	// the newest keep revisions are retained.
+	UpdateEntry(entry PasswordEntry, revision *EntryRevision, keep int) error
+
+	// TrashEntry moves an entry to the trash
+	TrashEntry(id int) error
+
+	// ListTrash returns the entries in the trash, most recently deleted first
+	ListTrash() ([]PasswordEntry, error)
+
+	// RestoreEntry takes an entry out of the trash
+	RestoreEntry(id int) error
+
+	// PurgeTrash permanently deletes the entries that have been in the trash
+	// for at least olderThan, together with their history and attachments,
+	// and returns how many there were
+	PurgeTrash(olderThan time.Duration) (int, error)
+
+	// ListRevisions returns the history of an entry, newest first
+	ListRevisions(entryUUID string) ([]EntryRevision, error)
+
+	// ListAllRevisions returns the history of every entry
+	ListAllRevisions() ([]EntryRevision, error)
+
+	// PruneRevisions drops all but the newest keep revisions of every entry
+	PruneRevisions(keep int) error
+
+	// AddAttachment stores an attachment together with its chunks
+	AddAttachment(attachment Attachment) error
+
+	// ListAttachments returns the attachments of an entry without their
+	// chunks, in the order they were added
+	ListAttachments(entryUUID string) ([]Attachment, error)
+
+	// ListAllAttachments returns the attachments of every entry without
+	// their chunks
+	ListAllAttachments() ([]Attachment, error)
+
+	// GetAttachment returns an attachment with its chunks, or nil if there
+	// is none
+	GetAttachment(id int) (*Attachment, error)
+
+	// DeleteAttachment permanently deletes an attachment
+	DeleteAttachment(id int) error
+
+	// RewriteEntries replaces the stored form of existing entries without
+	// touching their update time, and saves the given settings with them
+	RewriteEntries(entries []PasswordEntry, settings map[string]string) error
+
+	// GetSetting returns the value of a setting, or "" if it is not set
+	GetSetting(key string) (string, error)
+	SetSetting(key, value string) error
+
+	// ReplaceRecoveryCodes replaces all recovery codes with the given ones,
+	// mapping code hash to the vault key wrapped by the code
+	ReplaceRecoveryCodes(codes map[string]string) error
+	CountRecoveryCodes() (int, error)
+
+	// GetRecoveryCode returns the id and wrapped vault key of the recovery
+	// code with the given hash, or ErrInvalidRecoveryCode
+	GetRecoveryCode(codeHash string) (int, string, error)
+
+	// ConsumeRecoveryCode stores new master credentials and settings and
+	// deletes the used recovery code
+	ConsumeRecoveryCode(id int, hashedPassword, wrappedKey string, settings map[string]string) error
+}
+
+// RekeyCheck verifies what Rekey stored, read back before it is committed
+type RekeyCheck struct {
+	Entry      func(PasswordEntry) error
+	Revision   func(EntryRevision) error
+	Attachment func(Attachment) error
+}
+
+type PasswordEntry struct {
+	ID                int
+	UUID              string
+	Type              EntryType // what the entry holds; see SchemaOf
+	Service           string
+	Username          string
+	EncryptedPassword string
+	EncryptedTOTP     string // the sealed TOTP seed, if the entry has one
+	Notes             string
+	Alias             string
+	Folder            string
+	Tags              []string
+	Fields            []CustomField
+	URLs              []string // normalized; see NormalizeURL
+	URLMatch          URLMatch // how URLs are matched; empty means MatchDomain
+	RotationDays      int      // days after which the password is due to change; 0 means none of its own
+	CreatedAt         string
+	UpdatedAt         string
+	PasswordChangedAt string // when the secret last changed, unlike UpdatedAt
+	DeletedAt         string // when the entry was moved to the trash, if it is there
+
+	// EncryptedMetadata holds the sealed type, service, username, notes,
+	// alias, folder, tags, custom fields and URLs of a stored entry when
+	// metadata encryption is on
+	EncryptedMetadata string
+}
+
+// EntryRevision is a previous version of an entry in its stored form. Its
+// password is the ciphertext the entry had, and its other fields are sealed
+// together in EncryptedData.
+type EntryRevision struct {
+	ID                int
+	EntryUUID         string
+	EncryptedPassword string
+	EncryptedData     string
+	CreatedAt         string
+}
@@ -0,0 +1,133 @@
+package internal
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"unicode"
+)
+
+// Entries can carry any number of tags and sit in one folder, a path such as
+// /infra/aws. Tags are lower case and written with a leading # for filtering;
+// folders contain the entries of their subfolders.
+
+// EntryFilter selects entries by text, tags, folder and type; its empty value
+// matches every entry
+type EntryFilter struct {
+	Query  string    // text in the service, username, notes or alias
+	Tags   []string  // tags the entry must all have
+	Folder string    // folder the entry must be in, directly or in a subfolder
+	Type   EntryType // type the entry must have
+}
+
+// ParseEntryFilter reads a filter from search text, where words starting with
+// # are tags, words starting with / are a folder and the rest is text to match
+func ParseEntryFilter(text string) EntryFilter {
+	var filter EntryFilter
+	var words []string
+
+	for _, word := range strings.Fields(text) {
+		switch {
+		case strings.HasPrefix(word, "#") && len(word) > 1:
+			filter.Tags = append(filter.Tags, strings.ToLower(word[1:]))
+		case strings.HasPrefix(word, "/"):
+			filter.Folder, _ = NormalizeFolder(word)
+		default:
+			words = append(words, word)
+		}
+	}
+
+	filter.Query = strings.Join(words, " ")
+	return filter
+}
+
+// IsEmpty reports whether the filter matches every entry
+func (f EntryFilter) IsEmpty() bool {
+	return f.Query == "" && len(f.Tags) == 0 && f.Folder == "" && f.Type == ""
+}
+
+// Matches reports whether a decrypted entry passes the filter
+func (f EntryFilter) Matches(entry PasswordEntry) bool {
+	if f.Query != "" && !matchesQuery(entry, f.Query) {
+		return false
+	}
+	if f.Type != "" && entry.Type != f.Type {
+		return false
+	}
+
+	for _, tag := range f.Tags {
+		if !slices.Contains(entry.Tags, tag) {
+			return false
+		}
+	}
+
+	return InFolder(entry.Folder, f.Folder)
+}
+
+// FilterPasswords returns the entries not in the trash that pass filter
+func (v *Vault) FilterPasswords(filter EntryFilter, vaultKey *VaultKey) ([]PasswordEntry, error) {
+	entries, err := v.ListAllPasswords(vaultKey)
+	if err != nil {
+		return nil, err
+	}
+
+	if filter.IsEmpty() {
+		return entries, nil
+	}
+
+	var matches []PasswordEntry
+	for _, entry := range entries {
+		if filter.Matches(entry) {
+			matches = append(matches, entry)
+		}
+	}
+	return matches, nil
+}
+
+// NormalizeTags lower-cases tags, drops a leading # and duplicates, and sorts
+// them. Tags may not contain spaces or commas.
+func NormalizeTags(tags []string) ([]string, error) {
+	var normalized []string
+
+	for _, tag := range tags {
+		tag = strings.ToLower(strings.TrimPrefix(strings.TrimSpace(tag), "#"))
+		if tag == "" {
+			continue
+		}
+		if strings.ContainsFunc(tag, func(r rune) bool { return unicode.IsSpace(r) || r == ',' }) {
+			return nil, fmt.Errorf("invalid tag %q: tags cannot contain spaces or commas", tag)
+		}
+		if !slices.Contains(normalized, tag) {
+			normalized = append(normalized, tag)
+		}
+	}
+
+	slices.Sort(normalized)
+	return normalized, nil
+}
+
+// NormalizeFolder returns a folder path in the form /a/b, or "" for none
+func NormalizeFolder(folder string) (string, error) {
+	var parts []string
+	for _, part := range strings.Split(folder, "/") {
+		part = strings.TrimSpace(part)
+		switch part {
+		case "":
+			continue
+		case ".", "..":
+			return "", fmt.Errorf("invalid folder %q", folder)
+		}
+		parts = append(parts, part)
+	}
+
+	if len(parts) == 0 {
+		return "", nil
+	}
+	return "/" + strings.Join(parts, "/"), nil
+}
+
+// InFolder reports whether an entry in entryFolder is in folder or below it;
+// every entry is in the empty folder
+func InFolder(entryFolder, folder string) bool {
+	return folder == "" || entryFolder == folder || strings.HasPrefix(entryFolder, folder+"/")
+}
@@ -0,0 +1,351 @@
+package internal
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base32"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// An entry can hold the seed of a time-based one-time password (RFC 6238),
+// given either as a base32 secret or as an otpauth:// URI. It is stored as a
+// canonical otpauth URI, encrypted like the password and bound to the entry.
+
+// TOTP generates the one-time codes of an entry. Its secret is kept in a
+// SecretBuffer; call Destroy when done with it.
+type TOTP struct {
+	secret    *SecretBuffer
+	Algorithm string // SHA1, SHA256 or SHA512
+	Digits    int
+	Period    int // seconds each code is valid for
+	Issuer    string
+	Account   string
+}
+
+var totpEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// ParseTOTP reads a TOTP seed from a base32 secret or an otpauth://totp/ URI
+func ParseTOTP(input string) (*TOTP, error) {
+	data := []byte(input)
+	defer Wipe(data)
+	return parseTOTP(data)
+}
+
+// parseTOTP reads a TOTP seed without copying its secret into a string
+func parseTOTP(input []byte) (*TOTP, error) {
+	input = bytes.TrimSpace(input)
+	if len(input) == 0 {
+		return nil, errors.New("TOTP secret cannot be empty")
+	}
+
+	totp := &TOTP{Algorithm: "SHA1", Digits: 6, Period: 30}
+	secret := input
+
+	if len(input) >= len(otpauthPrefix) && strings.EqualFold(string(input[:len(otpauthPrefix)]), otpauthPrefix) {
+		metadata, uriSecret, err := cutTOTPSecret(input)
+		if err != nil {
+			return nil, err
+		}
+		defer Wipe(uriSecret)
+		secret = uriSecret
+
+		uri, err := url.Parse(metadata)
+		if err != nil {
+			return nil, fmt.Errorf("invalid otpauth URI: %w", err)
+		}
+		if !strings.EqualFold(uri.Host, "totp") {
+			return nil, fmt.Errorf("unsupported OTP type %q: only totp is supported", uri.Host)
+		}
+
+		label := strings.TrimPrefix(uri.Path, "/")
+		if issuer, account, ok := strings.Cut(label, ":"); ok {
+			totp.Issuer = strings.TrimSpace(issuer)
+			totp.Account = strings.TrimSpace(account)
+		} else {
+			totp.Account = strings.TrimSpace(label)
+		}
+
+		query := uri.Query()
+		if issuer := query.Get("issuer"); issuer != "" {
+			totp.Issuer = issuer
+		}
+		if algorithm := query.Get("algorithm"); algorithm != "" {
+			totp.Algorithm = strings.ToUpper(algorithm)
+		}
+		if digits := query.Get("digits"); digits != "" {
+			if totp.Digits, err = strconv.Atoi(digits); err != nil {
+				return nil, fmt.Errorf("invalid TOTP digits %q", digits)
+			}
+		}
+		if period := query.Get("period"); period != "" {
+			if totp.Period, err = strconv.Atoi(period); err != nil {
+				return nil, fmt.Errorf("invalid TOTP period %q", period)
+			}
+		}
+	}
+
+	if err := totp.validate(); err != nil {
+		return nil, err
+	}
+
+	key, err := decodeTOTPSecret(secret)
+	if err != nil {
+		return nil, err
+	}
+	totp.secret = key
+
+	return totp, nil
+}
+
+const otpauthPrefix = "otpauth://"
+
+// cutTOTPSecret splits an otpauth URI into the URI without its secret
+// parameter, which holds nothing secret, and the unescaped secret in a new
+// slice the caller wipes
+func cutTOTPSecret(uri []byte) (string, []byte, error) {
+	queryStart := bytes.IndexByte(uri, '?')
+	if queryStart < 0 {
+		return "", nil, errors.New("invalid otpauth URI: no secret")
+	}
+	queryEnd := len(uri)
+	if i := bytes.IndexByte(uri[queryStart:], '#'); i >= 0 {
+		queryEnd = queryStart + i
+	}
+
+	var metadata []string
+	var secret []byte
+	found := false
+	for param := range bytes.SplitSeq(uri[queryStart+1:queryEnd], []byte("&")) {
+		key, value, _ := bytes.Cut(param, []byte("="))
+		if string(key) != "secret" {
+			if len(param) > 0 {
+				metadata = append(metadata, string(param))
+			}
+			continue
+		}
+		if found {
+			continue
+		}
+		found = true
+
+		var err error
+		if secret, err = unescapeQueryValue(value); err != nil {
+			return "", nil, err
+		}
+	}
+	if !found {
+		return "", nil, errors.New("invalid otpauth URI: no secret")
+	}
+
+	return string(uri[:queryStart]) + "?" + strings.Join(metadata, "&"), secret, nil
+}
+
+// unescapeQueryValue decodes the percent escapes and plus signs of a query
+// value into a new slice
+func unescapeQueryValue(value []byte) ([]byte, error) {
+	unescaped := make([]byte, 0, len(value))
+	for i := 0; i < len(value); i++ {
+		switch c := value[i]; {
+		case c == '+':
+			unescaped = append(unescaped, ' ')
+		case c == '%':
+			if i+2 >= len(value) {
+				Wipe(unescaped)
+				return nil, errors.New("invalid otpauth URI: bad escape in secret")
+			}
+			var decoded [1]byte
+			if _, err := hex.Decode(decoded[:], value[i+1:i+3]); err != nil {
+				Wipe(unescaped)
+				return nil, errors.New("invalid otpauth URI: bad escape in secret")
+			}
+			unescaped = append(unescaped, decoded[0])
+			i += 2
+		default:
+			unescaped = append(unescaped, c)
+		}
+	}
+	return unescaped, nil
+}
+
+// decodeTOTPSecret decodes a base32 secret, ignoring spaces, case and padding
+func decodeTOTPSecret(secret []byte) (*SecretBuffer, error) {
+	normalized := make([]byte, 0, len(secret))
+	defer func() { Wipe(normalized) }()
+	for _, c := range secret {
+		switch {
+		case c == ' ':
+		case 'a' <= c && c <= 'z':
+			normalized = append(normalized, c-'a'+'A')
+		default:
+			normalized = append(normalized, c)
+		}
+	}
+	normalized = bytes.TrimRight(normalized, "=")
+
+	key := NewSecretBuffer(totpEncoding.DecodedLen(len(normalized)))
+	n, err := totpEncoding.Decode(key.Bytes(), normalized)
+	if err != nil || n == 0 {
+		key.Destroy()
+		return nil, errors.New("invalid TOTP secret: expected base32")
+	}
+	if n < key.Len() {
+		trimmed := NewSecretBufferFrom(key.Bytes()[:n])
+		key.Destroy()
+		key = trimmed
+	}
+
+	return key, nil
+}
+
+func (t *TOTP) validate() error {
+	if _, err := totpHash(t.Algorithm); err != nil {
+		return err
+	}
+	if t.Digits < 6 || t.Digits > 8 {
+		return fmt.Errorf("invalid TOTP digits %d: must be between 6 and 8", t.Digits)
+	}
+	if t.Period <= 0 {
+		return fmt.Errorf("invalid TOTP period %d", t.Period)
+	}
+	return nil
+}
+
+func totpHash(algorithm string) (func() hash.Hash, error) {
+	switch algorithm {
+	case "SHA1":
+		return sha1.New, nil
+	case "SHA256":
+		return sha256.New, nil
+	case "SHA512":
+		return sha512.New, nil
+	}
+	return nil, fmt.Errorf("unsupported TOTP algorithm %q", algorithm)
+}
+
+// URI returns the seed as an otpauth URI, in a new slice the caller wipes
+func (t *TOTP) URI() []byte {
+	query := url.Values{}
+	if t.Issuer != "" {
+		query.Set("issuer", t.Issuer)
+	}
+	query.Set("algorithm", t.Algorithm)
+	query.Set("digits", strconv.Itoa(t.Digits))
+	query.Set("period", strconv.Itoa(t.Period))
+
+	label := t.Account
+	if t.Issuer != "" {
+		label = t.Issuer + ":" + t.Account
+	}
+
+	// The secret sorts last, so it is appended without going through a string
+	uri := url.URL{Scheme: "otpauth", Host: "totp", Path: "/" + label, RawQuery: query.Encode()}
+	prefix := uri.String() + "&secret="
+
+	encoded := make([]byte, len(prefix)+totpEncoding.EncodedLen(t.secret.Len()))
+	n := copy(encoded, prefix)
+	totpEncoding.Encode(encoded[n:], t.secret.Bytes())
+	return encoded
+}
+
+// Code returns the one-time code valid at the given time
+func (t *TOTP) Code(at time.Time) string {
+	newHash, _ := totpHash(t.Algorithm)
+
+	var counter [8]byte
+	binary.BigEndian.PutUint64(counter[:], uint64(at.Unix())/uint64(t.Period))
+
+	mac := hmac.New(newHash, t.secret.Bytes())
+	mac.Write(counter[:])
+	sum := mac.Sum(nil)
+
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+
+	modulus := uint32(1)
+	for range t.Digits {
+		modulus *= 10
+	}
+	return fmt.Sprintf("%0*d", t.Digits, value%modulus)
+}
+
+// Remaining returns how long the code valid at the given time stays valid
+func (t *TOTP) Remaining(at time.Time) time.Duration {
+	period := int64(t.Period)
+	return time.Duration(period-at.Unix()%period) * time.Second
+}
+
+// Destroy wipes the secret
+func (t *TOTP) Destroy() {
+	if t != nil {
+		t.secret.Destroy()
+	}
+}
+
+// EncryptTOTP parses a TOTP seed and encrypts it, bound to the given entry
+func EncryptTOTP(input string, vaultKey *VaultKey, entryUUID string) (string, error) {
+	data := []byte(input)
+	defer Wipe(data)
+	return EncryptTOTPBytes(data, vaultKey, entryUUID)
+}
+
+// EncryptTOTPBytes is EncryptTOTP for a seed read into a byte slice, such as
+// the bytes of a SecretBuffer, so it is never copied into a string. The
+// caller still wipes input.
+func EncryptTOTPBytes(input []byte, vaultKey *VaultKey, entryUUID string) (string, error) {
+	if entryUUID == "" {
+		return "", errors.New("entry UUID cannot be empty")
+	}
+
+	totp, err := parseTOTP(input)
+	if err != nil {
+		return "", err
+	}
+	defer totp.Destroy()
+
+	uri := totp.URI()
+	defer Wipe(uri)
+
+	return encryptValue(uri, vaultKey, entryAAD(entryUUID, fieldTOTP))
+}
+
+// DecryptTOTP decrypts the TOTP seed of an entry. The caller destroys the
+// returned TOTP when done with it.
+func DecryptTOTP(encryptedTOTP string, vaultKey *VaultKey, entryUUID string) (*TOTP, error) {
+	if encryptedTOTP == "" {
+		return nil, errors.New("the entry has no TOTP secret")
+	}
+
+	plaintext, err := decryptValue(encryptedTOTP, vaultKey, entryAAD(entryUUID, fieldTOTP))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt TOTP secret: %w", err)
+	}
+	defer Wipe(plaintext)
+
+	return parseTOTP(plaintext)
+}
+
+// rekeyTOTP re-encrypts the TOTP seed of an entry under newKey
+func rekeyTOTP(encryptedTOTP string, entryUUID string, vaultKey, newKey *VaultKey) (string, error) {
+	if encryptedTOTP == "" {
+		return "", nil
+	}
+
+	plaintext, err := decryptValue(encryptedTOTP, vaultKey, entryAAD(entryUUID, fieldTOTP))
+	if err != nil {
+		return "", fmt.Errorf("failed to decrypt TOTP secret: %w", err)
+	}
+	defer Wipe(plaintext)
+
+	return encryptValue(plaintext, newKey, entryAAD(entryUUID, fieldTOTP))
+}
@@ -0,0 +1,211 @@
+package internal
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+	"time"
+)
+
+// "Hello!\xde\xad\xbe\xef" in base32
+const testTOTPSecret = "JBSWY3DPEHPK3PXP"
+
+var testTOTPKey = []byte("Hello!\xde\xad\xbe\xef")
+
+func TestParseTOTP(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		want  TOTP
+	}{
+		{"secret", testTOTPSecret, TOTP{Algorithm: "SHA1", Digits: 6, Period: 30}},
+		{"lower case with spaces", "jbsw y3dp ehpk 3pxp", TOTP{Algorithm: "SHA1", Digits: 6, Period: 30}},
+		{"padded", testTOTPSecret + "====", TOTP{Algorithm: "SHA1", Digits: 6, Period: 30}},
+		{"uri", "otpauth://totp/Example:alice@example.com?secret=" + testTOTPSecret + "&issuer=Example",
+			TOTP{Algorithm: "SHA1", Digits: 6, Period: 30, Issuer: "Example", Account: "alice@example.com"}},
+		{"uri with parameters", "otpauth://totp/alice?algorithm=sha256&digits=8&period=60&secret=" + testTOTPSecret,
+			TOTP{Algorithm: "SHA256", Digits: 8, Period: 60, Account: "alice"}},
+		{"escaped secret", "OTPAUTH://totp/alice?secret=JBSW%59%33DPEHPK3PXP&issuer=A+B",
+			TOTP{Algorithm: "SHA1", Digits: 6, Period: 30, Issuer: "A B", Account: "alice"}},
+		{"secret with fragment", "otpauth://totp/alice?secret=" + testTOTPSecret + "#ignored",
+			TOTP{Algorithm: "SHA1", Digits: 6, Period: 30, Account: "alice"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			totp, err := ParseTOTP(tt.input)
+			if err != nil {
+				t.Fatalf("ParseTOTP: %v", err)
+			}
+			defer totp.Destroy()
+
+			if !bytes.Equal(totp.secret.Bytes(), testTOTPKey) {
+				t.Errorf("secret = %x, want %x", totp.secret.Bytes(), testTOTPKey)
+			}
+			if totp.Algorithm != tt.want.Algorithm || totp.Digits != tt.want.Digits || totp.Period != tt.want.Period ||
+				totp.Issuer != tt.want.Issuer || totp.Account != tt.want.Account {
+				t.Errorf("ParseTOTP = %+v, want %+v", *totp, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseTOTPInvalid(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+	}{
+		{"empty", "  "},
+		{"not base32", "JBSWY3DP!"},
+		{"hotp", "otpauth://hotp/alice?secret=" + testTOTPSecret},
+		{"no query", "otpauth://totp/alice"},
+		{"no secret", "otpauth://totp/alice?issuer=Example"},
+		{"empty secret", "otpauth://totp/alice?secret="},
+		{"bad escape", "otpauth://totp/alice?secret=JBSW%5"},
+		{"bad hex escape", "otpauth://totp/alice?secret=JBSW%ZZ"},
+		{"unknown algorithm", "otpauth://totp/alice?algorithm=MD5&secret=" + testTOTPSecret},
+		{"bad digits", "otpauth://totp/alice?digits=six&secret=" + testTOTPSecret},
+		{"too few digits", "otpauth://totp/alice?digits=4&secret=" + testTOTPSecret},
+		{"period 0", "otpauth://totp/alice?period=0&secret=" + testTOTPSecret},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if totp, err := ParseTOTP(tt.input); err == nil {
+				totp.Destroy()
+				t.Errorf("ParseTOTP(%q) succeeded, want an error", tt.input)
+			}
+		})
+	}
+}
+
+func TestTOTPURIRoundTrip(t *testing.T) {
+	totp, err := ParseTOTP("otpauth://totp/Example:alice?algorithm=SHA512&digits=8&period=45&secret=" + testTOTPSecret)
+	if err != nil {
+		t.Fatalf("ParseTOTP: %v", err)
+	}
+	defer totp.Destroy()
+
+	uri := totp.URI()
+	defer Wipe(uri)
+	if !bytes.HasSuffix(uri, []byte("&secret="+testTOTPSecret)) {
+		t.Errorf("URI = %q, want it to end with the secret", uri)
+	}
+
+	parsed, err := parseTOTP(uri)
+	if err != nil {
+		t.Fatalf("parseTOTP(%q): %v", uri, err)
+	}
+	defer parsed.Destroy()
+	if !bytes.Equal(parsed.secret.Bytes(), testTOTPKey) || parsed.Algorithm != "SHA512" || parsed.Digits != 8 ||
+		parsed.Period != 45 || parsed.Issuer != "Example" || parsed.Account != "alice" {
+		t.Errorf("parseTOTP(URI()) = %+v", *parsed)
+	}
+}
+
+func TestEncryptTOTPRoundTrip(t *testing.T) {
+	vaultKey := testVaultKey(t, CipherAESGCM)
+
+	encrypted, err := EncryptTOTP(testTOTPSecret, vaultKey, "entry-1")
+	if err != nil {
+		t.Fatalf("EncryptTOTP: %v", err)
+	}
+
+	totp, err := DecryptTOTP(encrypted, vaultKey, "entry-1")
+	if err != nil {
+		t.Fatalf("DecryptTOTP: %v", err)
+	}
+	defer totp.Destroy()
+	if !bytes.Equal(totp.secret.Bytes(), testTOTPKey) {
+		t.Errorf("secret = %x, want %x", totp.secret.Bytes(), testTOTPKey)
+	}
+
+	if _, err := DecryptTOTP(encrypted, vaultKey, "entry-2"); err == nil {
+		t.Error("DecryptTOTP for another entry succeeded")
+	}
+
+	input := []byte(" " + testTOTPSecret + "\n")
+	encrypted, err = EncryptTOTPBytes(input, vaultKey, "entry-1")
+	if err != nil {
+		t.Fatalf("EncryptTOTPBytes: %v", err)
+	}
+	if string(input) != " "+testTOTPSecret+"\n" {
+		t.Errorf("EncryptTOTPBytes changed its input to %q", input)
+	}
+	totp, err = DecryptTOTP(encrypted, vaultKey, "entry-1")
+	if err != nil {
+		t.Fatalf("DecryptTOTP: %v", err)
+	}
+	defer totp.Destroy()
+	if !bytes.Equal(totp.secret.Bytes(), testTOTPKey) {
+		t.Errorf("secret = %x, want %x", totp.secret.Bytes(), testTOTPKey)
+	}
+}
+
+// The test vectors of RFC 6238, Appendix B
+func TestTOTPCodeRFC6238(t *testing.T) {
+	keys := map[string]string{
+		"SHA1":   "12345678901234567890",
+		"SHA256": "12345678901234567890123456789012",
+		"SHA512": "1234567890123456789012345678901234567890123456789012345678901234",
+	}
+
+	tests := []struct {
+		time      int64
+		algorithm string
+		code      string
+	}{
+		{59, "SHA1", "94287082"},
+		{59, "SHA256", "46119246"},
+		{59, "SHA512", "90693936"},
+		{1111111109, "SHA1", "07081804"},
+		{1111111109, "SHA256", "68084774"},
+		{1111111109, "SHA512", "25091201"},
+		{1111111111, "SHA1", "14050471"},
+		{1111111111, "SHA256", "67062674"},
+		{1111111111, "SHA512", "99943326"},
+		{1234567890, "SHA1", "89005924"},
+		{1234567890, "SHA256", "91819424"},
+		{1234567890, "SHA512", "93441116"},
+		{2000000000, "SHA1", "69279037"},
+		{2000000000, "SHA256", "90698825"},
+		{2000000000, "SHA512", "38618901"},
+		{20000000000, "SHA1", "65353130"},
+		{20000000000, "SHA256", "77737706"},
+		{20000000000, "SHA512", "47863826"},
+	}
+
+	for _, tt := range tests {
+		t.Run(fmt.Sprintf("%s at %d", tt.algorithm, tt.time), func(t *testing.T) {
+			secret := totpEncoding.EncodeToString([]byte(keys[tt.algorithm]))
+			totp, err := ParseTOTP("otpauth://totp/test?algorithm=" + tt.algorithm + "&digits=8&period=30&secret=" + secret)
+			if err != nil {
+				t.Fatalf("ParseTOTP: %v", err)
+			}
+			defer totp.Destroy()
+
+			if code := totp.Code(time.Unix(tt.time, 0)); code != tt.code {
+				t.Errorf("Code = %s, want %s", code, tt.code)
+			}
+		})
+	}
+}
+
+func TestTOTPRemaining(t *testing.T) {
+	totp := &TOTP{Period: 30}
+	tests := []struct {
+		time int64
+		want time.Duration
+	}{
+		{0, 30 * time.Second},
+		{59, time.Second},
+		{60, 30 * time.Second},
+		{1111111111, 29 * time.Second},
+	}
+
+	for _, tt := range tests {
+		if got := totp.Remaining(time.Unix(tt.time, 0)); got != tt.want {
+			t.Errorf("Remaining at %d = %v, want %v", tt.time, got, tt.want)
+		}
+	}
+}
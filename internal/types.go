@@ -0,0 +1,391 @@
+package internal
+
+import (
+	"errors"
+	"fmt"
+	"net/mail"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// Every entry has a type deciding what it holds. All types keep a name in the
+// service column and their main secret encrypted in the password column; most
+// have a second name in the username column, and the rest of their values are
+// kept as custom fields named after the type's fields, so they are encrypted,
+// versioned and exported like any other field.
+
+type EntryType string
+
+const (
+	TypeLogin    EntryType = "login"
+	TypeNote     EntryType = "note"
+	TypeCard     EntryType = "card"
+	TypeIdentity EntryType = "identity"
+	TypeSSHKey   EntryType = "ssh-key"
+	TypeAPIToken EntryType = "api-token"
+)
+
+// TypeField is a value an entry type keeps in the custom field of its name
+type TypeField struct {
+	Name     string
+	Label    string
+	Hint     string // format shown when prompting, such as MM/YY
+	Hidden   bool
+	Required bool
+	Validate func(value string) error
+}
+
+// EntrySchema describes the values an entry type holds and how they are
+// labelled
+type EntrySchema struct {
+	Type             EntryType
+	Description      string // what an entry of the type is, such as "payment card"
+	ServiceLabel     string
+	UsernameLabel    string // empty if the type has no username
+	UsernameRequired bool
+	SecretLabel      string
+	SecretRequired   bool
+	SecretMultiline  bool
+	ValidateSecret   func(secret []byte) error
+	Fields           []TypeField
+}
+
+var entrySchemas = []EntrySchema{
+	{
+		Type:             TypeLogin,
+		Description:      "login",
+		ServiceLabel:     "Service",
+		UsernameLabel:    "Username",
+		UsernameRequired: true,
+		SecretLabel:      "Password",
+		SecretRequired:   true,
+	},
+	{
+		Type:            TypeNote,
+		Description:     "secure note",
+		ServiceLabel:    "Title",
+		SecretLabel:     "Note",
+		SecretRequired:  true,
+		SecretMultiline: true,
+	},
+	{
+		Type:             TypeCard,
+		Description:      "payment card",
+		ServiceLabel:     "Card name",
+		UsernameLabel:    "Cardholder",
+		UsernameRequired: true,
+		SecretLabel:      "Card number",
+		SecretRequired:   true,
+		ValidateSecret:   validateCardNumber,
+		Fields: []TypeField{
+			{Name: "expiry", Label: "Expiry", Hint: "MM/YY", Required: true, Validate: validateCardExpiry},
+			{Name: "cvv", Label: "Security code", Hidden: true, Validate: digitsBetween(3, 4)},
+			{Name: "pin", Label: "PIN", Hidden: true, Validate: digitsBetween(4, 12)},
+		},
+	},
+	{
+		Type:             TypeIdentity,
+		Description:      "identity",
+		ServiceLabel:     "Title",
+		UsernameLabel:    "Full name",
+		UsernameRequired: true,
+		SecretLabel:      "ID number",
+		Fields: []TypeField{
+			{Name: "email", Label: "Email", Validate: validateEmail},
+			{Name: "phone", Label: "Phone"},
+			{Name: "address", Label: "Address"},
+			{Name: "birth_date", Label: "Birth date", Hint: "YYYY-MM-DD", Validate: validateDate},
+		},
+	},
+	{
+		Type:            TypeSSHKey,
+		Description:     "SSH key",
+		ServiceLabel:    "Name",
+		UsernameLabel:   "User",
+		SecretLabel:     "Private key",
+		SecretRequired:  true,
+		SecretMultiline: true,
+		ValidateSecret:  validatePrivateKey,
+		Fields: []TypeField{
+			{Name: "public_key", Label: "Public key", Validate: validatePublicKey},
+			{Name: "passphrase", Label: "Passphrase", Hidden: true},
+		},
+	},
+	{
+		Type:           TypeAPIToken,
+		Description:    "API token",
+		ServiceLabel:   "Service",
+		UsernameLabel:  "Key ID",
+		SecretLabel:    "Token",
+		SecretRequired: true,
+	},
+}
+
+// EntrySchemas returns the schemas of every entry type
+func EntrySchemas() []EntrySchema {
+	return entrySchemas
+}
+
+// SchemaOf returns the schema of an entry type; the empty type is a login
+func SchemaOf(entryType EntryType) (EntrySchema, error) {
+	if entryType == "" {
+		entryType = TypeLogin
+	}
+	for _, schema := range entrySchemas {
+		if schema.Type == entryType {
+			return schema, nil
+		}
+	}
+
+	names := make([]string, len(entrySchemas))
+	for i, schema := range entrySchemas {
+		names[i] = string(schema.Type)
+	}
+	return EntrySchema{}, fmt.Errorf("unknown entry type %q: use one of %s", entryType, strings.Join(names, ", "))
+}
+
+// Field returns the type field with the given name, if the type has one
+func (s EntrySchema) Field(name string) (TypeField, bool) {
+	for _, field := range s.Fields {
+		if strings.EqualFold(field.Name, name) {
+			return field, true
+		}
+	}
+	return TypeField{}, false
+}
+
+// CheckSecret reports whether secret is a valid main secret for the type
+func (s EntrySchema) CheckSecret(secret []byte) error {
+	if len(secret) == 0 {
+		if s.SecretRequired {
+			return fmt.Errorf("%s is required", LabelInSentence(s.SecretLabel))
+		}
+		return nil
+	}
+	if s.ValidateSecret != nil {
+		return s.ValidateSecret(secret)
+	}
+	return nil
+}
+
+// Check reports whether value is valid for the field
+func (f TypeField) Check(value string) error {
+	if value == "" {
+		if f.Required {
+			return fmt.Errorf("%s is required", LabelInSentence(f.Label))
+		}
+		return nil
+	}
+	if f.Validate != nil {
+		if err := f.Validate(value); err != nil {
+			return fmt.Errorf("invalid %s: %w", LabelInSentence(f.Label), err)
+		}
+	}
+	return nil
+}
+
+// Check reports whether an entry has the names and fields its type requires.
+// Its secret and hidden fields are checked when they are entered.
+func (s EntrySchema) Check(entry PasswordEntry) error {
+	if strings.TrimSpace(entry.Service) == "" {
+		return fmt.Errorf("%s is required", LabelInSentence(s.ServiceLabel))
+	}
+	if s.UsernameRequired && strings.TrimSpace(entry.Username) == "" {
+		return fmt.Errorf("%s is required", LabelInSentence(s.UsernameLabel))
+	}
+
+	for _, field := range s.Fields {
+		value := ""
+		if i := FindField(entry.Fields, field.Name); i >= 0 {
+			if entry.Fields[i].Hidden != field.Hidden {
+				return fmt.Errorf("field %s of a %s must be %s", field.Name, s.Description, visibility(field.Hidden))
+			}
+			if field.Hidden {
+				continue
+			}
+			value = entry.Fields[i].Value
+		}
+		if err := field.Check(value); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// ConformFields returns the custom fields of an entry with the type's fields
+// first, in the schema's order, named and hidden as the schema says, checking
+// their values. The entry's other fields follow in their own order.
+func (s EntrySchema) ConformFields(fields []CustomField, vaultKey *VaultKey, entryUUID string) ([]CustomField, error) {
+	var conformed []CustomField
+	rest := slices.Clone(fields)
+
+	for _, typeField := range s.Fields {
+		i := FindField(rest, typeField.Name)
+		if i < 0 {
+			continue
+		}
+		field := rest[i]
+		rest = slices.Delete(rest, i, i+1)
+
+		value, err := DecryptField(field, vaultKey, entryUUID)
+		if err != nil {
+			return nil, err
+		}
+
+		if err := typeField.Check(string(value.Bytes())); err != nil {
+			value.Destroy()
+			return nil, err
+		}
+		if len(value.Bytes()) == 0 {
+			value.Destroy()
+			continue
+		}
+
+		if field.Name != typeField.Name || field.Hidden != typeField.Hidden {
+			field, err = NewCustomField(typeField.Name, value.Bytes(), typeField.Hidden, vaultKey, entryUUID)
+		}
+		value.Destroy()
+		if err != nil {
+			return nil, err
+		}
+
+		conformed = append(conformed, field)
+	}
+
+	return append(conformed, rest...), nil
+}
+
+// LabelInSentence returns a label as written within a sentence, lower-casing
+// its first letter unless it starts an abbreviation such as PIN
+func LabelInSentence(label string) string {
+	if len(label) > 1 && unicode.IsUpper(rune(label[1])) {
+		return label
+	}
+	return strings.ToLower(label[:1]) + label[1:]
+}
+
+func visibility(hidden bool) string {
+	if hidden {
+		return "hidden"
+	}
+	return "visible"
+}
+
+// EncryptSecret encrypts the main secret of an entry like EncryptPassword,
+// allowing it to be empty for types whose secret is optional
+func EncryptSecret(secret []byte, vaultKey *VaultKey, entryUUID string) (string, error) {
+	if entryUUID == "" {
+		return "", errors.New("entry UUID cannot be empty")
+	}
+	return encryptValue(secret, vaultKey, entryAAD(entryUUID, fieldPassword))
+}
+
+// SSHPublicKey returns the public key in authorized_keys format of an
+// unencrypted private key, or "" if the private key is protected by a
+// passphrase
+func SSHPublicKey(privateKey []byte) (string, error) {
+	signer, err := ssh.ParsePrivateKey(privateKey)
+	if err != nil {
+		var missing *ssh.PassphraseMissingError
+		if errors.As(err, &missing) {
+			return "", nil
+		}
+		return "", fmt.Errorf("invalid private key: %w", err)
+	}
+	return strings.TrimSpace(string(ssh.MarshalAuthorizedKey(signer.PublicKey()))), nil
+}
+
+func validateCardNumber(secret []byte) error {
+	var digits []int
+	for _, c := range secret {
+		switch {
+		case c >= '0' && c <= '9':
+			digits = append(digits, int(c-'0'))
+		case c == ' ' || c == '-':
+		default:
+			return errors.New("card number may only contain digits, spaces and dashes")
+		}
+	}
+
+	if len(digits) < 12 || len(digits) > 19 {
+		return errors.New("card number must have 12 to 19 digits")
+	}
+
+	// Luhn checksum
+	sum := 0
+	for i := range digits {
+		digit := digits[len(digits)-1-i]
+		if i%2 == 1 {
+			digit *= 2
+			if digit > 9 {
+				digit -= 9
+			}
+		}
+		sum += digit
+	}
+	clear(digits)
+
+	if sum%10 != 0 {
+		return errors.New("card number fails its checksum; check for typos")
+	}
+	return nil
+}
+
+func validateCardExpiry(value string) error {
+	month, year, ok := strings.Cut(value, "/")
+	if !ok {
+		return errors.New("use MM/YY")
+	}
+
+	m, err := strconv.Atoi(month)
+	if err != nil || m < 1 || m > 12 {
+		return errors.New("month must be between 01 and 12")
+	}
+	if _, err := strconv.Atoi(year); err != nil || (len(year) != 2 && len(year) != 4) {
+		return errors.New("use MM/YY")
+	}
+	return nil
+}
+
+func digitsBetween(min, max int) func(string) error {
+	return func(value string) error {
+		if len(value) < min || len(value) > max || strings.Trim(value, "0123456789") != "" {
+			return fmt.Errorf("must be %d to %d digits", min, max)
+		}
+		return nil
+	}
+}
+
+func validateEmail(value string) error {
+	_, err := mail.ParseAddress(value)
+	return err
+}
+
+func validateDate(value string) error {
+	if _, err := time.Parse(time.DateOnly, value); err != nil {
+		return errors.New("use YYYY-MM-DD")
+	}
+	return nil
+}
+
+func validatePrivateKey(secret []byte) error {
+	_, err := ssh.ParseRawPrivateKey(secret)
+	var missing *ssh.PassphraseMissingError
+	if err != nil && !errors.As(err, &missing) {
+		return fmt.Errorf("invalid private key: %w", err)
+	}
+	return nil
+}
+
+func validatePublicKey(value string) error {
+	if _, _, _, _, err := ssh.ParseAuthorizedKey([]byte(value)); err != nil {
+		return errors.New("expected a key in authorized_keys format")
+	}
+	return nil
+}
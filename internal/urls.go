@@ -0,0 +1,221 @@
+package internal
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"slices"
+	"strings"
+
+	"golang.org/x/net/publicsuffix"
+)
+
+// An entry can have any number of URLs, such as the sign-in pages of a
+// service, so it can be found from the address of the page being visited
+// rather than by how its service was named. URLs are stored normalized and
+// are metadata: they are sealed with the rest of it when metadata encryption
+// is on. How the URLs of an entry are compared with a looked-up URL is chosen
+// per entry.
+
+// URLMatch is how the URLs of an entry are matched against a looked-up URL
+type URLMatch string
+
+const (
+	// MatchDomain matches URLs of the same registrable domain, so
+	// https://example.com matches https://login.example.com/path
+	MatchDomain URLMatch = "domain"
+
+	// MatchHost matches URLs of the same host and port
+	MatchHost URLMatch = "host"
+
+	// MatchPrefix matches URLs that start with the entry's URL
+	MatchPrefix URLMatch = "prefix"
+)
+
+// URLMatches lists the ways of matching URLs, the default first
+var URLMatches = []URLMatch{MatchDomain, MatchHost, MatchPrefix}
+
+// ParseURLMatch returns the way of matching URLs named by s; empty means
+// MatchDomain
+func ParseURLMatch(s string) (URLMatch, error) {
+	if s == "" {
+		return MatchDomain, nil
+	}
+
+	match := URLMatch(strings.ToLower(strings.TrimSpace(s)))
+	if !slices.Contains(URLMatches, match) {
+		return "", fmt.Errorf("unknown URL match %q (use domain, host or prefix)", s)
+	}
+	return match, nil
+}
+
+// NormalizeURL returns a URL in the form it is stored and compared in: with
+// a scheme (https if none is given), a lower-case host without a default
+// port, a path and no fragment
+func NormalizeURL(raw string) (string, error) {
+	raw = strings.TrimSpace(raw)
+	if raw == "" {
+		return "", fmt.Errorf("URL cannot be empty")
+	}
+	if !strings.Contains(raw, "://") {
+		raw = "https://" + raw
+	}
+
+	u, err := url.Parse(raw)
+	if err != nil {
+		return "", fmt.Errorf("invalid URL %q: %w", raw, err)
+	}
+
+	host := strings.TrimSuffix(strings.ToLower(u.Hostname()), ".")
+	if host == "" {
+		return "", fmt.Errorf("invalid URL %q: it has no host", raw)
+	}
+
+	u.Scheme = strings.ToLower(u.Scheme)
+	port := u.Port()
+	if u.Scheme == "https" && port == "443" || u.Scheme == "http" && port == "80" {
+		port = ""
+	}
+	u.Host = host
+	if strings.Contains(host, ":") {
+		u.Host = "[" + host + "]"
+	}
+	if port != "" {
+		u.Host += ":" + port
+	}
+
+	u.User = nil
+	u.Fragment = ""
+	u.RawFragment = ""
+	if u.Path == "" {
+		u.Path = "/"
+	}
+
+	return u.String(), nil
+}
+
+// NormalizeURLs normalizes URLs and drops duplicates, keeping their order
+func NormalizeURLs(urls []string) ([]string, error) {
+	var normalized []string
+	for _, raw := range urls {
+		if strings.TrimSpace(raw) == "" {
+			continue
+		}
+
+		u, err := NormalizeURL(raw)
+		if err != nil {
+			return nil, err
+		}
+		if !slices.Contains(normalized, u) {
+			normalized = append(normalized, u)
+		}
+	}
+	return normalized, nil
+}
+
+// RegistrableDomain returns the domain under which a host name was
+// registered, such as example.co.uk for login.example.co.uk, using the Public
+// Suffix List, which also names hosting domains whose subdomains belong to
+// different owners, such as github.io. IP addresses and single-label hosts
+// such as localhost are returned as they are. A host that is itself a public
+// suffix has no registrable domain, and ok is false.
+func RegistrableDomain(host string) (domain string, ok bool) {
+	host = strings.TrimSuffix(strings.ToLower(host), ".")
+	if net.ParseIP(host) != nil || !strings.Contains(host, ".") {
+		return host, true
+	}
+
+	domain, err := publicsuffix.EffectiveTLDPlusOne(host)
+	if err != nil {
+		return "", false
+	}
+	return domain, true
+}
+
+// MatchesURL reports whether one of the URLs of a decrypted entry matches
+// target, a normalized URL, the way the entry's URLMatch says. The URL of a
+// page only matches entry URLs of the same scheme, except that http entry
+// URLs also match https pages; an https entry is never offered to an http
+// page.
+func (e PasswordEntry) MatchesURL(target string) bool {
+	targetURL, err := url.Parse(target)
+	if err != nil {
+		return false
+	}
+
+	for _, raw := range e.URLs {
+		entryURL, err := url.Parse(raw)
+		if err != nil {
+			continue
+		}
+		if entryURL.Scheme != targetURL.Scheme && (entryURL.Scheme != "http" || targetURL.Scheme != "https") {
+			continue
+		}
+
+		var matched bool
+		switch e.URLMatch {
+		case MatchHost:
+			matched = entryURL.Host == targetURL.Host
+		case MatchPrefix:
+			matched = entryURL.Scheme == targetURL.Scheme && hasURLPrefix(target, raw)
+		default:
+			matched = sameRegistrableDomain(entryURL.Hostname(), targetURL.Hostname())
+		}
+		if matched {
+			return true
+		}
+	}
+	return false
+}
+
+// sameRegistrableDomain reports whether two hosts are under the same
+// registrable domain. A host that is a public suffix, such as github.io,
+// only matches itself.
+func sameRegistrableDomain(entryHost, targetHost string) bool {
+	entryDomain, ok := RegistrableDomain(entryHost)
+	if !ok {
+		return entryHost == targetHost
+	}
+	targetDomain, ok := RegistrableDomain(targetHost)
+	return ok && entryDomain == targetDomain
+}
+
+// hasURLPrefix reports whether target starts with prefix, ending at a path
+// segment, so https://example.com/app matches https://example.com/app/login
+// and https://example.com/app?tab=1 but not https://example.com/apple
+func hasURLPrefix(target, prefix string) bool {
+	if !strings.HasPrefix(target, prefix) {
+		return false
+	}
+	if len(target) == len(prefix) || strings.HasSuffix(prefix, "/") {
+		return true
+	}
+
+	next := target[len(prefix)]
+	if strings.Contains(prefix, "?") {
+		return next == '&'
+	}
+	return next == '/' || next == '?'
+}
+
+// FindPasswordsByURL returns the entries not in the trash with a URL that
+// matches rawURL
+func (v *Vault) FindPasswordsByURL(rawURL string, vaultKey *VaultKey) ([]PasswordEntry, error) {
+	target, err := NormalizeURL(rawURL)
+	if err != nil {
+		return nil, err
+	}
+
+	entries, err := v.ListAllPasswords(vaultKey)
+	if err != nil {
+		return nil, err
+	}
+
+	var matches []PasswordEntry
+	for _, entry := range entries {
+		if entry.MatchesURL(target) {
+			matches = append(matches, entry)
+		}
+	}
+	return matches, nil
+}
@@ -2,7 +2,9 @@ package internal
 
 import (
 	"bufio"
+	"bytes"
 	"fmt"
+	"io"
 	"os"
 	"strings"
 	"syscall"
@@ -14,80 +16,205 @@ import (
 	"golang.org/x/term"
 )
 
-func PromptMasterPassword() (string, error) {
-	isSet, err := IsMasterPasswordSet()
+// PromptMasterPassword prompts for and verifies the master password, creating
+// the vault on first use. It returns the master secret keys are derived from,
+// which also covers the key file when the vault requires one. The caller
+// destroys the returned buffer.
+func (v *Vault) PromptMasterPassword() (*SecretBuffer, error) {
+	password, keyFile, err := v.PromptMasterCredentials()
 	if err != nil {
-		return "", err
+		return nil, err
+	}
+	defer password.Destroy()
+	defer Wipe(keyFile)
+
+	return ComposeMasterSecret(password, keyFile), nil
+}
+
+// PromptMasterCredentials is like PromptMasterPassword but returns the master
+// password and the key file (nil if the vault has none) separately
+func (v *Vault) PromptMasterCredentials() (*SecretBuffer, []byte, error) {
+	isSet, err := v.store.IsMasterPasswordSet()
+	if err != nil {
+		return nil, nil, err
 	}
 
 	if !isSet {
-		return setupMasterPassword()
+		password, err := v.setupMasterPassword(DefaultCipherSuite)
+		return password, nil, err
 	}
 
-	return verifyMasterPassword()
+	return v.verifyMasterPassword()
 }
 
-func setupMasterPassword() (string, error) {
-	fmt.Println("No master password set. Let's create one.")
-	fmt.Print("Enter master password: ")
-	password, err := readPassword()
+// Unlock returns the vault key, taking it from the agent when one holds
+// it and prompting for the master password otherwise
+func (v *Vault) Unlock() (*VaultKey, error) {
+	if vaultKey := v.agentVaultKey(); vaultKey != nil {
+		return vaultKey, nil
+	}
+
+	masterPassword, err := v.PromptMasterPassword()
 	if err != nil {
-		return "", fmt.Errorf("failed to read password: %w", err)
+		return nil, err
 	}
+	defer masterPassword.Destroy()
 
-	if len(password) < 8 {
-		return "", fmt.Errorf("master password must be at least 8 characters")
+	vaultKey, err := v.OpenVaultKey(masterPassword)
+	if err != nil {
+		return nil, fmt.Errorf("failed to unlock vault: %w", err)
 	}
 
-	fmt.Print("Confirm master password: ")
-	confirm, err := readPassword()
+	return vaultKey, nil
+}
+
+// Init creates the vault whose entries are encrypted with the given cipher suite
+func (v *Vault) Init(suite CipherSuite) error {
+	isSet, err := v.store.IsMasterPasswordSet()
 	if err != nil {
-		return "", fmt.Errorf("failed to read confirmation: %w", err)
+		return err
 	}
 
-	if password != confirm {
-		return "", fmt.Errorf("passwords do not match")
+	if isSet {
+		return fmt.Errorf("vault is already initialized")
 	}
 
-	hashedPassword, err := HashMasterPassword(password)
+	password, err := v.setupMasterPassword(suite)
 	if err != nil {
-		return "", fmt.Errorf("failed to hash password: %w", err)
+		return err
 	}
+	password.Destroy()
+	return nil
+}
 
-	if err := SetMasterPassword(hashedPassword); err != nil {
-		return "", fmt.Errorf("failed to save master password: %w", err)
+func (v *Vault) setupMasterPassword(suite CipherSuite) (*SecretBuffer, error) {
+	fmt.Fprintln(v.prompts(), "No master password set. Let's create one.")
+	password, err := promptNewMasterPassword(v.prompts(), "Enter master password: ", "Confirm master password: ")
+	if err != nil {
+		return nil, err
 	}
 
-	fmt.Println("Master password set successfully!")
+	vaultKey, err := v.Create(password, suite)
+	if err != nil {
+		password.Destroy()
+		return nil, fmt.Errorf("failed to save master password: %w", err)
+	}
+	defer vaultKey.Destroy()
+
+	fmt.Fprintln(v.prompts(), "Master password set successfully!")
+
+	v.offerRecoveryCodes(vaultKey)
 	return password, nil
 }
 
-func verifyMasterPassword() (string, error) {
-	fmt.Print("Enter master password: ")
-	password, err := readPassword()
+// PromptNewMasterPassword reads a new master password and its confirmation.
+// The caller destroys the returned buffer.
+func PromptNewMasterPassword(prompt, confirmPrompt string) (*SecretBuffer, error) {
+	return promptNewMasterPassword(os.Stdout, prompt, confirmPrompt)
+}
+
+// promptNewMasterPassword is PromptNewMasterPassword with its prompts
+// written to w
+func promptNewMasterPassword(w io.Writer, prompt, confirmPrompt string) (*SecretBuffer, error) {
+	fmt.Fprint(w, prompt)
+	password, err := readPassword(w)
 	if err != nil {
-		return "", fmt.Errorf("failed to read password: %w", err)
+		return nil, fmt.Errorf("failed to read password: %w", err)
 	}
 
-	storedHash, err := GetMasterPasswordHash()
+	if password.Len() < 8 {
+		password.Destroy()
+		return nil, fmt.Errorf("master password must be at least 8 characters")
+	}
+
+	fmt.Fprint(w, confirmPrompt)
+	confirm, err := readPassword(w)
 	if err != nil {
-		return "", err
+		password.Destroy()
+		return nil, fmt.Errorf("failed to read confirmation: %w", err)
 	}
+	defer confirm.Destroy()
 
-	if err := VerifyMasterPassword(password, storedHash); err != nil {
-		return "", fmt.Errorf("incorrect master password")
+	if !password.Equal(confirm) {
+		password.Destroy()
+		return nil, fmt.Errorf("passwords do not match")
 	}
 
 	return password, nil
 }
 
-func readPassword() (string, error) {
-	password, err := term.ReadPassword(int(syscall.Stdin))
-	fmt.Println()
+// offerRecoveryCodes asks whether to generate recovery codes for a new vault.
+// Failing to generate them does not undo the vault.
+func (v *Vault) offerRecoveryCodes(vaultKey *VaultKey) {
+	fmt.Fprint(v.prompts(), "Generate recovery codes in case you forget the master password? (yes/no): ")
+	answer, err := PromptString("")
+	if err != nil || strings.ToLower(answer) != "yes" {
+		fmt.Fprintln(v.prompts(), "You can generate them later with 'passvault recovery generate'.")
+		return
+	}
+
+	codes, err := v.GenerateRecoveryCodes(vaultKey, DefaultRecoveryCodeCount)
 	if err != nil {
-		return "", err
+		fmt.Fprintf(os.Stderr, "Error generating recovery codes: %v\n", err)
+		fmt.Fprintln(v.prompts(), "You can try again with 'passvault recovery generate'.")
+		return
+	}
+
+	printRecoveryCodes(v.prompts(), codes)
+	fmt.Fprintln(v.prompts())
+}
+
+func (v *Vault) verifyMasterPassword() (*SecretBuffer, []byte, error) {
+	keyFile, err := v.LoadKeyFile()
+	if err != nil {
+		return nil, nil, err
 	}
-	return strings.TrimSpace(string(password)), nil
+
+	fmt.Fprint(v.prompts(), "Enter master password: ")
+	password, err := v.readMasterPassword()
+	if err != nil {
+		Wipe(keyFile)
+		return nil, nil, fmt.Errorf("failed to read password: %w", err)
+	}
+
+	storedHash, err := v.store.GetMasterPasswordHash()
+	if err != nil {
+		password.Destroy()
+		Wipe(keyFile)
+		return nil, nil, err
+	}
+
+	masterSecret := ComposeMasterSecret(password, keyFile)
+	defer masterSecret.Destroy()
+
+	if err := VerifyMasterPassword(masterSecret, storedHash); err != nil {
+		password.Destroy()
+		Wipe(keyFile)
+		return nil, nil, fmt.Errorf("incorrect master password")
+	}
+
+	return password, keyFile, nil
+}
+
+// readPassword reads a line from the terminal without echo straight into a
+// secret buffer, wiping the intermediate copy. The line break that is not
+// echoed is written to w.
+func readPassword(w io.Writer) (*SecretBuffer, error) {
+	raw, err := term.ReadPassword(int(syscall.Stdin))
+	fmt.Fprintln(w)
+	if err != nil {
+		return nil, err
+	}
+	defer Wipe(raw)
+
+	return NewSecretBufferFrom(bytes.TrimSpace(raw)), nil
+}
+
+// PromptSecret reads a value such as a PIN without echoing it. The caller
+// destroys the returned buffer when done with the value.
+func PromptSecret(prompt string) (*SecretBuffer, error) {
+	fmt.Print(prompt)
+	return readPassword(os.Stdout)
 }
 
 func PromptString(prompt string) (string, error) {
@@ -100,7 +227,7 @@ func PromptString(prompt string) (string, error) {
 	return strings.TrimSpace(input), nil
 }
 
-func SearchAndSelectPassword(query string) (*PasswordEntry, error) {
+func (v *Vault) SearchAndSelectPassword(query string, vaultKey *VaultKey) (*PasswordEntry, error) {
 	if query == "" {
 		var err error
 		query, err = PromptString("Search query: ")
@@ -113,15 +240,20 @@ func SearchAndSelectPassword(query string) (*PasswordEntry, error) {
 		return nil, fmt.Errorf("search query cannot be empty")
 	}
 
-	entries, err := SearchPasswords(query)
+	entries, err := v.SearchPasswords(query, vaultKey)
 	if err != nil {
 		return nil, fmt.Errorf("error searching passwords: %w", err)
 	}
 
 	if len(entries) == 0 {
-		return nil, fmt.Errorf("no passwords found matching '%s'", query)
+		return nil, fmt.Errorf("%w matching '%s'", ErrNoEntries, query)
 	}
 
+	return SelectPassword(entries, query)
+}
+
+// SelectPassword lets the user pick one of entries, which matched query
+func SelectPassword(entries []PasswordEntry, query string) (*PasswordEntry, error) {
 	if len(entries) == 1 {
 		return &entries[0], nil
 	}
@@ -275,7 +407,10 @@ func GenerateSecurePassword() (string, error) {
 	return pwd, nil
 }
 
-func PromptPasswordWithValidation(prompt string) (string, error) {
+// PromptPasswordWithValidation asks for a password, typed without echo or
+// generated, and warns when a typed one is weak. The caller destroys the
+// returned buffer when done with the password.
+func PromptPasswordWithValidation(prompt string) (*SecretBuffer, error) {
 	fmt.Println(prompt)
 	fmt.Println("Options:")
 	fmt.Println("1. Enter password manually")
@@ -283,25 +418,16 @@ func PromptPasswordWithValidation(prompt string) (string, error) {
 
 	choice, err := PromptString("Choose (1/2): ")
 	if err != nil {
-		return "", err
+		return nil, err
 	}
 
 	switch choice {
 	case "1":
 		return promptManualPasswordWithValidation()
 	case "2":
-		generated, err := GenerateSecurePassword()
-		if err != nil {
-			return "", err
-		}
-		fmt.Printf("\nGenerated password: %s\n", generated)
-		fmt.Print("Use this password? (yes/no): ")
-		confirm, err := PromptString("")
-		if err != nil {
-			return "", err
-		}
-		if strings.ToLower(confirm) == "yes" {
-			return generated, nil
+		generated, err := promptGeneratedPassword()
+		if err != nil || generated != nil {
+			return generated, err
 		}
 		return PromptPasswordWithValidation("Password:")
 	default:
@@ -310,92 +436,68 @@ func PromptPasswordWithValidation(prompt string) (string, error) {
 	}
 }
 
-func promptManualPasswordWithValidation() (string, error) {
+func promptManualPasswordWithValidation() (*SecretBuffer, error) {
 	for {
-		fmt.Print("Enter password: ")
-		pwd, err := PromptString("")
+		pwd, err := PromptSecret("Enter password: ")
 		if err != nil {
-			return "", err
+			return nil, err
 		}
 
-		if pwd == "" {
-			return "", fmt.Errorf("password cannot be empty")
+		if pwd.Len() == 0 {
+			pwd.Destroy()
+			return nil, fmt.Errorf("password cannot be empty")
 		}
 
-		strength := CheckPasswordStrength(pwd)
-
-		if strength.IsStrong {
-			fmt.Printf("✓ Password strength: Strong (score: %d/4)\n", strength.Score)
-			return pwd, nil
+		accepted, retry, err := checkPromptedPassword(pwd)
+		if err != nil || !retry {
+			return accepted, err
 		}
+	}
+}
 
-		fmt.Printf("\n⚠️  Password is weak (score: %d/4)\n", strength.Score)
-		if strength.Feedback != "" {
-			fmt.Printf("Feedback: %s\n", strength.Feedback)
-		}
-		fmt.Printf("Estimated crack time: %s\n\n", strength.CrackTime)
+// PromptPasswordWithDefaultAndValidation asks for a new password like
+// PromptPasswordWithValidation, returning nil if nothing is entered so the
+// current one is kept
+func PromptPasswordWithDefaultAndValidation(prompt string) (*SecretBuffer, error) {
+	pwd, err := PromptSecret(prompt + " [Press Enter to keep current]: ")
+	if err != nil {
+		return nil, err
+	}
 
-		fmt.Println("Options:")
-		fmt.Println("1. Enter a different password")
-		fmt.Println("2. Generate a secure password")
-		fmt.Println("3. Use this password anyway")
+	if pwd.Len() == 0 {
+		pwd.Destroy()
+		return nil, nil
+	}
 
-		choice, err := PromptString("Choose (1/2/3): ")
-		if err != nil {
-			return "", err
+	for {
+		accepted, retry, err := checkPromptedPassword(pwd)
+		if err != nil || !retry {
+			return accepted, err
 		}
 
-		switch choice {
-		case "1":
-			continue
-		case "2":
-			generated, err := GenerateSecurePassword()
-			if err != nil {
-				return "", err
-			}
-			fmt.Printf("\nGenerated password: %s\n", generated)
-			fmt.Print("Use this password? (yes/no): ")
-			confirm, err := PromptString("")
-			if err != nil {
-				return "", err
-			}
-			if strings.ToLower(confirm) == "yes" {
-				return generated, nil
-			}
-			continue
-		case "3":
-			fmt.Print("Are you sure you want to use this weak password? (yes/no): ")
-			confirm, err := PromptString("")
-			if err != nil {
-				return "", err
-			}
-			if strings.ToLower(confirm) == "yes" {
-				return pwd, nil
-			}
-			continue
-		default:
-			fmt.Println("Invalid choice. Please try again.")
-			continue
+		pwd, err = PromptSecret("New password: ")
+		if err != nil {
+			return nil, err
+		}
+		if pwd.Len() == 0 {
+			pwd.Destroy()
+			return nil, fmt.Errorf("password cannot be empty")
 		}
 	}
 }
 
-func PromptPasswordWithDefaultAndValidation(prompt, defaultValue string) (string, error) {
-	fmt.Printf("%s [Press Enter to keep current]: ", prompt)
-	pwd, err := PromptString("")
-	if err != nil {
-		return "", err
-	}
-
-	if pwd == "" {
-		return defaultValue, nil
-	}
-
-	strength := CheckPasswordStrength(pwd)
+// checkPromptedPassword returns pwd if it is strong, or else warns that it is
+// weak and offers to enter a different one, generate one or keep it. It
+// returns retry if a different password is to be entered; pwd is destroyed
+// unless it is returned.
+func checkPromptedPassword(pwd *SecretBuffer) (accepted *SecretBuffer, retry bool, err error) {
+	// zxcvbn only takes a string, so the password is copied into one to be
+	// rated
+	strength := CheckPasswordStrength(string(pwd.Bytes()))
 
 	if strength.IsStrong {
 		fmt.Printf("✓ Password strength: Strong (score: %d/4)\n", strength.Score)
-		return pwd, nil
+		return pwd, false, nil
 	}
 
 	for {
@@ -412,49 +514,30 @@ func PromptPasswordWithDefaultAndValidation(prompt, defaultValue string) (string
 
 		choice, err := PromptString("Choose (1/2/3): ")
 		if err != nil {
-			return "", err
+			pwd.Destroy()
+			return nil, false, err
 		}
 
 		switch choice {
 		case "1":
-			fmt.Print("New password: ")
-			newPwd, err := PromptString("")
-			if err != nil {
-				return "", err
-			}
-			if newPwd == "" {
-				return "", fmt.Errorf("password cannot be empty")
-			}
-			pwd = newPwd
-			strength = CheckPasswordStrength(pwd)
-			if strength.IsStrong {
-				fmt.Printf("✓ Password strength: Strong (score: %d/4)\n", strength.Score)
-				return pwd, nil
-			}
-			continue
+			pwd.Destroy()
+			return nil, true, nil
 		case "2":
-			generated, err := GenerateSecurePassword()
-			if err != nil {
-				return "", err
-			}
-			fmt.Printf("\nGenerated password: %s\n", generated)
-			fmt.Print("Use this password? (yes/no): ")
-			confirm, err := PromptString("")
-			if err != nil {
-				return "", err
-			}
-			if strings.ToLower(confirm) == "yes" {
-				return generated, nil
+			generated, err := promptGeneratedPassword()
+			if err != nil || generated != nil {
+				pwd.Destroy()
+				return generated, false, err
 			}
 			continue
 		case "3":
 			fmt.Print("Are you sure you want to use this weak password? (yes/no): ")
 			confirm, err := PromptString("")
 			if err != nil {
-				return "", err
+				pwd.Destroy()
+				return nil, false, err
 			}
 			if strings.ToLower(confirm) == "yes" {
-				return pwd, nil
+				return pwd, false, nil
 			}
 			continue
 		default:
@@ -463,3 +546,22 @@ func PromptPasswordWithDefaultAndValidation(prompt, defaultValue string) (string
 		}
 	}
 }
+
+// promptGeneratedPassword generates a password and shows it, returning it if
+// the user takes it or else nil
+func promptGeneratedPassword() (*SecretBuffer, error) {
+	generated, err := GenerateSecurePassword()
+	if err != nil {
+		return nil, err
+	}
+	fmt.Printf("\nGenerated password: %s\n", generated)
+	fmt.Print("Use this password? (yes/no): ")
+	confirm, err := PromptString("")
+	if err != nil {
+		return nil, err
+	}
+	if strings.ToLower(confirm) != "yes" {
+		return nil, nil
+	}
+	return NewSecretBufferFrom([]byte(generated)), nil
+}
@@ -0,0 +1,509 @@
+package internal
+
+import (
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+)
+
+// NewEntryUUID returns a random (version 4) UUID identifying a new entry
+func NewEntryUUID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("failed to generate entry UUID: %w", err)
+	}
+	b[6] = (b[6] & 0x0f) | 0x40
+	b[8] = (b[8] & 0x3f) | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:16]), nil
+}
+
+// Vault is a password vault kept in a Store. It does all the encryption, so
+// the store only ever holds hashes, wrapped keys and ciphertexts.
+type Vault struct {
+	store Store
+
+	// KeyFilePath is the key file to unlock with when the vault requires one
+	KeyFilePath string
+
+	// Prompts is where the prompts and messages of unlocking and creating
+	// the vault are written; nil means standard output
+	Prompts io.Writer
+
+	// ReadPassword reads the master password to unlock the vault with; nil
+	// reads it from the terminal without echo
+	ReadPassword func() (*SecretBuffer, error)
+}
+
+// NewVault returns the vault kept in store
+func NewVault(store Store) *Vault {
+	return &Vault{store: store}
+}
+
+// prompts returns where prompts and messages for the user are written
+func (v *Vault) prompts() io.Writer {
+	if v.Prompts == nil {
+		return os.Stdout
+	}
+	return v.Prompts
+}
+
+// readMasterPassword reads the master password to unlock the vault with
+func (v *Vault) readMasterPassword() (*SecretBuffer, error) {
+	if v.ReadPassword != nil {
+		return v.ReadPassword()
+	}
+	return readPassword(v.prompts())
+}
+
+// Store returns the store the vault is kept in
+func (v *Vault) Store() Store {
+	return v.store
+}
+
+// Close closes the vault's store
+func (v *Vault) Close() error {
+	return v.store.Close()
+}
+
+// IsMasterPasswordSet reports whether the vault has been created
+func (v *Vault) IsMasterPasswordSet() (bool, error) {
+	return v.store.IsMasterPasswordSet()
+}
+
+// Create stores the master password hash and a new vault key using the
+// given cipher suite for a vault that has no master password yet
+func (v *Vault) Create(masterPassword *SecretBuffer, suite CipherSuite) (*VaultKey, error) {
+	vaultKey, err := GenerateVaultKey(suite)
+	if err != nil {
+		return nil, err
+	}
+
+	hashedPassword, err := HashMasterPassword(masterPassword, DefaultHashParams)
+	if err != nil {
+		return nil, fmt.Errorf("failed to hash password: %w", err)
+	}
+
+	wrappedKey, err := WrapVaultKey(vaultKey, masterPassword, DefaultKeyParams)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := v.store.InitializeVault(hashedPassword, wrappedKey); err != nil {
+		vaultKey.Destroy()
+		return nil, err
+	}
+
+	return vaultKey, nil
+}
+
+// OpenVaultKey unwraps the vault key with the master password. Vaults created
+// before the key hierarchy existed are migrated on first unlock: a new vault
+// key is generated and every entry is re-encrypted under it.
+func (v *Vault) OpenVaultKey(masterPassword *SecretBuffer) (*VaultKey, error) {
+	isSet, err := v.store.IsVaultKeySet()
+	if err != nil {
+		return nil, err
+	}
+
+	if !isSet {
+		return v.migrateLegacyVault(masterPassword)
+	}
+
+	wrappedKey, err := v.store.GetWrappedVaultKey()
+	if err != nil {
+		return nil, err
+	}
+
+	vaultKey, err := UnwrapVaultKey(wrappedKey, masterPassword)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := v.bindUnboundEntries(vaultKey); err != nil {
+		vaultKey.Destroy()
+		return nil, fmt.Errorf("failed to bind entries: %w", err)
+	}
+
+	return vaultKey, nil
+}
+
+func (v *Vault) migrateLegacyVault(masterPassword *SecretBuffer) (*VaultKey, error) {
+	entries, err := v.store.ListEntries()
+	if err != nil {
+		return nil, err
+	}
+
+	if len(entries) > 0 {
+		fmt.Fprintf(v.prompts(), "Upgrading vault encryption for %d password(s). This only happens once...\n", len(entries))
+	}
+
+	vaultKey, err := GenerateVaultKey(DefaultCipherSuite)
+	if err != nil {
+		return nil, err
+	}
+
+	migrated := false
+	defer func() {
+		if !migrated {
+			vaultKey.Destroy()
+		}
+	}()
+
+	for i, entry := range entries {
+		decrypted, err := decryptLegacyPassword(entry.EncryptedPassword, masterPassword)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decrypt password for %s: %w", entry.Service, err)
+		}
+
+		entryUUID, err := NewEntryUUID()
+		if err != nil {
+			decrypted.Destroy()
+			return nil, err
+		}
+
+		encrypted, err := EncryptPassword(decrypted.Bytes(), vaultKey, entryUUID)
+		decrypted.Destroy()
+		if err != nil {
+			return nil, fmt.Errorf("failed to encrypt password for %s: %w", entry.Service, err)
+		}
+
+		entries[i].UUID = entryUUID
+		entries[i].EncryptedPassword = encrypted
+	}
+
+	wrappedKey, err := WrapVaultKey(vaultKey, masterPassword, DefaultKeyParams)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := v.store.MigrateToVaultKey(wrappedKey, entries); err != nil {
+		return nil, fmt.Errorf("failed to migrate vault: %w", err)
+	}
+
+	migrated = true
+	return vaultKey, nil
+}
+
+// bindUnboundEntries migrates entries written before ciphertexts were bound to
+// their row: each gets a UUID and its password and metadata are re-encrypted
+// with additional authenticated data naming that UUID and the field.
+func (v *Vault) bindUnboundEntries(vaultKey *VaultKey) error {
+	entries, err := v.store.ListEntries()
+	if err != nil {
+		return err
+	}
+
+	var unbound []PasswordEntry
+	for _, entry := range entries {
+		if entry.UUID != "" {
+			continue
+		}
+
+		entryUUID, err := NewEntryUUID()
+		if err != nil {
+			return err
+		}
+
+		password, err := decryptValue(entry.EncryptedPassword, vaultKey, nil)
+		if err != nil {
+			return fmt.Errorf("failed to decrypt password entry %d: %w", entry.ID, err)
+		}
+
+		entry.UUID = entryUUID
+		entry.EncryptedPassword, err = EncryptPassword(password, vaultKey, entryUUID)
+		Wipe(password)
+		if err != nil {
+			return fmt.Errorf("failed to encrypt password entry %d: %w", entry.ID, err)
+		}
+
+		if entry.EncryptedMetadata != "" {
+			metadata, err := decryptValue(entry.EncryptedMetadata, vaultKey, nil)
+			if err != nil {
+				return fmt.Errorf("failed to decrypt metadata of entry %d: %w", entry.ID, err)
+			}
+
+			entry.EncryptedMetadata, err = encryptValue(metadata, vaultKey, entryAAD(entryUUID, fieldMetadata))
+			Wipe(metadata)
+			if err != nil {
+				return fmt.Errorf("failed to encrypt metadata of entry %d: %w", entry.ID, err)
+			}
+		}
+
+		unbound = append(unbound, entry)
+	}
+
+	if len(unbound) == 0 {
+		return nil
+	}
+
+	return v.store.RewriteEntries(unbound, nil)
+}
+
+// ChangeMasterPassword re-wraps the vault key under a new master secret. The
+// vault key is kept, so entries, recovery codes and escrow shares are left
+// as they are. The new hash and wrapped key are checked to open with the new
+// secret before both are stored in a single transaction.
+func (v *Vault) ChangeMasterPassword(vaultKey *VaultKey, newMasterSecret *SecretBuffer) error {
+	hashedPassword, wrappedKey, err := v.masterCredentials(vaultKey, newMasterSecret)
+	if err != nil {
+		return err
+	}
+
+	if err := VerifyMasterPassword(newMasterSecret, hashedPassword); err != nil {
+		return fmt.Errorf("verification failed: %w", err)
+	}
+
+	unwrapped, err := UnwrapVaultKey(wrappedKey, newMasterSecret)
+	if err != nil {
+		return fmt.Errorf("verification failed: %w", err)
+	}
+	defer unwrapped.Destroy()
+
+	if unwrapped.cipher != vaultKey.cipher || !unwrapped.key.Equal(vaultKey.key) {
+		return errors.New("verification failed: the re-wrapped vault key does not match")
+	}
+
+	return v.store.UpdateMasterCredentials(hashedPassword, wrappedKey, nil)
+}
+
+// Rekey changes the master password and rotates the vault key. Every
+// entry, revision, attachment and tag rotation policy is re-encrypted under a
+// new vault key, and the new master password hash, wrapped key and entries
+// are committed in a single transaction only after each stored entry,
+// revision and attachment has been verified to decrypt under the new key.
+// Recovery codes and escrow shares of the old vault key stop working. It
+// returns the new vault key.
+func (v *Vault) Rekey(vaultKey *VaultKey, newMasterPassword *SecretBuffer) (*VaultKey, error) {
+	hashParams, keyParams, err := v.CurrentKDFParams()
+	if err != nil {
+		return nil, err
+	}
+
+	encrypted, err := v.IsMetadataEncrypted()
+	if err != nil {
+		return nil, err
+	}
+
+	entries, err := v.allPasswords(vaultKey)
+	if err != nil {
+		return nil, err
+	}
+
+	newKey, err := GenerateVaultKey(vaultKey.Cipher())
+	if err != nil {
+		return nil, err
+	}
+
+	committed := false
+	defer func() {
+		if !committed {
+			newKey.Destroy()
+		}
+	}()
+
+	plaintexts := make(map[string]*SecretBuffer, len(entries))
+	defer func() {
+		for _, password := range plaintexts {
+			password.Destroy()
+		}
+	}()
+
+	stored := make([]PasswordEntry, len(entries))
+	for i, entry := range entries {
+		password, err := DecryptPassword(entry.EncryptedPassword, vaultKey, entry.UUID)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decrypt password for %s: %w", entry.Service, err)
+		}
+		plaintexts[entry.UUID] = password
+
+		entry.EncryptedPassword, err = EncryptSecret(password.Bytes(), newKey, entry.UUID)
+		if err != nil {
+			return nil, fmt.Errorf("failed to encrypt password for %s: %w", entry.Service, err)
+		}
+
+		entry.Fields, err = rekeyFields(entry.Fields, entry.UUID, vaultKey, newKey)
+		if err != nil {
+			return nil, fmt.Errorf("failed to re-encrypt fields of %s: %w", entry.Service, err)
+		}
+
+		entry.EncryptedTOTP, err = rekeyTOTP(entry.EncryptedTOTP, entry.UUID, vaultKey, newKey)
+		if err != nil {
+			return nil, fmt.Errorf("failed to re-encrypt TOTP secret of %s: %w", entry.Service, err)
+		}
+
+		stored[i], err = encodeEntry(entry, newKey, encrypted)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	revisions, err := v.rekeyRevisions(vaultKey, newKey)
+	if err != nil {
+		return nil, err
+	}
+
+	attachments, err := v.rekeyAttachments(vaultKey, newKey)
+	if err != nil {
+		return nil, err
+	}
+
+	hashedPassword, err := HashMasterPassword(newMasterPassword, hashParams)
+	if err != nil {
+		return nil, err
+	}
+
+	wrappedKey, err := WrapVaultKey(newKey, newMasterPassword, keyParams)
+	if err != nil {
+		return nil, err
+	}
+
+	verifyEntry := func(entry PasswordEntry) error {
+		if err := openMetadata(&entry, newKey); err != nil {
+			return err
+		}
+
+		password, err := DecryptPassword(entry.EncryptedPassword, newKey, entry.UUID)
+		if err != nil {
+			return err
+		}
+		defer password.Destroy()
+
+		if expected, ok := plaintexts[entry.UUID]; !ok || !password.Equal(expected) {
+			return fmt.Errorf("decrypted password does not match")
+		}
+
+		for _, field := range entry.Fields {
+			value, err := DecryptField(field, newKey, entry.UUID)
+			if err != nil {
+				return err
+			}
+			value.Destroy()
+		}
+
+		if entry.EncryptedTOTP != "" {
+			totp, err := DecryptTOTP(entry.EncryptedTOTP, newKey, entry.UUID)
+			if err != nil {
+				return err
+			}
+			totp.Destroy()
+		}
+
+		return nil
+	}
+
+	verifyRevision := func(revision EntryRevision) error {
+		data, err := openRevision(revision, newKey)
+		if err != nil {
+			return err
+		}
+
+		password, err := DecryptPassword(revision.EncryptedPassword, newKey, revision.EntryUUID)
+		if err != nil {
+			return err
+		}
+		password.Destroy()
+
+		for _, field := range data.Fields {
+			value, err := DecryptField(field, newKey, revision.EntryUUID)
+			if err != nil {
+				return err
+			}
+			value.Destroy()
+		}
+
+		return nil
+	}
+
+	verifyAttachment := func(attachment Attachment) error {
+		info, err := openAttachmentInfo(attachment, newKey)
+		if err != nil {
+			return err
+		}
+
+		data, err := openAttachment(attachment, info, newKey)
+		if err != nil {
+			return err
+		}
+		Wipe(data)
+
+		return nil
+	}
+
+	rotationPolicies, err := v.rekeyRotationPolicies(vaultKey, newKey)
+	if err != nil {
+		return nil, err
+	}
+
+	// Escrow shares are of the old vault key and cannot be reissued without
+	// their holders, just like the recovery codes the store deletes
+	settings := map[string]string{settingEscrowCheck: "", settingRotationPolicies: rotationPolicies}
+
+	if err := v.store.Rekey(hashedPassword, wrappedKey, stored, revisions, attachments, settings, RekeyCheck{
+		Entry:      verifyEntry,
+		Revision:   verifyRevision,
+		Attachment: verifyAttachment,
+	}); err != nil {
+		return nil, err
+	}
+
+	committed = true
+	return newKey, nil
+}
+
+// CurrentKDFParams returns the Argon2 parameters the vault's master password
+// hash and wrapped vault key were created with, so re-wrapping keeps them.
+func (v *Vault) CurrentKDFParams() (hashParams, keyParams Argon2Params, err error) {
+	storedHash, err := v.store.GetMasterPasswordHash()
+	if err != nil {
+		return hashParams, keyParams, err
+	}
+
+	hashParams, err = parseKDFParams(storedHash, legacyHashParams)
+	if err != nil {
+		return hashParams, keyParams, fmt.Errorf("failed to read master password parameters: %w", err)
+	}
+
+	keyParams = DefaultKeyParams
+
+	isSet, err := v.store.IsVaultKeySet()
+	if err != nil {
+		return hashParams, keyParams, err
+	}
+
+	if isSet {
+		wrappedKey, err := v.store.GetWrappedVaultKey()
+		if err != nil {
+			return hashParams, keyParams, err
+		}
+
+		keyParams, err = parseKDFParams(wrappedKey, legacyKeyParams)
+		if err != nil {
+			return hashParams, keyParams, fmt.Errorf("failed to read vault key parameters: %w", err)
+		}
+	}
+
+	return hashParams, keyParams, nil
+}
+
+// masterCredentials hashes a new master secret and wraps the vault key with
+// it, keeping the vault's current Argon2 parameters
+func (v *Vault) masterCredentials(vaultKey *VaultKey, masterSecret *SecretBuffer) (hashedPassword, wrappedKey string, err error) {
+	hashParams, keyParams, err := v.CurrentKDFParams()
+	if err != nil {
+		return "", "", err
+	}
+
+	hashedPassword, err = HashMasterPassword(masterSecret, hashParams)
+	if err != nil {
+		return "", "", err
+	}
+
+	wrappedKey, err = WrapVaultKey(vaultKey, masterSecret, keyParams)
+	if err != nil {
+		return "", "", err
+	}
+
+	return hashedPassword, wrappedKey, nil
+}
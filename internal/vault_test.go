@@ -0,0 +1,249 @@
+package internal
+
+import (
+	"testing"
+)
+
+// testParams keep key derivation in tests fast
+var testParams = Argon2Params{Time: 1, Memory: 8 * 1024, Threads: 1, KeyLen: 32}
+
+// newTestVault returns a vault in memory with one entry, one revision of it
+// and one attachment, unlocked with masterPassword
+func newTestVault(t *testing.T, store Store, masterPassword string) (*Vault, *VaultKey, PasswordEntry) {
+	t.Helper()
+	vault := NewVault(store)
+
+	vaultKey, err := GenerateVaultKey(CipherAESGCM)
+	if err != nil {
+		t.Fatalf("GenerateVaultKey: %v", err)
+	}
+	t.Cleanup(vaultKey.Destroy)
+
+	secret := NewSecretBufferFrom([]byte(masterPassword))
+	defer secret.Destroy()
+	hashedPassword, err := HashMasterPassword(secret, testParams)
+	if err != nil {
+		t.Fatalf("HashMasterPassword: %v", err)
+	}
+	wrappedKey, err := WrapVaultKey(vaultKey, secret, testParams)
+	if err != nil {
+		t.Fatalf("WrapVaultKey: %v", err)
+	}
+	if err := store.InitializeVault(hashedPassword, wrappedKey); err != nil {
+		t.Fatalf("InitializeVault: %v", err)
+	}
+
+	entry := PasswordEntry{Type: TypeLogin, Service: "github", Username: "me"}
+	if entry.UUID, err = NewEntryUUID(); err != nil {
+		t.Fatalf("NewEntryUUID: %v", err)
+	}
+	if entry.EncryptedPassword, err = EncryptPassword([]byte("first"), vaultKey, entry.UUID); err != nil {
+		t.Fatalf("EncryptPassword: %v", err)
+	}
+	if err := vault.AddPassword(entry, vaultKey); err != nil {
+		t.Fatalf("AddPassword: %v", err)
+	}
+
+	stored, err := vault.GetPassword(entry.UUID, vaultKey)
+	if err != nil || stored == nil {
+		t.Fatalf("GetPassword = %v, %v", stored, err)
+	}
+	if stored.EncryptedPassword, err = EncryptPassword([]byte("second"), vaultKey, entry.UUID); err != nil {
+		t.Fatalf("EncryptPassword: %v", err)
+	}
+	if err := vault.UpdatePassword(*stored, vaultKey); err != nil {
+		t.Fatalf("UpdatePassword: %v", err)
+	}
+
+	if _, err := vault.AddAttachment(entry.UUID, "recovery.txt", []byte("codes"), vaultKey); err != nil {
+		t.Fatalf("AddAttachment: %v", err)
+	}
+
+	return vault, vaultKey, *stored
+}
+
+func unlockWith(t *testing.T, vault *Vault, masterPassword string) (*VaultKey, error) {
+	t.Helper()
+	secret := NewSecretBufferFrom([]byte(masterPassword))
+	defer secret.Destroy()
+	vaultKey, err := vault.OpenVaultKey(secret)
+	if err == nil {
+		t.Cleanup(vaultKey.Destroy)
+	}
+	return vaultKey, err
+}
+
+// checkVaultOpens checks that everything stored decrypts under vaultKey
+func checkVaultOpens(t *testing.T, vault *Vault, vaultKey *VaultKey, entry PasswordEntry) {
+	t.Helper()
+
+	stored, err := vault.GetPassword(entry.UUID, vaultKey)
+	if err != nil || stored == nil {
+		t.Fatalf("GetPassword = %v, %v", stored, err)
+	}
+	password, err := DecryptPassword(stored.EncryptedPassword, vaultKey, entry.UUID)
+	if err != nil {
+		t.Fatalf("DecryptPassword: %v", err)
+	}
+	defer password.Destroy()
+	if string(password.Bytes()) != "second" {
+		t.Errorf("password = %q, want %q", password.Bytes(), "second")
+	}
+
+	revisions, err := vault.store.ListAllRevisions()
+	if err != nil {
+		t.Fatalf("ListAllRevisions: %v", err)
+	}
+	if len(revisions) != 1 {
+		t.Fatalf("got %d revisions, want 1", len(revisions))
+	}
+	if _, err := openRevision(revisions[0], vaultKey); err != nil {
+		t.Errorf("openRevision: %v", err)
+	}
+
+	data, err := vault.ReadAttachment(entry.UUID, "recovery.txt", vaultKey)
+	if err != nil {
+		t.Fatalf("ReadAttachment: %v", err)
+	}
+	if string(data) != "codes" {
+		t.Errorf("attachment = %q, want %q", data, "codes")
+	}
+}
+
+func TestChangeMasterPasswordKeepsVaultKey(t *testing.T) {
+	vault, vaultKey, entry := newTestVault(t, NewMemoryStore(), "old password")
+
+	if _, err := vault.SplitKey(vaultKey, 3, 2); err != nil {
+		t.Fatalf("SplitKey: %v", err)
+	}
+
+	newSecret := NewSecretBufferFrom([]byte("new password"))
+	defer newSecret.Destroy()
+	if err := vault.ChangeMasterPassword(vaultKey, newSecret); err != nil {
+		t.Fatalf("ChangeMasterPassword: %v", err)
+	}
+
+	if _, err := unlockWith(t, vault, "old password"); err == nil {
+		t.Error("the old master password still unlocks the vault")
+	}
+
+	unlocked, err := unlockWith(t, vault, "new password")
+	if err != nil {
+		t.Fatalf("unlock with the new master password: %v", err)
+	}
+	if !unlocked.key.Equal(vaultKey.key) {
+		t.Error("the vault key changed")
+	}
+
+	if escrowed, err := vault.HasEscrowShares(); err != nil || !escrowed {
+		t.Errorf("HasEscrowShares = %v, %v, want true", escrowed, err)
+	}
+
+	checkVaultOpens(t, vault, unlocked, entry)
+}
+
+func TestRekeyRotatesVaultKey(t *testing.T) {
+	vault, vaultKey, entry := newTestVault(t, NewMemoryStore(), "old password")
+
+	newSecret := NewSecretBufferFrom([]byte("new password"))
+	defer newSecret.Destroy()
+	newKey, err := vault.Rekey(vaultKey, newSecret)
+	if err != nil {
+		t.Fatalf("Rekey: %v", err)
+	}
+	defer newKey.Destroy()
+
+	unlocked, err := unlockWith(t, vault, "new password")
+	if err != nil {
+		t.Fatalf("unlock with the new master password: %v", err)
+	}
+	if unlocked.key.Equal(vaultKey.key) || !unlocked.key.Equal(newKey.key) {
+		t.Error("the vault key was not rotated")
+	}
+
+	checkVaultOpens(t, vault, unlocked, entry)
+}
+
+func TestRekeyIdentityWithoutSecret(t *testing.T) {
+	vault, vaultKey, _ := newTestVault(t, NewMemoryStore(), "old password")
+
+	identity := PasswordEntry{Type: TypeIdentity, Service: "passport", Username: "Alice Example"}
+	var err error
+	if identity.UUID, err = NewEntryUUID(); err != nil {
+		t.Fatalf("NewEntryUUID: %v", err)
+	}
+	if identity.EncryptedPassword, err = EncryptSecret(nil, vaultKey, identity.UUID); err != nil {
+		t.Fatalf("EncryptSecret: %v", err)
+	}
+	if err := vault.AddPassword(identity, vaultKey); err != nil {
+		t.Fatalf("AddPassword: %v", err)
+	}
+
+	// An update keeps the empty secret in a revision
+	stored, err := vault.GetPassword(identity.UUID, vaultKey)
+	if err != nil || stored == nil {
+		t.Fatalf("GetPassword = %v, %v", stored, err)
+	}
+	stored.Username = "Alice B. Example"
+	if err := vault.UpdatePassword(*stored, vaultKey); err != nil {
+		t.Fatalf("UpdatePassword: %v", err)
+	}
+
+	newSecret := NewSecretBufferFrom([]byte("new password"))
+	defer newSecret.Destroy()
+	newKey, err := vault.Rekey(vaultKey, newSecret)
+	if err != nil {
+		t.Fatalf("Rekey: %v", err)
+	}
+	defer newKey.Destroy()
+
+	stored, err = vault.GetPassword(identity.UUID, newKey)
+	if err != nil || stored == nil {
+		t.Fatalf("GetPassword = %v, %v", stored, err)
+	}
+	secret, err := DecryptPassword(stored.EncryptedPassword, newKey, identity.UUID)
+	if err != nil {
+		t.Fatalf("DecryptPassword: %v", err)
+	}
+	defer secret.Destroy()
+	if len(secret.Bytes()) != 0 {
+		t.Errorf("secret = %q, want it empty", secret.Bytes())
+	}
+}
+
+// corruptingStore stores a corrupted revision or attachment when rekeying
+type corruptingStore struct {
+	*MemoryStore
+	corrupt string
+}
+
+func (s *corruptingStore) Rekey(hashedPassword, wrappedKey string, entries []PasswordEntry, revisions []EntryRevision, attachments []Attachment, settings map[string]string, check RekeyCheck) error {
+	switch s.corrupt {
+	case "revision":
+		revisions[0].EncryptedData = revisions[0].EncryptedPassword
+	case "attachment":
+		attachments[0].Chunks[0] = attachments[0].EncryptedInfo
+	}
+	return s.MemoryStore.Rekey(hashedPassword, wrappedKey, entries, revisions, attachments, settings, check)
+}
+
+func TestRekeyVerifiesBeforeCommit(t *testing.T) {
+	for _, corrupt := range []string{"revision", "attachment"} {
+		t.Run(corrupt, func(t *testing.T) {
+			vault, vaultKey, entry := newTestVault(t, &corruptingStore{MemoryStore: NewMemoryStore(), corrupt: corrupt}, "old password")
+
+			newSecret := NewSecretBufferFrom([]byte("new password"))
+			defer newSecret.Destroy()
+			if newKey, err := vault.Rekey(vaultKey, newSecret); err == nil {
+				newKey.Destroy()
+				t.Fatal("Rekey stored an undecryptable " + corrupt)
+			}
+
+			unlocked, err := unlockWith(t, vault, "old password")
+			if err != nil {
+				t.Fatalf("the failed rekey changed the master password: %v", err)
+			}
+			checkVaultOpens(t, vault, unlocked, entry)
+		})
+	}
+}